@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Récupération du compte : réinitialisation du mot de passe et vérification
+// de l'adresse email, par des liens à usage unique envoyés par email.
+
+const (
+	purposePasswordReset     = "password_reset"
+	purposeEmailVerification = "email_verification"
+
+	passwordResetTTL     = time.Hour
+	emailVerificationTTL = 48 * time.Hour
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// createUserToken enregistre un jeton à usage unique pour l'utilisateur et
+// renvoie sa version signée.
+func (h *AuthHandler) createUserToken(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, error) {
+	var id int64
+	var expiresAt time.Time
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO user_tokens (user_id, purpose, expires_at) VALUES ($1,$2,$3) RETURNING id, expires_at`,
+		userID, purpose, time.Now().Add(ttl),
+	).Scan(&id, &expiresAt)
+	if err != nil {
+		return "", err
+	}
+	return signActionToken(h.cfg, purpose, id, expiresAt, jwt.MapClaims{"sub": userID})
+}
+
+// consumeUserToken vérifie un jeton et le marque comme utilisé dans la
+// transaction ; renvoie l'utilisateur concerné.
+func consumeUserToken(ctx context.Context, tx pgx.Tx, cfg Config, purpose, tokenStr string) (int64, error) {
+	id, err := parseActionToken(cfg, purpose, tokenStr)
+	if err != nil {
+		return 0, err
+	}
+	var userID int64
+	err = tx.QueryRow(ctx,
+		`UPDATE user_tokens SET used_at = now()
+		 WHERE id = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > now()
+		 RETURNING user_id`,
+		id, purpose,
+	).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, errInvalidActionToken
+	}
+	return userID, err
+}
+
+// appLink construit un lien vers une page du frontend portant le jeton.
+func (h *AuthHandler) appLink(path, token string) string {
+	return h.cfg.AppURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// sendVerificationEmail envoie le lien de confirmation d'adresse.
+func (h *AuthHandler) sendVerificationEmail(ctx context.Context, userID int64, email string) error {
+	token, err := h.createUserToken(ctx, userID, purposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	sendEmailAsync(h.mailer, EmailMessage{
+		To:      email,
+		Subject: "Confirmez votre adresse email CarbonV2",
+		Text: fmt.Sprintf("Bonjour,\n\n"+
+			"Pour confirmer l'adresse email de votre compte CarbonV2, ouvrez ce lien (valable 48 heures) :\n\n"+
+			"%s\n\n"+
+			"Si vous n'avez pas créé de compte, ignorez cet email.\n", h.appLink("/verify-email", token)),
+	})
+	return nil
+}
+
+// POST /api/auth/password/forgot
+// Envoie un lien de réinitialisation si un compte existe pour cet email. La
+// réponse est la même dans tous les cas, pour ne pas révéler les comptes existants.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email invalide", "details": err.Error()})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var userID int64
+	err := h.db.QueryRow(ctx, `SELECT id FROM users WHERE email = $1`, email).Scan(&userID)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	if err == nil {
+		token, err := h.createUserToken(ctx, userID, purposePasswordReset, passwordResetTTL)
+		if err != nil {
+			log.Printf("jeton de réinitialisation pour l'utilisateur %d : %v", userID, err)
+		} else {
+			sendEmailAsync(h.mailer, EmailMessage{
+				To:      email,
+				Subject: "Réinitialisation de votre mot de passe CarbonV2",
+				Text: fmt.Sprintf("Bonjour,\n\n"+
+					"Une réinitialisation du mot de passe de votre compte CarbonV2 a été demandée. "+
+					"Pour choisir un nouveau mot de passe, ouvrez ce lien (valable 1 heure) :\n\n"+
+					"%s\n\n"+
+					"Si vous n'êtes pas à l'origine de cette demande, ignorez cet email : "+
+					"votre mot de passe reste inchangé.\n", h.appLink("/reset-password", token)),
+			})
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "si un compte existe pour cet email, un lien de réinitialisation a été envoyé"})
+}
+
+// POST /api/auth/password/reset
+// Définit un nouveau mot de passe à partir du lien reçu par email. Toutes les
+// sessions ouvertes sont fermées.
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Les informations fournies sont invalides. Le mot de passe doit contenir au moins 8 caractères.",
+			"details": err.Error(),
+		})
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de sécurité"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, h.cfg, purposePasswordReset, req.Token)
+	if errors.Is(err, errInvalidActionToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lien de réinitialisation invalide, expiré ou déjà utilisé"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+
+	// Le lien reçu par email prouve aussi que l'adresse est valide.
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET password_hash = $2, email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1`,
+		userID, string(passwordHash),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de modifier le mot de passe"})
+		return
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE user_tokens SET used_at = now() WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+		userID, purposePasswordReset,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now(), revoked_reason = 'password_reset'
+		 WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de fermer les sessions"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "mot de passe modifié, reconnectez-vous"})
+}
+
+// POST /api/auth/email/verify
+// Confirme l'adresse email à partir du lien reçu.
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req verifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "jeton manquant", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	userID, err := consumeUserToken(ctx, tx, h.cfg, purposeEmailVerification, req.Token)
+	if errors.Is(err, errInvalidActionToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lien de vérification invalide, expiré ou déjà utilisé"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+
+	var verifiedAt time.Time
+	err = tx.QueryRow(ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1 RETURNING email_verified_at`,
+		userID,
+	).Scan(&verifiedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de confirmer l'adresse"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"email_verified_at": verifiedAt})
+}
+
+// POST /api/auth/email/verification
+// Renvoie le lien de confirmation à l'utilisateur connecté.
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var email string
+	var verifiedAt *time.Time
+	err := h.db.QueryRow(ctx, `SELECT email, email_verified_at FROM users WHERE id = $1`, *userID).Scan(&email, &verifiedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du compte"})
+		return
+	}
+	if verifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "adresse email déjà confirmée"})
+		return
+	}
+	if err := h.sendVerificationEmail(ctx, *userID, email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'envoyer l'email de vérification"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"status": "email de vérification envoyé"})
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ActionsHandler gère le plan d'actions de réduction, sa projection et la courbe MACC.
+type ActionsHandler struct {
+	db *pgxpool.Pool
+}
+
+func NewActionsHandler(db *pgxpool.Pool) *ActionsHandler {
+	return &ActionsHandler{db: db}
+}
+
+type actionRequest struct {
+	Title                string   `json:"title" binding:"required"`
+	Owner                string   `json:"owner"`
+	Categories           []string `json:"categories"`
+	Scopes               []string `json:"scopes"`
+	StartDate            string   `json:"start_date" binding:"required"` // YYYY-MM-DD
+	Status               string   `json:"status"`
+	CostEUR              float64  `json:"cost_eur"`
+	LifetimeYears        int      `json:"lifetime_years"`
+	AnnualReductionTCO2e float64  `json:"annual_reduction_tco2e"`
+}
+
+// toAction valide la requête et la convertit en action ; renvoie un message d'erreur sinon.
+func (r actionRequest) toAction(tenantID int64) (ReductionAction, string) {
+	start, err := time.Parse("2006-01-02", r.StartDate)
+	if err != nil {
+		return ReductionAction{}, "start_date invalide, format attendu YYYY-MM-DD"
+	}
+	if r.Status == "" {
+		r.Status = "planned"
+	}
+	switch r.Status {
+	case "planned", "in_progress", "done", "cancelled":
+	default:
+		return ReductionAction{}, "status doit valoir 'planned', 'in_progress', 'done' ou 'cancelled'"
+	}
+	if r.CostEUR < 0 || r.AnnualReductionTCO2e < 0 {
+		return ReductionAction{}, "cost_eur et annual_reduction_tco2e doivent être positifs"
+	}
+	if r.LifetimeYears == 0 {
+		r.LifetimeYears = 10
+	}
+	if r.LifetimeYears < 0 || r.LifetimeYears > 100 {
+		return ReductionAction{}, "lifetime_years invalide"
+	}
+	for _, s := range r.Scopes {
+		if s != "1" && s != "2" && s != "3" {
+			return ReductionAction{}, "scope invalide : " + s
+		}
+	}
+	scopes := slices.Compact(slices.Sorted(slices.Values(r.Scopes)))
+	if scopes == nil {
+		scopes = []string{}
+	}
+	categories := r.Categories
+	if categories == nil {
+		categories = []string{}
+	}
+
+	var owner *string
+	if r.Owner != "" {
+		owner = &r.Owner
+	}
+
+	return ReductionAction{
+		TenantID:             tenantID,
+		Title:                r.Title,
+		Owner:                owner,
+		Categories:           categories,
+		Scopes:               scopes,
+		StartDate:            start,
+		Status:               r.Status,
+		CostEUR:              r.CostEUR,
+		LifetimeYears:        r.LifetimeYears,
+		AnnualReductionTCO2e: r.AnnualReductionTCO2e,
+	}, ""
+}
+
+// POST /api/tenants/:tenantId/actions
+func (h *ActionsHandler) CreateAction(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req actionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	a, msg := req.toAction(tenantID)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO reduction_actions (tenant_id, title, owner, categories, scopes, start_date, status, cost_eur, lifetime_years, annual_reduction_tco2e)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		 RETURNING id, created_at`,
+		a.TenantID, a.Title, a.Owner, a.Categories, a.Scopes, a.StartDate, a.Status, a.CostEUR, a.LifetimeYears, a.AnnualReductionTCO2e,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'action", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, a)
+}
+
+// GET /api/tenants/:tenantId/actions
+func (h *ActionsHandler) ListActions(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	actions, err := listActions(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des actions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, actions)
+}
+
+// PUT /api/tenants/:tenantId/actions/:actionId
+func (h *ActionsHandler) UpdateAction(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	actionID, ok := int64Param(c, "actionId")
+	if !ok {
+		return
+	}
+
+	var req actionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	a, msg := req.toAction(tenantID)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	a.ID = actionID
+	err := h.db.QueryRow(ctx,
+		`UPDATE reduction_actions
+		 SET title = $3, owner = $4, categories = $5, scopes = $6, start_date = $7, status = $8,
+		     cost_eur = $9, lifetime_years = $10, annual_reduction_tco2e = $11
+		 WHERE id = $1 AND tenant_id = $2
+		 RETURNING created_at`,
+		a.ID, a.TenantID, a.Title, a.Owner, a.Categories, a.Scopes, a.StartDate, a.Status, a.CostEUR, a.LifetimeYears, a.AnnualReductionTCO2e,
+	).Scan(&a.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "action non trouvée"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour l'action", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, a)
+}
+
+// DELETE /api/tenants/:tenantId/actions/:actionId
+func (h *ActionsHandler) DeleteAction(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	actionID, ok := int64Param(c, "actionId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`DELETE FROM reduction_actions WHERE id = $1 AND tenant_id = $2`,
+		actionID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer l'action"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "action non trouvée"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type projectionYear struct {
+	Year      int                `json:"year"`
+	Baseline  float64            `json:"baseline_tco2e"`
+	Reduction float64            `json:"reduction_tco2e"`
+	Projected float64            `json:"projected_tco2e"`
+	ByScope   map[string]float64 `json:"projected_by_scope"`
+}
+
+type projectionResponse struct {
+	BaseYear        int                `json:"base_year"`
+	BaselineByScope map[string]float64 `json:"baseline_by_scope"`
+	Years           []projectionYear   `json:"years"`
+}
+
+// GET /api/tenants/:tenantId/actions/projection?base_year=2024&to_year=2030
+// Projette les émissions en appliquant les actions (hors annulées) sur une baseline
+// figée à l'année de référence. Par défaut : dernière année disposant d'émissions.
+// Une action limitée à des catégories ne réduit que leurs émissions.
+func (h *ActionsHandler) ActionsProjection(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	baseYear, ok := optionalYearQuery(c, "base_year")
+	if !ok {
+		return
+	}
+	toYear, ok := optionalYearQuery(c, "to_year")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	yearly, err := yearlyEmissionsByScope(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+	if baseYear == 0 {
+		for y := range yearly {
+			baseYear = max(baseYear, y)
+		}
+	}
+	baseline, ok := yearly[baseYear]
+	if !ok {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "aucune émission calculée pour l'année de référence"})
+		return
+	}
+	if toYear == 0 {
+		toYear = baseYear + 10
+	}
+	if toYear <= baseYear || toYear-baseYear > 50 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to_year doit être postérieure à base_year (50 ans maximum)"})
+		return
+	}
+
+	byCategory, err := emissionsByScopeAndCategory(ctx, h.db, tenantID, baseYear)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+
+	actions, err := listActions(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des actions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, projectionResponse{
+		BaseYear:        baseYear,
+		BaselineByScope: baseline,
+		Years:           projectActions(baseYear, toYear, byCategory, actions),
+	})
+}
+
+// emissionsByScopeAndCategory agrège les émissions d'une année par scope puis
+// par catégorie d'entrée ("" pour les entrées sans catégorie).
+func emissionsByScopeAndCategory(ctx context.Context, db *pgxpool.Pool, tenantID int64, year int) (map[string]map[string]float64, error) {
+	rows, err := db.Query(ctx,
+		`SELECT em.scope, COALESCE(en.category, ''), COALESCE(SUM(em.tco2e), 0)
+		 FROM emissions em
+		 JOIN entries en ON en.id = em.entry_id
+		 WHERE em.tenant_id = $1 AND EXTRACT(YEAR FROM en.date) = $2
+		 GROUP BY 1, 2`,
+		tenantID, year,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]map[string]float64)
+	for rows.Next() {
+		var scope, category string
+		var sum float64
+		if err := rows.Scan(&scope, &category, &sum); err != nil {
+			return nil, err
+		}
+		if out[scope] == nil {
+			out[scope] = make(map[string]float64)
+		}
+		out[scope][category] += sum
+	}
+	return out, rows.Err()
+}
+
+// projectActions calcule, pour chaque année, la baseline (par scope puis par
+// catégorie) et les émissions restantes une fois les réductions des actions
+// appliquées (au prorata de l'année de démarrage). Les émissions de l'année de
+// référence intègrent déjà les actions démarrées au plus tard cette année-là :
+// seules les actions démarrées ensuite réduisent la projection.
+func projectActions(baseYear, toYear int, baseline map[string]map[string]float64, actions []ReductionAction) []projectionYear {
+	var baselineTotal float64
+	for _, cats := range baseline {
+		for _, v := range cats {
+			baselineTotal += v
+		}
+	}
+
+	out := make([]projectionYear, 0, toYear-baseYear+1)
+	for year := baseYear; year <= toYear; year++ {
+		remaining := make(map[string]map[string]float64, len(baseline))
+		for s, cats := range baseline {
+			remaining[s] = make(map[string]float64, len(cats))
+			for cat, v := range cats {
+				remaining[s][cat] = v
+			}
+		}
+
+		for _, a := range actions {
+			if a.Status == "cancelled" || a.AnnualReductionTCO2e <= 0 {
+				continue
+			}
+			share := activeShare(a.StartDate, baseYear, year)
+			if share == 0 {
+				continue
+			}
+			applyReduction(remaining, baseline, a.Scopes, a.Categories, a.AnnualReductionTCO2e*share)
+		}
+
+		py := projectionYear{Year: year, Baseline: baselineTotal, ByScope: make(map[string]float64, len(remaining))}
+		for s, cats := range remaining {
+			for _, v := range cats {
+				py.ByScope[s] += v
+			}
+			py.Projected += py.ByScope[s]
+		}
+		py.Reduction = baselineTotal - py.Projected
+		out = append(out, py)
+	}
+	return out
+}
+
+// activeShare renvoie la part de l'année pendant laquelle une action démarrée à
+// start est active, au-delà de l'année de référence : une action démarrée au
+// plus tard en baseYear est déjà reflétée par la baseline et compte pour zéro.
+func activeShare(start time.Time, baseYear, year int) float64 {
+	switch {
+	case start.Year() <= baseYear || year <= baseYear:
+		return 0
+	case start.Year() < year:
+		return 1
+	case start.Year() > year:
+		return 0
+	default:
+		return float64(13-int(start.Month())) / 12
+	}
+}
+
+// applyReduction répartit une réduction sur les scopes et catégories ciblés au
+// prorata de leur poids dans la baseline (tous les scopes, ou toutes les
+// catégories, si l'action n'en précise aucun), sans jamais descendre sous zéro :
+// une action ne réduit que les émissions de ses catégories.
+func applyReduction(remaining, baseline map[string]map[string]float64, scopes, categories []string, reduction float64) {
+	targeted := func(scope, category string) bool {
+		if len(scopes) > 0 && !slices.Contains(scopes, scope) {
+			return false
+		}
+		if len(categories) == 0 {
+			return true
+		}
+		return slices.ContainsFunc(categories, func(c string) bool {
+			return strings.EqualFold(strings.TrimSpace(c), strings.TrimSpace(category))
+		})
+	}
+	var weight float64
+	for s, cats := range baseline {
+		for cat, v := range cats {
+			if targeted(s, cat) {
+				weight += v
+			}
+		}
+	}
+	if weight <= 0 {
+		return
+	}
+	for s, cats := range baseline {
+		for cat, v := range cats {
+			if targeted(s, cat) {
+				remaining[s][cat] = math.Max(remaining[s][cat]-reduction*v/weight, 0)
+			}
+		}
+	}
+}
+
+type maccItem struct {
+	ActionID             int64   `json:"action_id"`
+	Title                string  `json:"title"`
+	Status               string  `json:"status"`
+	CostEUR              float64 `json:"cost_eur"`
+	AnnualReductionTCO2e float64 `json:"annual_reduction_tco2e"`
+	// Coût par tonne évitée sur la durée de vie de l'action (hauteur de la barre).
+	CostPerTCO2e float64 `json:"cost_per_tco2e"`
+	// Position de la barre sur l'axe des abscisses (réduction annuelle cumulée).
+	CumulativeStart float64 `json:"cumulative_start_tco2e"`
+	CumulativeEnd   float64 `json:"cumulative_end_tco2e"`
+}
+
+type maccResponse struct {
+	Unit                      string     `json:"unit"`
+	TotalAnnualReductionTCO2e float64    `json:"total_annual_reduction_tco2e"`
+	Items                     []maccItem `json:"items"`
+}
+
+// GET /api/tenants/:tenantId/actions/macc
+// Données de courbe des coûts marginaux d'abattement (EUR / tCO2e), triées par coût croissant.
+func (h *ActionsHandler) ActionsMACC(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	actions, err := listActions(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des actions"})
+		return
+	}
+
+	items, total := buildMACC(actions)
+	c.JSON(http.StatusOK, maccResponse{
+		Unit:                      "EUR/tCO2e",
+		TotalAnnualReductionTCO2e: total,
+		Items:                     items,
+	})
+}
+
+// buildMACC renvoie les barres de la courbe MACC, triées par coût par tonne
+// croissant, et la réduction annuelle totale des actions retenues.
+func buildMACC(actions []ReductionAction) ([]maccItem, float64) {
+	items := []maccItem{}
+	for _, a := range actions {
+		if a.Status == "cancelled" || a.AnnualReductionTCO2e <= 0 {
+			continue
+		}
+		items = append(items, maccItem{
+			ActionID:             a.ID,
+			Title:                a.Title,
+			Status:               a.Status,
+			CostEUR:              a.CostEUR,
+			AnnualReductionTCO2e: a.AnnualReductionTCO2e,
+			CostPerTCO2e:         a.CostEUR / (a.AnnualReductionTCO2e * float64(a.LifetimeYears)),
+		})
+	}
+	slices.SortStableFunc(items, func(a, b maccItem) int {
+		switch {
+		case a.CostPerTCO2e < b.CostPerTCO2e:
+			return -1
+		case a.CostPerTCO2e > b.CostPerTCO2e:
+			return 1
+		default:
+			return 0
+		}
+	})
+
+	var cumulative float64
+	for i := range items {
+		items[i].CumulativeStart = cumulative
+		cumulative += items[i].AnnualReductionTCO2e
+		items[i].CumulativeEnd = cumulative
+	}
+	return items, cumulative
+}
+
+func listActions(ctx context.Context, db *pgxpool.Pool, tenantID int64) ([]ReductionAction, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, tenant_id, title, owner, categories, scopes, start_date, status, cost_eur, lifetime_years, annual_reduction_tco2e, created_at
+		 FROM reduction_actions
+		 WHERE tenant_id = $1
+		 ORDER BY start_date, id`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	actions := []ReductionAction{}
+	for rows.Next() {
+		var a ReductionAction
+		if err := rows.Scan(&a.ID, &a.TenantID, &a.Title, &a.Owner, &a.Categories, &a.Scopes, &a.StartDate, &a.Status, &a.CostEUR, &a.LifetimeYears, &a.AnnualReductionTCO2e, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		actions = append(actions, a)
+	}
+	return actions, rows.Err()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func actionStart(year int, month time.Month) time.Time {
+	return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+}
+
+func TestActiveShare(t *testing.T) {
+	tests := []struct {
+		name  string
+		start time.Time
+		year  int
+		want  float64
+	}{
+		{"démarrée avant l'année de référence", actionStart(2022, time.March), 2026, 0},
+		{"démarrée pendant l'année de référence", actionStart(2024, time.January), 2026, 0},
+		{"année de référence", actionStart(2025, time.January), 2024, 0},
+		{"démarrée en janvier", actionStart(2025, time.January), 2025, 1},
+		{"démarrée en juillet", actionStart(2025, time.July), 2025, 0.5},
+		{"démarrée en décembre", actionStart(2025, time.December), 2025, 1.0 / 12},
+		{"année suivant le démarrage", actionStart(2025, time.July), 2026, 1},
+		{"pas encore démarrée", actionStart(2027, time.January), 2026, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := activeShare(tt.start, 2024, tt.year); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("activeShare = %v, attendu %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProjectActions(t *testing.T) {
+	baseline := map[string]map[string]float64{
+		"scope1": {"gaz": 60, "carburant": 20},
+		"scope2": {"electricite": 40},
+	}
+	tests := []struct {
+		name    string
+		actions []ReductionAction
+		want    []float64 // émissions projetées 2024, 2025, 2026
+	}{
+		{
+			name: "action démarrée pendant l'année de référence : déjà dans la baseline",
+			actions: []ReductionAction{
+				{Status: "done", StartDate: actionStart(2024, time.January), AnnualReductionTCO2e: 30},
+			},
+			want: []float64{120, 120, 120},
+		},
+		{
+			name: "action démarrée avant l'année de référence",
+			actions: []ReductionAction{
+				{Status: "done", StartDate: actionStart(2021, time.June), AnnualReductionTCO2e: 30},
+			},
+			want: []float64{120, 120, 120},
+		},
+		{
+			name: "action démarrée en juillet de l'année suivante",
+			actions: []ReductionAction{
+				{Status: "planned", StartDate: actionStart(2025, time.July), AnnualReductionTCO2e: 24},
+			},
+			want: []float64{120, 108, 96},
+		},
+		{
+			name: "action annulée ignorée",
+			actions: []ReductionAction{
+				{Status: "cancelled", StartDate: actionStart(2025, time.January), AnnualReductionTCO2e: 24},
+			},
+			want: []float64{120, 120, 120},
+		},
+		{
+			name: "réduction limitée aux catégories de l'action",
+			actions: []ReductionAction{
+				{Status: "planned", Categories: []string{"Gaz"}, StartDate: actionStart(2025, time.January), AnnualReductionTCO2e: 500},
+			},
+			want: []float64{120, 60, 60},
+		},
+		{
+			name: "réduction limitée à un scope, au prorata des catégories",
+			actions: []ReductionAction{
+				{Status: "planned", Scopes: []string{"scope1"}, StartDate: actionStart(2025, time.January), AnnualReductionTCO2e: 40},
+			},
+			want: []float64{120, 80, 80},
+		},
+		{
+			name: "catégorie absente de la baseline : aucune réduction",
+			actions: []ReductionAction{
+				{Status: "planned", Categories: []string{"fret"}, StartDate: actionStart(2025, time.January), AnnualReductionTCO2e: 10},
+			},
+			want: []float64{120, 120, 120},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			years := projectActions(2024, 2026, baseline, tt.actions)
+			if len(years) != len(tt.want) {
+				t.Fatalf("%d années, attendu %d", len(years), len(tt.want))
+			}
+			for i, y := range years {
+				if y.Year != 2024+i || y.Baseline != 120 {
+					t.Errorf("année %d : baseline %v", y.Year, y.Baseline)
+				}
+				if math.Abs(y.Projected-tt.want[i]) > 1e-9 {
+					t.Errorf("%d : projeté %v, attendu %v", y.Year, y.Projected, tt.want[i])
+				}
+				if math.Abs(y.Reduction-(y.Baseline-y.Projected)) > 1e-9 {
+					t.Errorf("%d : réduction %v incohérente", y.Year, y.Reduction)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMACC(t *testing.T) {
+	actions := []ReductionAction{
+		{ID: 1, Status: "planned", CostEUR: 100000, LifetimeYears: 10, AnnualReductionTCO2e: 50},    // 200 €/t
+		{ID: 2, Status: "done", CostEUR: 0, LifetimeYears: 5, AnnualReductionTCO2e: 10},             // 0 €/t
+		{ID: 3, Status: "cancelled", CostEUR: 1000, LifetimeYears: 5, AnnualReductionTCO2e: 100},    // ignorée
+		{ID: 4, Status: "in_progress", CostEUR: 60000, LifetimeYears: 20, AnnualReductionTCO2e: 30}, // 100 €/t
+		{ID: 5, Status: "planned", CostEUR: 5000, LifetimeYears: 5, AnnualReductionTCO2e: 0},        // ignorée
+	}
+	items, total := buildMACC(actions)
+
+	want := []struct {
+		id               int64
+		costPerT         float64
+		cumStart, cumEnd float64
+	}{
+		{2, 0, 0, 10},
+		{4, 100, 10, 40},
+		{1, 200, 40, 90},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("%d barres, attendu %d", len(items), len(want))
+	}
+	for i, w := range want {
+		it := items[i]
+		if it.ActionID != w.id || math.Abs(it.CostPerTCO2e-w.costPerT) > 1e-9 ||
+			it.CumulativeStart != w.cumStart || it.CumulativeEnd != w.cumEnd {
+			t.Errorf("barre %d = %+v, attendu action %d à %v €/t [%v, %v]", i, it, w.id, w.costPerT, w.cumStart, w.cumEnd)
+		}
+	}
+	if total != 90 {
+		t.Errorf("total = %v, attendu 90", total)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Clés d'API : accès machine à machine (ERP, scripts) limité à un tenant et à
+// des scopes, sans mot de passe d'utilisateur. La clé est présentée comme un
+// token (Authorization: Bearer cbk_...) ; seule son empreinte SHA-256 est
+// stockée. Une route n'accepte une clé que si elle déclare le scope requis
+// (AllowAPIKey) ; toutes les autres la refusent.
+
+const apiKeyPrefix = "cbk_"
+
+// Scopes attribuables à une clé d'API.
+const (
+	ScopeEntriesRead   = "entries:read"   // lecture des entrées
+	ScopeEntriesWrite  = "entries:write"  // saisie, import et calcul des entrées
+	ScopeEmissionsRead = "emissions:read" // émissions calculées et synthèses
+)
+
+var apiKeyScopes = []string{ScopeEntriesRead, ScopeEntriesWrite, ScopeEmissionsRead}
+
+type APIKeysHandler struct {
+	db  *pgxpool.Pool
+	cfg Config
+}
+
+func NewAPIKeysHandler(db *pgxpool.Pool, cfg Config) *APIKeysHandler {
+	return &APIKeysHandler{db: db, cfg: cfg}
+}
+
+type createAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"` // nil = sans expiration
+}
+
+type apiKeyItem struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // début de la clé, pour la reconnaître
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP *string    `json:"last_used_ip"`
+	CreatedBy  *int64     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// authenticateAPIKey vérifie une clé d'API et renvoie les claims qui la
+// représentent dans la requête (tenant, clé, scopes). La date de dernière
+// utilisation est mise à jour au plus une fois par minute.
+func authenticateAPIKey(ctx context.Context, db *pgxpool.Pool, key, ip string) (jwt.MapClaims, error) {
+	var id, tenantID int64
+	var scopes []string
+	err := db.QueryRow(ctx,
+		`SELECT id, tenant_id, scopes FROM api_keys
+		 WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`,
+		hashToken(key),
+	).Scan(&id, &tenantID, &scopes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(ctx,
+		`UPDATE api_keys SET last_used_at = now(), last_used_ip = $2
+		 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute' OR last_used_ip IS DISTINCT FROM $2)`,
+		id, ip,
+	); err != nil {
+		return nil, err
+	}
+	scopeList := make([]interface{}, len(scopes))
+	for i, s := range scopes {
+		scopeList[i] = s
+	}
+	return jwt.MapClaims{
+		"tenant_id":  float64(tenantID),
+		"api_key_id": float64(id),
+		"scopes":     scopeList,
+	}, nil
+}
+
+// isAPIKeyRequest indique si la requête est authentifiée par une clé d'API.
+func isAPIKeyRequest(c *gin.Context) bool {
+	claims, _ := c.MustGet("user").(jwt.MapClaims)
+	_, ok := claims["api_key_id"]
+	return ok
+}
+
+// AllowAPIKey ouvre la route aux clés d'API portant le scope ; sans effet pour
+// les utilisateurs. À placer avant RequirePermission.
+func AllowAPIKey(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAPIKeyRequest(c) {
+			c.Next()
+			return
+		}
+		claims, _ := c.MustGet("user").(jwt.MapClaims)
+		if !containsString(claimStrings(claims, "scopes"), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "scope insuffisant pour cette clé d'API",
+				"details": gin.H{"scope": scope},
+			})
+			return
+		}
+		c.Set("api_key_scope", scope)
+		c.Next()
+	}
+}
+
+// POST /api/tenants/:tenantId/api-keys
+// Crée une clé d'API. La clé n'est renvoyée qu'une seule fois.
+func (h *APIKeysHandler) CreateAPIKey(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name est requis"})
+		return
+	}
+	scopes := []string{}
+	for _, s := range req.Scopes {
+		if !containsString(apiKeyScopes, s) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "scope invalide : " + s, "details": gin.H{"scopes": apiKeyScopes}})
+			return
+		}
+		if !containsString(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at doit être dans le futur"})
+		return
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	item := apiKeyItem{Name: req.Name, Prefix: key[:len(apiKeyPrefix)+8], Scopes: scopes, ExpiresAt: req.ExpiresAt, CreatedBy: userIDFromRequest(c)}
+	err = h.db.QueryRow(ctx,
+		`INSERT INTO api_keys (tenant_id, name, prefix, key_hash, scopes, expires_at, created_by)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7)
+		 RETURNING id, created_at`,
+		tenantID, item.Name, item.Prefix, hashToken(key), item.Scopes, item.ExpiresAt, item.CreatedBy,
+	).Scan(&item.ID, &item.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer la clé d'API", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"api_key": item, "key": key})
+}
+
+// GET /api/tenants/:tenantId/api-keys
+// Liste les clés d'API du tenant (révoquées comprises), sans leur valeur.
+func (h *APIKeysHandler) ListAPIKeys(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT id, name, prefix, scopes, expires_at, last_used_at, last_used_ip, created_by, created_at, revoked_at
+		 FROM api_keys WHERE tenant_id = $1
+		 ORDER BY revoked_at IS NOT NULL, created_at DESC`,
+		tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des clés d'API"})
+		return
+	}
+	defer rows.Close()
+
+	items := []apiKeyItem{}
+	for rows.Next() {
+		var k apiKeyItem
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.Scopes, &k.ExpiresAt, &k.LastUsedAt, &k.LastUsedIP,
+			&k.CreatedBy, &k.CreatedAt, &k.RevokedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des clés d'API"})
+			return
+		}
+		items = append(items, k)
+	}
+	c.JSON(http.StatusOK, items)
+}
+
+// DELETE /api/tenants/:tenantId/api-keys/:keyId
+// Révoque une clé d'API : elle est refusée dès la requête suivante.
+func (h *APIKeysHandler) RevokeAPIKey(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	keyID, ok := int64Param(c, "keyId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var revokedAt *time.Time
+	err := h.db.QueryRow(ctx,
+		`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now())
+		 WHERE id = $1 AND tenant_id = $2
+		 RETURNING revoked_at`,
+		keyID, tenantID,
+	).Scan(&revokedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "clé d'API introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de révoquer la clé d'API"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
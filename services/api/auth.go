@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -15,12 +16,15 @@ import (
 )
 
 type AuthHandler struct {
-	db  *pgxpool.Pool
-	cfg Config
+	db             *pgxpool.Pool
+	cfg            Config
+	mailer         EmailSender
+	lockout        *LoginLockout // par compte et adresse IP
+	accountLockout *LoginLockout // par compte, toutes adresses confondues
 }
 
-func NewAuthHandler(db *pgxpool.Pool, cfg Config) *AuthHandler {
-	return &AuthHandler{db: db, cfg: cfg}
+func NewAuthHandler(db *pgxpool.Pool, cfg Config, mailer EmailSender, limits RateLimitStore) *AuthHandler {
+	return &AuthHandler{db: db, cfg: cfg, mailer: mailer, lockout: NewLoginLockout(limits), accountLockout: NewAccountLockout(limits)}
 }
 
 type signUpRequest struct {
@@ -36,7 +40,9 @@ type loginRequest struct {
 }
 
 type authResponse struct {
-	Token string `json:"token"`
+	Token        string `json:"token"`         // token d'accès, de courte durée
+	RefreshToken string `json:"refresh_token"` // à échanger sur /api/auth/refresh
+	ExpiresIn    int64  `json:"expires_in"`    // durée de validité du token d'accès, en secondes
 }
 
 func (h *AuthHandler) SignUp(c *gin.Context) {
@@ -78,7 +84,7 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 	var userID int64
 	err = tx.QueryRow(ctx,
 		`INSERT INTO users (tenant_id, email, role, password_hash) VALUES ($1, $2, $3, $4) RETURNING id`,
-		tenantID, strings.ToLower(req.Email), "admin", string(passwordHash),
+		tenantID, strings.ToLower(req.Email), RoleOwner, string(passwordHash),
 	).Scan(&userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'utilisateur"})
@@ -90,13 +96,18 @@ func (h *AuthHandler) SignUp(c *gin.Context) {
 		return
 	}
 
-	token, err := h.createToken(userID, tenantID, "admin")
+	resp, err := h.startSession(c, userID, tenantID, RoleOwner, authMethodPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le token"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, authResponse{Token: token})
+	// La confirmation de l'adresse n'est pas bloquante pour l'inscription.
+	if err := h.sendVerificationEmail(ctx, userID, strings.ToLower(req.Email)); err != nil {
+		log.Printf("email de vérification pour l'utilisateur %d : %v", userID, err)
+	}
+
+	c.JSON(http.StatusCreated, resp)
 }
 
 func (h *AuthHandler) Login(c *gin.Context) {
@@ -112,34 +123,70 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
+	// Blocage progressif après des échecs répétés (voir LoginLockout) : depuis
+	// une adresse IP, puis sur le compte pour une attaque répartie.
+	lockKey := loginLockoutKey(req.Email, c.ClientIP())
+	accountKey := accountLockoutKey(req.Email)
+	wait := h.lockout.Locked(ctx, lockKey)
+	if w := h.accountLockout.Locked(ctx, accountKey); w > wait {
+		wait = w
+	}
+	if wait > 0 {
+		respondLocked(c, wait)
+		return
+	}
+
 	var (
 		userID       int64
 		tenantID     int64
 		role         string
 		passwordHash string
+		totpEnabled  bool
 	)
 
 	err := h.db.QueryRow(ctx,
-		`SELECT id, tenant_id, role, password_hash FROM users WHERE email = $1`,
+		`SELECT id, tenant_id, role, password_hash, totp_enabled_at IS NOT NULL FROM users WHERE email = $1`,
 		strings.ToLower(req.Email),
-	).Scan(&userID, &tenantID, &role, &passwordHash)
+	).Scan(&userID, &tenantID, &role, &passwordHash, &totpEnabled)
 	if err != nil {
+		h.lockout.Failed(ctx, lockKey)
+		h.accountLockout.Failed(ctx, accountKey)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "identifiants invalides"})
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		h.lockout.Failed(ctx, lockKey)
+		h.accountLockout.Failed(ctx, accountKey)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "identifiants invalides"})
 		return
 	}
+	h.lockout.Succeeded(ctx, lockKey)
+	h.accountLockout.Succeeded(ctx, accountKey)
+
+	// Double authentification : la session n'est ouverte qu'après le code, sur
+	// /api/auth/login/mfa.
+	if totpEnabled {
+		mfaToken, err := signActionToken(h.cfg, "mfa_login", userID, time.Now().Add(mfaLoginTTL), nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+			"expires_in":   int64(mfaLoginTTL.Seconds()),
+		})
+		return
+	}
 
-	token, err := h.createToken(userID, tenantID, role)
+	resp, err := h.startSession(c, userID, tenantID, role, authMethodPassword)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le token"})
 		return
 	}
 
-	c.JSON(http.StatusOK, authResponse{Token: token})
+	c.JSON(http.StatusOK, resp)
 }
 
 func (h *AuthHandler) Me(c *gin.Context) {
@@ -152,12 +199,14 @@ func (h *AuthHandler) Me(c *gin.Context) {
 	c.JSON(http.StatusOK, user)
 }
 
-func (h *AuthHandler) createToken(userID, tenantID int64, role string) (string, error) {
+func (h *AuthHandler) createToken(userID, tenantID int64, role string, sessionID int64) (string, error) {
 	claims := jwt.MapClaims{
 		"sub":       userID,
 		"tenant_id": tenantID,
 		"role":      role,
-		"exp":       time.Now().Add(24 * time.Hour).Unix(),
+		"sid":       sessionID,
+		"jti":       newJTI(),
+		"exp":       time.Now().Add(h.cfg.AccessTokenTTL).Unix(),
 		"iat":       time.Now().Unix(),
 		"iss":       "carbonv2-api",
 	}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
@@ -39,6 +40,15 @@ func getRule(category, entryType string) emissionRule {
 		return emissionRule{FactorKgPerEUR: 0.6, Scope: "3"}
 	case strings.Contains(key, "train"):
 		return emissionRule{FactorKgPerEUR: 0.1, Scope: "3"}
+	case strings.Contains(key, "gaz naturel") || strings.Contains(key, "natural gas"):
+		// Combustion sur site : 0,227 kgCO2e/kWh PCS (ADEME Base Empreinte, gaz
+		// naturel, mix moyen France, amont compris) pour ~0,12 €/kWh TTC (prix
+		// repère de vente de la CRE, 2024), soit ~1,9 kgCO2e/EUR.
+		// Avant cette règle, ces entrées tombaient sur l'électricité (type
+		// energy, 0,3 scope 2) ou sur le repli (0,25 scope 3) : les émissions
+		// déjà calculées ne sont pas mises à jour, relancer compute-emission
+		// sur les entrées concernées.
+		return emissionRule{FactorKgPerEUR: 1.9, Scope: "1"}
 	case strings.Contains(key, "élec") || strings.Contains(key, "electric") || strings.Contains(t, "energy"):
 		return emissionRule{FactorKgPerEUR: 0.3, Scope: "2"}
 	case strings.Contains(key, "fuel") || strings.Contains(key, "carburant") || strings.Contains(t, "fuel"):
@@ -49,6 +59,47 @@ func getRule(category, entryType string) emissionRule {
 	}
 }
 
+// emissionCategory est une catégorie du catalogue proposée pour la classification
+// des dépenses. Son scope et son facteur sont ceux que getRule appliquera.
+type emissionCategory struct {
+	Name        string
+	Description string
+}
+
+// emissionCategories est le catalogue des catégories reconnues par getRule ;
+// les classifications (IA ou règles) doivent choisir parmi ces libellés.
+var emissionCategories = []emissionCategory{
+	{"Transport - Avion", "billets d'avion, vols professionnels, frais d'agence de voyage aérienne"},
+	{"Transport - Train", "billets de train, SNCF, Eurostar, abonnements ferroviaires"},
+	{"Énergie - Électricité", "factures d'électricité des locaux (EDF, Engie, TotalEnergies...)"},
+	{"Énergie - Gaz naturel", "factures de gaz naturel des locaux (chauffage, process), GRDF, Engie..."},
+	{"Énergie - Carburant", "carburant des véhicules de la flotte, fioul, gazole, essence"},
+	{"Transport - Fret", "transport et livraison de marchandises, messagerie, logistique"},
+	{"Déplacements - Hôtel", "hébergement et restauration lors des déplacements professionnels"},
+	{"Numérique - Cloud", "hébergement cloud, logiciels SaaS, services numériques"},
+	{"Achats - Biens", "fournitures, matières premières, marchandises achetées"},
+	{"Achats - Services", "prestations intellectuelles, conseil, assurance, banque, sous-traitance"},
+	{"Immobilisations - Équipement", "matériel informatique, mobilier, machines, véhicules achetés"},
+	{"Déchets", "collecte et traitement des déchets"},
+}
+
+// findEmissionCategory retrouve une catégorie du catalogue sans tenir compte de
+// la casse ni des variantes de tiret.
+func findEmissionCategory(name string) (emissionCategory, bool) {
+	normalize := func(s string) string {
+		s = strings.ToLower(strings.TrimSpace(s))
+		s = strings.NewReplacer("–", "-", "—", "-").Replace(s)
+		return strings.Join(strings.Fields(s), " ")
+	}
+	n := normalize(name)
+	for _, cat := range emissionCategories {
+		if normalize(cat.Name) == n {
+			return cat, true
+		}
+	}
+	return emissionCategory{}, false
+}
+
 type computeEmissionResponse struct {
 	EntryID    int64   `json:"entry_id"`
 	EmissionID int64   `json:"emission_id"`
@@ -163,14 +214,21 @@ func (h *CarbonHandler) ComputeEmissionForEntry(c *gin.Context) {
 
 type emissionsSummaryResponse struct {
 	TenantID       string             `json:"tenant_id"`
+	Year           *int               `json:"year,omitempty"`
+	SiteID         *int64             `json:"site_id,omitempty"`
+	Consolidation  string             `json:"consolidation,omitempty"`
 	TotalTCO2e     float64            `json:"total_tco2e"`
 	ByScope        map[string]float64 `json:"by_scope"`
 	EntriesCount   int64              `json:"entries_count"`
 	EmissionsCount int64              `json:"emissions_count"`
+	// Ratios d'intensité (tCO2e par MEUR de CA, par FTE, etc.), uniquement sur une année.
+	Intensities map[string]intensityRatio `json:"intensities,omitempty"`
 }
 
-// GET /api/tenants/:tenantId/emissions/summary
-// Retourne un petit résumé multi-tenant des émissions calculées.
+// GET /api/tenants/:tenantId/emissions/summary?year=2024&site_id=3&consolidation=equity
+// Retourne un petit résumé multi-tenant des émissions calculées, éventuellement
+// restreint à une année (date de l'entrée) avec les ratios d'intensité associés,
+// à un site et ses descendants, et consolidé selon l'approche demandée.
 func (h *CarbonHandler) EmissionsSummary(c *gin.Context) {
 	claimsVal, ok := c.Get("user")
 	if !ok {
@@ -203,58 +261,163 @@ func (h *CarbonHandler) EmissionsSummary(c *gin.Context) {
 		return
 	}
 
-	// Agrégation par scope.
-	rows, err := h.db.Query(ctx,
-		`SELECT scope, COALESCE(SUM(tco2e), 0) 
-		 FROM emissions 
-		 WHERE tenant_id = $1 
-		 GROUP BY scope`,
-		tenantIDInt,
-	)
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+	approach, ok := consolidationQuery(c)
+	if !ok {
+		return
+	}
+	siteID, ok := siteIDQuery(c)
+	if !ok {
+		return
+	}
+
+	// Périmètre : tout le tenant, ou un site et ses descendants (drill-down).
+	var factors map[int64]float64
+	var siteIDs []int64
+	if approach != "" || siteID != 0 {
+		sites, err := loadSites(ctx, h.db, tenantIDInt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des sites"})
+			return
+		}
+		if siteID != 0 {
+			if !siteExists(sites, siteID) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "site non trouvé"})
+				return
+			}
+			siteIDs = subtreeIDList(sites, siteID)
+		}
+		factors = consolidationFactors(sites, approach)
+	}
+
+	// Agrégation par site puis par scope, pondérée par le facteur de consolidation.
+	bySite, err := emissionsBySiteScope(ctx, h.db, tenantIDInt, year, siteIDs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
 		return
 	}
-	defer rows.Close()
 
 	byScope := make(map[string]float64)
 	var total float64
-	for rows.Next() {
-		var scope string
-		var sum float64
-		if err := rows.Scan(&scope, &sum); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de l'agrégation des émissions"})
-			return
+	for sid, scopes := range bySite {
+		factor := 1.0
+		if f, ok := factors[sid]; ok {
+			factor = f
+		}
+		for scope, sum := range scopes {
+			byScope[scope] += sum * factor
+			total += sum * factor
 		}
-		byScope[scope] = sum
-		total += sum
 	}
 
 	// Stat basique sur le nombre d'entrées et d'émissions.
 	var entriesCount, emissionsCount int64
 	if err := h.db.QueryRow(ctx,
-		`SELECT COUNT(*) FROM entries WHERE tenant_id = $1`,
+		`SELECT COUNT(*)
+		 FROM entries
+		 WHERE tenant_id = $1
+		   AND ($2 = 0 OR EXTRACT(YEAR FROM date) = $2)
+		   AND ($3::bigint[] IS NULL OR site_id = ANY($3))`,
 		tenantIDInt,
+		year,
+		siteIDs,
 	).Scan(&entriesCount); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors du comptage des entrées"})
 		return
 	}
 
 	if err := h.db.QueryRow(ctx,
-		`SELECT COUNT(*) FROM emissions WHERE tenant_id = $1`,
+		`SELECT COUNT(*)
+		 FROM emissions em
+		 JOIN entries en ON en.id = em.entry_id
+		 WHERE em.tenant_id = $1
+		   AND ($2 = 0 OR EXTRACT(YEAR FROM en.date) = $2)
+		   AND ($3::bigint[] IS NULL OR en.site_id = ANY($3))`,
 		tenantIDInt,
+		year,
+		siteIDs,
 	).Scan(&emissionsCount); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors du comptage des émissions"})
 		return
 	}
 
-	c.JSON(http.StatusOK, emissionsSummaryResponse{
+	resp := emissionsSummaryResponse{
 		TenantID:       pathTenant,
 		TotalTCO2e:     total,
 		ByScope:        byScope,
 		EntriesCount:   entriesCount,
 		EmissionsCount: emissionsCount,
-	})
+		Consolidation:  approach,
+	}
+	if siteID != 0 {
+		resp.SiteID = &siteID
+	}
+
+	if year != 0 {
+		resp.Year = &year
+	}
+	// Les dénominateurs sont saisis au niveau du tenant : pas d'intensité sur un sous-périmètre.
+	if year != 0 && siteID == 0 {
+		dens, err := loadDenominators(ctx, h.db, tenantIDInt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des dénominateurs"})
+			return
+		}
+		resp.Intensities = computeIntensities(total, dens[year])
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+type timeSeriesPoint struct {
+	Year        int                       `json:"year"`
+	TotalTCO2e  float64                   `json:"total_tco2e"`
+	ByScope     map[string]float64        `json:"by_scope"`
+	Intensities map[string]intensityRatio `json:"intensities"`
+}
+
+// GET /api/tenants/:tenantId/emissions/timeseries
+// Série annuelle des émissions (par scope) avec les ratios d'intensité disponibles.
+func (h *CarbonHandler) EmissionsTimeSeries(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	yearly, err := yearlyEmissionsByScope(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+	dens, err := loadDenominators(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des dénominateurs"})
+		return
+	}
+
+	years := make([]int, 0, len(yearly))
+	for y := range yearly {
+		years = append(years, y)
+	}
+	slices.Sort(years)
+
+	series := make([]timeSeriesPoint, 0, len(years))
+	for _, y := range years {
+		p := timeSeriesPoint{Year: y, ByScope: yearly[y]}
+		for _, v := range yearly[y] {
+			p.TotalTCO2e += v
+		}
+		p.Intensities = computeIntensities(p.TotalTCO2e, dens[y])
+		series = append(series, p)
+	}
+
+	c.JSON(http.StatusOK, series)
 }
 
 // GET /api/tenants/:tenantId/emissions
@@ -290,11 +453,23 @@ func (h *CarbonHandler) ListEmissions(c *gin.Context) {
 		return
 	}
 
+	threshold, err := loadMaterialityThreshold(ctx, h.db, tenantIDInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du seuil de matérialité"})
+		return
+	}
+
+	// Les justificatifs rattachés à l'entrée sont renvoyés avec chaque émission
+	// (version courante des documents).
 	rows, err := h.db.Query(ctx,
-		`SELECT id, entry_id, scope, tco2e, computed_at
-		 FROM emissions
-		 WHERE tenant_id = $1
-		 ORDER BY computed_at DESC
+		`SELECT em.id, em.entry_id, em.scope, em.tco2e, em.computed_at,
+		        ARRAY(SELECT DISTINCT cur.id FROM entry_documents ed
+		              JOIN documents d ON d.id = ed.document_id
+		              JOIN documents cur ON COALESCE(cur.version_of, cur.id) = COALESCE(d.version_of, d.id) AND cur.superseded_by IS NULL
+		              WHERE ed.entry_id = em.entry_id AND cur.deleted_at IS NULL ORDER BY cur.id)
+		 FROM emissions em
+		 WHERE em.tenant_id = $1
+		 ORDER BY em.computed_at DESC
 		 LIMIT 100`,
 		tenantIDInt,
 	)
@@ -310,18 +485,54 @@ func (h *CarbonHandler) ListEmissions(c *gin.Context) {
 		var scope string
 		var tco2e float64
 		var computedAt time.Time
-		err := rows.Scan(&id, &entryID, &scope, &tco2e, &computedAt)
+		var evidence []int64
+		err := rows.Scan(&id, &entryID, &scope, &tco2e, &computedAt, &evidence)
 		if err != nil {
 			continue
 		}
+		material := threshold != nil && tco2e >= *threshold
 		emissions = append(emissions, map[string]interface{}{
-			"id":          id,
-			"entry_id":    entryID,
-			"scope":       scope,
-			"tco2e":       tco2e,
-			"computed_at": computedAt.Format(time.RFC3339),
+			"id":                    id,
+			"entry_id":              entryID,
+			"scope":                 scope,
+			"tco2e":                 tco2e,
+			"computed_at":           computedAt.Format(time.RFC3339),
+			"evidence_document_ids": evidence,
+			"material":              material,
+			"missing_evidence":      material && len(evidence) == 0,
 		})
 	}
 
 	c.JSON(http.StatusOK, emissions)
 }
+
+// yearlyEmissionsByScope agrège les émissions d'un tenant par année (date de l'entrée) et par scope.
+func yearlyEmissionsByScope(ctx context.Context, db *pgxpool.Pool, tenantID int64) (map[int]map[string]float64, error) {
+	rows, err := db.Query(ctx,
+		`SELECT EXTRACT(YEAR FROM en.date)::int, em.scope, COALESCE(SUM(em.tco2e), 0)
+		 FROM emissions em
+		 JOIN entries en ON en.id = em.entry_id
+		 WHERE em.tenant_id = $1
+		 GROUP BY 1, 2`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]map[string]float64)
+	for rows.Next() {
+		var year int
+		var scope string
+		var sum float64
+		if err := rows.Scan(&year, &scope, &sum); err != nil {
+			return nil, err
+		}
+		if out[year] == nil {
+			out[year] = make(map[string]float64)
+		}
+		out[year][scope] = sum
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// CategorizationHandler gère la catégorisation automatique des entrées sans
+// catégorie : règles déterministes puis IA par lots, sous forme de suggestions
+// à valider.
+type CategorizationHandler struct {
+	db      *pgxpool.Pool
+	mistral *MistralClient
+}
+
+func NewCategorizationHandler(db *pgxpool.Pool, cfg Config) *CategorizationHandler {
+	return &CategorizationHandler{db: db, mistral: NewMistralClient(cfg)}
+}
+
+// Nombre d'entrées envoyées à l'IA par requête.
+const categorizationBatchSize = 20
+
+// POST /api/tenants/:tenantId/categorization-jobs
+// Lance la catégorisation en arrière-plan et renvoie le job à suivre.
+func (h *CategorizationHandler) StartJob(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Un job resté « running » plus d'une heure (redémarrage du serveur) est
+	// clos pour ne plus bloquer le tenant.
+	_, err := h.db.Exec(ctx,
+		`UPDATE categorization_jobs
+		 SET status = 'failed', error = 'interrompu (redémarrage du serveur)', finished_at = now()
+		 WHERE tenant_id = $1 AND status = 'running' AND created_at <= now() - interval '1 hour'`,
+		tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification des jobs"})
+		return
+	}
+
+	// Un seul job à la fois par tenant : garanti par l'index unique partiel
+	// categorization_jobs_running, même pour deux requêtes simultanées.
+	job, err := scanCategorizationJob(h.db.QueryRow(ctx,
+		`INSERT INTO categorization_jobs (tenant_id) VALUES ($1)
+		 RETURNING `+categorizationJobColumns,
+		tenantID,
+	))
+	if err != nil {
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "une catégorisation est déjà en cours pour ce tenant"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer le job", "details": err.Error()})
+		return
+	}
+
+	go h.runJob(job.ID, tenantID)
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GET /api/tenants/:tenantId/categorization-jobs
+func (h *CategorizationHandler) ListJobs(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT `+categorizationJobColumns+`
+		 FROM categorization_jobs
+		 WHERE tenant_id = $1
+		 ORDER BY created_at DESC
+		 LIMIT 20`,
+		tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des jobs"})
+		return
+	}
+	defer rows.Close()
+
+	jobs := []CategorizationJob{}
+	for rows.Next() {
+		job, err := scanCategorizationJob(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des jobs"})
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	c.JSON(http.StatusOK, jobs)
+}
+
+// GET /api/tenants/:tenantId/categorization-jobs/:jobId
+func (h *CategorizationHandler) GetJob(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	jobID, ok := int64Param(c, "jobId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	job, err := scanCategorizationJob(h.db.QueryRow(ctx,
+		`SELECT `+categorizationJobColumns+`
+		 FROM categorization_jobs
+		 WHERE id = $1 AND tenant_id = $2`,
+		jobID, tenantID,
+	))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "job non trouvé"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// runJob exécute la catégorisation. Les compteurs du job sont mis à jour à la fin,
+// ou le job est marqué en échec avec le message d'erreur.
+func (h *CategorizationHandler) runJob(jobID, tenantID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+
+	job := CategorizationJob{ID: jobID}
+	err := h.categorize(ctx, tenantID, &job)
+
+	status := "done"
+	var errMsg *string
+	if err != nil {
+		log.Printf("catégorisation job %d (tenant %d) : %v", jobID, tenantID, err)
+		status = "failed"
+		msg := err.Error()
+		errMsg = &msg
+	}
+
+	// Contexte distinct : le statut doit être enregistré même si le délai du job est dépassé.
+	updateCtx, updateCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer updateCancel()
+	_, err = h.db.Exec(updateCtx,
+		`UPDATE categorization_jobs
+		 SET status = $2, total_entries = $3, rule_matches = $4, llm_matches = $5, unmatched = $6,
+		     error = $7, finished_at = now()
+		 WHERE id = $1`,
+		jobID, status, job.TotalEntries, job.RuleMatches, job.LLMMatches, job.Unmatched, errMsg,
+	)
+	if err != nil {
+		log.Printf("catégorisation job %d : mise à jour du statut impossible : %v", jobID, err)
+	}
+}
+
+func (h *CategorizationHandler) categorize(ctx context.Context, tenantID int64, job *CategorizationJob) error {
+	entries, err := loadUncategorizedEntries(ctx, h.db, tenantID)
+	if err != nil {
+		return err
+	}
+	job.TotalEntries = len(entries)
+	rules, err := loadTenantRules(ctx, h.db, tenantID)
+	if err != nil {
+		return err
+	}
+
+	rejected, err := loadRejectedCategories(ctx, h.db, tenantID)
+	if err != nil {
+		return err
+	}
+
+	// 1. Règles du tenant, puis règles génériques. Une catégorie déjà refusée
+	// pour l'entrée n'est pas reproposée : l'entrée passe à l'IA.
+	var rest []entryToClassify
+	hits := make(map[int64]int)
+	for _, e := range entries {
+		m, ruleID, ok := matchRules(rules, rejected[e.ID], e)
+		if !ok {
+			rest = append(rest, e)
+			continue
+		}
+		if ruleID != 0 {
+			hits[ruleID]++
+		}
+		s := CategorySuggestion{
+			EntryID:    e.ID,
+			Category:   m.Category,
+			Origin:     "rule",
+			MatchedBy:  &m.MatchedBy,
+			Confidence: m.Confidence,
+		}
+		if err := h.insertSuggestion(ctx, tenantID, job.ID, s); err != nil {
+			return err
+		}
+		job.RuleMatches++
+	}
+	recordRuleHits(ctx, h.db, hits)
+
+	// 2. IA par lots pour le reste ; un lot en échec n'interrompt pas le job.
+	if !h.mistral.enabled() {
+		job.Unmatched = len(rest)
+		return nil
+	}
+	for start := 0; start < len(rest); start += categorizationBatchSize {
+		batch := rest[start:min(start+categorizationBatchSize, len(rest))]
+		results, err := h.mistral.classifyBatch(batch)
+		if err != nil {
+			log.Printf("catégorisation job %d : lot %d ignoré : %v", job.ID, start/categorizationBatchSize, err)
+		}
+		for _, e := range batch {
+			cl, ok := results[e.ID]
+			if !ok || rejected[e.ID][cl.Category] {
+				job.Unmatched++
+				continue
+			}
+			reason := cl.Reason
+			s := CategorySuggestion{
+				EntryID:    e.ID,
+				Category:   cl.Category,
+				Origin:     "llm",
+				Reason:     &reason,
+				Confidence: cl.Confidence,
+			}
+			if err := h.insertSuggestion(ctx, tenantID, job.ID, s); err != nil {
+				return err
+			}
+			job.LLMMatches++
+		}
+	}
+	return nil
+}
+
+// matchRules applique les règles du tenant puis les règles génériques, sauf si
+// la catégorie trouvée a déjà été refusée pour l'entrée. ruleID vaut 0 pour
+// une règle générique.
+func matchRules(rules []tenantRule, rejected map[string]bool, e entryToClassify) (m categoryMatch, ruleID int64, ok bool) {
+	if r, found := matchTenantRules(rules, e); found {
+		m = categoryMatch{Category: r.Category, MatchedBy: "tenant_rule:" + strconv.FormatInt(r.ID, 10), Confidence: 0.95}
+		ruleID = r.ID
+	} else if m, found = matchBuiltinRules(e); !found {
+		return categoryMatch{}, 0, false
+	}
+	if rejected[m.Category] {
+		return categoryMatch{}, 0, false
+	}
+	return m, ruleID, true
+}
+
+// loadRejectedCategories renvoie, par entrée, les catégories déjà refusées.
+func loadRejectedCategories(ctx context.Context, db *pgxpool.Pool, tenantID int64) (map[int64]map[string]bool, error) {
+	rows, err := db.Query(ctx,
+		`SELECT entry_id, category FROM category_suggestions
+		 WHERE tenant_id = $1 AND status = 'rejected'`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rejected := make(map[int64]map[string]bool)
+	for rows.Next() {
+		var entryID int64
+		var category string
+		if err := rows.Scan(&entryID, &category); err != nil {
+			return nil, err
+		}
+		if rejected[entryID] == nil {
+			rejected[entryID] = make(map[string]bool)
+		}
+		rejected[entryID][category] = true
+	}
+	return rejected, rows.Err()
+}
+
+// insertSuggestion enregistre une suggestion, sauf si l'entrée en a déjà une en attente.
+func (h *CategorizationHandler) insertSuggestion(ctx context.Context, tenantID, jobID int64, s CategorySuggestion) error {
+	_, err := h.db.Exec(ctx,
+		`INSERT INTO category_suggestions (tenant_id, job_id, entry_id, category, scope, origin, matched_by, reason, confidence)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		 ON CONFLICT (entry_id) WHERE status = 'pending' DO NOTHING`,
+		tenantID, jobID, s.EntryID, s.Category, getRule(s.Category, "").Scope, s.Origin, s.MatchedBy, s.Reason, s.Confidence,
+	)
+	return err
+}
+
+// GET /api/tenants/:tenantId/category-suggestions?status=pending&job_id=12
+func (h *CategorizationHandler) ListSuggestions(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	status := c.DefaultQuery("status", "pending")
+	if status != "pending" && status != "accepted" && status != "rejected" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status doit valoir 'pending', 'accepted' ou 'rejected'"})
+		return
+	}
+	var jobID int64
+	if raw := c.Query("job_id"); raw != "" {
+		id, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "job_id invalide"})
+			return
+		}
+		jobID = id
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT `+categorySuggestionColumns+`
+		 FROM category_suggestions
+		 WHERE tenant_id = $1 AND status = $2 AND ($3 = 0 OR job_id = $3)
+		 ORDER BY confidence DESC, id`,
+		tenantID, status, jobID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des suggestions"})
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []CategorySuggestion{}
+	for rows.Next() {
+		s, err := scanCategorySuggestion(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des suggestions"})
+			return
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	c.JSON(http.StatusOK, suggestions)
+}
+
+type acceptSuggestionRequest struct {
+	// Catégorie corrigée (facultative) ; par défaut la catégorie suggérée est appliquée.
+	Category string `json:"category"`
+}
+
+// POST /api/tenants/:tenantId/category-suggestions/:suggestionId/accept
+// Applique la catégorie suggérée (ou corrigée) à l'entrée.
+func (h *CategorizationHandler) AcceptSuggestion(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	suggestionID, ok := int64Param(c, "suggestionId")
+	if !ok {
+		return
+	}
+
+	var req acceptSuggestionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+			return
+		}
+	}
+	var corrected *string
+	if req.Category != "" {
+		cat, ok := findEmissionCategory(req.Category)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "catégorie inconnue du catalogue"})
+			return
+		}
+		corrected = &cat.Name
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	s, err := scanCategorySuggestion(tx.QueryRow(ctx,
+		`SELECT `+categorySuggestionColumns+`
+		 FROM category_suggestions
+		 WHERE id = $1 AND tenant_id = $2 AND status = 'pending'
+		 FOR UPDATE`,
+		suggestionID, tenantID,
+	))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "suggestion en attente non trouvée"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de la suggestion"})
+		return
+	}
+
+	category := s.Category
+	if corrected != nil {
+		category = *corrected
+	}
+	if _, err := tx.Exec(ctx,
+		`UPDATE entries SET category = $3 WHERE id = $1 AND tenant_id = $2`,
+		s.EntryID, tenantID, category,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour l'entrée"})
+		return
+	}
+
+	s, err = scanCategorySuggestion(tx.QueryRow(ctx,
+		`UPDATE category_suggestions
+		 SET status = 'accepted', accepted_category = $2, reviewed_at = now()
+		 WHERE id = $1
+		 RETURNING `+categorySuggestionColumns,
+		suggestionID, corrected,
+	))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la suggestion"})
+		return
+	}
+
+	// Une suggestion de l'IA validée, ou une correction, devient une règle du tenant
+	// pour que les prochaines lignes du même fournisseur soient classées sans revue.
+	learned := false
+	if corrected != nil || s.Origin == "llm" {
+		e, err := scanEntryToClassify(tx.QueryRow(ctx,
+			`SELECT `+entryToClassifyColumns+` FROM entries e WHERE e.id = $1 AND e.tenant_id = $2`,
+			s.EntryID, tenantID,
+		))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de l'entrée"})
+			return
+		}
+		if learned, err = learnRule(ctx, tx, tenantID, e, category); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer la règle apprise"})
+			return
+		}
+	}
+
+	// Les émissions déjà calculées pour l'entrée l'ont été avec l'ancienne catégorie.
+	var stale bool
+	if err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM emissions WHERE entry_id = $1)`, s.EntryID).Scan(&stale); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la suggestion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"suggestion": s, "recompute_required": stale, "rule_learned": learned})
+}
+
+// POST /api/tenants/:tenantId/category-suggestions/:suggestionId/reject
+func (h *CategorizationHandler) RejectSuggestion(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	suggestionID, ok := int64Param(c, "suggestionId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	s, err := scanCategorySuggestion(h.db.QueryRow(ctx,
+		`UPDATE category_suggestions
+		 SET status = 'rejected', reviewed_at = now()
+		 WHERE id = $1 AND tenant_id = $2 AND status = 'pending'
+		 RETURNING `+categorySuggestionColumns,
+		suggestionID, tenantID,
+	))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "suggestion en attente non trouvée"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de rejeter la suggestion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+const categorizationJobColumns = `id, tenant_id, status, total_entries, rule_matches, llm_matches, unmatched, error, created_at, finished_at`
+
+func scanCategorizationJob(row pgx.Row) (CategorizationJob, error) {
+	var j CategorizationJob
+	err := row.Scan(&j.ID, &j.TenantID, &j.Status, &j.TotalEntries, &j.RuleMatches, &j.LLMMatches, &j.Unmatched, &j.Error, &j.CreatedAt, &j.FinishedAt)
+	return j, err
+}
+
+const categorySuggestionColumns = `id, tenant_id, job_id, entry_id, category, scope, origin, matched_by, reason, confidence, status, accepted_category, created_at, reviewed_at`
+
+func scanCategorySuggestion(row pgx.Row) (CategorySuggestion, error) {
+	var s CategorySuggestion
+	err := row.Scan(&s.ID, &s.TenantID, &s.JobID, &s.EntryID, &s.Category, &s.Scope, &s.Origin, &s.MatchedBy, &s.Reason,
+		&s.Confidence, &s.Status, &s.AcceptedCategory, &s.CreatedAt, &s.ReviewedAt)
+	return s, err
+}
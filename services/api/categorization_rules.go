@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Règles de catégorisation propres à chaque tenant. Elles sont évaluées avant les
+// règles génériques et avant toute requête à l'IA, à l'import comme lors des jobs
+// de catégorisation.
+
+const (
+	ruleMatchVendor     = "vendor"
+	ruleMatchLabelRegex = "label_regex"
+	ruleMatchAccount    = "account"
+
+	manualRulePriority  = 100
+	learnedRulePriority = 50
+)
+
+// tenantRule est une règle prête à être évaluée (expression régulière compilée).
+type tenantRule struct {
+	CategorizationRule
+	re *regexp.Regexp
+}
+
+// matches indique si la règle s'applique à l'entrée.
+func (r tenantRule) matches(e entryToClassify) bool {
+	switch r.MatchType {
+	case ruleMatchVendor:
+		key := " " + r.Pattern + " "
+		return strings.Contains(normalizeWords(e.Vendor), key) || strings.Contains(normalizeWords(e.Label), key)
+	case ruleMatchLabelRegex:
+		return r.re != nil && r.re.MatchString(e.Label)
+	case ruleMatchAccount:
+		return strings.HasPrefix(digitsOnly(e.Account), r.Pattern)
+	default:
+		return false
+	}
+}
+
+// normalizeRulePattern valide et normalise un motif selon son type.
+// Renvoie un message d'erreur non vide si le motif est invalide.
+func normalizeRulePattern(matchType, pattern string) (string, string) {
+	switch matchType {
+	case ruleMatchVendor:
+		p := strings.TrimSpace(normalizeWords(pattern))
+		if p == "" {
+			return "", "pattern doit contenir un nom de fournisseur"
+		}
+		return p, ""
+	case ruleMatchLabelRegex:
+		p := strings.TrimSpace(pattern)
+		if p == "" || len(p) > 500 {
+			return "", "pattern doit être une expression régulière de 1 à 500 caractères"
+		}
+		if _, err := regexp.Compile("(?i)" + p); err != nil {
+			return "", "expression régulière invalide : " + err.Error()
+		}
+		return p, ""
+	case ruleMatchAccount:
+		p := digitsOnly(pattern)
+		if p == "" {
+			return "", "pattern doit être un numéro (ou préfixe) de compte"
+		}
+		return p, ""
+	default:
+		return "", "match_type doit valoir 'vendor', 'label_regex' ou 'account'"
+	}
+}
+
+// loadTenantRules charge les règles du tenant par priorité décroissante.
+func loadTenantRules(ctx context.Context, db *pgxpool.Pool, tenantID int64) ([]tenantRule, error) {
+	rows, err := db.Query(ctx,
+		`SELECT `+categorizationRuleColumns+`
+		 FROM categorization_rules
+		 WHERE tenant_id = $1
+		 ORDER BY priority DESC, id`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rules []tenantRule
+	for rows.Next() {
+		r, err := scanCategorizationRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		tr := tenantRule{CategorizationRule: r}
+		if r.MatchType == ruleMatchLabelRegex {
+			if tr.re, err = regexp.Compile("(?i)" + r.Pattern); err != nil {
+				continue
+			}
+		}
+		rules = append(rules, tr)
+	}
+	return rules, rows.Err()
+}
+
+// matchTenantRules renvoie la première règle (par priorité) qui s'applique à l'entrée.
+func matchTenantRules(rules []tenantRule, e entryToClassify) (tenantRule, bool) {
+	for _, r := range rules {
+		if r.matches(e) {
+			return r, true
+		}
+	}
+	return tenantRule{}, false
+}
+
+// recordRuleHits incrémente les compteurs d'utilisation des règles appliquées.
+func recordRuleHits(ctx context.Context, db *pgxpool.Pool, hits map[int64]int) {
+	for id, n := range hits {
+		if _, err := db.Exec(ctx,
+			`UPDATE categorization_rules SET hits = hits + $2, last_matched_at = now() WHERE id = $1`,
+			id, n,
+		); err != nil {
+			log.Printf("règle de catégorisation %d : mise à jour du compteur impossible : %v", id, err)
+		}
+	}
+}
+
+// learnRule crée (ou met à jour) une règle apprise à partir d'une entrée dont la
+// catégorie vient d'être validée : sur le fournisseur s'il est connu, sinon sur
+// le libellé exact. Une règle manuelle existante n'est jamais écrasée.
+func learnRule(ctx context.Context, tx pgx.Tx, tenantID int64, e entryToClassify, category string) (bool, error) {
+	matchType, pattern := ruleMatchVendor, strings.TrimSpace(normalizeWords(e.Vendor))
+	if pattern == "" {
+		label := strings.TrimSpace(e.Label)
+		if label == "" {
+			return false, nil
+		}
+		matchType, pattern = ruleMatchLabelRegex, "^"+regexp.QuoteMeta(label)+"$"
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO categorization_rules (tenant_id, match_type, pattern, category, priority, origin)
+		 VALUES ($1,$2,$3,$4,$5,'learned')
+		 ON CONFLICT (tenant_id, match_type, pattern) DO UPDATE
+		 SET category = EXCLUDED.category, updated_at = now()
+		 WHERE categorization_rules.origin = 'learned'`,
+		tenantID, matchType, pattern, category, learnedRulePriority,
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+type categorizationRuleRequest struct {
+	MatchType string `json:"match_type" binding:"required"`
+	Pattern   string `json:"pattern" binding:"required"`
+	Category  string `json:"category" binding:"required"`
+	Priority  *int   `json:"priority"`
+}
+
+// validate normalise la requête ; renvoie un message d'erreur non vide si elle est invalide.
+func (r *categorizationRuleRequest) validate() string {
+	pattern, msg := normalizeRulePattern(r.MatchType, r.Pattern)
+	if msg != "" {
+		return msg
+	}
+	r.Pattern = pattern
+	cat, ok := findEmissionCategory(r.Category)
+	if !ok {
+		return "catégorie inconnue du catalogue"
+	}
+	r.Category = cat.Name
+	if r.Priority == nil {
+		p := manualRulePriority
+		r.Priority = &p
+	}
+	return ""
+}
+
+// POST /api/tenants/:tenantId/categorization-rules
+func (h *CategorizationHandler) CreateRule(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req categorizationRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Une règle manuelle remplace une règle apprise sur le même motif.
+	r, err := scanCategorizationRule(h.db.QueryRow(ctx,
+		`INSERT INTO categorization_rules (tenant_id, match_type, pattern, category, priority, origin)
+		 VALUES ($1,$2,$3,$4,$5,'manual')
+		 ON CONFLICT (tenant_id, match_type, pattern) DO UPDATE
+		 SET category = EXCLUDED.category, priority = EXCLUDED.priority, origin = 'manual', updated_at = now()
+		 WHERE categorization_rules.origin = 'learned'
+		 RETURNING `+categorizationRuleColumns,
+		tenantID, req.MatchType, req.Pattern, req.Category, *req.Priority,
+	))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusConflict, gin.H{"error": "une règle manuelle existe déjà pour ce motif"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer la règle", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, r)
+}
+
+// GET /api/tenants/:tenantId/categorization-rules
+func (h *CategorizationHandler) ListRules(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rules, err := loadTenantRules(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des règles"})
+		return
+	}
+
+	out := make([]CategorizationRule, 0, len(rules))
+	for _, r := range rules {
+		out = append(out, r.CategorizationRule)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// PUT /api/tenants/:tenantId/categorization-rules/:ruleId
+// Une règle apprise modifiée devient manuelle.
+func (h *CategorizationHandler) UpdateRule(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	ruleID, ok := int64Param(c, "ruleId")
+	if !ok {
+		return
+	}
+
+	var req categorizationRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	r, err := scanCategorizationRule(h.db.QueryRow(ctx,
+		`UPDATE categorization_rules
+		 SET match_type = $3, pattern = $4, category = $5, priority = $6, origin = 'manual', updated_at = now()
+		 WHERE id = $1 AND tenant_id = $2
+		 RETURNING `+categorizationRuleColumns,
+		ruleID, tenantID, req.MatchType, req.Pattern, req.Category, *req.Priority,
+	))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "règle non trouvée"})
+			return
+		}
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "une règle existe déjà pour ce motif"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour la règle", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, r)
+}
+
+// DELETE /api/tenants/:tenantId/categorization-rules/:ruleId
+func (h *CategorizationHandler) DeleteRule(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	ruleID, ok := int64Param(c, "ruleId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`DELETE FROM categorization_rules WHERE id = $1 AND tenant_id = $2`,
+		ruleID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer la règle"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "règle non trouvée"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type testRulesRequest struct {
+	Type    string `json:"type"`
+	Label   string `json:"label"`
+	Vendor  string `json:"vendor"`
+	Account string `json:"account"`
+}
+
+// POST /api/tenants/:tenantId/categorization-rules/test
+// Indique quelle règle serait appliquée à une ligne, sans rien enregistrer.
+func (h *CategorizationHandler) TestRules(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req testRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rules, err := loadTenantRules(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des règles"})
+		return
+	}
+
+	e := entryToClassify{Type: req.Type, Label: req.Label, Vendor: req.Vendor, Account: req.Account}
+	if r, ok := matchTenantRules(rules, e); ok {
+		c.JSON(http.StatusOK, gin.H{"matched": true, "source": "tenant_rule", "category": r.Category, "rule": r.CategorizationRule})
+		return
+	}
+	if m, ok := matchBuiltinRules(e); ok {
+		c.JSON(http.StatusOK, gin.H{"matched": true, "source": "builtin_rule", "category": m.Category, "matched_by": m.MatchedBy})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"matched": false, "source": "none"})
+}
+
+const categorizationRuleColumns = `id, tenant_id, match_type, pattern, category, priority, origin, hits, last_matched_at, created_at, updated_at`
+
+func scanCategorizationRule(row pgx.Row) (CategorizationRule, error) {
+	var r CategorizationRule
+	err := row.Scan(&r.ID, &r.TenantID, &r.MatchType, &r.Pattern, &r.Category, &r.Priority, &r.Origin, &r.Hits, &r.LastMatchedAt, &r.CreatedAt, &r.UpdatedAt)
+	return r, err
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestMatchRules(t *testing.T) {
+	rules := []tenantRule{
+		{CategorizationRule: CategorizationRule{ID: 7, MatchType: ruleMatchVendor, Pattern: "ovh", Category: "Achats - Services"}},
+	}
+	ovh := entryToClassify{ID: 1, Vendor: "OVH SAS", Label: "hébergement serveurs"}
+	sncf := entryToClassify{ID: 2, Vendor: "SNCF", Label: "billet Paris-Lyon"}
+	unknown := entryToClassify{ID: 3, Label: "divers"}
+
+	tests := []struct {
+		name     string
+		entry    entryToClassify
+		rejected map[string]bool
+		category string
+		ruleID   int64
+	}{
+		{"règle du tenant prioritaire", ovh, nil, "Achats - Services", 7},
+		{"règle générique", sncf, nil, "Transport - Train", 0},
+		{"aucune règle", unknown, nil, "", 0},
+		{"catégorie du tenant déjà refusée", ovh, map[string]bool{"Achats - Services": true}, "", 0},
+		{"catégorie générique déjà refusée", sncf, map[string]bool{"Transport - Train": true}, "", 0},
+		{"autre catégorie refusée", sncf, map[string]bool{"Transport - Avion": true}, "Transport - Train", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, ruleID, ok := matchRules(rules, tt.rejected, tt.entry)
+			if ok != (tt.category != "") || m.Category != tt.category || ruleID != tt.ruleID {
+				t.Errorf("matchRules = %q (règle %d), %v ; attendu %q (règle %d)", m.Category, ruleID, ok, tt.category, tt.ruleID)
+			}
+		})
+	}
+}
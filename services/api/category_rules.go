@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"unicode"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Règles déterministes de catégorisation, appliquées avant l'IA : compte du plan
+// comptable général (PCG), fournisseur connu, puis mots-clés du libellé.
+
+// entryToClassify rassemble les informations d'une entrée utiles à sa catégorisation.
+type entryToClassify struct {
+	ID       int64
+	Type     string
+	Amount   float64
+	Currency string
+	Label    string // libellé : metadata label/libelle/description, sinon source
+	Vendor   string // metadata vendor/fournisseur/supplier
+	Account  string // metadata account/compte (numéro de compte PCG)
+}
+
+// categoryMatch est une catégorie trouvée par une règle.
+type categoryMatch struct {
+	Category   string
+	MatchedBy  string // ex: "account:6061", "vendor:sncf", "keyword:hôtel"
+	Confidence float64
+}
+
+// pcgAccountRules associe des préfixes de comptes PCG à une catégorie ; le
+// préfixe le plus long l'emporte.
+var pcgAccountRules = map[string]string{
+	"60614": "Énergie - Carburant",
+	"60622": "Énergie - Carburant",
+	"6061":  "Énergie - Électricité",
+	"601":   "Achats - Biens",
+	"602":   "Achats - Biens",
+	"6063":  "Achats - Biens",
+	"6064":  "Achats - Biens",
+	"607":   "Achats - Biens",
+	"611":   "Achats - Services",
+	"616":   "Achats - Services",
+	"622":   "Achats - Services",
+	"627":   "Achats - Services",
+	"6241":  "Transport - Fret",
+	"6242":  "Transport - Fret",
+	"6256":  "Déplacements - Hôtel",
+	"651":   "Numérique - Cloud",
+	"215":   "Immobilisations - Équipement",
+	"218":   "Immobilisations - Équipement",
+}
+
+// vendorRules associe des fournisseurs courants (mots entiers) à une catégorie.
+var vendorRules = []struct {
+	Vendor   string
+	Category string
+}{
+	{"air france", "Transport - Avion"}, {"easyjet", "Transport - Avion"}, {"ryanair", "Transport - Avion"},
+	{"transavia", "Transport - Avion"}, {"lufthansa", "Transport - Avion"}, {"volotea", "Transport - Avion"},
+	{"sncf", "Transport - Train"}, {"ouigo", "Transport - Train"}, {"eurostar", "Transport - Train"},
+	{"trainline", "Transport - Train"}, {"tgv", "Transport - Train"}, {"ter", "Transport - Train"},
+	{"edf", "Énergie - Électricité"}, {"enercoop", "Énergie - Électricité"}, {"ekwateur", "Énergie - Électricité"},
+	{"engie", "Énergie - Électricité"}, {"enedis", "Énergie - Électricité"},
+	{"grdf", "Énergie - Gaz naturel"},
+	{"shell", "Énergie - Carburant"}, {"esso", "Énergie - Carburant"}, {"bp", "Énergie - Carburant"},
+	{"avia", "Énergie - Carburant"}, {"as24", "Énergie - Carburant"},
+	{"aws", "Numérique - Cloud"}, {"amazon web services", "Numérique - Cloud"}, {"ovh", "Numérique - Cloud"},
+	{"ovhcloud", "Numérique - Cloud"}, {"scaleway", "Numérique - Cloud"}, {"google cloud", "Numérique - Cloud"},
+	{"azure", "Numérique - Cloud"}, {"microsoft 365", "Numérique - Cloud"}, {"salesforce", "Numérique - Cloud"},
+	{"accor", "Déplacements - Hôtel"}, {"ibis", "Déplacements - Hôtel"}, {"novotel", "Déplacements - Hôtel"},
+	{"booking com", "Déplacements - Hôtel"}, {"airbnb", "Déplacements - Hôtel"}, {"b b hotels", "Déplacements - Hôtel"},
+	{"dhl", "Transport - Fret"}, {"ups", "Transport - Fret"}, {"fedex", "Transport - Fret"},
+	{"chronopost", "Transport - Fret"}, {"colissimo", "Transport - Fret"}, {"geodis", "Transport - Fret"},
+	{"veolia", "Déchets"}, {"suez", "Déchets"}, {"paprec", "Déchets"},
+}
+
+// keywordRules associe des mots-clés du libellé ou du type d'entrée à une catégorie.
+// Un mot-clé correspond au début d'un mot (« déchet » couvre « déchets ») ; un
+// espace final impose le mot entier.
+var keywordRules = []struct {
+	Keywords []string
+	Category string
+}{
+	{[]string{"avion", "billet d avion", "aérien", "aerien", "flight", "airline"}, "Transport - Avion"},
+	{[]string{"train ", "trains ", "ferroviaire", "railway"}, "Transport - Train"},
+	{[]string{"électricité", "electricite", "electricity", "kwh"}, "Énergie - Électricité"},
+	{[]string{"gaz naturel", "natural gas", "pce "}, "Énergie - Gaz naturel"},
+	{[]string{"carburant", "gazole", "diesel", "essence", "fioul", "fuel"}, "Énergie - Carburant"},
+	{[]string{"hôtel", "hotel", "hébergement", "hebergement", "nuitée"}, "Déplacements - Hôtel"},
+	{[]string{"cloud", "saas", "logiciel", "software", "abonnement informatique"}, "Numérique - Cloud"},
+	{[]string{"fret", "livraison", "messagerie", "transport de marchandises", "logistique"}, "Transport - Fret"},
+	{[]string{"déchet", "dechet", "recyclage", "collecte"}, "Déchets"},
+	{[]string{"ordinateur", "laptop", "mobilier", "machine", "équipement", "equipement"}, "Immobilisations - Équipement"},
+	{[]string{"honoraires", "conseil", "assurance", "prestation", "sous traitance", "frais bancaires"}, "Achats - Services"},
+	{[]string{"fournitures", "matières premières", "marchandises"}, "Achats - Biens"},
+}
+
+// matchBuiltinRules applique les règles dans l'ordre : compte, fournisseur, mots-clés.
+func matchBuiltinRules(e entryToClassify) (categoryMatch, bool) {
+	if account := digitsOnly(e.Account); account != "" {
+		best := ""
+		for prefix := range pcgAccountRules {
+			if strings.HasPrefix(account, prefix) && len(prefix) > len(best) {
+				best = prefix
+			}
+		}
+		if best != "" {
+			return categoryMatch{Category: pcgAccountRules[best], MatchedBy: "account:" + best, Confidence: 0.9}, true
+		}
+	}
+
+	vendor := normalizeWords(e.Vendor)
+	label := normalizeWords(e.Label)
+	for _, r := range vendorRules {
+		if strings.Contains(vendor, " "+r.Vendor+" ") {
+			return categoryMatch{Category: r.Category, MatchedBy: "vendor:" + r.Vendor, Confidence: 0.85}, true
+		}
+	}
+	for _, r := range vendorRules {
+		if strings.Contains(label, " "+r.Vendor+" ") {
+			return categoryMatch{Category: r.Category, MatchedBy: "vendor:" + r.Vendor, Confidence: 0.8}, true
+		}
+	}
+
+	text := normalizeWords(e.Label + " " + e.Vendor + " " + e.Type)
+	for _, r := range keywordRules {
+		for _, k := range r.Keywords {
+			if strings.Contains(text, " "+k) {
+				return categoryMatch{Category: r.Category, MatchedBy: "keyword:" + strings.TrimSpace(k), Confidence: 0.7}, true
+			}
+		}
+	}
+	return categoryMatch{}, false
+}
+
+// normalizeWords met un texte en minuscules, remplace la ponctuation par des
+// espaces et l'entoure d'espaces, pour des recherches de mots entiers.
+func normalizeWords(s string) string {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return " " + strings.Join(fields, " ") + " "
+}
+
+func digitsOnly(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// entryToClassifyColumns projette une entrée (alias e) sur les champs d'entryToClassify.
+const entryToClassifyColumns = `e.id, e.type, e.amount, e.currency,
+		COALESCE(e.metadata->>'label', e.metadata->>'libelle', e.metadata->>'description', e.source, ''),
+		COALESCE(e.metadata->>'vendor', e.metadata->>'fournisseur', e.metadata->>'supplier', ''),
+		COALESCE(e.metadata->>'account', e.metadata->>'compte', '')`
+
+// loadUncategorizedEntries charge les entrées sans catégorie ni suggestion en attente.
+func loadUncategorizedEntries(ctx context.Context, db *pgxpool.Pool, tenantID int64) ([]entryToClassify, error) {
+	rows, err := db.Query(ctx,
+		`SELECT `+entryToClassifyColumns+`
+		 FROM entries e
+		 WHERE e.tenant_id = $1
+		   AND COALESCE(TRIM(e.category), '') = ''
+		   AND NOT EXISTS (
+		       SELECT 1 FROM category_suggestions s
+		       WHERE s.entry_id = e.id AND s.status = 'pending'
+		   )
+		 ORDER BY e.id`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []entryToClassify
+	for rows.Next() {
+		e, err := scanEntryToClassify(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func scanEntryToClassify(row pgx.Row) (entryToClassify, error) {
+	var e entryToClassify
+	err := row.Scan(&e.ID, &e.Type, &e.Amount, &e.Currency, &e.Label, &e.Vendor, &e.Account)
+	return e, err
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pré-remplissage du questionnaire CDP Climate Change (modules C4, C5, C6, C7).
+// Chaque question est restituée sous forme de tableau reprenant les colonnes du
+// questionnaire en ligne, pour un copier-coller direct. Les libellés restent en
+// anglais, langue de référence du questionnaire.
+
+type cdpQuestion struct {
+	ID      string          `json:"id"` // ex: "C6.1"
+	Title   string          `json:"title"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+	Note    string          `json:"note,omitempty"`
+}
+
+type cdpExport struct {
+	TenantName  string        `json:"tenant_name"`
+	Year        int           `json:"year"`
+	PeriodStart string        `json:"period_start"`
+	PeriodEnd   string        `json:"period_end"`
+	Questions   []cdpQuestion `json:"questions"`
+	GeneratedAt time.Time     `json:"generated_at"`
+}
+
+// GET /api/tenants/:tenantId/exports/cdp?year=2024&format=json|csv
+// Réponses pré-remplies du questionnaire CDP à partir des émissions, objectifs,
+// sites et dénominateurs d'intensité du tenant.
+func (h *ReportsHandler) ExportCDP(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+	if year == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year est requis (année de reporting)"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format doit valoir 'json' ou 'csv'"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	export, err := h.buildCDP(ctx, tenantID, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'assembler l'export CDP", "details": err.Error()})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	data, err := export.csv()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le CSV"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="cdp_%d_%d.csv"`, tenantID, year))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
+
+func (h *ReportsHandler) buildCDP(ctx context.Context, tenantID int64, year int) (cdpExport, error) {
+	tenant, err := getTenant(ctx, h.db, tenantID)
+	if err != nil {
+		return cdpExport{}, err
+	}
+	lines, err := loadEmissionLines(ctx, h.db, tenantID, year)
+	if err != nil {
+		return cdpExport{}, err
+	}
+	yearly, err := yearlyEmissionsByScope(ctx, h.db, tenantID)
+	if err != nil {
+		return cdpExport{}, err
+	}
+	dens, err := loadDenominators(ctx, h.db, tenantID)
+	if err != nil {
+		return cdpExport{}, err
+	}
+	targets, err := listTargets(ctx, h.db, tenantID)
+	if err != nil {
+		return cdpExport{}, err
+	}
+	sites, err := loadSites(ctx, h.db, tenantID)
+	if err != nil {
+		return cdpExport{}, err
+	}
+
+	export := cdpExport{
+		TenantName:  tenant.Name,
+		Year:        year,
+		PeriodStart: fmt.Sprintf("%d-01-01", year),
+		PeriodEnd:   fmt.Sprintf("%d-12-31", year),
+		GeneratedAt: time.Now().UTC(),
+	}
+	export.Questions = append(export.Questions, cdpTargets(targets, yearly, dens, year)...)
+	export.Questions = append(export.Questions, cdpMethodology())
+	export.Questions = append(export.Questions, cdpScopeTotals(yearly, year, export.PeriodStart, export.PeriodEnd)...)
+	export.Questions = append(export.Questions, cdpScope3(lines))
+	export.Questions = append(export.Questions, cdpIntensities(yearly, dens, year))
+	export.Questions = append(export.Questions, cdpBreakdowns(lines, sites)...)
+	return export, nil
+}
+
+// cdpTargets remplit C4.1a (objectifs absolus) et C4.1b (objectifs d'intensité).
+func cdpTargets(targets []ReductionTarget, yearly map[int]map[string]float64, dens map[int]map[string]IntensityDenominator, year int) []cdpQuestion {
+	abs := cdpQuestion{
+		ID:    "C4.1a",
+		Title: "Provide details of your absolute emissions target(s) and progress made against those targets.",
+		Columns: []string{"Target reference number", "Year target was set", "Target coverage", "Scope(s)",
+			"Base year", "Base year emissions covered by target (metric tons CO2e)", "Target year",
+			"Targeted reduction from base year (%)", "Emissions in reporting year covered by target (metric tons CO2e)",
+			"% of target achieved relative to base year", "Is this a science-based target?", "Target ambition"},
+		Rows: [][]interface{}{},
+	}
+	intensity := cdpQuestion{
+		ID:    "C4.1b",
+		Title: "Provide details of your emissions intensity target(s) and progress made against those target(s).",
+		Columns: []string{"Target reference number", "Year target was set", "Target coverage", "Scope(s)",
+			"Intensity metric", "Base year", "Intensity figure in base year", "Target year",
+			"Targeted reduction from base year (%)", "Intensity figure in reporting year",
+			"% of target achieved relative to base year", "Is this a science-based target?", "Target ambition"},
+		Rows: [][]interface{}{},
+	}
+
+	// valueFor renvoie les émissions couvertes par l'objectif, ou l'intensité correspondante.
+	valueFor := func(t ReductionTarget, y int) (float64, bool) {
+		byScope, ok := yearly[y]
+		if !ok {
+			return 0, false
+		}
+		var sum float64
+		for _, s := range t.Scopes {
+			sum += byScope[s]
+		}
+		if t.TargetType != "intensity" {
+			return sum, true
+		}
+		r, ok := dens[y][*t.IntensityMetric].ratio(sum)
+		return r.Value, ok
+	}
+
+	for _, t := range targets {
+		base, hasBase := valueFor(t, t.BaseYear)
+		current, hasCurrent := valueFor(t, year)
+		var achieved interface{}
+		if hasBase && hasCurrent && base > 0 && t.ReductionPct > 0 {
+			achieved = round(((base-current)/base*100)/t.ReductionPct*100, 1)
+		}
+		scopes := make([]string, len(t.Scopes))
+		for i, s := range t.Scopes {
+			scopes[i] = "Scope " + s
+		}
+		sbti, ambition := "No", ""
+		if sbtiAligned(t) {
+			sbti, ambition = "Yes, we consider this a science-based target, but it has not been approved by the Science Based Targets initiative", "1.5°C aligned"
+		}
+
+		if t.TargetType == "intensity" {
+			intensity.Rows = append(intensity.Rows, []interface{}{
+				fmt.Sprintf("Int %d", len(intensity.Rows)+1), t.CreatedAt.Year(), "Company-wide", strings.Join(scopes, "+"),
+				cdpIntensityMetric(*t.IntensityMetric), t.BaseYear, optionalRound(base, hasBase, 6), t.TargetYear,
+				t.ReductionPct, optionalRound(current, hasCurrent, 6), achieved, sbti, ambition,
+			})
+			continue
+		}
+		abs.Rows = append(abs.Rows, []interface{}{
+			fmt.Sprintf("Abs %d", len(abs.Rows)+1), t.CreatedAt.Year(), "Company-wide", strings.Join(scopes, "+"),
+			t.BaseYear, optionalRound(base, hasBase, 3), t.TargetYear, t.ReductionPct,
+			optionalRound(current, hasCurrent, 3), achieved, sbti, ambition,
+		})
+	}
+	if len(abs.Rows) == 0 {
+		abs.Note = "Aucun objectif absolu enregistré."
+	}
+	if len(intensity.Rows) == 0 {
+		intensity.Note = "Aucun objectif d'intensité enregistré."
+	}
+	return []cdpQuestion{abs, intensity}
+}
+
+// cdpMethodology remplit C5.3 (référentiels utilisés pour le calcul).
+func cdpMethodology() cdpQuestion {
+	return cdpQuestion{
+		ID:      "C5.3",
+		Title:   "Select the name of the standard, protocol, or methodology you have used to collect activity data and calculate emissions.",
+		Columns: []string{"Standard, protocol, or methodology"},
+		Rows: [][]interface{}{
+			{"The Greenhouse Gas Protocol: A Corporate Accounting and Reporting Standard (Revised Edition)"},
+			{"The Greenhouse Gas Protocol: Scope 2 Guidance"},
+			{"The Greenhouse Gas Protocol: Corporate Value Chain (Scope 3) Standard"},
+			{"France - Bilan Carbone® / BEGES v5 (ADEME Base Empreinte® emission factors)"},
+		},
+	}
+}
+
+// cdpScopeTotals remplit C6.1 (scope 1), C6.2 (approche scope 2) et C6.3 (scope 2),
+// avec l'année de reporting et l'année précédente si elle est connue.
+func cdpScopeTotals(yearly map[int]map[string]float64, year int, start, end string) []cdpQuestion {
+	s1 := cdpQuestion{
+		ID:      "C6.1",
+		Title:   "What were your organization's gross global Scope 1 emissions in metric tons CO2e?",
+		Columns: []string{"Row", "Gross global Scope 1 emissions (metric tons CO2e)", "Start date", "End date", "Comment"},
+		Rows:    [][]interface{}{},
+	}
+	s2 := cdpQuestion{
+		ID:    "C6.3",
+		Title: "What were your organization's gross global Scope 2 emissions in metric tons CO2e?",
+		Columns: []string{"Row", "Scope 2, location-based", "Scope 2, market-based (if applicable)",
+			"Start date", "End date", "Comment"},
+		Rows: [][]interface{}{},
+		Note: "Le scope 2 market-based nécessite les garanties d'origine et contrats d'achat, non renseignés.",
+	}
+
+	rows := []struct {
+		label string
+		year  int
+	}{{"Reporting year", year}, {"Past year 1", year - 1}}
+	for _, r := range rows {
+		byScope, ok := yearly[r.year]
+		if !ok {
+			continue
+		}
+		ys, ye := start, end
+		if r.year != year {
+			ys, ye = fmt.Sprintf("%d-01-01", r.year), fmt.Sprintf("%d-12-31", r.year)
+		}
+		s1.Rows = append(s1.Rows, []interface{}{r.label, round(byScope["1"], 3), ys, ye, ""})
+		s2.Rows = append(s2.Rows, []interface{}{r.label, round(byScope["2"], 3), nil, ys, ye,
+			"Location-based figure computed with national average grid factors."})
+	}
+
+	approach := cdpQuestion{
+		ID:      "C6.2",
+		Title:   "Describe your organization's approach to reporting Scope 2 emissions.",
+		Columns: []string{"Scope 2, location-based", "Scope 2, market-based", "Comment"},
+		Rows: [][]interface{}{{
+			"We are reporting a Scope 2, location-based figure",
+			"We have no operations where we are able to access electricity supplier emission factors or residual emissions factors and are unable to report a Scope 2, market-based figure",
+			"",
+		}},
+	}
+	return []cdpQuestion{s1, approach, s2}
+}
+
+// cdpScope3 remplit C6.5 : les 15 catégories GHG Protocol avec statut d'évaluation.
+func cdpScope3(lines []emissionLine) cdpQuestion {
+	byCat := make(map[int][]emissionLine)
+	for _, l := range lines {
+		if l.Scope == "3" {
+			cat := ghgScope3CategoryFor(begesPosteFor(l))
+			byCat[cat] = append(byCat[cat], l)
+		}
+	}
+
+	q := cdpQuestion{
+		ID:    "C6.5",
+		Title: "Account for your organization's gross global Scope 3 emissions, disclosing and explaining any exclusions.",
+		Columns: []string{"Category", "Evaluation status", "Emissions in reporting year (metric tons CO2e)",
+			"Emissions calculation methodology", "Percentage of emissions calculated using data obtained from suppliers or value chain partners",
+			"Please explain"},
+		Rows: [][]interface{}{},
+	}
+	for cat := 1; cat <= 15; cat++ {
+		cl := byCat[cat]
+		if len(cl) == 0 {
+			q.Rows = append(q.Rows, []interface{}{ghgScope3Categories[cat], "Not evaluated", nil, "", nil,
+				"No activity data recorded for this category in the reporting year."})
+			continue
+		}
+		var total, spend float64
+		for _, l := range cl {
+			total += l.TCO2e
+			if l.isSpendBased() {
+				spend += l.TCO2e
+			}
+		}
+		method := "Average data method"
+		if spend > 0 {
+			method = "Spend-based method"
+		}
+		q.Rows = append(q.Rows, []interface{}{ghgScope3Categories[cat], "Relevant, calculated", round(total, 3), method, 0,
+			fmt.Sprintf("%d activity lines; %.0f%% estimated with monetary emission factors (ADEME).", len(cl), spend/total*100)})
+	}
+	if other := byCat[0]; len(other) > 0 {
+		var total float64
+		for _, l := range other {
+			total += l.TCO2e
+		}
+		q.Rows = append(q.Rows, []interface{}{"Other (upstream)", "Relevant, calculated", round(total, 3), "Spend-based method", 0,
+			"Visitor and customer travel (BEGES 3.4) and other indirect emissions (BEGES 6.1)."})
+	}
+	return q
+}
+
+// cdpIntensities remplit C6.10 pour chaque dénominateur disponible (scopes 1+2, location-based).
+func cdpIntensities(yearly map[int]map[string]float64, dens map[int]map[string]IntensityDenominator, year int) cdpQuestion {
+	q := cdpQuestion{
+		ID:    "C6.10",
+		Title: "Describe your gross global combined Scope 1 and 2 emissions for the reporting year in metric tons CO2e per unit currency total revenue and provide any additional intensity metrics that are appropriate to your business operations.",
+		Columns: []string{"Intensity figure", "Metric numerator (Gross global combined Scope 1 and 2 emissions, metric tons CO2e)",
+			"Metric denominator", "Metric denominator: Unit total", "Scope 2 figure used",
+			"% change from previous year", "Direction of change"},
+		Rows: [][]interface{}{},
+	}
+	s12 := func(y int) float64 { return yearly[y]["1"] + yearly[y]["2"] }
+
+	for _, metric := range []string{"revenue", "headcount", "floor_area", "production"} {
+		d, ok := dens[year][metric]
+		if !ok || d.Value <= 0 {
+			continue
+		}
+		// CDP attend l'intensité rapportée à l'unité brute (EUR, FTE...), pas au MEUR.
+		figure := s12(year) / d.Value
+		var change interface{}
+		direction := ""
+		if prev, ok := dens[year-1][metric]; ok && prev.Value > 0 && s12(year-1) > 0 {
+			before := s12(year-1) / prev.Value
+			pct := (figure - before) / before * 100
+			change = round(math.Abs(pct), 1)
+			switch {
+			case pct > 0.05:
+				direction = "Increased"
+			case pct < -0.05:
+				direction = "Decreased"
+			default:
+				direction = "No change"
+			}
+		}
+		q.Rows = append(q.Rows, []interface{}{round(figure, 9), round(s12(year), 3), cdpIntensityMetric(metric),
+			d.Value, "Location-based", change, direction})
+	}
+	if len(q.Rows) == 0 {
+		q.Note = "Aucun dénominateur d'intensité renseigné pour l'année."
+	}
+	return q
+}
+
+// cdpBreakdowns remplit le module C7 : ventilation par gaz (C7.1a), par pays
+// (C7.2, C7.5), par site (C7.3b, C7.6b) et par activité (C7.3c, C7.6c).
+func cdpBreakdowns(lines []emissionLine, sites []Site) []cdpQuestion {
+	byID := make(map[int64]Site, len(sites))
+	for _, s := range sites {
+		byID[s.ID] = s
+	}
+	// Le pays d'un site est hérité de son parent s'il n'est pas renseigné.
+	countryOf := func(siteID *int64) string {
+		for id, depth := siteID, 0; id != nil && depth <= len(sites); depth++ {
+			s, ok := byID[*id]
+			if !ok {
+				break
+			}
+			if s.Country != nil && *s.Country != "" {
+				return *s.Country
+			}
+			id = s.ParentID
+		}
+		return "Not assigned"
+	}
+	siteOf := func(siteID *int64) string {
+		if siteID != nil {
+			if s, ok := byID[*siteID]; ok {
+				return s.Name
+			}
+		}
+		return "Not assigned"
+	}
+	activityOf := func(l emissionLine) string {
+		if l.Category != "" {
+			return l.Category
+		}
+		return "Uncategorized"
+	}
+
+	var co2, ch4, n2o, other float64
+	estimated := false
+	for _, l := range lines {
+		if l.Scope != "1" {
+			continue
+		}
+		a, b, c, d, est := l.gasBreakdown()
+		co2, ch4, n2o, other = co2+a, ch4+b, n2o+c, other+d
+		estimated = estimated || est
+	}
+	gasRows := [][]interface{}{
+		{"CO2", round(co2, 3), "IPCC Fifth Assessment Report (AR5 – 100 year)"},
+		{"CH4", round(ch4, 3), "IPCC Fifth Assessment Report (AR5 – 100 year)"},
+		{"N2O", round(n2o, 3), "IPCC Fifth Assessment Report (AR5 – 100 year)"},
+		{"Other (HFCs, PFCs, SF6, NF3)", round(other, 3), "IPCC Fifth Assessment Report (AR5 – 100 year)"},
+	}
+	gases := cdpQuestion{
+		ID:      "C7.1a",
+		Title:   "Break down your total gross global Scope 1 emissions by greenhouse gas type and provide the source of each used greenhouse warming potential (GWP).",
+		Columns: []string{"Greenhouse gas", "Scope 1 emissions (metric tons of CO2e)", "GWP Reference"},
+		Rows:    gasRows,
+	}
+	if estimated {
+		gases.Note = "Une partie du scope 1 n'est pas ventilée par gaz et est reportée en CO2."
+	}
+
+	q := []cdpQuestion{gases,
+		cdpBreakdown("C7.2", "Break down your total gross global Scope 1 emissions by country/area/region.",
+			"Country/Area/Region", "Scope 1 emissions (metric tons CO2e)", lines, "1", func(l emissionLine) string { return countryOf(l.SiteID) }),
+		cdpBreakdown("C7.3b", "Break down your total gross global Scope 1 emissions by business facility.",
+			"Facility", "Scope 1 emissions (metric tons CO2e)", lines, "1", func(l emissionLine) string { return siteOf(l.SiteID) }),
+		cdpBreakdown("C7.3c", "Break down your total gross global Scope 1 emissions by business activity.",
+			"Activity", "Scope 1 emissions (metric tons CO2e)", lines, "1", activityOf),
+		cdpBreakdown("C7.5", "Break down your total gross global Scope 2 emissions by country/area/region.",
+			"Country/Area/Region", "Scope 2, location-based (metric tons CO2e)", lines, "2", func(l emissionLine) string { return countryOf(l.SiteID) }),
+		cdpBreakdown("C7.6b", "Break down your total gross global Scope 2 emissions by business facility.",
+			"Facility", "Scope 2, location-based (metric tons CO2e)", lines, "2", func(l emissionLine) string { return siteOf(l.SiteID) }),
+		cdpBreakdown("C7.6c", "Break down your total gross global Scope 2 emissions by business activity.",
+			"Activity", "Scope 2, location-based (metric tons CO2e)", lines, "2", activityOf),
+	}
+	return q
+}
+
+// cdpBreakdown agrège les émissions d'un scope selon la clé donnée, par ordre décroissant.
+func cdpBreakdown(id, title, keyColumn, valueColumn string, lines []emissionLine, scope string, key func(emissionLine) string) cdpQuestion {
+	totals := make(map[string]float64)
+	for _, l := range lines {
+		if l.Scope == scope {
+			totals[key(l)] += l.TCO2e
+		}
+	}
+	keys := make([]string, 0, len(totals))
+	for k := range totals {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b string) int { return cmpDesc(totals[a], totals[b]) })
+
+	q := cdpQuestion{ID: id, Title: title, Columns: []string{keyColumn, valueColumn}, Rows: [][]interface{}{}}
+	for _, k := range keys {
+		q.Rows = append(q.Rows, []interface{}{k, round(totals[k], 3)})
+	}
+	if _, ok := totals["Not assigned"]; ok {
+		q.Note = "Une partie des émissions n'est rattachée à aucun site (ou à un site sans pays)."
+	}
+	return q
+}
+
+// cdpIntensityMetric traduit un dénominateur en libellé CDP.
+func cdpIntensityMetric(metric string) string {
+	switch metric {
+	case "revenue":
+		return "unit total revenue"
+	case "headcount":
+		return "full time equivalent (FTE) employee"
+	case "floor_area":
+		return "square meter"
+	default:
+		return "unit of production"
+	}
+}
+
+func round(v float64, prec int) float64 {
+	p := math.Pow(10, float64(prec))
+	return math.Round(v*p) / p
+}
+
+// optionalRound renvoie nil (cellule vide) si la valeur n'est pas disponible.
+func optionalRound(v float64, ok bool, prec int) interface{} {
+	if !ok {
+		return nil
+	}
+	return round(v, prec)
+}
+
+// csv produit un classeur « à plat » : un bloc par question (identifiant et
+// intitulé, ligne d'en-tête, lignes de réponse), séparés par une ligne vide.
+func (e cdpExport) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+
+	records := [][]string{
+		{"Organization", e.TenantName},
+		{"Reporting year", strconv.Itoa(e.Year), e.PeriodStart, e.PeriodEnd},
+		{},
+	}
+	for _, q := range e.Questions {
+		records = append(records, []string{q.ID, q.Title}, q.Columns)
+		for _, r := range q.Rows {
+			rec := make([]string, len(r))
+			for i, v := range r {
+				rec[i] = cdpCell(v)
+			}
+			records = append(records, rec)
+		}
+		if q.Note != "" {
+			records = append(records, []string{"Note", q.Note})
+		}
+		records = append(records, []string{})
+	}
+
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cdpCell(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Classification des dépenses par l'agent Mistral : réponse JSON contrainte au
+// catalogue emissionCategories, validée puis redemandée une fois si invalide.
+
+// transactionClassification est une classification validée.
+type transactionClassification struct {
+	Category   string  `json:"category"`
+	Scope      string  `json:"scope"`
+	Reason     string  `json:"reason"`
+	Confidence float64 `json:"confidence"`
+}
+
+// errInvalidClassification signale une réponse du modèle inexploitable.
+var errInvalidClassification = errors.New("classification invalide")
+
+// rawClassification est une classification telle que renvoyée par le modèle,
+// avant validation.
+type rawClassification struct {
+	ID         int64           `json:"id"` // uniquement pour les classifications par lot
+	Category   string          `json:"category"`
+	Scope      json.RawMessage `json:"scope"`
+	Reason     string          `json:"reason"`
+	Confidence *float64        `json:"confidence"`
+}
+
+// validate vérifie que la catégorie appartient au catalogue, que le scope est
+// celui de la catégorie et que la confiance est comprise entre 0 et 1.
+func (r rawClassification) validate() (transactionClassification, error) {
+	cat, ok := findEmissionCategory(r.Category)
+	if !ok {
+		return transactionClassification{}, fmt.Errorf("%w : catégorie inconnue %q", errInvalidClassification, r.Category)
+	}
+	// Le scope peut être renvoyé sous forme de chaîne ("2") ou de nombre (2).
+	scope := strings.Trim(strings.TrimSpace(string(r.Scope)), `"`)
+	if scope != "1" && scope != "2" && scope != "3" {
+		return transactionClassification{}, fmt.Errorf("%w : scope %q hors de 1/2/3", errInvalidClassification, scope)
+	}
+	if expected := getRule(cat.Name, "").Scope; scope != expected {
+		return transactionClassification{}, fmt.Errorf("%w : la catégorie %q relève du scope %s, pas %s", errInvalidClassification, cat.Name, expected, scope)
+	}
+	if r.Confidence == nil || *r.Confidence < 0 || *r.Confidence > 1 {
+		return transactionClassification{}, fmt.Errorf("%w : confidence absente ou hors de [0,1]", errInvalidClassification)
+	}
+
+	return transactionClassification{
+		Category:   cat.Name,
+		Scope:      scope,
+		Reason:     strings.TrimSpace(r.Reason),
+		Confidence: *r.Confidence,
+	}, nil
+}
+
+// parseClassification extrait et valide l'objet JSON renvoyé par l'agent.
+func parseClassification(text string) (transactionClassification, error) {
+	raw := extractJSON(text, '{', '}')
+	if raw == "" {
+		return transactionClassification{}, fmt.Errorf("%w : aucun objet JSON trouvé", errInvalidClassification)
+	}
+	var r rawClassification
+	if err := json.Unmarshal([]byte(raw), &r); err != nil {
+		return transactionClassification{}, fmt.Errorf("%w : JSON illisible (%v)", errInvalidClassification, err)
+	}
+	return r.validate()
+}
+
+// parseBatchClassification extrait le tableau JSON d'une classification par lot.
+// Les éléments invalides sont ignorés ; seule une réponse illisible est une erreur.
+func parseBatchClassification(text string) (map[int64]transactionClassification, error) {
+	raw := extractJSON(text, '[', ']')
+	if raw == "" {
+		return nil, fmt.Errorf("%w : aucun tableau JSON trouvé", errInvalidClassification)
+	}
+	var items []rawClassification
+	if err := json.Unmarshal([]byte(raw), &items); err != nil {
+		return nil, fmt.Errorf("%w : JSON illisible (%v)", errInvalidClassification, err)
+	}
+	out := make(map[int64]transactionClassification, len(items))
+	for _, it := range items {
+		if cl, err := it.validate(); err == nil && it.ID > 0 {
+			out[it.ID] = cl
+		}
+	}
+	return out, nil
+}
+
+// extractJSON isole le premier objet (ou tableau) JSON d'un texte, en ignorant
+// un éventuel bloc de code Markdown ou du texte autour.
+func extractJSON(text string, opening, closing byte) string {
+	text = strings.TrimSpace(text)
+	if after, ok := strings.CutPrefix(text, "```"); ok {
+		after = strings.TrimPrefix(after, "json")
+		text, _, _ = strings.Cut(after, "```")
+	}
+	start := strings.IndexByte(text, opening)
+	end := strings.LastIndexByte(text, closing)
+	if start < 0 || end < start {
+		return ""
+	}
+	return text[start : end+1]
+}
+
+// classifyTransaction interroge l'agent et relance une fois en cas de réponse
+// invalide. Renvoie la classification, le texte brut de la dernière réponse et
+// le nombre d'appels effectués.
+func (m *MistralClient) classifyTransaction(desc string, amount float64, currency string) (transactionClassification, string, int, error) {
+	prompt := buildClassificationPrompt(desc, amount, currency)
+
+	var text string
+	for attempt := 1; attempt <= 2; attempt++ {
+		out, err := m.invokeAgent(prompt)
+		if err != nil {
+			return transactionClassification{}, text, attempt, err
+		}
+		text = agentText(out)
+
+		cl, err := parseClassification(text)
+		if err == nil {
+			return cl, text, attempt, nil
+		}
+		if attempt == 2 {
+			return transactionClassification{}, text, attempt, err
+		}
+		prompt = buildClassificationRetryPrompt(buildClassificationPrompt(desc, amount, currency), text, err.Error())
+	}
+	return transactionClassification{}, text, 2, errInvalidClassification
+}
+
+// classifyBatch classe un lot d'entrées en un seul appel, avec une relance si la
+// réponse est illisible. Les entrées absentes ou invalides de la réponse ne
+// figurent pas dans le résultat.
+func (m *MistralClient) classifyBatch(entries []entryToClassify) (map[int64]transactionClassification, error) {
+	prompt := buildBatchClassificationPrompt(entries)
+	out, err := m.invokeAgent(prompt)
+	if err != nil {
+		return nil, err
+	}
+	text := agentText(out)
+	result, err := parseBatchClassification(text)
+	if err == nil {
+		return result, nil
+	}
+
+	out, err = m.invokeAgent(buildClassificationRetryPrompt(prompt, text, err.Error()))
+	if err != nil {
+		return nil, err
+	}
+	return parseBatchClassification(agentText(out))
+}
@@ -4,6 +4,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -22,6 +24,45 @@ type Config struct {
 	DBName         string
 	MistralAPIKey  string
 	MistralAgentID string
+	// Durées de vie du token d'accès (JWT) et du jeton de rafraîchissement (session).
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	// Délai avant suppression définitive des fichiers des documents supprimés.
+	DocumentRetention time.Duration
+
+	// Stockage des documents : "local" (disque) ou "s3" (compatible S3 : AWS, MinIO...).
+	StorageBackend    string
+	StorageLocalDir   string
+	PublicURL         string // URL publique de l'API : liens présignés du stockage local, retour SSO
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3PathStyle       bool // requis par MinIO
+
+	// Antivirus appliqué aux uploads : "none" ou "clamd" (adresse "unix:/chemin" ou "hôte:port").
+	MalwareScanner string
+	ClamdAddress   string
+
+	// Envoi des emails : "smtp" (SMTP_HOST requis) ou "log" (journalisés, liens
+	// masqués ; développement uniquement).
+	EmailDriver  string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	AppURL       string // URL du frontend, pour les liens envoyés par email
+
+	// Limitation de débit : compteurs en mémoire ("memory", propres à l'instance)
+	// ou partagés ("redis", REDIS_URL ex: redis://:motdepasse@redis:6379/0).
+	RateLimitStore string
+	RedisURL       string
+	// Proxies dont l'en-tête X-Forwarded-For est pris en compte pour l'IP du
+	// client (liste d'adresses ou de CIDR séparés par des virgules). Vide :
+	// aucun proxy n'est de confiance.
+	TrustedProxies []string
 }
 
 // LoadConfig charge la configuration à partir des variables d'environnement.
@@ -39,6 +80,65 @@ func LoadConfig() Config {
 		MistralAgentID: getEnv("MISTRAL_AGENT_ID", "ag_019aa6e42967756f96ee8200155ff336"),
 	}
 
+	accessMinutes, err := strconv.Atoi(getEnv("API_ACCESS_TOKEN_TTL_MINUTES", "15"))
+	if err != nil || accessMinutes <= 0 {
+		log.Println("[AVERTISSEMENT] API_ACCESS_TOKEN_TTL_MINUTES invalide, valeur par défaut de 15 minutes utilisée.")
+		accessMinutes = 15
+	}
+	cfg.AccessTokenTTL = time.Duration(accessMinutes) * time.Minute
+
+	refreshDays, err := strconv.Atoi(getEnv("API_REFRESH_TOKEN_TTL_DAYS", "30"))
+	if err != nil || refreshDays <= 0 {
+		log.Println("[AVERTISSEMENT] API_REFRESH_TOKEN_TTL_DAYS invalide, valeur par défaut de 30 jours utilisée.")
+		refreshDays = 30
+	}
+	cfg.RefreshTokenTTL = time.Duration(refreshDays) * 24 * time.Hour
+
+	retentionDays, err := strconv.Atoi(getEnv("API_DOCUMENT_RETENTION_DAYS", "30"))
+	if err != nil || retentionDays < 0 {
+		log.Println("[AVERTISSEMENT] API_DOCUMENT_RETENTION_DAYS invalide, valeur par défaut de 30 jours utilisée.")
+		retentionDays = 30
+	}
+	cfg.DocumentRetention = time.Duration(retentionDays) * 24 * time.Hour
+
+	cfg.StorageBackend = getEnv("STORAGE_BACKEND", "local")
+	cfg.StorageLocalDir = getEnv("STORAGE_LOCAL_DIR", "uploads")
+	cfg.PublicURL = getEnv("API_PUBLIC_URL", "")
+	cfg.S3Endpoint = getEnv("S3_ENDPOINT", "https://s3.amazonaws.com")
+	cfg.S3Region = getEnv("S3_REGION", "us-east-1")
+	cfg.S3Bucket = getEnv("S3_BUCKET", "")
+	cfg.S3AccessKeyID = getEnv("S3_ACCESS_KEY_ID", "")
+	cfg.S3SecretAccessKey = getEnv("S3_SECRET_ACCESS_KEY", "")
+	cfg.S3PathStyle = getEnv("S3_PATH_STYLE", "false") == "true"
+
+	cfg.MalwareScanner = getEnv("MALWARE_SCANNER", "none")
+	cfg.ClamdAddress = getEnv("CLAMD_ADDRESS", "unix:/var/run/clamav/clamd.ctl")
+	cfg.EmailDriver = getEnv("EMAIL_DRIVER", "smtp")
+	cfg.SMTPHost = getEnv("SMTP_HOST", "")
+	cfg.SMTPPort = getEnv("SMTP_PORT", "587")
+	cfg.SMTPUsername = getEnv("SMTP_USERNAME", "")
+	cfg.SMTPPassword = getEnv("SMTP_PASSWORD", "")
+	cfg.MailFrom = getEnv("MAIL_FROM", "CarbonV2 <no-reply@carbonv2.local>")
+	cfg.AppURL = strings.TrimRight(getEnv("APP_URL", "http://localhost:3000"), "/")
+	cfg.RateLimitStore = getEnv("RATE_LIMIT_STORE", "memory")
+	cfg.RedisURL = getEnv("REDIS_URL", "")
+	for _, p := range strings.Split(getEnv("API_TRUSTED_PROXIES", ""), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			cfg.TrustedProxies = append(cfg.TrustedProxies, p)
+		}
+	}
+	if cfg.EmailDriver == "log" {
+		log.Println("[INFO] EMAIL_DRIVER=log : les emails seront écrits dans les logs (liens masqués) au lieu d'être envoyés.")
+	}
+
+	if cfg.MalwareScanner == "none" && cfg.Env == "production" {
+		log.Println("[AVERTISSEMENT] MALWARE_SCANNER n'est pas configuré : les documents uploadés ne sont pas analysés par un antivirus.")
+	}
+
+	if cfg.TrustedProxies == nil && cfg.Env == "production" {
+		log.Println("[INFO] API_TRUSTED_PROXIES n'est pas configuré : X-Forwarded-For est ignoré. Derrière un proxy, toutes les requêtes partagent son adresse IP pour les limites par IP.")
+	}
+
 	if cfg.JWTSecret == "" || cfg.JWTSecret == "changeme-super-secret" {
 		log.Println("[AVERTISSEMENT] API_JWT_SECRET n'est pas configuré ou utilise la valeur par défaut. Ne pas utiliser en production.")
 	}
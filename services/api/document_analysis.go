@@ -0,0 +1,734 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Analyse asynchrone des factures importées : extraction du texte du PDF,
+// détection du type de facture (électricité, gaz, carburant, voyage), lecture
+// des données utiles au bilan et proposition d'entrées à confirmer.
+
+// documentAnalysis est le résultat stocké dans documents.analysis.
+type documentAnalysis struct {
+	InvoiceType     string               `json:"invoice_type"` // 'electricity' | 'gas' | 'fuel' | 'travel' | 'unknown'
+	Supplier        string               `json:"supplier,omitempty"`
+	InvoiceDate     string               `json:"invoice_date,omitempty"` // YYYY-MM-DD
+	PeriodStart     string               `json:"period_start,omitempty"`
+	PeriodEnd       string               `json:"period_end,omitempty"`
+	KWh             *float64             `json:"kwh,omitempty"`
+	VolumeM3        *float64             `json:"volume_m3,omitempty"`
+	Litres          *float64             `json:"litres,omitempty"`
+	DistanceKm      *float64             `json:"distance_km,omitempty"`
+	TravelMode      string               `json:"travel_mode,omitempty"` // 'train' | 'plane'
+	AmountHT        *float64             `json:"amount_ht,omitempty"`
+	AmountTTC       *float64             `json:"amount_ttc,omitempty"`
+	Currency        string               `json:"currency"`
+	DeliveryPoint   string               `json:"delivery_point,omitempty"`      // PDL/PRM (électricité) ou PCE (gaz)
+	DeliveryPointOf string               `json:"delivery_point_kind,omitempty"` // 'pdl' | 'pce'
+	Warnings        []string             `json:"warnings,omitempty"`
+	Proposed        []createEntryRequest `json:"proposed_entries"`
+	TextExcerpt     string               `json:"text_excerpt,omitempty"`
+}
+
+// invoiceKeywords sert à détecter le type de facture : le type qui totalise le
+// plus de mots-clés (mots entiers du texte normalisé) l'emporte.
+var invoiceKeywords = map[string][]string{
+	"electricity": {"électricité", "electricite", "kwh", "pdl", "prm", "enedis", "turpe", "acheminement", "heures pleines", "heures creuses", "cta", "cspe", "accise sur l électricité"},
+	"gas":         {"gaz naturel", "pce", "grdf", "m3", "ticgn", "accise sur le gaz", "coefficient de conversion", "pcs"},
+	"fuel":        {"carburant", "gazole", "diesel", "sp95", "sp98", "e10", "e85", "gnr", "fioul", "litres", "station", "ticpe", "pompe"},
+	"travel":      {"billet", "voyage", "trajet", "passager", "embarquement", "vol", "aller", "retour", "départ", "arrivée", "sncf", "tgv", "ouigo", "siège", "voiture"},
+}
+
+// invoiceSuppliers liste les fournisseurs reconnus, du plus spécifique au plus
+// générique (mots entiers du texte normalisé).
+var invoiceSuppliers = []struct {
+	Pattern string
+	Name    string
+}{
+	{"totalenergies", "TotalEnergies"}, {"edf", "EDF"}, {"engie", "Engie"}, {"enercoop", "Enercoop"},
+	{"ekwateur", "ekWateur"}, {"vattenfall", "Vattenfall"}, {"octopus energy", "Octopus Energy"},
+	{"mint energie", "Mint Énergie"}, {"ilek", "ilek"}, {"eni", "Eni"}, {"planète oui", "Planète OUI"},
+	{"gaz de bordeaux", "Gaz de Bordeaux"}, {"grdf", "GRDF"},
+	{"ouigo", "OUIGO"}, {"eurostar", "Eurostar"}, {"trainline", "Trainline"}, {"sncf", "SNCF"},
+	{"air france", "Air France"}, {"easyjet", "easyJet"}, {"ryanair", "Ryanair"}, {"transavia", "Transavia"},
+	{"lufthansa", "Lufthansa"}, {"volotea", "Volotea"},
+	{"shell", "Shell"}, {"esso", "Esso"}, {"bp", "BP"}, {"avia", "Avia"}, {"as24", "AS24"},
+	{"intermarché", "Intermarché"}, {"leclerc", "E.Leclerc"}, {"carrefour", "Carrefour"}, {"auchan", "Auchan"},
+}
+
+// invoiceCategories associe un type de facture à sa catégorie du catalogue et au
+// type d'entrée proposé.
+var invoiceCategories = map[string]struct {
+	Category  string
+	EntryType string
+	Kind      string // valeur de documents.kind
+}{
+	"electricity": {"Énergie - Électricité", "energy", "facture_electricite"},
+	"gas":         {"Énergie - Gaz naturel", "energy", "facture_gaz"},
+	"fuel":        {"Énergie - Carburant", "fuel", "facture_carburant"},
+	"travel":      {"", "travel", "facture_transport"}, // catégorie selon le mode
+}
+
+// Les montants suivent le format français ("1 234,56") ou anglais ("1234.56").
+const numPattern = `(\d{1,3}(?:[ \x{a0}\x{202f}.]\d{3})+(?:,\d{1,3})?|\d+(?:[.,]\d{1,3})?)`
+
+// Les dates sont numériques (01/02/2024) ou en toutes lettres (1er février 2024).
+const datePattern = `(\d{1,2}[/.-]\d{1,2}[/.-]\d{2,4}|\d{1,2}(?:er)?\s+[a-zéû]+\s+\d{4})`
+
+var (
+	reAmountTTC = []*regexp.Regexp{
+		regexp.MustCompile(`(?:montant|total)\s+(?:de la facture\s+)?ttc[^\d]{0,40}?` + numPattern + `\s*(?:€|eur)`),
+		regexp.MustCompile(`(?:net|total|montant|reste)\s+à\s+payer[^\d]{0,40}?` + numPattern + `\s*(?:€|eur)`),
+		regexp.MustCompile(`total\s+(?:facture|général|a payer)[^\d]{0,40}?` + numPattern + `\s*(?:€|eur)`),
+		regexp.MustCompile(`(?:prix|montant)\s+(?:total|du billet|payé)[^\d]{0,40}?` + numPattern + `\s*(?:€|eur)`),
+	}
+	reAmountHT    = regexp.MustCompile(`(?:montant|total)\s+(?:de la facture\s+)?(?:ht|h\.t\.)[^\d]{0,40}?` + numPattern + `\s*(?:€|eur)`)
+	reAnyEuro     = regexp.MustCompile(numPattern + `\s*(?:€|eur\b|euros?\b)`)
+	reConsumption = regexp.MustCompile(`consommation[^\d]{0,80}?` + numPattern + `\s*(kwh|mwh)\b`)
+	reEnergy      = regexp.MustCompile(numPattern + `\s*(kwh|mwh)\b`)
+	reVolumeM3    = regexp.MustCompile(numPattern + `\s*(?:m3|m³)`)
+	reLitres      = regexp.MustCompile(numPattern + `\s*(?:litres?|ltr|l)\b`)
+	reQuantity    = regexp.MustCompile(`(?:quantité|volume|qté)[^\d]{0,30}?` + numPattern)
+	reDistance    = regexp.MustCompile(numPattern + `\s*km\b`)
+	rePDL         = regexp.MustCompile(`(?:pdl|prm|point de livraison|point de référence mesure|référence d.acheminement)[^\d]{0,40}(\d(?:[ \x{a0}]?\d){13})`)
+	rePCE         = regexp.MustCompile(`(?:pce|point de comptage et d.estimation)[^\da-z]{0,40}(gi\d{6}|\d(?:[ \x{a0}]?\d){13})`)
+	rePeriod      = []*regexp.Regexp{
+		regexp.MustCompile(`du\s+` + datePattern + `\s+au\s+` + datePattern),
+		regexp.MustCompile(`période[^\d]{0,40}` + datePattern + `\s*(?:-|–|à|au)\s*` + datePattern),
+	}
+	reInvoiceDate = regexp.MustCompile(`(?:date de (?:la )?facture|facture du|émise le|date d.émission|date d.achat|date du voyage|départ le|date)\s*:?\s*` + datePattern)
+)
+
+var frenchMonths = map[string]time.Month{
+	"janvier": time.January, "février": time.February, "fevrier": time.February, "mars": time.March,
+	"avril": time.April, "mai": time.May, "juin": time.June, "juillet": time.July, "août": time.August,
+	"aout": time.August, "septembre": time.September, "octobre": time.October, "novembre": time.November,
+	"décembre": time.December, "decembre": time.December,
+}
+
+// analyzeInvoiceText extrait les données d'une facture à partir de son texte.
+func analyzeInvoiceText(text string) documentAnalysis {
+	lower := strings.ToLower(strings.NewReplacer("\u00a0", " ", "\u202f", " ", "\u2019", "'").Replace(text))
+	words := normalizeWords(lower)
+
+	a := documentAnalysis{InvoiceType: detectInvoiceType(words), Currency: "EUR"}
+	for _, s := range invoiceSuppliers {
+		if strings.Contains(words, " "+s.Pattern+" ") {
+			a.Supplier = s.Name
+			break
+		}
+	}
+
+	for _, re := range rePeriod {
+		if m := re.FindStringSubmatch(lower); m != nil {
+			a.PeriodStart, a.PeriodEnd = parseInvoiceDate(m[1]), parseInvoiceDate(m[2])
+			break
+		}
+	}
+	if m := reInvoiceDate.FindStringSubmatch(lower); m != nil {
+		a.InvoiceDate = parseInvoiceDate(m[1])
+	}
+
+	for _, re := range reAmountTTC {
+		if m := re.FindStringSubmatch(lower); m != nil {
+			a.AmountTTC = parseInvoiceNumber(m[1])
+			break
+		}
+	}
+	if m := reAmountHT.FindStringSubmatch(lower); m != nil {
+		a.AmountHT = parseInvoiceNumber(m[1])
+	}
+	if a.AmountTTC == nil && a.AmountHT == nil {
+		// À défaut de libellé reconnu, on retient le plus grand montant en euros.
+		var best *float64
+		for _, m := range reAnyEuro.FindAllStringSubmatch(lower, -1) {
+			if v := parseInvoiceNumber(m[1]); v != nil && (best == nil || *v > *best) {
+				best = v
+			}
+		}
+		if best != nil {
+			a.AmountTTC = best
+			a.Warnings = append(a.Warnings, "montant total non identifié : le plus grand montant du document a été retenu")
+		}
+	}
+
+	switch a.InvoiceType {
+	case "electricity", "gas":
+		a.KWh = extractEnergy(lower)
+		if a.InvoiceType == "electricity" {
+			if m := rePDL.FindStringSubmatch(lower); m != nil {
+				a.DeliveryPoint, a.DeliveryPointOf = digitsOnly(m[1]), "pdl"
+			}
+		} else {
+			if m := rePCE.FindStringSubmatch(lower); m != nil {
+				a.DeliveryPoint, a.DeliveryPointOf = strings.ToUpper(strings.ReplaceAll(m[1], " ", "")), "pce"
+			}
+			if m := reVolumeM3.FindStringSubmatch(lower); m != nil {
+				a.VolumeM3 = parseInvoiceNumber(m[1])
+			}
+		}
+		if a.KWh == nil {
+			a.Warnings = append(a.Warnings, "consommation en kWh non trouvée")
+		}
+		if a.DeliveryPoint == "" {
+			a.Warnings = append(a.Warnings, "numéro de point de livraison (PDL/PRM/PCE) non trouvé")
+		}
+		if a.PeriodStart == "" {
+			a.Warnings = append(a.Warnings, "période de consommation non trouvée")
+		}
+	case "fuel":
+		if m := reLitres.FindStringSubmatch(lower); m != nil {
+			a.Litres = parseInvoiceNumber(m[1])
+		} else if m := reQuantity.FindStringSubmatch(lower); m != nil {
+			a.Litres = parseInvoiceNumber(m[1])
+		}
+		if a.Litres == nil {
+			a.Warnings = append(a.Warnings, "volume de carburant (litres) non trouvé")
+		}
+	case "travel":
+		a.TravelMode = "train"
+		if strings.Contains(words, " vol ") || strings.Contains(words, " embarquement ") ||
+			strings.Contains(words, " avion ") || strings.Contains(words, " aéroport ") {
+			a.TravelMode = "plane"
+		}
+		for _, r := range vendorRules {
+			if a.Supplier != "" && strings.EqualFold(r.Vendor, a.Supplier) {
+				if r.Category == "Transport - Avion" {
+					a.TravelMode = "plane"
+				}
+				break
+			}
+		}
+		if m := reDistance.FindStringSubmatch(lower); m != nil {
+			a.DistanceKm = parseInvoiceNumber(m[1])
+		}
+	}
+
+	if a.AmountTTC == nil && a.AmountHT == nil && a.InvoiceType != "unknown" {
+		a.Warnings = append(a.Warnings, "montant de la facture non trouvé")
+	}
+	return a
+}
+
+// detectInvoiceType renvoie le type de facture le plus probable, ou 'unknown'
+// si moins de deux mots-clés sont trouvés.
+func detectInvoiceType(words string) string {
+	best, bestScore := "unknown", 1
+	for _, t := range []string{"electricity", "gas", "fuel", "travel"} {
+		score := 0
+		for _, k := range invoiceKeywords[t] {
+			if strings.Contains(words, " "+normalizeWords(k)[1:]) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best
+}
+
+// extractEnergy lit la consommation : d'abord une valeur précédée de
+// « consommation », sinon la première quantité en kWh/MWh du document.
+func extractEnergy(lower string) *float64 {
+	m := reConsumption.FindStringSubmatch(lower)
+	if m == nil {
+		m = reEnergy.FindStringSubmatch(lower)
+	}
+	if m == nil {
+		return nil
+	}
+	v := parseInvoiceNumber(m[1])
+	if v != nil && m[2] == "mwh" {
+		*v *= 1000
+	}
+	return v
+}
+
+// parseInvoiceNumber lit un nombre au format français ou anglais.
+func parseInvoiceNumber(s string) *float64 {
+	s = strings.NewReplacer(" ", "", "\u00a0", "", "\u202f", "").Replace(s)
+	if strings.Contains(s, ",") {
+		s = strings.ReplaceAll(s, ".", "")
+		s = strings.ReplaceAll(s, ",", ".")
+	} else if strings.Count(s, ".") > 1 || (strings.Contains(s, ".") && len(s)-strings.LastIndex(s, ".") == 4) {
+		// "1.234" ou "1.234.567" : séparateurs de milliers.
+		s = strings.ReplaceAll(s, ".", "")
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil
+	}
+	return &v
+}
+
+// parseInvoiceDate convertit une date numérique (JJ/MM/AAAA) ou en toutes
+// lettres en YYYY-MM-DD ; renvoie "" si la date est illisible.
+func parseInvoiceDate(s string) string {
+	s = strings.TrimSpace(s)
+	if f := strings.Fields(s); len(f) == 3 {
+		month, ok := frenchMonths[f[1]]
+		day, err1 := strconv.Atoi(strings.TrimSuffix(f[0], "er"))
+		year, err2 := strconv.Atoi(f[2])
+		if !ok || err1 != nil || err2 != nil {
+			return ""
+		}
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool { return r == '/' || r == '.' || r == '-' })
+	if len(parts) != 3 {
+		return ""
+	}
+	day, err1 := strconv.Atoi(parts[0])
+	month, err2 := strconv.Atoi(parts[1])
+	year, err3 := strconv.Atoi(parts[2])
+	if err1 != nil || err2 != nil || err3 != nil || month < 1 || month > 12 || day < 1 || day > 31 {
+		return ""
+	}
+	if year < 100 {
+		year += 2000
+	}
+	return time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+}
+
+// proposeEntries construit les entrées proposées à partir de l'analyse.
+func (a *documentAnalysis) proposeEntries(docID int64, uploadedAt time.Time) {
+	a.Proposed = []createEntryRequest{}
+	ic, ok := invoiceCategories[a.InvoiceType]
+	if !ok {
+		return
+	}
+	// Les facteurs monétaires s'appliquent aux montants hors taxes quand ils sont connus.
+	amount := a.AmountHT
+	if amount == nil {
+		amount = a.AmountTTC
+	}
+	if amount == nil || *amount <= 0 {
+		return
+	}
+
+	category := ic.Category
+	if a.InvoiceType == "travel" {
+		category = "Transport - Train"
+		if a.TravelMode == "plane" {
+			category = "Transport - Avion"
+		}
+	}
+
+	date := a.PeriodEnd
+	if date == "" {
+		date = a.InvoiceDate
+	}
+	if date == "" {
+		date = uploadedAt.Format("2006-01-02")
+	}
+
+	metadata := map[string]string{
+		"document_id": strconv.FormatInt(docID, 10),
+		"label":       "Facture " + category,
+	}
+	if a.Supplier != "" {
+		metadata["vendor"] = a.Supplier
+		metadata["label"] = "Facture " + a.Supplier + " - " + category
+	}
+	if a.PeriodStart != "" {
+		metadata["period_start"] = a.PeriodStart
+		metadata["period_end"] = a.PeriodEnd
+	}
+	if a.DeliveryPoint != "" {
+		metadata[a.DeliveryPointOf] = a.DeliveryPoint
+	}
+	if a.AmountTTC != nil {
+		metadata["amount_ttc"] = strconv.FormatFloat(*a.AmountTTC, 'f', 2, 64)
+	}
+	for key, v := range map[string]*float64{"kwh": a.KWh, "volume_m3": a.VolumeM3, "litres": a.Litres, "distance_km": a.DistanceKm} {
+		if v != nil {
+			metadata[key] = strconv.FormatFloat(*v, 'f', -1, 64)
+		}
+	}
+
+	a.Proposed = append(a.Proposed, createEntryRequest{
+		Type:     ic.EntryType,
+		Amount:   *amount,
+		Currency: a.Currency,
+		Date:     date,
+		Category: category,
+		Source:   a.Supplier,
+		Metadata: metadata,
+	})
+}
+
+// isAnalyzableMime indique si le document peut être analysé (texte extractible).
+func isAnalyzableMime(m string) bool {
+	return strings.HasPrefix(strings.ToLower(m), "application/pdf")
+}
+
+// maxAnalysisFileBytes borne la lecture du fichier analysé (plus grande
+// taille de fichier acceptée à l'upload).
+const maxAnalysisFileBytes = 100 * mib
+
+// analyzeDocument exécute l'analyse en arrière-plan et enregistre son résultat.
+// Une panique pendant l'analyse marque le document en échec au lieu
+// d'arrêter le serveur.
+func (h *DocumentsHandler) analyzeDocument(docID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("analyse du document %d : panique : %v\n%s", docID, r, debug.Stack())
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := h.db.Exec(ctx,
+				`UPDATE documents SET analysis_status = 'failed', analysis_error = $2, analyzed_at = now() WHERE id = $1`,
+				docID, "erreur interne pendant l'analyse",
+			); err != nil {
+				log.Printf("analyse du document %d : mise à jour du statut : %v", docID, err)
+			}
+		}
+	}()
+
+	var tenantID int64
+	var path string
+	var uploadedAt time.Time
+	err := h.db.QueryRow(ctx,
+		`UPDATE documents SET analysis_status = 'processing', analysis_error = NULL
+		 WHERE id = $1
+		 RETURNING tenant_id, storage_path, created_at`,
+		docID,
+	).Scan(&tenantID, &path, &uploadedAt)
+	if err != nil {
+		log.Printf("analyse du document %d : %v", docID, err)
+		return
+	}
+
+	a, err := h.runAnalysis(ctx, tenantID, docID, path, uploadedAt)
+	if err != nil {
+		log.Printf("analyse du document %d : %v", docID, err)
+		if _, err := h.db.Exec(ctx,
+			`UPDATE documents SET analysis_status = 'failed', analysis_error = $2, analyzed_at = now() WHERE id = $1`,
+			docID, err.Error(),
+		); err != nil {
+			log.Printf("analyse du document %d : mise à jour du statut : %v", docID, err)
+		}
+		return
+	}
+
+	raw, _ := json.Marshal(a)
+	var kind, source *string
+	if ic, ok := invoiceCategories[a.InvoiceType]; ok {
+		kind = &ic.Kind
+	}
+	if a.Supplier != "" {
+		source = &a.Supplier
+	}
+	if _, err := h.db.Exec(ctx,
+		`UPDATE documents
+		 SET analysis = $2::jsonb, analysis_status = 'done', analyzed_at = now(),
+		     kind = COALESCE($3, kind), source = COALESCE($4, source)
+		 WHERE id = $1`,
+		docID, string(raw), kind, source,
+	); err != nil {
+		log.Printf("analyse du document %d : enregistrement : %v", docID, err)
+	}
+}
+
+// runAnalysis lit le fichier, en extrait le texte et les données de facture, et
+// rattache les entrées proposées au site déjà utilisé pour le même point de livraison.
+func (h *DocumentsHandler) runAnalysis(ctx context.Context, tenantID, docID int64, path string, uploadedAt time.Time) (documentAnalysis, error) {
+	rc, _, err := h.storage.Open(ctx, path)
+	if err != nil {
+		return documentAnalysis{}, fmt.Errorf("lecture du fichier : %w", err)
+	}
+	data, err := io.ReadAll(io.LimitReader(rc, maxAnalysisFileBytes+1))
+	rc.Close()
+	if err != nil {
+		return documentAnalysis{}, fmt.Errorf("lecture du fichier : %w", err)
+	}
+	if len(data) > maxAnalysisFileBytes {
+		return documentAnalysis{}, errors.New("fichier trop volumineux pour l'analyse")
+	}
+	text, err := extractPDFText(data)
+	if err != nil {
+		return documentAnalysis{}, fmt.Errorf("extraction du texte : %w", err)
+	}
+
+	a := analyzeInvoiceText(text)
+	if strings.TrimSpace(text) == "" {
+		a.Warnings = append(a.Warnings, "aucun texte extractible (document scanné ?)")
+	}
+	a.TextExcerpt = text
+	if r := []rune(text); len(r) > 2000 {
+		a.TextExcerpt = string(r[:2000])
+	}
+	a.proposeEntries(docID, uploadedAt)
+
+	if a.DeliveryPoint != "" && len(a.Proposed) > 0 {
+		var siteID int64
+		err := h.db.QueryRow(ctx,
+			`SELECT site_id FROM entries
+			 WHERE tenant_id = $1 AND metadata->>$2 = $3 AND site_id IS NOT NULL
+			 ORDER BY date DESC
+			 LIMIT 1`,
+			tenantID, a.DeliveryPointOf, a.DeliveryPoint,
+		).Scan(&siteID)
+		if err == nil {
+			a.Proposed[0].SiteID = &siteID
+		} else if !errors.Is(err, pgx.ErrNoRows) {
+			return documentAnalysis{}, err
+		}
+	}
+	return a, nil
+}
+
+// GET /api/tenants/:tenantId/documents/:documentId/analysis
+// Statut et résultat de l'analyse d'un document.
+func (h *DocumentsHandler) GetAnalysis(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var status string
+	var analysisErr *string
+	var raw []byte
+	var analyzedAt, confirmedAt *time.Time
+	err := h.db.QueryRow(ctx,
+		`SELECT analysis_status, analysis_error, analysis, analyzed_at, entries_confirmed_at
+		 FROM documents
+		 WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`,
+		docID, tenantID,
+	).Scan(&status, &analysisErr, &raw, &analyzedAt, &confirmedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de l'analyse", "details": err.Error()})
+		return
+	}
+
+	resp := gin.H{
+		"document_id":          docID,
+		"status":               status,
+		"error":                analysisErr,
+		"analyzed_at":          analyzedAt,
+		"entries_confirmed_at": confirmedAt,
+		"analysis":             nil,
+	}
+	if len(raw) > 0 {
+		resp["analysis"] = json.RawMessage(raw)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// POST /api/tenants/:tenantId/documents/:documentId/analyze
+// Relance l'analyse d'un document (ex: après amélioration des règles d'extraction).
+func (h *DocumentsHandler) Reanalyze(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var mime, status string
+	var confirmedAt *time.Time
+	err := h.db.QueryRow(ctx,
+		`SELECT mime_type, analysis_status, entries_confirmed_at FROM documents WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`,
+		docID, tenantID,
+	).Scan(&mime, &status, &confirmedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document", "details": err.Error()})
+		return
+	}
+	if !isAnalyzableMime(mime) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "seuls les PDF peuvent être analysés", "details": mime})
+		return
+	}
+	if status == "pending" || status == "processing" {
+		c.JSON(http.StatusConflict, gin.H{"error": "analyse déjà en cours"})
+		return
+	}
+	if confirmedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "les entrées de ce document ont déjà été confirmées"})
+		return
+	}
+
+	if _, err := h.db.Exec(ctx, `UPDATE documents SET analysis_status = 'pending' WHERE id = $1`, docID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de relancer l'analyse", "details": err.Error()})
+		return
+	}
+	go h.analyzeDocument(docID)
+
+	c.JSON(http.StatusAccepted, gin.H{"document_id": docID, "status": "pending"})
+}
+
+type confirmDocumentEntriesRequest struct {
+	// Entrées corrigées par l'utilisateur ; à défaut, les entrées proposées par l'analyse.
+	Entries []createEntryRequest `json:"entries"`
+	// Site appliqué aux entrées qui n'en précisent pas.
+	SiteID *int64 `json:"site_id"`
+}
+
+// POST /api/tenants/:tenantId/documents/:documentId/confirm
+// Crée en un appel les entrées proposées par l'analyse (éventuellement corrigées).
+func (h *DocumentsHandler) ConfirmEntries(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	var req confirmDocumentEntriesRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	var raw []byte
+	var confirmedAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT analysis_status, analysis, entries_confirmed_at
+		 FROM documents
+		 WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		 FOR UPDATE`,
+		docID, tenantID,
+	).Scan(&status, &raw, &confirmedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document", "details": err.Error()})
+		return
+	}
+	if confirmedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "les entrées de ce document ont déjà été confirmées"})
+		return
+	}
+
+	entries := req.Entries
+	if len(entries) == 0 {
+		if status != "done" {
+			c.JSON(http.StatusConflict, gin.H{"error": "analyse non terminée", "details": status})
+			return
+		}
+		var a documentAnalysis
+		if err := json.Unmarshal(raw, &a); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "analyse illisible", "details": err.Error()})
+			return
+		}
+		entries = a.Proposed
+	}
+	if len(entries) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "aucune entrée à créer pour ce document"})
+		return
+	}
+
+	docRef := strconv.FormatInt(docID, 10)
+	ids := make([]int64, 0, len(entries))
+	for i, e := range entries {
+		if e.Type == "" || e.Currency == "" || e.Amount == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "entrée invalide", "details": fmt.Sprintf("entrée %d : type, amount et currency sont requis", i)})
+			return
+		}
+		date, err := time.Parse("2006-01-02", e.Date)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date invalide (format attendu YYYY-MM-DD)", "details": fmt.Sprintf("entrée %d", i)})
+			return
+		}
+		if e.SiteID == nil {
+			e.SiteID = req.SiteID
+		}
+		if e.Metadata == nil {
+			e.Metadata = map[string]string{}
+		}
+		e.Metadata["document_id"] = docRef
+
+		var id int64
+		err = tx.QueryRow(ctx,
+			`INSERT INTO entries (tenant_id, type, amount, currency, date, category, source, metadata, site_id)
+			 SELECT $1,$2,$3,$4,$5,$6,$7,$8::jsonb,$9
+			 WHERE $9::bigint IS NULL OR EXISTS (SELECT 1 FROM sites WHERE id = $9 AND tenant_id = $1)
+			 RETURNING id`,
+			tenantID, e.Type, e.Amount, e.Currency, date, e.Category, e.Source, toJSONB(e.Metadata), e.SiteID,
+		).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "site introuvable", "details": fmt.Sprintf("entrée %d", i)})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'entrée", "details": err.Error()})
+			return
+		}
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO entry_documents (entry_id, document_id, tenant_id, attached_by) VALUES ($1,$2,$3,$4)`,
+			id, docID, tenantID, userIDFromRequest(c),
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de rattacher le document à l'entrée", "details": err.Error()})
+			return
+		}
+		ids = append(ids, id)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE documents SET entries_confirmed_at = now() WHERE id = $1`, docID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour le document", "details": err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la transaction"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"document_id":        docID,
+		"entry_ids":          ids,
+		"recompute_required": true,
+	})
+}
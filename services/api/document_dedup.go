@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Déduplication des documents : les fichiers sont stockés sous leur empreinte
+// SHA-256, un même contenu n'est enregistré qu'une fois par tenant, et
+// l'empreinte conservée permet de vérifier plus tard l'intégrité des justificatifs.
+
+// documentContentKey renvoie la clé de stockage adressée par le contenu.
+func documentContentKey(tenantID int64, sum string) string {
+	return fmt.Sprintf("tenant_%d/sha256/%s/%s", tenantID, sum[:2], sum)
+}
+
+// hashUpload calcule l'empreinte SHA-256 du fichier puis le rembobine.
+// En cas d'échec la réponse d'erreur est déjà écrite.
+func hashUpload(c *gin.Context, file io.ReadSeeker) (string, bool) {
+	sum, err := sha256Hex(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de lire le fichier", "details": err.Error()})
+		return "", false
+	}
+	return sum, true
+}
+
+func sha256Hex(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// respondWithDuplicate renvoie le document courant du tenant ayant ce contenu,
+// s'il existe. Renvoie true si une réponse a été écrite (doublon ou erreur).
+func (h *DocumentsHandler) respondWithDuplicate(c *gin.Context, tenantID int64, sum string) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var id, size int64
+	var name, mimeType, analysisStatus string
+	var version int
+	err := h.db.QueryRow(ctx,
+		`SELECT id, original_name, mime_type, size_bytes, version, analysis_status
+		 FROM documents
+		 WHERE tenant_id = $1 AND sha256 = $2 AND deleted_at IS NULL AND superseded_by IS NULL`,
+		tenantID, sum,
+	).Scan(&id, &name, &mimeType, &size, &version, &analysisStatus)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la recherche de doublons"})
+		return true
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":              id,
+		"original_name":   name,
+		"mime_type":       mimeType,
+		"size_bytes":      size,
+		"version":         version,
+		"analysis_status": analysisStatus,
+		"sha256":          sum,
+		"duplicate":       true,
+	})
+	return true
+}
+
+// releaseFile supprime un fichier du stockage s'il n'est plus référencé par
+// aucun autre document non purgé : un même contenu peut être partagé par
+// plusieurs lignes (document supprimé puis importé à nouveau, par exemple).
+func (h *DocumentsHandler) releaseFile(ctx context.Context, key string, exceptID int64) error {
+	var shared bool
+	err := h.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM documents WHERE storage_path = $1 AND id <> $2 AND purged_at IS NULL)`,
+		key, exceptID,
+	).Scan(&shared)
+	if err != nil {
+		return err
+	}
+	if shared {
+		return nil
+	}
+	return h.storage.Delete(ctx, key)
+}
+
+// GET /api/tenants/:tenantId/documents/:documentId/verify
+// Recalcule l'empreinte SHA-256 du fichier stocké et la compare à celle
+// enregistrée à l'upload. Pour les documents antérieurs au calcul des
+// empreintes, l'empreinte calculée est enregistrée comme référence.
+func (h *DocumentsHandler) VerifyDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var expected *string
+	var path string
+	err := h.db.QueryRow(ctx,
+		`SELECT sha256, storage_path
+		 FROM documents
+		 WHERE id = $1 AND tenant_id = $2 AND purged_at IS NULL`,
+		docID, tenantID,
+	).Scan(&expected, &path)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document"})
+		return
+	}
+
+	// Le contexte de la requête (et non celui de 5 s) borne la lecture du fichier.
+	rc, _, err := h.storage.Open(c.Request.Context(), path)
+	if errors.Is(err, errStorageNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": "fichier absent du stockage"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de lire le fichier"})
+		return
+	}
+	computed, err := sha256Hex(rc)
+	rc.Close()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de lire le fichier", "details": err.Error()})
+		return
+	}
+
+	recorded := false
+	if expected == nil {
+		uctx, ucancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer ucancel()
+		_, err := h.db.Exec(uctx,
+			`UPDATE documents SET sha256 = $3 WHERE id = $1 AND tenant_id = $2 AND sha256 IS NULL`,
+			docID, tenantID, computed,
+		)
+		// Un autre document courant peut déjà porter ce contenu : l'empreinte
+		// n'est alors pas enregistrée, mais la réponse la donne quand même.
+		if err != nil && !isUniqueViolation(err) {
+			log.Printf("enregistrement de l'empreinte du document %d : %v", docID, err)
+		}
+		recorded = err == nil
+		expected = &computed
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":              docID,
+		"sha256":          *expected,
+		"computed_sha256": computed,
+		"valid":           *expected == computed,
+		"recorded":        recorded,
+		"verified_at":     time.Now().UTC(),
+	})
+}
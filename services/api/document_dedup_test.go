@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDocumentContentKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenant  int64
+		content string
+		want    string
+	}{
+		{"contenu vide", 7, "", "tenant_7/sha256/e3/e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"facture", 42, "facture", "tenant_42/sha256/70/700705415442b8015ee24ae925043596dd2718263ec58b7362b37eb80e8bcb71"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum, err := sha256Hex(strings.NewReader(tt.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := documentContentKey(tt.tenant, sum); got != tt.want {
+				t.Errorf("documentContentKey = %q, attendu %q", got, tt.want)
+			}
+		})
+	}
+
+	// Un même contenu n'est partagé qu'au sein d'un tenant.
+	sum := mustSha256("facture")
+	if documentContentKey(1, sum) == documentContentKey(2, sum) {
+		t.Error("deux tenants partagent la même clé de stockage")
+	}
+	if mustSha256("facture") == mustSha256("facture ") {
+		t.Error("deux contenus différents ont la même empreinte")
+	}
+}
+
+func mustSha256(s string) string {
+	sum, err := sha256Hex(strings.NewReader(s))
+	if err != nil {
+		panic(err)
+	}
+	return sum
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Cycle de vie des documents : téléchargement, suppression logique avec purge
+// différée des fichiers, et remplacement par une nouvelle version.
+
+// documentVersion est une version d'un document telle que listée dans l'historique.
+type documentVersion struct {
+	ID           int64      `json:"id"`
+	Version      int        `json:"version"`
+	OriginalName string     `json:"original_name"`
+	MimeType     string     `json:"mime_type"`
+	SizeBytes    int64      `json:"size_bytes"`
+	Sha256       *string    `json:"sha256"`
+	Current      bool       `json:"current"`
+	DeletedAt    *time.Time `json:"deleted_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// GET /api/tenants/:tenantId/documents/:documentId/download?inline=true
+// Renvoie le fichier en flux, avec son type et son nom d'origine. Les versions
+// antérieures restent téléchargeables par leur identifiant.
+func (h *DocumentsHandler) DownloadDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var name, mimeType, path string
+	err := h.db.QueryRow(ctx,
+		`SELECT original_name, mime_type, storage_path
+		 FROM documents
+		 WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`,
+		docID, tenantID,
+	).Scan(&name, &mimeType, &path)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document"})
+		return
+	}
+
+	// Le contexte de la requête (et non celui de 5 s) borne la lecture du flux.
+	rc, size, err := h.storage.Open(c.Request.Context(), path)
+	if errors.Is(err, errStorageNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": "fichier absent du stockage"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de lire le fichier"})
+		return
+	}
+	defer rc.Close()
+
+	disposition := "attachment"
+	if c.Query("inline") == "true" {
+		disposition = "inline"
+	}
+
+	c.DataFromReader(http.StatusOK, size, mimeType, rc, map[string]string{
+		"Content-Disposition":    contentDisposition(disposition, name),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
+
+// GET /api/tenants/:tenantId/documents/:documentId/download-url?expires=900
+// Renvoie une URL de téléchargement temporaire (présignée), utilisable sans
+// authentification jusqu'à son expiration (en secondes, 15 min par défaut, 24 h max).
+func (h *DocumentsHandler) DownloadURL(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+	expires := 15 * time.Minute
+	if raw := c.Query("expires"); raw != "" {
+		secs, err := strconv.Atoi(raw)
+		if err != nil || secs < 60 || secs > 86400 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires doit être compris entre 60 et 86400 secondes"})
+			return
+		}
+		expires = time.Duration(secs) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var name, mimeType, key string
+	err := h.db.QueryRow(ctx,
+		`SELECT original_name, mime_type, storage_path
+		 FROM documents
+		 WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`,
+		docID, tenantID,
+	).Scan(&name, &mimeType, &key)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document"})
+		return
+	}
+
+	signed, err := h.storage.PresignGet(ctx, key, expires, name, mimeType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le lien de téléchargement", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"url":        signed,
+		"expires_at": time.Now().Add(expires).UTC(),
+	})
+}
+
+// DELETE /api/tenants/:tenantId/documents/:documentId
+// Suppression logique du document et de toutes ses versions. Les fichiers sont
+// effacés après le délai de rétention ; le document peut être restauré d'ici là.
+func (h *DocumentsHandler) DeleteDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var deletedAt time.Time
+	err := h.db.QueryRow(ctx,
+		`WITH target AS (
+		     SELECT COALESCE(version_of, id) AS family FROM documents
+		     WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL
+		 )
+		 UPDATE documents d SET deleted_at = now()
+		 FROM target
+		 WHERE d.tenant_id = $2 AND COALESCE(d.version_of, d.id) = target.family AND d.deleted_at IS NULL
+		 RETURNING d.deleted_at`,
+		docID, tenantID,
+	).Scan(&deletedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer le document", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":          docID,
+		"deleted_at":  deletedAt,
+		"purge_after": deletedAt.Add(h.retention),
+	})
+}
+
+// POST /api/tenants/:tenantId/documents/:documentId/restore
+// Annule une suppression tant que les fichiers n'ont pas été purgés.
+func (h *DocumentsHandler) RestoreDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`WITH target AS (
+		     SELECT COALESCE(version_of, id) AS family FROM documents
+		     WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL AND purged_at IS NULL
+		 )
+		 UPDATE documents d SET deleted_at = NULL
+		 FROM target
+		 WHERE d.tenant_id = $2 AND COALESCE(d.version_of, d.id) = target.family AND d.purged_at IS NULL`,
+		docID, tenantID,
+	)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "un document identique a été importé depuis la suppression"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de restaurer le document", "details": err.Error()})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "aucun document supprimé et restaurable avec cet identifiant"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": docID, "restored_versions": tag.RowsAffected()})
+}
+
+// POST /api/tenants/:tenantId/documents/:documentId/versions
+// Remplace un document par une version corrigée (multipart, champ "file"). La
+// version précédente est conservée dans l'historique ; les liens justificatifs
+// restent sur la version rattachée et sont lus sur la version courante.
+func (h *DocumentsHandler) ReplaceDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	file, header, ok := uploadedFile(c)
+	if !ok {
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Vérification préalable pour ne pas stocker de fichier inutilement.
+	var superseded *int64
+	var currentSum *string
+	err := h.db.QueryRow(ctx,
+		`SELECT superseded_by, sha256 FROM documents WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL`,
+		docID, tenantID,
+	).Scan(&superseded, &currentSum)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document"})
+		return
+	}
+	if superseded != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "seule la version courante peut être remplacée", "details": gin.H{"current_id": *superseded}})
+		return
+	}
+
+	sum, ok := hashUpload(c, file)
+	if !ok {
+		return
+	}
+	if currentSum != nil && *currentSum == sum {
+		c.JSON(http.StatusConflict, gin.H{"error": "le fichier est identique à la version courante"})
+		return
+	}
+
+	contentType, ok := h.validateUpload(c, tenantID, file, header)
+	if !ok {
+		return
+	}
+	storageKey, written, ok := h.saveUploadedFile(c, tenantID, sum, file, header.Size, contentType)
+	if !ok {
+		return
+	}
+	// L'envoi du fichier au stockage peut avoir consommé le délai précédent.
+	ctx, cancel = context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+	// Le fichier est retiré si la nouvelle version n'est pas enregistrée (et
+	// qu'aucun autre document ne partage ce contenu).
+	committed := false
+	defer func() {
+		if !committed {
+			if err := h.releaseFile(context.Background(), storageKey, 0); err != nil {
+				log.Printf("nettoyage du fichier %s : %v", storageKey, err)
+			}
+		}
+	}()
+
+	analysisStatus := "none"
+	if isAnalyzableMime(contentType) {
+		analysisStatus = "pending"
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Verrou sur la version courante : deux remplacements simultanés ne peuvent pas aboutir.
+	var family int64
+	var version int
+	var confirmedAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT COALESCE(version_of, id), version, entries_confirmed_at
+		 FROM documents
+		 WHERE id = $1 AND tenant_id = $2 AND deleted_at IS NULL AND superseded_by IS NULL
+		 FOR UPDATE`,
+		docID, tenantID,
+	).Scan(&family, &version, &confirmedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "le document a été modifié entre-temps"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du document"})
+		return
+	}
+
+	// Les entrées déjà créées depuis ce document ne sont pas proposées une seconde fois.
+	var newID int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO documents (tenant_id, original_name, mime_type, size_bytes, storage_path,
+		                        analysis_status, version, version_of, entries_confirmed_at, sha256)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)
+		 RETURNING id`,
+		tenantID, header.Filename, contentType, written, storageKey,
+		analysisStatus, version+1, family, confirmedAt, sum,
+	).Scan(&newID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "un autre document du tenant a déjà ce contenu"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer la nouvelle version", "details": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(ctx, `UPDATE documents SET superseded_by = $2 WHERE id = $1`, docID, newID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour la version précédente", "details": err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la transaction"})
+		return
+	}
+	committed = true
+
+	if analysisStatus == "pending" {
+		go h.analyzeDocument(newID)
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":              newID,
+		"version":         version + 1,
+		"replaces":        docID,
+		"original_name":   header.Filename,
+		"mime_type":       contentType,
+		"size_bytes":      written,
+		"analysis_status": analysisStatus,
+		"sha256":          sum,
+	})
+}
+
+// GET /api/tenants/:tenantId/documents/:documentId/versions
+// Historique des versions du document, de la plus récente à la plus ancienne.
+func (h *DocumentsHandler) ListVersions(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT d.id, d.version, d.original_name, d.mime_type, d.size_bytes, d.sha256,
+		        d.superseded_by IS NULL, d.deleted_at, d.created_at
+		 FROM documents d
+		 JOIN documents t ON COALESCE(t.version_of, t.id) = COALESCE(d.version_of, d.id)
+		 WHERE t.id = $1 AND t.tenant_id = $2 AND d.tenant_id = $2 AND d.purged_at IS NULL
+		 ORDER BY d.version DESC`,
+		docID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des versions"})
+		return
+	}
+	defer rows.Close()
+
+	var versions []documentVersion
+	for rows.Next() {
+		var v documentVersion
+		if err := rows.Scan(&v.ID, &v.Version, &v.OriginalName, &v.MimeType, &v.SizeBytes, &v.Sha256,
+			&v.Current, &v.DeletedAt, &v.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des versions"})
+			return
+		}
+		versions = append(versions, v)
+	}
+	if len(versions) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "document introuvable"})
+		return
+	}
+	c.JSON(http.StatusOK, versions)
+}
+
+// RunPurge supprime périodiquement les fichiers des documents supprimés depuis
+// plus longtemps que le délai de rétention, jusqu'à l'annulation du contexte.
+func (h *DocumentsHandler) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if n, err := h.purgeDeleted(ctx); err != nil {
+			log.Printf("purge des documents supprimés : %v", err)
+		} else if n > 0 {
+			log.Printf("purge des documents supprimés : %d fichier(s) effacé(s)", n)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeleted efface les fichiers arrivés en fin de rétention. La ligne est
+// conservée (purged_at) pour garder la trace du document dans la piste d'audit.
+func (h *DocumentsHandler) purgeDeleted(ctx context.Context) (int, error) {
+	qctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(qctx,
+		`SELECT id, storage_path FROM documents
+		 WHERE deleted_at IS NOT NULL AND purged_at IS NULL AND deleted_at < $1
+		 ORDER BY deleted_at
+		 LIMIT 500`,
+		time.Now().Add(-h.retention),
+	)
+	if err != nil {
+		return 0, err
+	}
+	type target struct {
+		id   int64
+		path string
+	}
+	var targets []target
+	for rows.Next() {
+		var t target
+		if err := rows.Scan(&t.id, &t.path); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		targets = append(targets, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, t := range targets {
+		if err := h.releaseFile(qctx, t.path, t.id); err != nil {
+			log.Printf("purge du document %d : %v", t.id, err)
+			continue
+		}
+		if _, err := h.db.Exec(qctx, `UPDATE documents SET purged_at = now() WHERE id = $1`, t.id); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+	return purged, nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"io"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strings"
 	"time"
@@ -16,11 +15,14 @@ import (
 
 // DocumentsHandler gère l'upload et la liste des documents (factures, PDF, etc.).
 type DocumentsHandler struct {
-	db *pgxpool.Pool
+	db        *pgxpool.Pool
+	storage   DocumentStorage
+	scanner   MalwareScanner
+	retention time.Duration // délai avant suppression des fichiers des documents supprimés
 }
 
-func NewDocumentsHandler(db *pgxpool.Pool) *DocumentsHandler {
-	return &DocumentsHandler{db: db}
+func NewDocumentsHandler(db *pgxpool.Pool, cfg Config, storage DocumentStorage, scanner MalwareScanner) *DocumentsHandler {
+	return &DocumentsHandler{db: db, storage: storage, scanner: scanner, retention: cfg.DocumentRetention}
 }
 
 // POST /api/tenants/:tenantId/documents
@@ -54,77 +56,76 @@ func (h *DocumentsHandler) UploadDocument(c *gin.Context) {
 		return
 	}
 
-	file, header, err := c.Request.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "fichier manquant", "details": err.Error()})
+	file, header, ok := uploadedFile(c)
+	if !ok {
 		return
 	}
 	defer file.Close()
 
-	if header.Size <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "fichier vide"})
-		return
-	}
-
-	// Très simple filtrage de type mime : on accepte surtout les PDF et images.
-	contentType := header.Header.Get("Content-Type")
-	if contentType == "" {
-		contentType = http.DetectContentType(make([]byte, 512))
-	}
-	if !isAllowedMime(contentType) {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "type de fichier non supporté", "details": contentType})
+	// Un contenu déjà présent dans le tenant n'est pas enregistré une seconde fois.
+	sum, ok := hashUpload(c, file)
+	if !ok {
 		return
 	}
-
-	// Répertoire de stockage local (simple pour le MVP).
-	baseDir := "uploads"
-	tenantDir := filepath.Join(baseDir, "tenant_"+pathTenant)
-	if err := os.MkdirAll(tenantDir, 0o755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer le répertoire de stockage"})
+	if h.respondWithDuplicate(c, tenantIDInt, sum) {
 		return
 	}
 
-	safeName := sanitizeFilename(header.Filename)
-	filename := time.Now().Format("20060102-150405") + "-" + safeName
-	fullPath := filepath.Join(tenantDir, filename)
-
-	dst, err := os.Create(fullPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de sauvegarder le fichier"})
+	contentType, ok := h.validateUpload(c, tenantIDInt, file, header)
+	if !ok {
 		return
 	}
-	defer dst.Close()
 
-	written, err := io.Copy(dst, file)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la copie du fichier"})
+	storageKey, written, ok := h.saveUploadedFile(c, tenantIDInt, sum, file, header.Size, contentType)
+	if !ok {
 		return
 	}
 
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
+	// Les PDF sont analysés en arrière-plan (type de facture, kWh, montant...).
+	analysisStatus := "none"
+	if isAnalyzableMime(contentType) {
+		analysisStatus = "pending"
+	}
+
 	var docID int64
-	err = h.db.QueryRow(ctx,
-		`INSERT INTO documents (tenant_id, original_name, mime_type, size_bytes, storage_path)
-		 VALUES ($1,$2,$3,$4,$5)
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO documents (tenant_id, original_name, mime_type, size_bytes, storage_path, analysis_status, sha256)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7)
 		 RETURNING id`,
 		tenantIDInt,
 		header.Filename,
 		contentType,
 		written,
-		fullPath,
+		storageKey,
+		analysisStatus,
+		sum,
 	).Scan(&docID)
+	if isUniqueViolation(err) {
+		// Upload simultané du même contenu : le premier enregistré l'emporte.
+		if !h.respondWithDuplicate(c, tenantIDInt, sum) {
+			c.JSON(http.StatusConflict, gin.H{"error": "un document identique est en cours d'enregistrement"})
+		}
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer le document", "details": err.Error()})
 		return
 	}
+	if analysisStatus == "pending" {
+		go h.analyzeDocument(docID)
+	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"id":            docID,
-		"original_name": header.Filename,
-		"mime_type":     contentType,
-		"size_bytes":    written,
+		"id":              docID,
+		"original_name":   header.Filename,
+		"mime_type":       contentType,
+		"size_bytes":      written,
+		"analysis_status": analysisStatus,
+		"sha256":          sum,
+		"duplicate":       false,
 	})
 }
 
@@ -162,9 +163,9 @@ func (h *DocumentsHandler) ListDocuments(c *gin.Context) {
 	defer cancel()
 
 	rows, err := h.db.Query(ctx,
-		`SELECT id, original_name, mime_type, size_bytes, created_at
+		`SELECT id, original_name, mime_type, size_bytes, sha256, version, analysis_status, kind, source, created_at
 		 FROM documents
-		 WHERE tenant_id = $1
+		 WHERE tenant_id = $1 AND deleted_at IS NULL AND superseded_by IS NULL
 		 ORDER BY created_at DESC
 		 LIMIT 100`,
 		tenantIDInt,
@@ -176,18 +177,23 @@ func (h *DocumentsHandler) ListDocuments(c *gin.Context) {
 	defer rows.Close()
 
 	type docItem struct {
-		ID           int64  `json:"id"`
-		OriginalName string `json:"original_name"`
-		MimeType     string `json:"mime_type"`
-		SizeBytes    int64  `json:"size_bytes"`
-		CreatedAt    string `json:"created_at"`
+		ID             int64   `json:"id"`
+		OriginalName   string  `json:"original_name"`
+		MimeType       string  `json:"mime_type"`
+		SizeBytes      int64   `json:"size_bytes"`
+		Sha256         *string `json:"sha256"`
+		Version        int     `json:"version"`
+		AnalysisStatus string  `json:"analysis_status"`
+		Kind           *string `json:"kind"`
+		Source         *string `json:"source"`
+		CreatedAt      string  `json:"created_at"`
 	}
 
 	var docs []docItem
 	for rows.Next() {
 		var d docItem
 		var created time.Time
-		if err := rows.Scan(&d.ID, &d.OriginalName, &d.MimeType, &d.SizeBytes, &created); err != nil {
+		if err := rows.Scan(&d.ID, &d.OriginalName, &d.MimeType, &d.SizeBytes, &d.Sha256, &d.Version, &d.AnalysisStatus, &d.Kind, &d.Source, &created); err != nil {
 			continue
 		}
 		d.CreatedAt = created.Format(time.RFC3339)
@@ -197,22 +203,17 @@ func (h *DocumentsHandler) ListDocuments(c *gin.Context) {
 	c.JSON(http.StatusOK, docs)
 }
 
-// isAllowedMime filtre quelques types simples pour l'upload.
-func isAllowedMime(m string) bool {
-	if m == "" {
-		return false
-	}
-	m = strings.ToLower(m)
-	if strings.HasPrefix(m, "application/pdf") {
-		return true
-	}
-	if strings.HasPrefix(m, "image/") {
-		return true
-	}
-	if m == "text/csv" || strings.Contains(m, "excel") {
-		return true
+// saveUploadedFile enregistre le fichier dans le stockage des documents, sous
+// son empreinte SHA-256, et renvoie sa clé et sa taille. En cas d'échec la
+// réponse d'erreur est déjà écrite.
+func (h *DocumentsHandler) saveUploadedFile(c *gin.Context, tenantID int64, sum string, file io.Reader, size int64, contentType string) (string, int64, bool) {
+	key := documentContentKey(tenantID, sum)
+	written, err := h.storage.Put(c.Request.Context(), key, file, size, contentType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de sauvegarder le fichier", "details": err.Error()})
+		return "", 0, false
 	}
-	return false
+	return key, written, true
 }
 
 // sanitizeFilename nettoie un nom de fichier pour éviter les caractères problématiques.
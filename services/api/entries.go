@@ -5,6 +5,7 @@ import (
 	"encoding/csv"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -28,6 +29,7 @@ type createEntryRequest struct {
 	Date     string            `json:"date" binding:"required"` // YYYY-MM-DD
 	Category string            `json:"category"`
 	Source   string            `json:"source"`
+	SiteID   *int64            `json:"site_id"`
 	Metadata map[string]string `json:"metadata"`
 }
 
@@ -76,10 +78,17 @@ func (h *EntriesHandler) CreateEntry(c *gin.Context) {
 		return
 	}
 
+	if req.SiteID != nil {
+		if _, err := getSite(ctx, h.db, tenantIDInt, *req.SiteID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "site_id inconnu pour ce tenant"})
+			return
+		}
+	}
+
 	var entryID int64
 	err = h.db.QueryRow(ctx,
-		`INSERT INTO entries (tenant_id, type, amount, currency, date, category, source, metadata)
-		 VALUES ($1,$2,$3,$4,$5,$6,$7,COALESCE($8::jsonb, '{}'::jsonb))
+		`INSERT INTO entries (tenant_id, type, amount, currency, date, category, source, metadata, site_id)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,COALESCE($8::jsonb, '{}'::jsonb),$9)
 		 RETURNING id`,
 		tenantIDInt,
 		req.Type,
@@ -89,6 +98,7 @@ func (h *EntriesHandler) CreateEntry(c *gin.Context) {
 		req.Category,
 		req.Source,
 		toJSONB(req.Metadata),
+		req.SiteID,
 	).Scan(&entryID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'entrée", "details": err.Error()})
@@ -98,14 +108,21 @@ func (h *EntriesHandler) CreateEntry(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"id": entryID})
 }
 
-// GET /api/tenants/:tenantId/entries
+// GET /api/tenants/:tenantId/entries?site_id=12
+// site_id filtre sur le site et ses sites descendants.
 func (h *EntriesHandler) ListEntries(c *gin.Context) {
 	claimsVal, ok := c.Get("user")
 	if !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
 		return
 	}
-	claims := claimsVal.(map[string]interface{})
+	// AuthMiddleware stocke des jwt.MapClaims : une assertion vers un autre
+	// type (map[string]interface{} auparavant) paniquait à chaque appel.
+	claims, ok := claimsVal.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
 
 	pathTenant := c.Param("tenantId")
 	tenantIDFromToken := claims["tenant_id"]
@@ -131,13 +148,35 @@ func (h *EntriesHandler) ListEntries(c *gin.Context) {
 		return
 	}
 
+	siteID, ok := siteIDQuery(c)
+	if !ok {
+		return
+	}
+
+	// Filtre sur un site et ses descendants, comme le drill-down du résumé.
+	var siteIDs []int64
+	if siteID != 0 {
+		sites, err := loadSites(ctx, h.db, tenantIDInt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des sites"})
+			return
+		}
+		if !siteExists(sites, siteID) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "site non trouvé"})
+			return
+		}
+		siteIDs = subtreeIDList(sites, siteID)
+	}
+
 	rows, err := h.db.Query(ctx,
-		`SELECT id, type, amount, currency, date, category, source
+		`SELECT id, type, amount, currency, date, category, source, site_id
 		 FROM entries
 		 WHERE tenant_id = $1
+		   AND ($2::bigint[] IS NULL OR site_id = ANY($2))
 		 ORDER BY date DESC, created_at DESC
 		 LIMIT 100`,
 		tenantIDInt,
+		siteIDs,
 	)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des entrées"})
@@ -149,7 +188,7 @@ func (h *EntriesHandler) ListEntries(c *gin.Context) {
 	for rows.Next() {
 		var e Entry
 		var category, source *string
-		err := rows.Scan(&e.ID, &e.Type, &e.Amount, &e.Currency, &e.Date, &category, &source)
+		err := rows.Scan(&e.ID, &e.Type, &e.Amount, &e.Currency, &e.Date, &category, &source, &e.SiteID)
 		if err != nil {
 			continue
 		}
@@ -166,6 +205,9 @@ func (h *EntriesHandler) ListEntries(c *gin.Context) {
 		if source != nil {
 			entry["source"] = *source
 		}
+		if e.SiteID != nil {
+			entry["site_id"] = *e.SiteID
+		}
 		entries = append(entries, entry)
 	}
 
@@ -173,7 +215,8 @@ func (h *EntriesHandler) ListEntries(c *gin.Context) {
 }
 
 // POST /api/tenants/:tenantId/import (CSV simple)
-// Format attendu (en-têtes) : type,amount,currency,date,category,source
+// Format attendu (en-têtes) : type,amount,currency,date,category,source[,vendor,account]
+// Les lignes sans catégorie sont catégorisées par les règles du tenant lorsqu'une règle s'applique.
 func (h *EntriesHandler) ImportCSV(c *gin.Context) {
 	claimsVal, ok := c.Get("user")
 	if !ok {
@@ -206,6 +249,14 @@ func (h *EntriesHandler) ImportCSV(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
 	defer cancel()
 
+	tenantIDInt, _ := strconv.ParseInt(pathTenant, 10, 64)
+	rules, err := loadTenantRules(ctx, h.db, tenantIDInt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des règles de catégorisation"})
+		return
+	}
+	hits := make(map[int64]int)
+
 	tx, err := h.db.BeginTx(ctx, pgx.TxOptions{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
@@ -213,7 +264,7 @@ func (h *EntriesHandler) ImportCSV(c *gin.Context) {
 	}
 	defer tx.Rollback(ctx)
 
-	inserted := 0
+	inserted, autoCategorized := 0, 0
 	for i, row := range records {
 		if i == 0 {
 			continue // en-tête
@@ -230,19 +281,41 @@ func (h *EntriesHandler) ImportCSV(c *gin.Context) {
 			continue
 		}
 
+		category := valueOrEmpty(row, 4)
+		vendor, account := valueOrEmpty(row, 6), valueOrEmpty(row, 7)
+		var ruleID int64
+		if strings.TrimSpace(category) == "" {
+			e := entryToClassify{Type: row[0], Amount: amount, Currency: row[2], Label: valueOrEmpty(row, 5), Vendor: vendor, Account: account}
+			if r, ok := matchTenantRules(rules, e); ok {
+				category, ruleID = r.Category, r.ID
+			}
+		}
+		metadata := map[string]string{}
+		if vendor != "" {
+			metadata["vendor"] = vendor
+		}
+		if account != "" {
+			metadata["account"] = account
+		}
+
 		_, err = tx.Exec(ctx,
-			`INSERT INTO entries (tenant_id, type, amount, currency, date, category, source)
-			 VALUES ($1,$2,$3,$4,$5,$6,$7)`,
+			`INSERT INTO entries (tenant_id, type, amount, currency, date, category, source, metadata)
+			 VALUES ($1,$2,$3,$4,$5,$6,$7,$8::jsonb)`,
 			tenantIDFromToken,
 			row[0],
 			amount,
 			row[2],
 			dateVal,
-			valueOrEmpty(row, 4),
+			category,
 			valueOrEmpty(row, 5),
+			toJSONB(metadata),
 		)
 		if err == nil {
 			inserted++
+			if ruleID != 0 {
+				autoCategorized++
+				hits[ruleID]++
+			}
 		}
 	}
 
@@ -251,7 +324,9 @@ func (h *EntriesHandler) ImportCSV(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"inserted": inserted})
+	recordRuleHits(ctx, h.db, hits)
+
+	c.JSON(http.StatusOK, gin.H{"inserted": inserted, "auto_categorized": autoCategorized})
 }
 
 func valueOrEmpty(row []string, idx int) string {
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rapport CSRD : points de données quantitatifs de la norme ESRS E1 (changement
+// climatique), alimentant les rapports /app/esg.
+
+const (
+	esrsStatusReported  = "reported"
+	esrsStatusEstimated = "estimated"
+	esrsStatusMissing   = "missing"
+)
+
+// esrsDatapoint est un point de données ESRS E1 avec son statut de complétude.
+type esrsDatapoint struct {
+	Code    string   `json:"code"`    // identifiant interne stable, ex: "E1-6_44a"
+	Ref     string   `json:"ref"`     // référence ESRS, ex: "E1-6 §44 (a)"
+	Concept string   `json:"concept"` // élément de la taxonomie XBRL ESRS
+	Label   string   `json:"label"`
+	Value   *float64 `json:"value"`
+	Unit    string   `json:"unit"`
+	Status  string   `json:"status"` // "reported","estimated","missing"
+	Note    string   `json:"note,omitempty"`
+}
+
+type esrsTargetDatapoint struct {
+	TargetID        int64    `json:"target_id"`
+	Name            string   `json:"name"`
+	Scopes          []string `json:"scopes"`
+	TargetType      string   `json:"target_type"`
+	IntensityMetric *string  `json:"intensity_metric,omitempty"`
+	BaseYear        int      `json:"base_year"`
+	BaseValue       *float64 `json:"base_value"`
+	TargetYear      int      `json:"target_year"`
+	ReductionPct    float64  `json:"reduction_pct"`
+	Pathway         string   `json:"pathway"`
+	SBTiAligned     bool     `json:"sbti_aligned"`
+	Status          string   `json:"status"`
+}
+
+type esrsE1Report struct {
+	TenantID   int64  `json:"tenant_id"`
+	TenantName string `json:"tenant_name"`
+	Siret      string `json:"siret"`
+	Year       int    `json:"year"`
+	// E1-6 : émissions brutes scopes 1, 2, 3 et totales, biogénique, intensité.
+	E16 []esrsDatapoint `json:"e1_6"`
+	// Ventilation du scope 3 par catégorie GHG Protocol (E1-6 §51).
+	Scope3Categories []esrsDatapoint `json:"scope3_categories"`
+	// E1-4 : objectifs de réduction.
+	E14 []esrsTargetDatapoint `json:"e1_4"`
+	// Codes des points de données manquants ou estimés, pour revue.
+	Missing     []string  `json:"missing"`
+	Estimated   []string  `json:"estimated"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// GET /api/tenants/:tenantId/reports/esrs-e1?year=2024&format=json|xbrl
+// Assemble les points de données quantitatifs ESRS E1 de la période, en JSON
+// structuré ou en document xBRL-JSON prêt à être balisé.
+func (h *ReportsHandler) ESRSE1Report(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+	if year == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year est requis (exercice de reporting)"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "xbrl" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format doit valoir 'json' ou 'xbrl'"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	report, err := h.buildESRSE1(ctx, tenantID, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'assembler le rapport ESRS E1", "details": err.Error()})
+		return
+	}
+
+	if format == "xbrl" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="esrs_e1_%d_%d.json"`, tenantID, year))
+		c.JSON(http.StatusOK, report.xbrlJSON())
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *ReportsHandler) buildESRSE1(ctx context.Context, tenantID int64, year int) (esrsE1Report, error) {
+	tenant, err := getTenant(ctx, h.db, tenantID)
+	if err != nil {
+		return esrsE1Report{}, err
+	}
+	lines, err := loadEmissionLines(ctx, h.db, tenantID, year)
+	if err != nil {
+		return esrsE1Report{}, err
+	}
+	dens, err := loadDenominators(ctx, h.db, tenantID)
+	if err != nil {
+		return esrsE1Report{}, err
+	}
+	targets, err := listTargets(ctx, h.db, tenantID)
+	if err != nil {
+		return esrsE1Report{}, err
+	}
+	yearly, err := yearlyEmissionsByScope(ctx, h.db, tenantID)
+	if err != nil {
+		return esrsE1Report{}, err
+	}
+
+	report := esrsE1Report{
+		TenantID:    tenant.ID,
+		TenantName:  tenant.Name,
+		Siret:       tenant.Siret,
+		Year:        year,
+		Missing:     []string{},
+		Estimated:   []string{},
+		GeneratedAt: time.Now().UTC(),
+	}
+
+	byScope := make(map[string][]emissionLine)
+	byCat := make(map[int][]emissionLine)
+	var biogenic float64
+	hasBiogenic := false
+	for _, l := range lines {
+		byScope[l.Scope] = append(byScope[l.Scope], l)
+		if l.Scope == "3" {
+			cat := ghgScope3CategoryFor(begesPosteFor(l))
+			byCat[cat] = append(byCat[cat], l)
+		}
+		if l.Biogenic != nil {
+			biogenic += *l.Biogenic
+			hasBiogenic = true
+		}
+	}
+
+	scope1 := esrsEmissionsDatapoint("E1-6_44a", "E1-6 §44 (a)", "esrs:GrossScope1GreenhouseGasEmissions",
+		"Émissions brutes de GES de scope 1", byScope["1"])
+	scope2Loc := esrsEmissionsDatapoint("E1-6_44b_loc", "E1-6 §44 (b)", "esrs:GrossLocationBasedScope2GreenhouseGasEmissions",
+		"Émissions brutes de GES de scope 2 (méthode location-based)", byScope["2"])
+	scope3 := esrsEmissionsDatapoint("E1-6_44c", "E1-6 §44 (c)", "esrs:GrossScope3GreenhouseGasEmissions",
+		"Émissions brutes de GES de scope 3", byScope["3"])
+	totalLoc := esrsEmissionsDatapoint("E1-6_44d_loc", "E1-6 §44 (d)", "esrs:TotalGHGEmissionsLocationBased",
+		"Émissions totales de GES (location-based)", lines)
+
+	// Le scope 2 market-based nécessite les instruments contractuels (garanties
+	// d'origine, contrats d'achat) qui ne sont pas encore saisis.
+	scope2Mkt := esrsDatapoint{
+		Code: "E1-6_44b_mkt", Ref: "E1-6 §44 (b)", Concept: "esrs:GrossMarketBasedScope2GreenhouseGasEmissions",
+		Label: "Émissions brutes de GES de scope 2 (méthode market-based)", Unit: "tCO2e",
+		Status: esrsStatusMissing, Note: "instruments contractuels (garanties d'origine) non renseignés",
+	}
+	totalMkt := esrsDatapoint{
+		Code: "E1-6_44d_mkt", Ref: "E1-6 §44 (d)", Concept: "esrs:TotalGHGEmissionsMarketBased",
+		Label: "Émissions totales de GES (market-based)", Unit: "tCO2e",
+		Status: esrsStatusMissing, Note: "dépend du scope 2 market-based",
+	}
+	ets := esrsDatapoint{
+		Code: "E1-6_48a", Ref: "E1-6 §48 (a)", Concept: "esrs:PercentageOfScope1GHGEmissionsFromRegulatedEmissionTradingSchemes",
+		Label: "Part du scope 1 couverte par un système d'échange de quotas", Unit: "%",
+		Status: esrsStatusMissing, Note: "périmètre SEQE non renseigné",
+	}
+
+	bio := esrsDatapoint{
+		Code: "E1-6_AR43c", Ref: "E1-6 AR 43 (c)", Concept: "esrs:BiogenicEmissionsOfCO2FromCombustionOrBiodegradationOfBiomassNotIncludedInScope1GHGEmissions",
+		Label: "Émissions de CO2 biogénique hors scope 1", Unit: "tCO2",
+		Status: esrsStatusMissing, Note: "aucune émission biogénique renseignée",
+	}
+	if hasBiogenic {
+		bio.Value, bio.Status, bio.Note = &biogenic, esrsStatusReported, ""
+	}
+
+	intensity := esrsDatapoint{
+		Code: "E1-6_53_loc", Ref: "E1-6 §53", Concept: "esrs:GHGEmissionsIntensityLocationBasedTotalGHGEmissionsPerNetRevenue",
+		Label: "Intensité GES par chiffre d'affaires net (location-based)", Unit: "tCO2e/MEUR",
+		Status: esrsStatusMissing, Note: "chiffre d'affaires de l'exercice non renseigné",
+	}
+	if d, ok := dens[year]["revenue"]; ok && totalLoc.Value != nil {
+		if r, ok := d.ratio(*totalLoc.Value); ok {
+			intensity.Value, intensity.Unit, intensity.Status, intensity.Note = &r.Value, r.Unit, totalLoc.Status, totalLoc.Note
+		}
+	}
+
+	report.E16 = []esrsDatapoint{scope1, ets, scope2Loc, scope2Mkt, scope3, totalLoc, totalMkt, bio, intensity}
+
+	for cat := 1; cat <= 15; cat++ {
+		dp := esrsEmissionsDatapoint(fmt.Sprintf("E1-6_51_cat%d", cat), "E1-6 §51",
+			"esrs:GrossScope3GreenhouseGasEmissions", fmt.Sprintf("Scope 3 — catégorie %d : %s", cat, ghgScope3Categories[cat]), byCat[cat])
+		if len(byCat[cat]) == 0 {
+			// Une catégorie sans donnée n'est pas forcément un manque : elle peut être non matérielle.
+			dp.Status, dp.Note = esrsStatusMissing, "aucune donnée ; à justifier si la catégorie est non matérielle"
+		}
+		report.Scope3Categories = append(report.Scope3Categories, dp)
+	}
+
+	report.E14 = []esrsTargetDatapoint{}
+	for _, t := range targets {
+		td := esrsTargetDatapoint{
+			TargetID:        t.ID,
+			Name:            t.Name,
+			Scopes:          t.Scopes,
+			TargetType:      t.TargetType,
+			IntensityMetric: t.IntensityMetric,
+			BaseYear:        t.BaseYear,
+			TargetYear:      t.TargetYear,
+			ReductionPct:    t.ReductionPct,
+			Pathway:         t.Pathway,
+			Status:          esrsStatusMissing,
+		}
+		if byScopeBase, ok := yearly[t.BaseYear]; ok && t.TargetType == "absolute" {
+			var base float64
+			for _, s := range t.Scopes {
+				base += byScopeBase[s]
+			}
+			if base > 0 {
+				td.BaseValue, td.Status = &base, esrsStatusReported
+			}
+		}
+		td.SBTiAligned = sbtiAligned(t)
+		report.E14 = append(report.E14, td)
+	}
+	if len(report.E14) == 0 {
+		report.Missing = append(report.Missing, "E1-4")
+	}
+
+	for _, dp := range append(report.E16, report.Scope3Categories...) {
+		switch dp.Status {
+		case esrsStatusMissing:
+			report.Missing = append(report.Missing, dp.Code)
+		case esrsStatusEstimated:
+			report.Estimated = append(report.Estimated, dp.Code)
+		}
+	}
+	for _, td := range report.E14 {
+		if td.Status == esrsStatusMissing {
+			report.Missing = append(report.Missing, "E1-4_target_"+strconv.FormatInt(td.TargetID, 10))
+		}
+	}
+
+	return report, nil
+}
+
+// esrsEmissionsDatapoint somme les émissions et déduit le statut : estimé dès
+// qu'une part provient de ratios monétaires, manquant si aucune donnée.
+func esrsEmissionsDatapoint(code, ref, concept, label string, lines []emissionLine) esrsDatapoint {
+	dp := esrsDatapoint{Code: code, Ref: ref, Concept: concept, Label: label, Unit: "tCO2e"}
+	if len(lines) == 0 {
+		dp.Status, dp.Note = esrsStatusMissing, "aucune émission calculée sur la période"
+		return dp
+	}
+	var total, spend float64
+	for _, l := range lines {
+		total += l.TCO2e
+		if l.isSpendBased() {
+			spend += l.TCO2e
+		}
+	}
+	dp.Value = &total
+	dp.Status = esrsStatusReported
+	if spend > 0 {
+		dp.Status = esrsStatusEstimated
+		dp.Note = fmt.Sprintf("%.0f%% estimé par ratios monétaires", spend/total*100)
+	}
+	return dp
+}
+
+// xbrlJSON produit un document xBRL-JSON (OIM) contenant un fait par point de
+// données renseigné, à charger dans un outil de balisage iXBRL ESEF/ESRS.
+func (r esrsE1Report) xbrlJSON() gin.H {
+	entity := "carbonv2:tenant-" + strconv.FormatInt(r.TenantID, 10)
+	if r.Siret != "" {
+		entity = "siret:" + r.Siret
+	}
+	period := fmt.Sprintf("%d-01-01T00:00:00/%d-01-01T00:00:00", r.Year, r.Year+1)
+
+	facts := gin.H{}
+	skipped := []string{}
+	n := 0
+	for _, dp := range append(r.E16, r.Scope3Categories...) {
+		if dp.Value == nil {
+			skipped = append(skipped, dp.Code)
+			continue
+		}
+		n++
+		value, decimals := *dp.Value, 3
+		if dp.Unit == "tCO2e/MEUR" {
+			// La taxonomie exprime l'intensité par unité monétaire (EUR).
+			value, decimals = value/1e6, 9
+		}
+		dims := gin.H{
+			"concept": dp.Concept,
+			"entity":  entity,
+			"period":  period,
+			"unit":    xbrlUnit(dp.Unit),
+		}
+		if cat, ok := strings.CutPrefix(dp.Code, "E1-6_51_cat"); ok {
+			dims["esrs:Scope3CategoryAxis"] = "esrs:Scope3Category" + cat + "Member"
+		}
+		facts[fmt.Sprintf("f%d", n)] = gin.H{
+			"value":           strconv.FormatFloat(value, 'f', decimals, 64),
+			"decimals":        decimals,
+			"dimensions":      dims,
+			"carbonv2:status": dp.Status,
+		}
+	}
+
+	return gin.H{
+		"documentInfo": gin.H{
+			"documentType": "https://xbrl.org/2021/xbrl-json",
+			"namespaces": gin.H{
+				"esrs":     "https://xbrl.efrag.org/taxonomy/esrs/2023-12-22",
+				"xbrli":    "http://www.xbrl.org/2003/instance",
+				"iso4217":  "http://www.xbrl.org/2003/iso4217",
+				"utr":      "http://www.xbrl.org/2009/utr",
+				"siret":    "http://www.insee.fr/siret",
+				"carbonv2": "https://carbonv2.app/xbrl",
+			},
+			"taxonomy": []string{"https://xbrl.efrag.org/taxonomy/esrs/2023-12-22/esrs_all.xsd"},
+		},
+		"facts":              facts,
+		"carbonv2:notTagged": skipped,
+	}
+}
+
+// xbrlUnit convertit une unité du rapport en mesure xBRL (registre UTR).
+func xbrlUnit(unit string) string {
+	switch unit {
+	case "tCO2e", "tCO2":
+		return "utr:tCO2e"
+	case "tCO2e/MEUR":
+		return "utr:tCO2e/iso4217:EUR"
+	case "%":
+		return "xbrli:pure"
+	default:
+		return unit
+	}
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// EvidenceHandler gère les pièces justificatives des entrées (lien entrée <->
+// document) et le contrôle de matérialité attendu par les auditeurs.
+type EvidenceHandler struct {
+	db *pgxpool.Pool
+}
+
+func NewEvidenceHandler(db *pgxpool.Pool) *EvidenceHandler {
+	return &EvidenceHandler{db: db}
+}
+
+// evidenceDocument est un justificatif tel que présenté dans les listes et exports.
+type evidenceDocument struct {
+	DocumentID         int64     `json:"document_id"`          // version courante
+	AttachedDocumentID int64     `json:"attached_document_id"` // version rattachée à l'entrée
+	OriginalName       string    `json:"original_name"`
+	MimeType           string    `json:"mime_type"`
+	Sha256             *string   `json:"sha256"`
+	Note               *string   `json:"note"`
+	AttachedBy         *int64    `json:"attached_by"`
+	AttachedAt         time.Time `json:"attached_at"`
+}
+
+type attachEvidenceRequest struct {
+	DocumentID int64  `json:"document_id" binding:"required"`
+	Note       string `json:"note"`
+}
+
+// POST /api/tenants/:tenantId/entries/:entryId/documents
+// Rattache un document justificatif à une entrée.
+func (h *EvidenceHandler) AttachDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	entryID, ok := int64Param(c, "entryId")
+	if !ok {
+		return
+	}
+
+	var req attachEvidenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var note *string
+	if n := strings.TrimSpace(req.Note); n != "" {
+		note = &n
+	}
+
+	// L'entrée et le document doivent appartenir au tenant ; un lien existant
+	// (sur cette version ou une version précédente) n'est pas dupliqué.
+	var link EntryDocument
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO entry_documents (entry_id, document_id, tenant_id, note, attached_by)
+		 SELECT e.id, d.id, e.tenant_id, $4, $5
+		 FROM entries e, documents d
+		 WHERE e.id = $1 AND e.tenant_id = $3 AND d.id = $2 AND d.tenant_id = $3
+		   AND d.deleted_at IS NULL AND d.superseded_by IS NULL
+		   AND NOT EXISTS (SELECT 1 FROM entry_documents x JOIN documents xd ON xd.id = x.document_id
+		                   WHERE x.entry_id = e.id AND COALESCE(xd.version_of, xd.id) = COALESCE(d.version_of, d.id))
+		 ON CONFLICT (entry_id, document_id) DO NOTHING
+		 RETURNING entry_id, document_id, tenant_id, note, attached_by, attached_at`,
+		entryID, req.DocumentID, tenantID, note, userIDFromRequest(c),
+	).Scan(&link.EntryID, &link.DocumentID, &link.TenantID, &link.Note, &link.AttachedBy, &link.AttachedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		var exists bool
+		if err := h.db.QueryRow(ctx,
+			`SELECT EXISTS (SELECT 1 FROM entry_documents ed
+			                JOIN documents d ON d.id = ed.document_id
+			                JOIN documents t ON COALESCE(t.version_of, t.id) = COALESCE(d.version_of, d.id)
+			                WHERE ed.entry_id = $1 AND t.id = $2 AND ed.tenant_id = $3)`,
+			entryID, req.DocumentID, tenantID,
+		).Scan(&exists); err == nil && exists {
+			c.JSON(http.StatusConflict, gin.H{"error": "document déjà rattaché à cette entrée"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "entrée ou document (version courante) introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de rattacher le document", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, link)
+}
+
+// DELETE /api/tenants/:tenantId/entries/:entryId/documents/:documentId
+// Détache un document justificatif d'une entrée, quelle que soit la version
+// rattachée (le document est conservé).
+func (h *EvidenceHandler) DetachDocument(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	entryID, ok := int64Param(c, "entryId")
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`DELETE FROM entry_documents ed
+		 USING documents d, documents t
+		 WHERE ed.entry_id = $1 AND ed.tenant_id = $3 AND d.id = ed.document_id
+		   AND t.id = $2 AND t.tenant_id = $3 AND COALESCE(t.version_of, t.id) = COALESCE(d.version_of, d.id)`,
+		entryID, docID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de détacher le document", "details": err.Error()})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "lien introuvable"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GET /api/tenants/:tenantId/entries/:entryId/documents
+// Justificatifs rattachés à une entrée.
+func (h *EvidenceHandler) ListEntryDocuments(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	entryID, ok := int64Param(c, "entryId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	if err := h.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM entries WHERE id = $1 AND tenant_id = $2)`,
+		entryID, tenantID,
+	).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de l'entrée"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "entrée introuvable"})
+		return
+	}
+
+	docs, err := loadEvidence(ctx, h.db, tenantID, []int64{entryID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des justificatifs"})
+		return
+	}
+	out := docs[entryID]
+	if out == nil {
+		out = []evidenceDocument{}
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// GET /api/tenants/:tenantId/documents/:documentId/entries
+// Entrées justifiées par un document (toutes versions confondues).
+func (h *EvidenceHandler) ListDocumentEntries(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	docID, ok := int64Param(c, "documentId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT DISTINCT ON (e.date, e.id)
+		        e.id, e.type, e.amount, e.currency, e.date, COALESCE(e.category, ''), COALESCE(e.source, ''),
+		        ed.document_id, ed.attached_at
+		 FROM documents t
+		 JOIN documents d ON COALESCE(d.version_of, d.id) = COALESCE(t.version_of, t.id)
+		 JOIN entry_documents ed ON ed.document_id = d.id
+		 JOIN entries e ON e.id = ed.entry_id
+		 WHERE t.id = $1 AND t.tenant_id = $2 AND t.deleted_at IS NULL AND ed.tenant_id = $2
+		 ORDER BY e.date, e.id, ed.attached_at DESC`,
+		docID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des entrées"})
+		return
+	}
+	defer rows.Close()
+
+	out := []gin.H{}
+	for rows.Next() {
+		var id, attachedDocID int64
+		var typ, currency, category, source string
+		var amount float64
+		var date, attachedAt time.Time
+		if err := rows.Scan(&id, &typ, &amount, &currency, &date, &category, &source, &attachedDocID, &attachedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des entrées"})
+			return
+		}
+		out = append(out, gin.H{
+			"entry_id":             id,
+			"type":                 typ,
+			"amount":               amount,
+			"currency":             currency,
+			"date":                 date.Format("2006-01-02"),
+			"category":             category,
+			"source":               source,
+			"attached_document_id": attachedDocID,
+			"attached_at":          attachedAt,
+		})
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+type materialitySettingsRequest struct {
+	// Seuil en tCO2e par entrée ; null désactive le contrôle.
+	ThresholdTCO2e *float64 `json:"threshold_tco2e"`
+}
+
+// GET /api/tenants/:tenantId/settings/materiality
+func (h *EvidenceHandler) GetMateriality(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	threshold, err := loadMaterialityThreshold(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du seuil"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"threshold_tco2e": threshold})
+}
+
+// PUT /api/tenants/:tenantId/settings/materiality
+// Définit le seuil (tCO2e par entrée) au-delà duquel un justificatif est attendu.
+func (h *EvidenceHandler) UpdateMateriality(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req materialitySettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	if req.ThresholdTCO2e != nil && *req.ThresholdTCO2e < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "threshold_tco2e doit être positif ou null"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.db.Exec(ctx,
+		`UPDATE tenants SET materiality_threshold_tco2e = $2 WHERE id = $1`,
+		tenantID, req.ThresholdTCO2e,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour le seuil", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"threshold_tco2e": req.ThresholdTCO2e})
+}
+
+// evidenceLine est une ligne de la piste d'audit : une émission, son poste et ses justificatifs.
+type evidenceLine struct {
+	EmissionID      int64              `json:"emission_id"`
+	EntryID         int64              `json:"entry_id"`
+	Date            string             `json:"date"`
+	Poste           string             `json:"beges_poste"`
+	Scope           string             `json:"scope"`
+	Category        string             `json:"category"`
+	Source          string             `json:"source"`
+	Amount          float64            `json:"amount"`
+	Currency        string             `json:"currency"`
+	TCO2e           float64            `json:"tco2e"`
+	Material        bool               `json:"material"`
+	MissingEvidence bool               `json:"missing_evidence"`
+	Documents       []evidenceDocument `json:"documents"`
+}
+
+// GET /api/tenants/:tenantId/exports/evidence?year=2024&format=json|csv&missing=true
+// Piste d'audit : chaque émission de l'année avec ses justificatifs. missing=true
+// ne conserve que les entrées matérielles sans justificatif.
+func (h *EvidenceHandler) ExportEvidence(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+	if year == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year est requis (année de reporting)"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format doit valoir 'json' ou 'csv'"})
+		return
+	}
+	onlyMissing := c.Query("missing") == "true"
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	threshold, err := loadMaterialityThreshold(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du seuil"})
+		return
+	}
+	lines, err := loadEmissionLines(ctx, h.db, tenantID, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+	entryIDs := make([]int64, 0, len(lines))
+	for _, l := range lines {
+		entryIDs = append(entryIDs, l.EntryID)
+	}
+	docs, err := loadEvidence(ctx, h.db, tenantID, entryIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des justificatifs"})
+		return
+	}
+
+	out := []evidenceLine{}
+	var material, missing int
+	for _, l := range lines {
+		el := evidenceLine{
+			EmissionID: l.EmissionID,
+			EntryID:    l.EntryID,
+			Date:       l.Date.Format("2006-01-02"),
+			Poste:      begesPosteFor(l),
+			Scope:      l.Scope,
+			Category:   l.Category,
+			Source:     l.Source,
+			Amount:     l.Amount,
+			Currency:   l.Currency,
+			TCO2e:      l.TCO2e,
+			Material:   l.isMaterial(threshold),
+			Documents:  docs[l.EntryID],
+		}
+		if el.Documents == nil {
+			el.Documents = []evidenceDocument{}
+		}
+		el.MissingEvidence = el.Material && len(el.Documents) == 0
+		if el.Material {
+			material++
+		}
+		if el.MissingEvidence {
+			missing++
+		}
+		if onlyMissing && !el.MissingEvidence {
+			continue
+		}
+		out = append(out, el)
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"year":                        year,
+			"materiality_threshold_tco2e": threshold,
+			"material_lines":              material,
+			"material_without_evidence":   missing,
+			"lines":                       out,
+		})
+		return
+	}
+
+	data, err := evidenceCSV(out)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le CSV"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="justificatifs_%d_%d.csv"`, tenantID, year))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
+
+// evidenceCSV produit la piste d'audit au format des autres exports (« ; »,
+// décimales à la virgule, BOM UTF-8).
+func evidenceCSV(lines []evidenceLine) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+	w.Comma = ';'
+
+	records := [][]string{{"Émission", "Entrée", "Date", "Poste BEGES", "Scope", "Catégorie", "Source",
+		"Montant", "Devise", "tCO2e", "Matérielle", "Justificatif manquant", "Justificatifs"}}
+	yesNo := func(b bool) string {
+		if b {
+			return "oui"
+		}
+		return "non"
+	}
+	for _, l := range lines {
+		refs := make([]string, 0, len(l.Documents))
+		for _, d := range l.Documents {
+			ref := fmt.Sprintf("#%d %s", d.DocumentID, d.OriginalName)
+			if d.Sha256 != nil {
+				ref += " (sha256:" + *d.Sha256 + ")"
+			}
+			refs = append(refs, ref)
+		}
+		records = append(records, []string{
+			strconv.FormatInt(l.EmissionID, 10), strconv.FormatInt(l.EntryID, 10), l.Date, l.Poste, l.Scope,
+			l.Category, l.Source, frDecimal(l.Amount, 2), l.Currency, frDecimal(l.TCO2e, 3),
+			yesNo(l.Material), yesNo(l.MissingEvidence), strings.Join(refs, " | "),
+		})
+	}
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadEvidence charge les justificatifs des entrées données, par entrée. Un
+// lien rattaché à une version remplacée est lu sur la version courante.
+func loadEvidence(ctx context.Context, db *pgxpool.Pool, tenantID int64, entryIDs []int64) (map[int64][]evidenceDocument, error) {
+	out := make(map[int64][]evidenceDocument)
+	if len(entryIDs) == 0 {
+		return out, nil
+	}
+	rows, err := db.Query(ctx,
+		`SELECT entry_id, id, attached_id, original_name, mime_type, sha256, note, attached_by, attached_at
+		 FROM (
+		     SELECT DISTINCT ON (ed.entry_id, cur.id)
+		            ed.entry_id, cur.id, ed.document_id AS attached_id, cur.original_name, cur.mime_type, cur.sha256,
+		            ed.note, ed.attached_by, ed.attached_at
+		     FROM entry_documents ed
+		     JOIN documents d ON d.id = ed.document_id
+		     JOIN documents cur ON COALESCE(cur.version_of, cur.id) = COALESCE(d.version_of, d.id) AND cur.superseded_by IS NULL
+		     WHERE ed.tenant_id = $1 AND ed.entry_id = ANY($2) AND cur.deleted_at IS NULL
+		     ORDER BY ed.entry_id, cur.id, ed.attached_at DESC
+		 ) ev
+		 ORDER BY entry_id, attached_at, id`,
+		tenantID, entryIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int64
+		var d evidenceDocument
+		if err := rows.Scan(&entryID, &d.DocumentID, &d.AttachedDocumentID, &d.OriginalName, &d.MimeType, &d.Sha256,
+			&d.Note, &d.AttachedBy, &d.AttachedAt); err != nil {
+			return nil, err
+		}
+		out[entryID] = append(out[entryID], d)
+	}
+	return out, rows.Err()
+}
+
+// loadMaterialityThreshold renvoie le seuil de matérialité du tenant (nil si non configuré).
+func loadMaterialityThreshold(ctx context.Context, db *pgxpool.Pool, tenantID int64) (*float64, error) {
+	var threshold *float64
+	err := db.QueryRow(ctx,
+		`SELECT materiality_threshold_tco2e FROM tenants WHERE id = $1`,
+		tenantID,
+	).Scan(&threshold)
+	return threshold, err
+}
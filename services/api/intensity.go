@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IntensityHandler gère les dénominateurs utilisés pour les ratios d'intensité carbone.
+type IntensityHandler struct {
+	db *pgxpool.Pool
+}
+
+func NewIntensityHandler(db *pgxpool.Pool) *IntensityHandler {
+	return &IntensityHandler{db: db}
+}
+
+// intensityMetric décrit un dénominateur supporté : unité de saisie et unité du ratio.
+type intensityMetric struct {
+	Unit      string  // unité imposée à la saisie ("" = libre, ex: production)
+	RatioUnit string  // unité du dénominateur dans le ratio (ex: MEUR)
+	Divisor   float64 // conversion unité de saisie -> unité du ratio
+}
+
+var intensityMetrics = map[string]intensityMetric{
+	"revenue":    {Unit: "EUR", RatioUnit: "MEUR", Divisor: 1e6},
+	"headcount":  {Unit: "FTE", RatioUnit: "FTE", Divisor: 1},
+	"floor_area": {Unit: "m2", RatioUnit: "m2", Divisor: 1},
+	"production": {Divisor: 1},
+}
+
+// intensityRatio est un ratio tCO2e par unité de dénominateur.
+type intensityRatio struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// ratio calcule l'intensité d'un total d'émissions rapporté au dénominateur.
+// Renvoie false si le dénominateur est nul ou inconnu.
+func (d IntensityDenominator) ratio(totalTCO2e float64) (intensityRatio, bool) {
+	m, ok := intensityMetrics[d.Metric]
+	if !ok || d.Value <= 0 {
+		return intensityRatio{}, false
+	}
+	unit := m.RatioUnit
+	if unit == "" {
+		unit = d.Unit
+	}
+	return intensityRatio{
+		Value: totalTCO2e / (d.Value / m.Divisor),
+		Unit:  "tCO2e/" + unit,
+	}, true
+}
+
+// computeIntensities calcule tous les ratios disponibles pour une année.
+func computeIntensities(totalTCO2e float64, dens map[string]IntensityDenominator) map[string]intensityRatio {
+	out := make(map[string]intensityRatio, len(dens))
+	for metric, d := range dens {
+		if r, ok := d.ratio(totalTCO2e); ok {
+			out[metric] = r
+		}
+	}
+	return out
+}
+
+type upsertDenominatorRequest struct {
+	Year   int     `json:"year" binding:"required"`
+	Metric string  `json:"metric" binding:"required"`
+	Value  float64 `json:"value" binding:"required"`
+	Unit   string  `json:"unit"`
+}
+
+// PUT /api/tenants/:tenantId/denominators
+// Crée ou remplace la valeur d'un dénominateur pour une année.
+func (h *IntensityHandler) UpsertDenominator(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req upsertDenominatorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	m, known := intensityMetrics[req.Metric]
+	if !known {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric doit valoir 'revenue', 'headcount', 'floor_area' ou 'production'"})
+		return
+	}
+	if req.Year < 1990 || req.Year > 2100 || req.Value <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year ou value invalide"})
+		return
+	}
+	if m.Unit != "" {
+		req.Unit = m.Unit
+	} else if req.Unit == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unit est requis pour ce dénominateur (ex: tonnes, unités)"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	d := IntensityDenominator{TenantID: tenantID, Year: req.Year, Metric: req.Metric, Value: req.Value, Unit: req.Unit}
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO intensity_denominators (tenant_id, year, metric, value, unit)
+		 VALUES ($1,$2,$3,$4,$5)
+		 ON CONFLICT (tenant_id, year, metric) DO UPDATE SET value = EXCLUDED.value, unit = EXCLUDED.unit
+		 RETURNING id, created_at`,
+		d.TenantID, d.Year, d.Metric, d.Value, d.Unit,
+	).Scan(&d.ID, &d.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer le dénominateur", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, d)
+}
+
+// GET /api/tenants/:tenantId/denominators
+func (h *IntensityHandler) ListDenominators(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT id, tenant_id, year, metric, value, unit, created_at
+		 FROM intensity_denominators
+		 WHERE tenant_id = $1
+		 ORDER BY year DESC, metric`,
+		tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des dénominateurs"})
+		return
+	}
+	defer rows.Close()
+
+	dens := []IntensityDenominator{}
+	for rows.Next() {
+		var d IntensityDenominator
+		if err := rows.Scan(&d.ID, &d.TenantID, &d.Year, &d.Metric, &d.Value, &d.Unit, &d.CreatedAt); err != nil {
+			continue
+		}
+		dens = append(dens, d)
+	}
+
+	c.JSON(http.StatusOK, dens)
+}
+
+// DELETE /api/tenants/:tenantId/denominators/:denominatorId
+func (h *IntensityHandler) DeleteDenominator(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	denID, ok := int64Param(c, "denominatorId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`DELETE FROM intensity_denominators WHERE id = $1 AND tenant_id = $2`,
+		denID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer le dénominateur"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "dénominateur non trouvé"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// loadDenominators renvoie les dénominateurs d'un tenant indexés par année puis par métrique.
+func loadDenominators(ctx context.Context, db *pgxpool.Pool, tenantID int64) (map[int]map[string]IntensityDenominator, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, tenant_id, year, metric, value, unit, created_at
+		 FROM intensity_denominators
+		 WHERE tenant_id = $1`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int]map[string]IntensityDenominator)
+	for rows.Next() {
+		var d IntensityDenominator
+		if err := rows.Scan(&d.ID, &d.TenantID, &d.Year, &d.Metric, &d.Value, &d.Unit, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		if out[d.Year] == nil {
+			out[d.Year] = make(map[string]IntensityDenominator)
+		}
+		out[d.Year][d.Metric] = d
+	}
+	return out, rows.Err()
+}
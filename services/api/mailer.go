@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// EmailMessage est un email texte envoyé par l'API (réinitialisation de mot
+// de passe, vérification d'adresse, invitation).
+type EmailMessage struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// EmailSender envoie les emails transactionnels.
+type EmailSender interface {
+	Send(ctx context.Context, msg EmailMessage) error
+}
+
+// NewEmailSender construit l'envoi choisi par EMAIL_DRIVER : "smtp" (défaut,
+// SMTP_HOST requis) ou "log". Les emails contiennent des liens de connexion
+// (réinitialisation, invitation) : le journal n'est jamais choisi par défaut
+// et n'est accepté qu'en développement.
+func NewEmailSender(cfg Config) (EmailSender, error) {
+	from, err := mail.ParseAddress(cfg.MailFrom)
+	if err != nil {
+		return nil, fmt.Errorf("MAIL_FROM invalide : %w", err)
+	}
+	switch cfg.EmailDriver {
+	case "", "smtp":
+	case "log":
+		if cfg.Env != "development" {
+			return nil, fmt.Errorf("EMAIL_DRIVER=log n'est autorisé qu'en développement (API_ENV=%s)", cfg.Env)
+		}
+		return LogEmailSender{}, nil
+	default:
+		return nil, fmt.Errorf("EMAIL_DRIVER inconnu : %q (attendu : smtp ou log)", cfg.EmailDriver)
+	}
+	if cfg.SMTPHost == "" {
+		return nil, errors.New("SMTP_HOST est requis (EMAIL_DRIVER=log pour journaliser les emails en développement)")
+	}
+	return &SMTPSender{
+		Host:        cfg.SMTPHost,
+		Port:        cfg.SMTPPort,
+		Username:    cfg.SMTPUsername,
+		Password:    cfg.SMTPPassword,
+		From:        from,
+		ImplicitTLS: cfg.SMTPPort == "465",
+		Timeout:     20 * time.Second,
+	}, nil
+}
+
+// LogEmailSender écrit les emails dans les logs au lieu de les envoyer. Les
+// paramètres des liens, qui portent les jetons, sont masqués.
+type LogEmailSender struct{}
+
+func (LogEmailSender) Send(_ context.Context, msg EmailMessage) error {
+	log.Printf("[email] à %s : %s\n%s", msg.To, msg.Subject, redactLinks(msg.Text))
+	return nil
+}
+
+var emailLinkParams = regexp.MustCompile(`(https?://[^\s?#]+)[?#]\S*`)
+
+// redactLinks retire la requête et le fragment des URL du texte.
+func redactLinks(text string) string {
+	return emailLinkParams.ReplaceAllString(text, "$1?[masqué]")
+}
+
+// SMTPSender envoie les emails par SMTP. STARTTLS est utilisé dès que le
+// serveur le propose ; le port 465 implique TLS dès la connexion.
+type SMTPSender struct {
+	Host        string
+	Port        string
+	Username    string
+	Password    string
+	From        *mail.Address
+	ImplicitTLS bool
+	Timeout     time.Duration
+}
+
+func (s *SMTPSender) Send(ctx context.Context, msg EmailMessage) error {
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("destinataire invalide : %w", err)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return errors.New("sujet invalide")
+	}
+	body, err := s.message(to, msg)
+	if err != nil {
+		return err
+	}
+
+	d := net.Dialer{Timeout: s.Timeout}
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(s.Host, s.Port))
+	if err != nil {
+		return fmt.Errorf("connexion SMTP : %w", err)
+	}
+	deadline := time.Now().Add(s.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	tlsConfig := &tls.Config{ServerName: s.Host}
+	if s.ImplicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("session SMTP : %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !s.ImplicitTLS {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS : %w", err)
+		}
+	}
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("authentification SMTP : %w", err)
+		}
+	}
+	if err := client.Mail(s.From.Address); err != nil {
+		return fmt.Errorf("MAIL FROM : %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("RCPT TO : %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("DATA : %w", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("envoi du message : %w", err)
+	}
+	return client.Quit()
+}
+
+// message construit le message MIME (texte UTF-8 en quoted-printable).
+func (s *SMTPSender) message(to *mail.Address, msg EmailMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.From.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", newJTI(), s.From.Address[strings.LastIndex(s.From.Address, "@")+1:])
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(msg.Text, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sendEmailAsync envoie un email sans bloquer la requête ; un échec est journalisé.
+func sendEmailAsync(sender EmailSender, msg EmailMessage) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := sender.Send(ctx, msg); err != nil {
+			log.Printf("envoi de l'email « %s » à %s : %v", msg.Subject, msg.To, err)
+		}
+	}()
+}
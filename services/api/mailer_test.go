@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpSink est un serveur SMTP de test : il accepte un message et le garde.
+type smtpSink struct {
+	ln       net.Listener
+	auth     string // identifiants reçus par AUTH PLAIN (décodés)
+	from, to string
+	data     string
+	done     chan struct{}
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{ln: ln, done: make(chan struct{})}
+	t.Cleanup(func() { ln.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *smtpSink) serve() {
+	defer close(s.done)
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	r := bufio.NewReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 sink ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"):
+			reply("250-sink")
+			reply("250 AUTH PLAIN")
+		case strings.HasPrefix(cmd, "AUTH PLAIN "):
+			raw, _ := base64.StdEncoding.DecodeString(line[len("AUTH PLAIN "):])
+			s.auth = string(raw)
+			reply("235 2.7.0 authentifié")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			s.from = line[len("MAIL FROM:"):]
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.to = line[len("RCPT TO:"):]
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 fin par <CRLF>.<CRLF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(l, "."))
+			}
+			s.data = data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 commande inconnue")
+		}
+	}
+}
+
+func TestSMTPSenderDeliversToSink(t *testing.T) {
+	sink := newSMTPSink(t)
+	_, port, _ := net.SplitHostPort(sink.ln.Addr().String())
+	from, _ := mail.ParseAddress("CarbonV2 <no-reply@carbonv2.example>")
+	sender := &SMTPSender{
+		Host:     "127.0.0.1",
+		Port:     port,
+		Username: "api",
+		Password: "secret",
+		From:     from,
+		Timeout:  5 * time.Second,
+	}
+
+	msg := EmailMessage{
+		To:      "Élodie <elodie@example.com>",
+		Subject: "Réinitialisation de votre mot de passe",
+		Text:    "Bonjour,\n\nOuvrez ce lien : https://app.example/reset?token=abc\n",
+	}
+	if err := sender.Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send : %v", err)
+	}
+	<-sink.done
+
+	if sink.auth != "\x00api\x00secret" {
+		t.Errorf("AUTH PLAIN = %q", sink.auth)
+	}
+	if sink.from != "<no-reply@carbonv2.example>" || sink.to != "<elodie@example.com>" {
+		t.Errorf("enveloppe : MAIL FROM %s, RCPT TO %s", sink.from, sink.to)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(sink.data))
+	if err != nil {
+		t.Fatalf("message reçu illisible : %v\n%s", err, sink.data)
+	}
+	if got, _ := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject")); got != msg.Subject {
+		t.Errorf("Subject = %q", got)
+	}
+	if to, err := parsed.Header.AddressList("To"); err != nil || len(to) != 1 || to[0].Address != "elodie@example.com" {
+		t.Errorf("To = %v (%v)", to, err)
+	}
+	if got := parsed.Header.Get("Content-Transfer-Encoding"); got != "quoted-printable" {
+		t.Errorf("Content-Transfer-Encoding = %q", got)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(msg.Text, "\n", "\r\n"); string(body) != want {
+		t.Errorf("corps = %q, attendu %q", body, want)
+	}
+}
+
+func TestSMTPSenderRejectsHeaderInjection(t *testing.T) {
+	from, _ := mail.ParseAddress("no-reply@carbonv2.example")
+	sender := &SMTPSender{Host: "127.0.0.1", Port: "1", From: from, Timeout: time.Second}
+	for _, msg := range []EmailMessage{
+		{To: "a@example.com", Subject: "x\r\nBcc: b@example.com"},
+		{To: "a@example.com\r\nBcc: b@example.com", Subject: "x"},
+	} {
+		if err := sender.Send(context.Background(), msg); err == nil || strings.Contains(err.Error(), "connexion SMTP") {
+			t.Errorf("message %q accepté ou envoyé : %v", msg.Subject, err)
+		}
+	}
+}
+
+func TestNewEmailSender(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string // "smtp", "log" ou "" (erreur)
+	}{
+		{"SMTP configuré", Config{Env: "production", SMTPHost: "smtp.example", SMTPPort: "587"}, "smtp"},
+		{"SMTP par défaut sans hôte", Config{Env: "development"}, ""},
+		{"journal en développement", Config{Env: "development", EmailDriver: "log"}, "log"},
+		{"journal refusé en production", Config{Env: "production", EmailDriver: "log"}, ""},
+		{"journal refusé en préproduction", Config{Env: "staging", EmailDriver: "log"}, ""},
+		{"pilote inconnu", Config{Env: "development", EmailDriver: "sendmail", SMTPHost: "smtp.example"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.cfg.MailFrom = "CarbonV2 <no-reply@carbonv2.example>"
+			sender, err := NewEmailSender(tt.cfg)
+			var got string
+			switch sender.(type) {
+			case *SMTPSender:
+				got = "smtp"
+			case LogEmailSender:
+				got = "log"
+			}
+			if got != tt.want || (tt.want == "") != (err != nil) {
+				t.Errorf("NewEmailSender = %T, %v ; attendu %q", sender, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogEmailSenderRedactsLinks(t *testing.T) {
+	var buf bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(prev)
+
+	msg := EmailMessage{
+		To:      "alice@example.com",
+		Subject: "Invitation",
+		Text: "Acceptez : https://app.example/invitations/accept?token=eyJhbGciOi.secret.sig\n" +
+			"ou https://app.example/reset#token=abc, puis https://app.example/aide.\n",
+	}
+	if err := (LogEmailSender{}).Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, secret := range []string{"eyJhbGciOi", "token=", "abc"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("le journal contient %q :\n%s", secret, out)
+		}
+	}
+	for _, kept := range []string{"https://app.example/invitations/accept?[masqué]", "https://app.example/aide.", "alice@example.com"} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("le journal ne contient pas %q :\n%s", kept, out)
+		}
+	}
+}
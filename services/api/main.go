@@ -27,6 +27,11 @@ func main() {
 	defer db.Close()
 
 	router := gin.Default()
+	// Sans API_TRUSTED_PROXIES, X-Forwarded-For est ignoré : l'IP du client
+	// est celle de la connexion.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		log.Fatalf("API_TRUSTED_PROXIES invalide: %v", err)
+	}
 
 	// Middlewares globaux
 	router.Use(CORSMiddleware())
@@ -41,37 +46,188 @@ func main() {
 
 	router.GET("/health", HealthHandler(cfg))
 
-	authHandler := NewAuthHandler(db, cfg)
+	mailer, err := NewEmailSender(cfg)
+	if err != nil {
+		log.Fatalf("configuration de l'envoi des emails invalide: %v", err)
+	}
+	limits, err := NewRateLimitStore(cfg)
+	if err != nil {
+		log.Fatalf("configuration de la limitation de débit invalide: %v", err)
+	}
+	authHandler := NewAuthHandler(db, cfg, mailer, limits)
 	entriesHandler := NewEntriesHandler(db)
 	carbonHandler := NewCarbonHandler(db)
-	documentsHandler := NewDocumentsHandler(db)
+	storage, err := NewDocumentStorage(cfg)
+	if err != nil {
+		log.Fatalf("configuration du stockage des documents invalide: %v", err)
+	}
+	scanner, err := NewMalwareScanner(cfg)
+	if err != nil {
+		log.Fatalf("configuration de l'antivirus invalide: %v", err)
+	}
+	documentsHandler := NewDocumentsHandler(db, cfg, storage, scanner)
+	evidenceHandler := NewEvidenceHandler(db)
+	targetsHandler := NewTargetsHandler(db)
+	actionsHandler := NewActionsHandler(db)
+	intensityHandler := NewIntensityHandler(db)
+	sitesHandler := NewSitesHandler(db)
+	reportsHandler := NewReportsHandler(db, cfg)
+	categorizationHandler := NewCategorizationHandler(db, cfg)
+	membersHandler := NewMembersHandler(db, cfg, mailer)
+	ssoHandler := NewSSOHandler(db, cfg, authHandler)
+	apiKeysHandler := NewAPIKeysHandler(db, cfg)
 	api := router.Group("/api")
 	{
+		// Routes qui vérifient un secret (mot de passe, code, jeton) ou envoient un
+		// email : limitées par adresse IP.
+		authLimit := RateLimit(limits, authIPLimit, rateLimitByIP)
 		auth := api.Group("/auth")
 		{
-			auth.POST("/signup", authHandler.SignUp)
-			auth.POST("/login", authHandler.Login)
+			auth.POST("/signup", authLimit, authHandler.SignUp)
+			auth.POST("/login", authLimit, authHandler.Login)
+			auth.POST("/login/mfa", authLimit, authHandler.LoginMFA)
 			auth.GET("/me", AuthMiddleware(cfg, db), authHandler.Me)
+			auth.POST("/refresh", authLimit, authHandler.Refresh)
+			auth.POST("/logout", AuthMiddleware(cfg, db), authHandler.Logout)
+			auth.POST("/logout-all", AuthMiddleware(cfg, db), authHandler.LogoutAll)
+			auth.GET("/sessions", AuthMiddleware(cfg, db), authHandler.ListSessions)
+			auth.POST("/password/forgot", authLimit, authHandler.ForgotPassword)
+			auth.POST("/password/reset", authLimit, authHandler.ResetPassword)
+			auth.POST("/email/verify", authLimit, authHandler.VerifyEmail)
+			auth.POST("/email/verification", AuthMiddleware(cfg, db), authLimit, authHandler.ResendVerification)
+
+			// Double authentification (TOTP)
+			auth.GET("/mfa", AuthMiddleware(cfg, db), authHandler.MFAStatus)
+			auth.POST("/mfa/totp/setup", AuthMiddleware(cfg, db), authHandler.SetupTOTP)
+			auth.POST("/mfa/totp/enable", AuthMiddleware(cfg, db), authLimit, authHandler.EnableTOTP)
+			auth.POST("/mfa/totp/disable", AuthMiddleware(cfg, db), authLimit, authHandler.DisableTOTP)
+			auth.POST("/mfa/recovery-codes", AuthMiddleware(cfg, db), authLimit, authHandler.RegenerateRecoveryCodes)
+
+			// Connexion unique OpenID Connect (configurée par tenant)
+			auth.GET("/sso/discover", authLimit, ssoHandler.Discover)
+			auth.GET("/sso/:tenantId/login", authLimit, ssoHandler.Login)
+			auth.GET("/sso/callback", authLimit, ssoHandler.Callback)
+			auth.GET("/invitations", authLimit, authHandler.GetInvitation)
+			auth.POST("/invitations/accept", authLimit, authHandler.AcceptInvitation)
 		}
 
-		tenants := api.Group("/tenants", AuthMiddleware(cfg, db))
+		// Liens présignés du stockage local (le stockage S3 sert ses propres liens).
+		if local, ok := storage.(*LocalStorage); ok {
+			api.GET("/files", local.ServeSigned)
+		}
+
+		// Chaque route exige une permission, accordée selon le rôle (voir rbac.go) ;
+		// les clés d'API n'accèdent qu'aux routes marquées AllowAPIKey (voir api_keys.go).
+		tenants := api.Group("/tenants", AuthMiddleware(cfg, db),
+			RateLimit(limits, apiUserLimit, rateLimitByPrincipal),
+			RateLimit(limits, apiTenantLimit, rateLimitByTenant))
 		{
-			tenants.POST("/:tenantId/entries", entriesHandler.CreateEntry)
-			tenants.GET("/:tenantId/entries", entriesHandler.ListEntries)
-			tenants.POST("/:tenantId/import", entriesHandler.ImportCSV)
+			tenants.POST("/:tenantId/entries", AllowAPIKey(ScopeEntriesWrite), RequirePermission(PermEntriesWrite), entriesHandler.CreateEntry)
+			tenants.GET("/:tenantId/entries", AllowAPIKey(ScopeEntriesRead), RequirePermission(PermEntriesRead), entriesHandler.ListEntries)
+			tenants.POST("/:tenantId/import", AllowAPIKey(ScopeEntriesWrite), RequirePermission(PermEntriesWrite), entriesHandler.ImportCSV)
+			tenants.PUT("/:tenantId/entries/:entryId/site", RequirePermission(PermEntriesWrite), sitesHandler.AssignEntrySite)
+
+			// Membres du tenant, invitations et connexion unique (SSO)
+			tenants.GET("/:tenantId/members", RequirePermission(PermMembersManage), membersHandler.ListMembers)
+			tenants.PUT("/:tenantId/members/:userId/role", RequirePermission(PermMembersManage), membersHandler.UpdateMemberRole)
+			tenants.DELETE("/:tenantId/members/:userId", RequirePermission(PermMembersManage), membersHandler.RemoveMember)
+			tenants.DELETE("/:tenantId/members/:userId/mfa", RequirePermission(PermMembersManage), membersHandler.ResetMemberMFA)
+			tenants.POST("/:tenantId/invitations", RequirePermission(PermMembersManage), membersHandler.CreateInvitation)
+			tenants.GET("/:tenantId/invitations", RequirePermission(PermMembersManage), membersHandler.ListInvitations)
+			tenants.DELETE("/:tenantId/invitations/:invitationId", RequirePermission(PermMembersManage), membersHandler.RevokeInvitation)
+			tenants.GET("/:tenantId/sso", RequirePermission(PermMembersManage), ssoHandler.GetConfig)
+			tenants.PUT("/:tenantId/sso", RequirePermission(PermMembersManage), RequireOwner(), ssoHandler.UpdateConfig)
+			tenants.DELETE("/:tenantId/sso", RequirePermission(PermMembersManage), RequireOwner(), ssoHandler.DeleteConfig)
+			tenants.POST("/:tenantId/sso/domains/:domain/verify", RequirePermission(PermMembersManage), RequireOwner(), ssoHandler.VerifyDomain)
+
+			// Pièces justificatives des entrées et contrôle de matérialité (piste d'audit)
+			tenants.POST("/:tenantId/entries/:entryId/documents", RequirePermission(PermEntriesWrite), evidenceHandler.AttachDocument)
+			tenants.GET("/:tenantId/entries/:entryId/documents", RequirePermission(PermDocumentsRead), evidenceHandler.ListEntryDocuments)
+			tenants.DELETE("/:tenantId/entries/:entryId/documents/:documentId", RequirePermission(PermEntriesWrite), evidenceHandler.DetachDocument)
+			tenants.GET("/:tenantId/documents/:documentId/entries", RequirePermission(PermDocumentsRead), evidenceHandler.ListDocumentEntries)
+			tenants.GET("/:tenantId/settings/materiality", RequirePermission(PermEntriesRead), evidenceHandler.GetMateriality)
+			tenants.PUT("/:tenantId/settings/materiality", RequirePermission(PermSettingsManage), evidenceHandler.UpdateMateriality)
+			tenants.GET("/:tenantId/settings/mfa", RequirePermission(PermSettingsManage), membersHandler.GetMFAPolicy)
+			tenants.PUT("/:tenantId/settings/mfa", RequirePermission(PermSettingsManage), membersHandler.UpdateMFAPolicy)
+
+			// Clés d'API des intégrations (ERP, scripts)
+			tenants.POST("/:tenantId/api-keys", RequirePermission(PermSettingsManage), apiKeysHandler.CreateAPIKey)
+			tenants.GET("/:tenantId/api-keys", RequirePermission(PermSettingsManage), apiKeysHandler.ListAPIKeys)
+			tenants.DELETE("/:tenantId/api-keys/:keyId", RequirePermission(PermSettingsManage), apiKeysHandler.RevokeAPIKey)
+
+			// Structure organisationnelle : entités, sites, centres de coût
+			tenants.POST("/:tenantId/sites", RequirePermission(PermSitesManage), sitesHandler.CreateSite)
+			tenants.GET("/:tenantId/sites", RequirePermission(PermEntriesRead), sitesHandler.ListSites)
+			tenants.GET("/:tenantId/sites/tree", RequirePermission(PermEntriesRead), sitesHandler.SiteTree)
+			tenants.PUT("/:tenantId/sites/:siteId", RequirePermission(PermSitesManage), sitesHandler.UpdateSite)
+			tenants.DELETE("/:tenantId/sites/:siteId", RequirePermission(PermSitesManage), sitesHandler.DeleteSite)
+
+			// Exports réglementaires
+			tenants.GET("/:tenantId/exports/beges", RequirePermission(PermReportsRead), reportsHandler.ExportBEGES)
+			tenants.GET("/:tenantId/exports/cdp", RequirePermission(PermReportsRead), reportsHandler.ExportCDP)
+			tenants.GET("/:tenantId/exports/evidence", RequirePermission(PermAuditExport), evidenceHandler.ExportEvidence)
+			tenants.GET("/:tenantId/reports/esrs-e1", RequirePermission(PermReportsRead), reportsHandler.ESRSE1Report)
+			tenants.GET("/:tenantId/reports/bilan.pdf", RequirePermission(PermReportsRead), reportsHandler.BilanPDF)
 
 			// Documents (factures, contrats énergie, etc.) liés à un tenant
-			tenants.POST("/:tenantId/documents", documentsHandler.UploadDocument)
-			tenants.GET("/:tenantId/documents", documentsHandler.ListDocuments)
+			tenants.POST("/:tenantId/documents", RequirePermission(PermDocumentsWrite), documentsHandler.UploadDocument)
+			tenants.GET("/:tenantId/documents", RequirePermission(PermDocumentsRead), documentsHandler.ListDocuments)
+			tenants.GET("/:tenantId/documents/:documentId/analysis", RequirePermission(PermDocumentsRead), documentsHandler.GetAnalysis)
+			tenants.POST("/:tenantId/documents/:documentId/analyze", RequirePermission(PermDocumentsWrite), documentsHandler.Reanalyze)
+			tenants.POST("/:tenantId/documents/:documentId/confirm", RequirePermission(PermEntriesWrite), documentsHandler.ConfirmEntries)
+			tenants.GET("/:tenantId/documents/:documentId/download", RequirePermission(PermDocumentsRead), documentsHandler.DownloadDocument)
+			tenants.GET("/:tenantId/documents/:documentId/download-url", RequirePermission(PermDocumentsRead), documentsHandler.DownloadURL)
+			tenants.GET("/:tenantId/documents/:documentId/verify", RequirePermission(PermDocumentsRead), documentsHandler.VerifyDocument)
+			tenants.DELETE("/:tenantId/documents/:documentId", RequirePermission(PermDocumentsWrite), documentsHandler.DeleteDocument)
+			tenants.POST("/:tenantId/documents/:documentId/restore", RequirePermission(PermDocumentsWrite), documentsHandler.RestoreDocument)
+			tenants.POST("/:tenantId/documents/:documentId/versions", RequirePermission(PermDocumentsWrite), documentsHandler.ReplaceDocument)
+			tenants.GET("/:tenantId/documents/:documentId/versions", RequirePermission(PermDocumentsRead), documentsHandler.ListVersions)
 
 			// Endpoints MVP carbone multi-tenant
-			tenants.POST("/:tenantId/entries/:entryId/compute-emission", carbonHandler.ComputeEmissionForEntry)
-			tenants.GET("/:tenantId/emissions/summary", carbonHandler.EmissionsSummary)
-			tenants.GET("/:tenantId/emissions", carbonHandler.ListEmissions)
+			tenants.POST("/:tenantId/entries/:entryId/compute-emission", AllowAPIKey(ScopeEntriesWrite), RequirePermission(PermEntriesWrite), carbonHandler.ComputeEmissionForEntry)
+			tenants.GET("/:tenantId/emissions/summary", AllowAPIKey(ScopeEmissionsRead), RequirePermission(PermEntriesRead), carbonHandler.EmissionsSummary)
+			tenants.GET("/:tenantId/emissions", AllowAPIKey(ScopeEmissionsRead), RequirePermission(PermEntriesRead), carbonHandler.ListEmissions)
+			tenants.GET("/:tenantId/emissions/timeseries", AllowAPIKey(ScopeEmissionsRead), RequirePermission(PermEntriesRead), carbonHandler.EmissionsTimeSeries)
+
+			// Dénominateurs pour les ratios d'intensité (CA, effectif, surface, production)
+			tenants.PUT("/:tenantId/denominators", RequirePermission(PermPlanWrite), intensityHandler.UpsertDenominator)
+			tenants.GET("/:tenantId/denominators", RequirePermission(PermEntriesRead), intensityHandler.ListDenominators)
+			tenants.DELETE("/:tenantId/denominators/:denominatorId", RequirePermission(PermPlanWrite), intensityHandler.DeleteDenominator)
+
+			// Objectifs de réduction et suivi de trajectoire (SBTi)
+			tenants.POST("/:tenantId/targets", RequirePermission(PermPlanWrite), targetsHandler.CreateTarget)
+			tenants.GET("/:tenantId/targets", RequirePermission(PermEntriesRead), targetsHandler.ListTargets)
+			tenants.DELETE("/:tenantId/targets/:targetId", RequirePermission(PermPlanWrite), targetsHandler.DeleteTarget)
+			tenants.GET("/:tenantId/targets/:targetId/trajectory", RequirePermission(PermReportsRead), targetsHandler.TargetTrajectory)
+
+			// Plan d'actions de réduction, projection et courbe MACC
+			tenants.POST("/:tenantId/actions", RequirePermission(PermPlanWrite), actionsHandler.CreateAction)
+			tenants.GET("/:tenantId/actions", RequirePermission(PermEntriesRead), actionsHandler.ListActions)
+			tenants.GET("/:tenantId/actions/projection", RequirePermission(PermReportsRead), actionsHandler.ActionsProjection)
+			tenants.GET("/:tenantId/actions/macc", RequirePermission(PermReportsRead), actionsHandler.ActionsMACC)
+			tenants.PUT("/:tenantId/actions/:actionId", RequirePermission(PermPlanWrite), actionsHandler.UpdateAction)
+			tenants.DELETE("/:tenantId/actions/:actionId", RequirePermission(PermPlanWrite), actionsHandler.DeleteAction)
+
+			// Catégorisation automatique des entrées (règles puis IA) et revue des suggestions
+			tenants.POST("/:tenantId/categorization-jobs", RequirePermission(PermEntriesWrite), categorizationHandler.StartJob)
+			tenants.GET("/:tenantId/categorization-jobs", RequirePermission(PermEntriesRead), categorizationHandler.ListJobs)
+			tenants.GET("/:tenantId/categorization-jobs/:jobId", RequirePermission(PermEntriesRead), categorizationHandler.GetJob)
+			tenants.GET("/:tenantId/category-suggestions", RequirePermission(PermEntriesRead), categorizationHandler.ListSuggestions)
+			tenants.POST("/:tenantId/category-suggestions/:suggestionId/accept", RequirePermission(PermEntriesWrite), categorizationHandler.AcceptSuggestion)
+			tenants.POST("/:tenantId/category-suggestions/:suggestionId/reject", RequirePermission(PermEntriesWrite), categorizationHandler.RejectSuggestion)
+			tenants.POST("/:tenantId/categorization-rules", RequirePermission(PermSettingsManage), categorizationHandler.CreateRule)
+			tenants.GET("/:tenantId/categorization-rules", RequirePermission(PermEntriesRead), categorizationHandler.ListRules)
+			tenants.POST("/:tenantId/categorization-rules/test", RequirePermission(PermEntriesRead), categorizationHandler.TestRules)
+			tenants.PUT("/:tenantId/categorization-rules/:ruleId", RequirePermission(PermSettingsManage), categorizationHandler.UpdateRule)
+			tenants.DELETE("/:tenantId/categorization-rules/:ruleId", RequirePermission(PermSettingsManage), categorizationHandler.DeleteRule)
 		}
 
 		mlHandler := NewMLHandler(cfg)
-		ml := api.Group("/ml")
+		// Routes d'IA : réservées aux membres authentifiés (pas aux clés d'API) et
+		// limitées, chaque appel consommant le quota Mistral.
+		ml := api.Group("/ml", AuthMiddleware(cfg, db), RequirePermission(PermEntriesRead),
+			RateLimit(limits, mlUserLimit, rateLimitByPrincipal),
+			RateLimit(limits, mlTenantLimit, rateLimitByTenant))
 		{
 			ml.POST("/chat", mlHandler.Chat)
 			ml.POST("/classify-transaction", mlHandler.ClassifyTransaction)
@@ -80,6 +236,13 @@ func main() {
 		}
 	}
 
+	// Purge des fichiers des documents supprimés, une fois le délai de rétention écoulé,
+	// et des sessions terminées.
+	purgeCtx, stopPurge := context.WithCancel(context.Background())
+	defer stopPurge()
+	go documentsHandler.RunPurge(purgeCtx, time.Hour)
+	go authHandler.RunSessionCleanup(purgeCtx, time.Hour)
+
 	srv := NewHTTPServer(cfg, router)
 
 	// Démarrage gracieux
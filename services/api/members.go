@@ -0,0 +1,572 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Membres d'un tenant : invitation de collègues par email (jeton signé à durée
+// limitée), acceptation avec choix du mot de passe, gestion des rôles.
+
+// invitationTTL est la durée de validité d'une invitation.
+const invitationTTL = 7 * 24 * time.Hour
+
+type MembersHandler struct {
+	db     *pgxpool.Pool
+	cfg    Config
+	mailer EmailSender
+}
+
+func NewMembersHandler(db *pgxpool.Pool, cfg Config, mailer EmailSender) *MembersHandler {
+	return &MembersHandler{db: db, cfg: cfg, mailer: mailer}
+}
+
+type member struct {
+	ID         int64     `json:"id"`
+	Email      string    `json:"email"`
+	Role       string    `json:"role"`
+	MFAEnabled bool      `json:"mfa_enabled"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type invitation struct {
+	ID        int64     `json:"id"`
+	Email     string    `json:"email"`
+	Role      string    `json:"role"`
+	InvitedBy *int64    `json:"invited_by"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+	Token     string    `json:"-"` // envoyé uniquement par email
+}
+
+type createInvitationRequest struct {
+	Email string `json:"email" binding:"required,email"`
+	Role  string `json:"role"`
+}
+
+type updateMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type acceptInvitationRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+// requireOwnerFor répond 403 si l'action touche au rôle propriétaire et que
+// l'utilisateur n'est pas lui-même propriétaire.
+func requireOwnerFor(c *gin.Context, roles ...string) bool {
+	if roleFromRequest(c) == RoleOwner || !containsString(roles, RoleOwner) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "seul un propriétaire du tenant peut attribuer ou retirer ce rôle"})
+	return false
+}
+
+func signInvitation(cfg Config, inv invitation, tenantID int64) (string, error) {
+	return signActionToken(cfg, "invitation", inv.ID, inv.ExpiresAt, jwt.MapClaims{
+		"tenant_id": tenantID,
+		"email":     inv.Email,
+	})
+}
+
+// parseInvitation vérifie un jeton d'invitation et renvoie l'identifiant de l'invitation.
+func parseInvitation(cfg Config, tokenStr string) (int64, error) {
+	id, err := parseActionToken(cfg, "invitation", tokenStr)
+	if err != nil {
+		return 0, errors.New("jeton d'invitation invalide ou expiré")
+	}
+	return id, nil
+}
+
+// POST /api/tenants/:tenantId/invitations
+// Invite un collègue : le lien d'acceptation lui est envoyé par email, et
+// seulement par email, ce qui confirme l'adresse à l'acceptation. Une nouvelle
+// invitation remplace celle encore en attente. La réponse est la même qu'un
+// compte existe ou non pour cet email : l'invité est alors prévenu par email.
+func (h *MembersHandler) CreateInvitation(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req createInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	role := req.Role
+	if role == "" {
+		role = RoleContributor
+	}
+	if !validMemberRole(role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rôle invalide", "details": gin.H{"roles": memberRoles}})
+		return
+	}
+	if !requireOwnerFor(c, role) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var exists bool
+	if err := h.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, email).Scan(&exists); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification de l'email"})
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE invitations SET revoked_at = now()
+		 WHERE tenant_id = $1 AND email = $2 AND accepted_at IS NULL AND revoked_at IS NULL`,
+		tenantID, email,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de remplacer l'invitation en attente"})
+		return
+	}
+
+	inv := invitation{Email: email, Role: role, InvitedBy: userIDFromRequest(c)}
+	err = tx.QueryRow(ctx,
+		`INSERT INTO invitations (tenant_id, email, role, invited_by, expires_at)
+		 VALUES ($1,$2,$3,$4,$5)
+		 RETURNING id, expires_at, created_at`,
+		tenantID, email, role, inv.InvitedBy, time.Now().Add(invitationTTL),
+	).Scan(&inv.ID, &inv.ExpiresAt, &inv.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'invitation", "details": err.Error()})
+		return
+	}
+
+	inv.Token, err = signInvitation(h.cfg, inv, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le jeton d'invitation"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la transaction"})
+		return
+	}
+
+	var tenantName string
+	if err := h.db.QueryRow(ctx, `SELECT name FROM tenants WHERE id = $1`, tenantID).Scan(&tenantName); err != nil {
+		tenantName = "votre organisation"
+	}
+	if exists {
+		sendEmailAsync(h.mailer, EmailMessage{
+			To:      email,
+			Subject: fmt.Sprintf("Invitation à rejoindre %s sur CarbonV2", tenantName),
+			Text: fmt.Sprintf("Bonjour,\n\n"+
+				"Vous avez été invité(e) à rejoindre %s sur CarbonV2, mais un compte existe déjà "+
+				"avec cette adresse. Un compte ne peut appartenir qu'à une organisation : "+
+				"contactez la personne qui vous a invité(e) ou connectez-vous avec votre compte actuel.\n\n"+
+				"Si vous ne vous attendiez pas à cette invitation, ignorez cet email.\n",
+				tenantName),
+		})
+		c.JSON(http.StatusCreated, inv)
+		return
+	}
+	sendEmailAsync(h.mailer, EmailMessage{
+		To:      email,
+		Subject: fmt.Sprintf("Invitation à rejoindre %s sur CarbonV2", tenantName),
+		Text: fmt.Sprintf("Bonjour,\n\n"+
+			"Vous êtes invité(e) à rejoindre %s sur CarbonV2. Pour accepter l'invitation "+
+			"et choisir votre mot de passe, ouvrez ce lien (valable 7 jours) :\n\n"+
+			"%s\n\n"+
+			"Si vous ne vous attendiez pas à cette invitation, ignorez cet email.\n",
+			tenantName, h.cfg.AppURL+"/invitations/accept?token="+url.QueryEscape(inv.Token)),
+	})
+
+	c.JSON(http.StatusCreated, inv)
+}
+
+// GET /api/tenants/:tenantId/invitations
+// Invitations en attente (ni acceptées, ni révoquées, ni expirées).
+func (h *MembersHandler) ListInvitations(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT id, email, role, invited_by, expires_at, created_at
+		 FROM invitations
+		 WHERE tenant_id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		 ORDER BY created_at DESC`,
+		tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des invitations"})
+		return
+	}
+	defer rows.Close()
+
+	invitations := []invitation{}
+	for rows.Next() {
+		var inv invitation
+		if err := rows.Scan(&inv.ID, &inv.Email, &inv.Role, &inv.InvitedBy, &inv.ExpiresAt, &inv.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des invitations"})
+			return
+		}
+		invitations = append(invitations, inv)
+	}
+	c.JSON(http.StatusOK, invitations)
+}
+
+// DELETE /api/tenants/:tenantId/invitations/:invitationId
+// Révoque une invitation en attente : son jeton n'est plus accepté.
+func (h *MembersHandler) RevokeInvitation(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	invitationID, ok := int64Param(c, "invitationId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`UPDATE invitations SET revoked_at = now()
+		 WHERE id = $1 AND tenant_id = $2 AND accepted_at IS NULL AND revoked_at IS NULL`,
+		invitationID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de révoquer l'invitation"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "invitation introuvable ou déjà utilisée"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /api/tenants/:tenantId/members
+func (h *MembersHandler) ListMembers(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT id, email, role, totp_enabled_at IS NOT NULL, created_at FROM users WHERE tenant_id = $1 ORDER BY created_at, id`,
+		tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des membres"})
+		return
+	}
+	defer rows.Close()
+
+	members := []member{}
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.ID, &m.Email, &m.Role, &m.MFAEnabled, &m.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des membres"})
+			return
+		}
+		members = append(members, m)
+	}
+	c.JSON(http.StatusOK, members)
+}
+
+// PUT /api/tenants/:tenantId/members/:userId/role
+// Change le rôle d'un membre. Le tenant garde toujours au moins un propriétaire.
+func (h *MembersHandler) UpdateMemberRole(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	userID, ok := int64Param(c, "userId")
+	if !ok {
+		return
+	}
+
+	var req updateMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	if !validMemberRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "rôle invalide", "details": gin.H{"roles": memberRoles}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	current, ok := lockMember(ctx, c, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	if !requireOwnerFor(c, current.Role, req.Role) {
+		return
+	}
+	if current.Role == RoleOwner && req.Role != RoleOwner && !keepsAnOwner(ctx, c, tx, tenantID, userID) {
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE users SET role = $3 WHERE id = $1 AND tenant_id = $2`, userID, tenantID, req.Role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de modifier le rôle"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la transaction"})
+		return
+	}
+
+	current.Role = req.Role
+	c.JSON(http.StatusOK, current)
+}
+
+// DELETE /api/tenants/:tenantId/members/:userId
+// Retire un membre du tenant (son compte est supprimé).
+func (h *MembersHandler) RemoveMember(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	userID, ok := int64Param(c, "userId")
+	if !ok {
+		return
+	}
+	if self := userIDFromRequest(c); self != nil && *self == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "impossible de se retirer soi-même du tenant"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	current, ok := lockMember(ctx, c, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	if !requireOwnerFor(c, current.Role) {
+		return
+	}
+	if current.Role == RoleOwner && !keepsAnOwner(ctx, c, tx, tenantID, userID) {
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1 AND tenant_id = $2`, userID, tenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de retirer le membre", "details": err.Error()})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider la transaction"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// lockMember charge et verrouille un membre du tenant. En cas d'échec la
+// réponse d'erreur est déjà écrite.
+func lockMember(ctx context.Context, c *gin.Context, tx pgx.Tx, tenantID, userID int64) (member, bool) {
+	var m member
+	err := tx.QueryRow(ctx,
+		`SELECT id, email, role, totp_enabled_at IS NOT NULL, created_at FROM users WHERE id = $1 AND tenant_id = $2 FOR UPDATE`,
+		userID, tenantID,
+	).Scan(&m.ID, &m.Email, &m.Role, &m.MFAEnabled, &m.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "membre introuvable"})
+		return m, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du membre"})
+		return m, false
+	}
+	return m, true
+}
+
+// keepsAnOwner vérifie qu'il restera un autre propriétaire dans le tenant.
+// Les propriétaires sont verrouillés pour que deux retraits simultanés ne
+// laissent pas le tenant sans propriétaire.
+func keepsAnOwner(ctx context.Context, c *gin.Context, tx pgx.Tx, tenantID, exceptUserID int64) bool {
+	rows, err := tx.Query(ctx,
+		`SELECT id FROM users WHERE tenant_id = $1 AND role = 'owner' AND id <> $2 FOR UPDATE`,
+		tenantID, exceptUserID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification des propriétaires"})
+		return false
+	}
+	others := 0
+	for rows.Next() {
+		others++
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification des propriétaires"})
+		return false
+	}
+	if others == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "le tenant doit conserver au moins un propriétaire"})
+		return false
+	}
+	return true
+}
+
+// GET /api/auth/invitations?token=...
+// Détail d'une invitation avant acceptation (tenant, email, rôle).
+func (h *AuthHandler) GetInvitation(c *gin.Context) {
+	invitationID, err := parseInvitation(h.cfg, c.Query("token"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var email, role, tenantName string
+	var expiresAt time.Time
+	err = h.db.QueryRow(ctx,
+		`SELECT i.email, i.role, i.expires_at, t.name
+		 FROM invitations i
+		 JOIN tenants t ON t.id = i.tenant_id
+		 WHERE i.id = $1 AND i.accepted_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > now()`,
+		invitationID,
+	).Scan(&email, &role, &expiresAt, &tenantName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusGone, gin.H{"error": "invitation expirée, révoquée ou déjà utilisée"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de l'invitation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"email":       email,
+		"role":        role,
+		"tenant_name": tenantName,
+		"expires_at":  expiresAt,
+	})
+}
+
+// POST /api/auth/invitations/accept
+// Accepte une invitation : crée le compte avec le mot de passe choisi (l'adresse
+// est confirmée, le lien ayant été reçu par email) et renvoie un token de connexion.
+func (h *AuthHandler) AcceptInvitation(c *gin.Context) {
+	var req acceptInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   "Les informations fournies sont invalides. Le mot de passe doit contenir au moins 8 caractères.",
+			"details": err.Error(),
+		})
+		return
+	}
+	invitationID, err := parseInvitation(h.cfg, req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var tenantID int64
+	var email, role string
+	err = tx.QueryRow(ctx,
+		`SELECT tenant_id, email, role FROM invitations
+		 WHERE id = $1 AND accepted_at IS NULL AND revoked_at IS NULL AND expires_at > now()
+		 FOR UPDATE`,
+		invitationID,
+	).Scan(&tenantID, &email, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusGone, gin.H{"error": "invitation expirée, révoquée ou déjà utilisée"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de l'invitation"})
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de sécurité"})
+		return
+	}
+
+	var userID int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO users (tenant_id, email, role, password_hash, email_verified_at) VALUES ($1, $2, $3, $4, now()) RETURNING id`,
+		tenantID, email, role, string(passwordHash),
+	).Scan(&userID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "un compte existe déjà avec cet email"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'utilisateur"})
+		return
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE invitations SET accepted_at = now(), accepted_user_id = $2 WHERE id = $1`,
+		invitationID, userID,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de valider l'invitation"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	resp, err := h.startSession(c, userID, tenantID, role, authMethodPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le token"})
+		return
+	}
+	c.JSON(http.StatusCreated, resp)
+}
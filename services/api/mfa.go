@@ -0,0 +1,535 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Double authentification (TOTP) : enrôlement par QR code, codes de
+// récupération, second facteur à la connexion et obligation par tenant. Un
+// tenant qui l'impose bloque l'accès à ses routes tant que le membre ne l'a
+// pas activée (les connexions SSO en sont dispensées : le fournisseur
+// d'identité applique sa propre politique).
+
+// mfaLoginTTL borne le délai entre le mot de passe et le code.
+const mfaLoginTTL = 5 * time.Minute
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type mfaLoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // code TOTP ou code de récupération
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type mfaPolicyRequest struct {
+	Required *bool `json:"required" binding:"required"`
+}
+
+// checkSecondFactor vérifie un code TOTP (ou, si allowRecovery, un code de
+// récupération, alors consommé) dans la transaction.
+func (h *AuthHandler) checkSecondFactor(ctx context.Context, tx pgx.Tx, userID int64, code string, allowRecovery bool) (bool, error) {
+	code = strings.TrimSpace(code)
+	var sealed *string
+	var enabledAt *time.Time
+	var lastStep int64
+	err := tx.QueryRow(ctx,
+		`SELECT totp_secret, totp_enabled_at, totp_last_step FROM users WHERE id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&sealed, &enabledAt, &lastStep)
+	if err != nil {
+		return false, err
+	}
+	if sealed == nil || enabledAt == nil {
+		return false, nil
+	}
+
+	if isTOTPCode(code) {
+		secret, err := openTOTPSecret(h.cfg, *sealed)
+		if err != nil {
+			return false, err
+		}
+		step, ok := verifyTOTP(secret, code, lastStep, time.Now())
+		if !ok {
+			return false, nil
+		}
+		_, err = tx.Exec(ctx, `UPDATE users SET totp_last_step = $2 WHERE id = $1`, userID, step)
+		return err == nil, err
+	}
+	if !allowRecovery {
+		return false, nil
+	}
+	tag, err := tx.Exec(ctx,
+		`UPDATE mfa_recovery_codes SET used_at = now()
+		 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// replaceRecoveryCodes remplace les codes de récupération de l'utilisateur et
+// renvoie les nouveaux (affichés une seule fois).
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int64) ([]string, error) {
+	codes, err := newRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1,$2)`,
+			userID, hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// POST /api/auth/login/mfa
+// Second temps de la connexion : échange le mfa_token renvoyé par /login et un
+// code TOTP (ou de récupération) contre une session.
+func (h *AuthHandler) LoginMFA(c *gin.Context) {
+	var req mfaLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mfa_token et code sont requis", "details": err.Error()})
+		return
+	}
+	userID, err := parseActionToken(h.cfg, "mfa_login", req.MFAToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "connexion expirée, saisissez à nouveau votre mot de passe"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Le mot de passe est déjà connu : les essais de code sont comptés par
+	// compte, quelle que soit l'adresse IP.
+	lockKey := "mfa:" + toStringID(userID)
+	if wait := h.lockout.Locked(ctx, lockKey); wait > 0 {
+		respondLocked(c, wait)
+		return
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	ok, err := h.checkSecondFactor(ctx, tx, userID, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification du code"})
+		return
+	}
+	if !ok {
+		h.lockout.Failed(ctx, lockKey)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "code de vérification invalide"})
+		return
+	}
+	var tenantID int64
+	var role string
+	if err := tx.QueryRow(ctx, `SELECT tenant_id, role FROM users WHERE id = $1`, userID).Scan(&tenantID, &role); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+	h.lockout.Succeeded(ctx, lockKey)
+
+	resp, err := h.startSession(c, userID, tenantID, role, authMethodMFA)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le token"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GET /api/auth/mfa
+// État de la double authentification de l'utilisateur connecté.
+func (h *AuthHandler) MFAStatus(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var enabledAt *time.Time
+	var required bool
+	var remaining int
+	err := h.db.QueryRow(ctx,
+		`SELECT u.totp_enabled_at, t.require_mfa,
+		        (SELECT count(*) FROM mfa_recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+		 FROM users u JOIN tenants t ON t.id = u.tenant_id
+		 WHERE u.id = $1`,
+		*userID,
+	).Scan(&enabledAt, &required, &remaining)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du compte"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"totp_enabled":             enabledAt != nil,
+		"totp_enabled_at":          enabledAt,
+		"recovery_codes_remaining": remaining,
+		"required_by_tenant":       required,
+	})
+}
+
+// POST /api/auth/mfa/totp/setup
+// Génère un secret TOTP et son URI otpauth:// (à afficher en QR code). La
+// double authentification n'est active qu'après confirmation d'un premier code.
+func (h *AuthHandler) SetupTOTP(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	sealed, err := sealTOTPSecret(h.cfg, secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var email string
+	err = h.db.QueryRow(ctx,
+		`UPDATE users SET totp_secret = $2 WHERE id = $1 AND totp_enabled_at IS NULL RETURNING email`,
+		*userID, sealed,
+	).Scan(&email)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "double authentification déjà activée"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer le secret"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totpURI(secret, email),
+	})
+}
+
+// POST /api/auth/mfa/totp/enable
+// Active la double authentification après vérification d'un code généré avec
+// le secret d'enrôlement, et renvoie les codes de récupération.
+func (h *AuthHandler) EnableTOTP(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code manquant", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var sealed *string
+	var enabledAt *time.Time
+	err = tx.QueryRow(ctx,
+		`SELECT totp_secret, totp_enabled_at FROM users WHERE id = $1 FOR UPDATE`,
+		*userID,
+	).Scan(&sealed, &enabledAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du compte"})
+		return
+	}
+	if enabledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "double authentification déjà activée"})
+		return
+	}
+	if sealed == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "aucun enrôlement en cours, appelez d'abord /auth/mfa/totp/setup"})
+		return
+	}
+	secret, err := openTOTPSecret(h.cfg, *sealed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	step, ok := verifyTOTP(secret, strings.TrimSpace(req.Code), 0, time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code de vérification invalide"})
+		return
+	}
+
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET totp_enabled_at = now(), totp_last_step = $2 WHERE id = $1`,
+		*userID, step,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'activer la double authentification"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, *userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer les codes de récupération"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"totp_enabled": true, "recovery_codes": codes})
+}
+
+// POST /api/auth/mfa/totp/disable
+// Désactive la double authentification (mot de passe et code exigés). Refusé
+// si le tenant l'impose.
+func (h *AuthHandler) DisableTOTP(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+	var req disableTOTPRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mot de passe et code sont requis", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var passwordHash string
+	var required bool
+	err = tx.QueryRow(ctx,
+		`SELECT u.password_hash, t.require_mfa FROM users u JOIN tenants t ON t.id = u.tenant_id WHERE u.id = $1`,
+		*userID,
+	).Scan(&passwordHash, &required)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du compte"})
+		return
+	}
+	if required {
+		c.JSON(http.StatusConflict, gin.H{"error": "la double authentification est imposée par votre organisation"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "mot de passe invalide"})
+		return
+	}
+	ok, err := h.checkSecondFactor(ctx, tx, *userID, req.Code, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification du code"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "code de vérification invalide"})
+		return
+	}
+
+	if err := disableMFA(ctx, tx, *userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de désactiver la double authentification"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"totp_enabled": false})
+}
+
+// POST /api/auth/mfa/recovery-codes
+// Génère de nouveaux codes de récupération (les anciens sont invalidés) ;
+// exige un code TOTP.
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "code manquant", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	ok, err := h.checkSecondFactor(ctx, tx, *userID, req.Code, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification du code"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "code de vérification invalide"})
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, tx, *userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer les codes de récupération"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// disableMFA efface le secret TOTP et les codes de récupération.
+func disableMFA(ctx context.Context, tx pgx.Tx, userID int64) error {
+	if _, err := tx.Exec(ctx,
+		`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = $1`,
+		userID,
+	); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+	return err
+}
+
+// GET /api/tenants/:tenantId/settings/mfa
+func (h *MembersHandler) GetMFAPolicy(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var required bool
+	var withoutMFA int
+	err := h.db.QueryRow(ctx,
+		`SELECT t.require_mfa,
+		        (SELECT count(*) FROM users u WHERE u.tenant_id = t.id AND u.totp_enabled_at IS NULL)
+		 FROM tenants t WHERE t.id = $1`,
+		tenantID,
+	).Scan(&required, &withoutMFA)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du tenant"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"required": required, "members_without_mfa": withoutMFA})
+}
+
+// PUT /api/tenants/:tenantId/settings/mfa
+// Impose (ou non) la double authentification aux membres du tenant. Les
+// membres qui ne l'ont pas activée doivent le faire avant d'accéder aux données.
+func (h *MembersHandler) UpdateMFAPolicy(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	var req mfaPolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.db.Exec(ctx, `UPDATE tenants SET require_mfa = $2 WHERE id = $1`, tenantID, *req.Required); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de modifier la politique de sécurité"})
+		return
+	}
+	h.GetMFAPolicy(c)
+}
+
+// DELETE /api/tenants/:tenantId/members/:userId/mfa
+// Réinitialise la double authentification d'un membre (appareil perdu, sans
+// codes de récupération) ; il devra la réactiver si le tenant l'impose.
+func (h *MembersHandler) ResetMemberMFA(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	userID, ok := int64Param(c, "userId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la transaction"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	current, ok := lockMember(ctx, c, tx, tenantID, userID)
+	if !ok {
+		return
+	}
+	if !requireOwnerFor(c, current.Role) {
+		return
+	}
+	if err := disableMFA(ctx, tx, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de réinitialiser la double authentification"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
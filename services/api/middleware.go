@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -27,7 +30,8 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-// AuthMiddleware protège les routes en vérifiant le JWT.
+// AuthMiddleware protège les routes en vérifiant le JWT, ou la clé d'API
+// (préfixe cbk_) d'une intégration.
 func AuthMiddleware(cfg Config, db *pgxpool.Pool) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
@@ -43,6 +47,23 @@ func AuthMiddleware(cfg Config, db *pgxpool.Pool) gin.HandlerFunc {
 		}
 
 		tokenStr := parts[1]
+		if strings.HasPrefix(tokenStr, apiKeyPrefix) {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+			defer cancel()
+			claims, err := authenticateAPIKey(ctx, db, tokenStr, c.ClientIP())
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "clé d'API invalide, expirée ou révoquée"})
+				return
+			}
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification de la clé d'API"})
+				return
+			}
+			c.Set("user", claims)
+			c.Next()
+			return
+		}
+
 		token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
 			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, jwt.ErrTokenSignatureInvalid
@@ -68,6 +89,39 @@ func AuthMiddleware(cfg Config, db *pgxpool.Pool) gin.HandlerFunc {
 			}
 		}
 
+		// La session doit être encore ouverte : déconnexion, révocation ou retrait
+		// du membre invalident le token avant son expiration. Le rôle est relu en
+		// base pour qu'un changement de rôle prenne effet immédiatement, de même que
+		// l'obligation de double authentification imposée par le tenant.
+		sid, okSid := claims["sid"].(float64)
+		sub, okSub := claims["sub"].(float64)
+		if !okSid || !okSub {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session invalide, reconnectez-vous"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		var role string
+		var mfaEnrollment bool
+		err = db.QueryRow(ctx,
+			`SELECT u.role, t.require_mfa AND u.totp_enabled_at IS NULL AND s.auth_method <> 'sso'
+			 FROM sessions s
+			 JOIN users u ON u.id = s.user_id
+			 JOIN tenants t ON t.id = u.tenant_id
+			 WHERE s.id = $1 AND s.user_id = $2 AND s.revoked_at IS NULL`,
+			int64(sid), int64(sub),
+		).Scan(&role, &mfaEnrollment)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session révoquée, reconnectez-vous"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification de la session"})
+			return
+		}
+		claims["role"] = role
+		claims["mfa_enrollment_required"] = mfaEnrollment
+
 		c.Set("user", claims)
 		c.Next()
 	}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -93,4 +94,48 @@ func (m *MistralClient) invokeAgent(prompt string) (string, error) {
 	return string(b), nil
 }
 
+// mistralConversationResponse ne décrit que la partie utile de la réponse
+// /conversations : les sorties de type message.output.
+type mistralConversationResponse struct {
+	Outputs []struct {
+		Type    string          `json:"type"`
+		Content json.RawMessage `json:"content"`
+	} `json:"outputs"`
+}
+
+// agentText extrait le texte produit par l'agent depuis le corps brut renvoyé par
+// invokeAgent. Le contenu peut être une chaîne ou une liste de fragments ; si le
+// schéma n'est pas reconnu, le corps brut est renvoyé tel quel.
+func agentText(raw string) string {
+	var resp mistralConversationResponse
+	if err := json.Unmarshal([]byte(raw), &resp); err != nil || len(resp.Outputs) == 0 {
+		return raw
+	}
 
+	var b strings.Builder
+	for _, o := range resp.Outputs {
+		if o.Type != "message.output" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(o.Content, &text); err == nil {
+			b.WriteString(text)
+			continue
+		}
+		var chunks []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(o.Content, &chunks); err == nil {
+			for _, ch := range chunks {
+				if ch.Type == "text" {
+					b.WriteString(ch.Text)
+				}
+			}
+		}
+	}
+	if b.Len() == 0 {
+		return raw
+	}
+	return b.String()
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -56,13 +57,16 @@ type classifyTransactionRequest struct {
 }
 
 type classifyTransactionResponse struct {
-	Category string `json:"category"`
-	Scope    string `json:"scope"` // "1","2","3"
-	Reason   string `json:"reason"`
-	Raw      string `json:"raw"`
+	Category   string  `json:"category"`
+	Scope      string  `json:"scope"` // "1","2","3"
+	Reason     string  `json:"reason"`
+	Confidence float64 `json:"confidence"` // 0 à 1, estimée par le modèle
+	Attempts   int     `json:"attempts"`
+	Raw        string  `json:"raw"`
 }
 
 // POST /api/ml/classify-transaction
+// La catégorie renvoyée appartient toujours au catalogue des facteurs d'émission.
 func (h *MLHandler) ClassifyTransaction(c *gin.Context) {
 	if !h.mistral.enabled() {
 		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Mistral non configuré côté serveur"})
@@ -75,19 +79,23 @@ func (h *MLHandler) ClassifyTransaction(c *gin.Context) {
 		return
 	}
 
-	prompt := buildClassificationPrompt(req.Description, req.Amount, req.Currency)
-	out, err := h.mistral.invokeAgent(prompt)
+	cl, raw, attempts, err := h.mistral.classifyTransaction(req.Description, req.Amount, req.Currency)
 	if err != nil {
+		if errors.Is(err, errInvalidClassification) {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "réponse Mistral invalide après relance", "details": err.Error(), "raw": raw})
+			return
+		}
 		c.JSON(http.StatusBadGateway, gin.H{"error": "échec appel Mistral", "details": err.Error()})
 		return
 	}
 
-	// Pour l’instant on renvoie le texte brut et on laisse le frontend parser / afficher.
 	c.JSON(http.StatusOK, classifyTransactionResponse{
-		Category: "",
-		Scope:    "",
-		Reason:   "",
-		Raw:      out,
+		Category:   cl.Category,
+		Scope:      cl.Scope,
+		Reason:     cl.Reason,
+		Confidence: cl.Confidence,
+		Attempts:   attempts,
+		Raw:        raw,
 	})
 }
 
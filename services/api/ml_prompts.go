@@ -6,18 +6,66 @@ import (
 	"strings"
 )
 
+// categoryCatalogPrompt liste les catégories autorisées avec leur scope.
+func categoryCatalogPrompt() string {
+	var cats strings.Builder
+	for _, cat := range emissionCategories {
+		fmt.Fprintf(&cats, "- \"%s\" (scope %s) : %s\n", cat.Name, getRule(cat.Name, "").Scope, cat.Description)
+	}
+	return cats.String()
+}
+
 func buildClassificationPrompt(desc string, amount float64, currency string) string {
 	return fmt.Sprintf(`Tu es un expert en comptabilité carbone.
 Classe la dépense suivante :
 - Description : %s
 - Montant : %.2f %s
 
-Objectif :
-- Identifier une catégorie carbone claire (par exemple : "Transport - Avion", "Numérique - Cloud", "Énergie - Électricité").
-- Déterminer le scope principal (1, 2 ou 3).
-- Expliquer en quelques phrases la logique de classification.
+Catégories autorisées (libellé exact, scope associé) :
+%s
+Consignes :
+- Choisis obligatoirement une catégorie de la liste ci-dessus, en recopiant son libellé exact.
+- Le scope doit être celui associé à la catégorie choisie ("1", "2" ou "3").
+- Explique en une ou deux phrases la logique de classification.
+- Indique ta confiance entre 0 et 1 (1 = certain).
+
+Réponds UNIQUEMENT avec un objet JSON, sans texte autour ni bloc de code, au format :
+{"category": "...", "scope": "1|2|3", "reason": "...", "confidence": 0.0}`, desc, amount, strings.ToUpper(currency), categoryCatalogPrompt())
+}
+
+// buildBatchClassificationPrompt demande la classification d'un lot d'entrées
+// sous forme d'un tableau JSON indexé par identifiant d'entrée.
+func buildBatchClassificationPrompt(entries []entryToClassify) string {
+	var lines strings.Builder
+	for _, e := range entries {
+		fmt.Fprintf(&lines, "- id %d : type %q, libellé %q, fournisseur %q, compte %q, montant %.2f %s\n",
+			e.ID, e.Type, e.Label, e.Vendor, e.Account, e.Amount, strings.ToUpper(e.Currency))
+	}
+
+	return fmt.Sprintf(`Tu es un expert en comptabilité carbone.
+Classe chacune des écritures comptables suivantes :
+%s
+Catégories autorisées (libellé exact, scope associé) :
+%s
+Consignes :
+- Pour chaque écriture, choisis une catégorie de la liste en recopiant son libellé exact.
+- Le scope doit être celui associé à la catégorie choisie ("1", "2" ou "3").
+- Donne une justification courte et ta confiance entre 0 et 1.
+- Si une écriture est trop ambiguë, omets-la plutôt que de deviner.
+
+Réponds UNIQUEMENT avec un tableau JSON, sans texte autour ni bloc de code, au format :
+[{"id": 123, "category": "...", "scope": "1|2|3", "reason": "...", "confidence": 0.0}]`, lines.String(), categoryCatalogPrompt())
+}
+
+// buildClassificationRetryPrompt relance le modèle après une réponse invalide en
+// lui rappelant l'erreur constatée.
+func buildClassificationRetryPrompt(prompt, previous, problem string) string {
+	return fmt.Sprintf(`%s
+
+Ta réponse précédente était invalide (%s) :
+%s
 
-Réponds dans un format texte structuré et lisible pour un humain.`, desc, amount, strings.ToUpper(currency))
+Corrige-la et réponds uniquement avec l'objet JSON demandé.`, prompt, problem, previous)
 }
 
 func buildForecastPrompt(history []float64) string {
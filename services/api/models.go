@@ -9,6 +9,10 @@ type Tenant struct {
 	Siret     string    `db:"siret"`
 	Plan      string    `db:"plan"`
 	CreatedAt time.Time `db:"created_at"`
+	// Seuil (tCO2e par entrée) au-delà duquel une pièce justificative est attendue.
+	MaterialityThresholdTCO2e *float64 `db:"materiality_threshold_tco2e"`
+	// Double authentification imposée à tous les membres.
+	RequireMFA bool `db:"require_mfa"`
 }
 
 // User représente un utilisateur rattaché à un tenant.
@@ -16,9 +20,18 @@ type User struct {
 	ID           int64     `db:"id"`
 	TenantID     int64     `db:"tenant_id"`
 	Email        string    `db:"email"`
-	Role         string    `db:"role"`
+	Role         string    `db:"role"` // "owner","admin","contributor","viewer","auditor"
 	PasswordHash string    `db:"password_hash"`
 	CreatedAt    time.Time `db:"created_at"`
+	// Date de confirmation de l'adresse email (nil tant qu'elle n'est pas confirmée).
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	// Double authentification : secret TOTP chiffré, actif une fois confirmé.
+	TOTPSecret    *string    `db:"totp_secret"`
+	TOTPEnabledAt *time.Time `db:"totp_enabled_at"`
+	TOTPLastStep  int64      `db:"totp_last_step"`
+	// Identité SSO (issuer, sub) à laquelle le compte est rattaché.
+	SSOIssuer  *string `db:"sso_issuer"`
+	SSOSubject *string `db:"sso_subject"`
 }
 
 // Entry représente une ligne comptable ou une activité saisie par un tenant.
@@ -31,6 +44,7 @@ type Entry struct {
 	Date      time.Time `db:"date"`
 	Category  *string   `db:"category"`
 	Source    *string   `db:"source"`
+	SiteID    *int64    `db:"site_id"`
 	CreatedAt time.Time `db:"created_at"`
 }
 
@@ -43,6 +57,12 @@ type Emission struct {
 	TCO2e              float64   `db:"tco2e"`
 	MethodologyVersion string    `db:"methodology_version"`
 	ComputedAt         time.Time `db:"computed_at"`
+	// Ventilation par gaz, optionnelle (nil = non ventilée).
+	TCO2eCO2     *float64 `db:"tco2e_co2"`
+	TCO2eCH4     *float64 `db:"tco2e_ch4"`
+	TCO2eN2O     *float64 `db:"tco2e_n2o"`
+	TCO2eOther   *float64 `db:"tco2e_other"`
+	TCO2Biogenic *float64 `db:"tco2_biogenic"`
 }
 
 // Document représente un document importé (facture EDF, contrat énergie, etc.).
@@ -53,7 +73,204 @@ type Document struct {
 	MimeType     string    `db:"mime_type"`
 	SizeBytes    int64     `db:"size_bytes"`
 	StoragePath  string    `db:"storage_path"`
+	Sha256       *string   `db:"sha256"` // empreinte du contenu, nil pour les documents antérieurs
 	Source       *string   `db:"source"`
 	Kind         *string   `db:"kind"`
 	CreatedAt    time.Time `db:"created_at"`
+
+	AnalysisStatus     string     `db:"analysis_status"` // 'none' | 'pending' | 'processing' | 'done' | 'failed'
+	AnalysisError      *string    `db:"analysis_error"`
+	AnalyzedAt         *time.Time `db:"analyzed_at"`
+	EntriesConfirmedAt *time.Time `db:"entries_confirmed_at"`
+
+	Version      int        `db:"version"`
+	VersionOf    *int64     `db:"version_of"`    // première version du document (nil pour celle-ci)
+	SupersededBy *int64     `db:"superseded_by"` // version suivante, nil pour la version courante
+	DeletedAt    *time.Time `db:"deleted_at"`
+	PurgedAt     *time.Time `db:"purged_at"`
+}
+
+// ReductionTarget représente un objectif de réduction des émissions d'un tenant.
+type ReductionTarget struct {
+	ID              int64     `db:"id" json:"id"`
+	TenantID        int64     `db:"tenant_id" json:"tenant_id"`
+	Name            string    `db:"name" json:"name"`
+	BaseYear        int       `db:"base_year" json:"base_year"`
+	TargetYear      int       `db:"target_year" json:"target_year"`
+	Scopes          []string  `db:"scopes" json:"scopes"`
+	TargetType      string    `db:"target_type" json:"target_type"` // "absolute","intensity"
+	IntensityMetric *string   `db:"intensity_metric" json:"intensity_metric,omitempty"`
+	ReductionPct    float64   `db:"reduction_pct" json:"reduction_pct"`
+	Pathway         string    `db:"pathway" json:"pathway"` // "linear","sbti_1_5c"
+	CreatedAt       time.Time `db:"created_at" json:"created_at"`
+}
+
+// ReductionAction représente une action du plan de réduction d'un tenant.
+type ReductionAction struct {
+	ID                   int64     `db:"id" json:"id"`
+	TenantID             int64     `db:"tenant_id" json:"tenant_id"`
+	Title                string    `db:"title" json:"title"`
+	Owner                *string   `db:"owner" json:"owner,omitempty"`
+	Categories           []string  `db:"categories" json:"categories"`
+	Scopes               []string  `db:"scopes" json:"scopes"`
+	StartDate            time.Time `db:"start_date" json:"start_date"`
+	Status               string    `db:"status" json:"status"` // "planned","in_progress","done","cancelled"
+	CostEUR              float64   `db:"cost_eur" json:"cost_eur"`
+	LifetimeYears        int       `db:"lifetime_years" json:"lifetime_years"`
+	AnnualReductionTCO2e float64   `db:"annual_reduction_tco2e" json:"annual_reduction_tco2e"`
+	CreatedAt            time.Time `db:"created_at" json:"created_at"`
+}
+
+// IntensityDenominator représente une grandeur de référence (CA, effectif, etc.) pour une année.
+type IntensityDenominator struct {
+	ID        int64     `db:"id" json:"id"`
+	TenantID  int64     `db:"tenant_id" json:"tenant_id"`
+	Year      int       `db:"year" json:"year"`
+	Metric    string    `db:"metric" json:"metric"` // "revenue","headcount","floor_area","production"
+	Value     float64   `db:"value" json:"value"`
+	Unit      string    `db:"unit" json:"unit"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Site représente un nœud de la structure organisationnelle d'un tenant
+// (entité juridique, site ou centre de coût).
+type Site struct {
+	ID                 int64     `db:"id" json:"id"`
+	TenantID           int64     `db:"tenant_id" json:"tenant_id"`
+	ParentID           *int64    `db:"parent_id" json:"parent_id"`
+	Name               string    `db:"name" json:"name"`
+	Kind               string    `db:"kind" json:"kind"` // "entity","site","cost_centre"
+	Country            *string   `db:"country" json:"country,omitempty"`
+	OwnershipPct       float64   `db:"ownership_pct" json:"ownership_pct"`
+	OperationalControl bool      `db:"operational_control" json:"operational_control"`
+	FinancialControl   bool      `db:"financial_control" json:"financial_control"`
+	CreatedAt          time.Time `db:"created_at" json:"created_at"`
+}
+
+// CategorizationJob suit l'exécution d'une catégorisation automatique des entrées.
+type CategorizationJob struct {
+	ID           int64      `db:"id" json:"id"`
+	TenantID     int64      `db:"tenant_id" json:"tenant_id"`
+	Status       string     `db:"status" json:"status"` // "running","done","failed"
+	TotalEntries int        `db:"total_entries" json:"total_entries"`
+	RuleMatches  int        `db:"rule_matches" json:"rule_matches"`
+	LLMMatches   int        `db:"llm_matches" json:"llm_matches"`
+	Unmatched    int        `db:"unmatched" json:"unmatched"`
+	Error        *string    `db:"error" json:"error,omitempty"`
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	FinishedAt   *time.Time `db:"finished_at" json:"finished_at,omitempty"`
+}
+
+// CategorySuggestion est une catégorie proposée pour une entrée, en attente de revue.
+type CategorySuggestion struct {
+	ID         int64   `db:"id" json:"id"`
+	TenantID   int64   `db:"tenant_id" json:"tenant_id"`
+	JobID      int64   `db:"job_id" json:"job_id"`
+	EntryID    int64   `db:"entry_id" json:"entry_id"`
+	Category   string  `db:"category" json:"category"`
+	Scope      string  `db:"scope" json:"scope"`
+	Origin     string  `db:"origin" json:"origin"` // "rule","llm"
+	MatchedBy  *string `db:"matched_by" json:"matched_by,omitempty"`
+	Reason     *string `db:"reason" json:"reason,omitempty"`
+	Confidence float64 `db:"confidence" json:"confidence"`
+	Status     string  `db:"status" json:"status"` // "pending","accepted","rejected"
+	// Catégorie retenue lorsque l'utilisateur a corrigé la suggestion en l'acceptant.
+	AcceptedCategory *string    `db:"accepted_category" json:"accepted_category,omitempty"`
+	CreatedAt        time.Time  `db:"created_at" json:"created_at"`
+	ReviewedAt       *time.Time `db:"reviewed_at" json:"reviewed_at,omitempty"`
+}
+
+// CategorizationRule associe un fournisseur, un libellé ou un compte comptable à une
+// catégorie pour un tenant.
+type CategorizationRule struct {
+	ID            int64      `db:"id" json:"id"`
+	TenantID      int64      `db:"tenant_id" json:"tenant_id"`
+	MatchType     string     `db:"match_type" json:"match_type"` // "vendor","label_regex","account"
+	Pattern       string     `db:"pattern" json:"pattern"`
+	Category      string     `db:"category" json:"category"`
+	Priority      int        `db:"priority" json:"priority"`
+	Origin        string     `db:"origin" json:"origin"` // "manual","learned"
+	Hits          int        `db:"hits" json:"hits"`
+	LastMatchedAt *time.Time `db:"last_matched_at" json:"last_matched_at,omitempty"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt     time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// EntryDocument relie une entrée à un document justificatif.
+type EntryDocument struct {
+	EntryID    int64     `db:"entry_id" json:"entry_id"`
+	DocumentID int64     `db:"document_id" json:"document_id"`
+	TenantID   int64     `db:"tenant_id" json:"-"`
+	Note       *string   `db:"note" json:"note"`
+	AttachedBy *int64    `db:"attached_by" json:"attached_by"`
+	AttachedAt time.Time `db:"attached_at" json:"attached_at"`
+}
+
+// QuarantinedUpload est un fichier refusé par l'antivirus, conservé pour examen.
+type QuarantinedUpload struct {
+	ID           int64     `db:"id" json:"id"`
+	TenantID     int64     `db:"tenant_id" json:"tenant_id"`
+	OriginalName string    `db:"original_name" json:"original_name"`
+	MimeType     string    `db:"mime_type" json:"mime_type"`
+	SizeBytes    int64     `db:"size_bytes" json:"size_bytes"`
+	StoragePath  string    `db:"storage_path" json:"-"`
+	Signature    string    `db:"signature" json:"signature"`
+	UploadedBy   *int64    `db:"uploaded_by" json:"uploaded_by"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+}
+
+// Invitation invite un collègue à rejoindre un tenant avec un rôle donné.
+type Invitation struct {
+	ID             int64      `db:"id" json:"id"`
+	TenantID       int64      `db:"tenant_id" json:"tenant_id"`
+	Email          string     `db:"email" json:"email"`
+	Role           string     `db:"role" json:"role"`
+	InvitedBy      *int64     `db:"invited_by" json:"invited_by"`
+	ExpiresAt      time.Time  `db:"expires_at" json:"expires_at"`
+	AcceptedAt     *time.Time `db:"accepted_at" json:"accepted_at,omitempty"`
+	AcceptedUserID *int64     `db:"accepted_user_id" json:"accepted_user_id,omitempty"`
+	RevokedAt      *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	CreatedAt      time.Time  `db:"created_at" json:"created_at"`
+}
+
+// TenantSSO est la configuration OpenID Connect d'un tenant (le secret client
+// n'est jamais sérialisé).
+type TenantSSO struct {
+	TenantID       int64             `db:"tenant_id" json:"tenant_id"`
+	Issuer         string            `db:"issuer" json:"issuer"`
+	ClientID       string            `db:"client_id" json:"client_id"`
+	ClientSecret   string            `db:"client_secret" json:"-"`
+	AllowedDomains []string          `db:"allowed_domains" json:"allowed_domains"`
+	DefaultRole    string            `db:"default_role" json:"default_role"`
+	GroupsClaim    string            `db:"groups_claim" json:"groups_claim"`
+	GroupRoles     map[string]string `db:"group_roles" json:"group_roles"`
+	Enabled        bool              `db:"enabled" json:"enabled"`
+	CreatedAt      time.Time         `db:"created_at" json:"created_at"`
+	UpdatedAt      time.Time         `db:"updated_at" json:"updated_at"`
+}
+
+// TenantSSODomain est un domaine email revendiqué par la configuration SSO d'un
+// tenant ; il ne compte qu'une fois sa propriété vérifiée (enregistrement DNS TXT).
+type TenantSSODomain struct {
+	TenantID          int64      `db:"tenant_id" json:"tenant_id"`
+	Domain            string     `db:"domain" json:"domain"`
+	VerificationToken string     `db:"verification_token" json:"-"`
+	VerifiedAt        *time.Time `db:"verified_at" json:"verified_at"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+}
+
+// APIKey est une clé d'API d'intégration (seule son empreinte est stockée).
+type APIKey struct {
+	ID         int64      `db:"id" json:"id"`
+	TenantID   int64      `db:"tenant_id" json:"tenant_id"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	KeyHash    string     `db:"key_hash" json:"-"`
+	Scopes     []string   `db:"scopes" json:"scopes"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+	LastUsedIP *string    `db:"last_used_ip" json:"last_used_ip"`
+	CreatedBy  *int64     `db:"created_by" json:"created_by"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
 }
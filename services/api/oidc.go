@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Client OpenID Connect minimal : découverte du fournisseur, échange du code
+// d'autorisation (avec PKCE) et vérification de l'ID token signé en RS256.
+
+// oidcProvider est la configuration publiée par le fournisseur
+// (/.well-known/openid-configuration).
+type oidcProvider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+
+	fetchedAt time.Time
+	keys      map[string]*rsa.PublicKey // clés publiques par kid
+}
+
+// oidcClient interroge les fournisseurs et garde leur configuration en cache.
+// L'issuer est saisi par le propriétaire du tenant : le client n'appelle que
+// des URL HTTPS et refuse, à la résolution comme à la connexion, les adresses
+// internes (boucle locale, réseaux privés, lien local), pour que la
+// configuration SSO ne permette pas de sonder le réseau du serveur.
+type oidcClient struct {
+	http      *http.Client
+	ttl       time.Duration
+	checkHost func(ctx context.Context, host string) error // nil : pas de vérification (tests)
+
+	mu        sync.Mutex
+	providers map[string]*oidcProvider // par issuer
+}
+
+// errOIDCPrivateAddress signale un fournisseur hébergé sur une adresse interne.
+var errOIDCPrivateAddress = errors.New("adresse du fournisseur OIDC non publique")
+
+func newOIDCClient() *oidcClient {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &oidcClient{
+		http: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: transport,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if req.URL.Scheme != "https" || len(via) >= 5 {
+					return errors.New("redirection OIDC refusée")
+				}
+				return nil
+			},
+		},
+		ttl:       time.Hour,
+		checkHost: checkPublicHost,
+		providers: make(map[string]*oidcProvider),
+	}
+}
+
+// dialPublicOnly refuse la connexion vers une adresse interne. Elle s'applique
+// à l'adresse effectivement résolue, ce qui couvre aussi un changement
+// d'enregistrement DNS entre la vérification et l'appel.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return errOIDCPrivateAddress
+	}
+	return nil
+}
+
+// checkPublicHost vérifie que toutes les adresses du nom d'hôte sont publiques.
+func checkPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if !publicIP(a.IP) {
+			return errOIDCPrivateAddress
+		}
+	}
+	return nil
+}
+
+// cgnatBlock est l'espace partagé des opérateurs (RFC 6598), non routé sur Internet.
+var cgnatBlock = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// publicIP indique si l'adresse est joignable sur Internet.
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnatBlock.Contains(ip))
+}
+
+// checkIssuer vérifie qu'un issuer est une URL HTTPS hébergée sur une adresse
+// publique, avant tout appel au fournisseur.
+func (o *oidcClient) checkIssuer(ctx context.Context, issuer string) error {
+	if !validIssuer(issuer) {
+		return errors.New("issuer OIDC invalide")
+	}
+	if o.checkHost == nil {
+		return nil
+	}
+	u, _ := url.Parse(issuer)
+	return o.checkHost(ctx, u.Hostname())
+}
+
+// validIssuer accepte les issuers HTTPS, sans identifiants, requête ni fragment.
+func validIssuer(issuer string) bool {
+	u, err := url.Parse(issuer)
+	if err != nil {
+		return false
+	}
+	return u.Scheme == "https" && u.Hostname() != "" && u.User == nil && u.RawQuery == "" && u.Fragment == ""
+}
+
+// httpsURL indique si une URL publiée par le fournisseur est en HTTPS.
+func httpsURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && u.Host != ""
+}
+
+func (o *oidcClient) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s : statut %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// provider renvoie la configuration du fournisseur, découverte au besoin.
+func (o *oidcClient) provider(ctx context.Context, issuer string) (*oidcProvider, error) {
+	o.mu.Lock()
+	p, ok := o.providers[issuer]
+	o.mu.Unlock()
+	if ok && time.Since(p.fetchedAt) < o.ttl {
+		return p, nil
+	}
+
+	if err := o.checkIssuer(ctx, issuer); err != nil {
+		return nil, fmt.Errorf("découverte OIDC : %w", err)
+	}
+	var fresh oidcProvider
+	if err := o.getJSON(ctx, strings.TrimRight(issuer, "/")+"/.well-known/openid-configuration", &fresh); err != nil {
+		return nil, fmt.Errorf("découverte OIDC : %w", err)
+	}
+	if strings.TrimRight(fresh.Issuer, "/") != strings.TrimRight(issuer, "/") {
+		return nil, fmt.Errorf("découverte OIDC : issuer %q inattendu", fresh.Issuer)
+	}
+	if fresh.AuthorizationEndpoint == "" || fresh.TokenEndpoint == "" || fresh.JWKSURI == "" {
+		return nil, errors.New("découverte OIDC : configuration incomplète")
+	}
+	if !httpsURL(fresh.AuthorizationEndpoint) || !httpsURL(fresh.TokenEndpoint) || !httpsURL(fresh.JWKSURI) {
+		return nil, errors.New("découverte OIDC : points d'accès non HTTPS")
+	}
+	if err := o.loadKeys(ctx, &fresh); err != nil {
+		return nil, err
+	}
+	fresh.fetchedAt = time.Now()
+
+	o.mu.Lock()
+	o.providers[issuer] = &fresh
+	o.mu.Unlock()
+	return &fresh, nil
+}
+
+// loadKeys télécharge les clés RSA publiées par le fournisseur (JWKS).
+func (o *oidcClient) loadKeys(ctx context.Context, p *oidcProvider) error {
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := o.getJSON(ctx, p.JWKSURI, &set); err != nil {
+		return fmt.Errorf("clés OIDC : %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if len(keys) == 0 {
+		return errors.New("clés OIDC : aucune clé RSA de signature")
+	}
+	p.keys = keys
+	return nil
+}
+
+// key renvoie la clé publique d'identifiant kid ; les clés sont rechargées une
+// fois si elle est inconnue (rotation des clés chez le fournisseur).
+func (o *oidcClient) key(ctx context.Context, p *oidcProvider, kid string) (*rsa.PublicKey, error) {
+	o.mu.Lock()
+	k, ok := p.keys[kid]
+	o.mu.Unlock()
+	if ok {
+		return k, nil
+	}
+	refreshed := &oidcProvider{JWKSURI: p.JWKSURI}
+	if err := o.loadKeys(ctx, refreshed); err != nil {
+		return nil, err
+	}
+	o.mu.Lock()
+	p.keys = refreshed.keys
+	k, ok = p.keys[kid]
+	o.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("clé OIDC %q inconnue", kid)
+	}
+	return k, nil
+}
+
+// exchangeCode échange le code d'autorisation contre les jetons du fournisseur
+// et renvoie l'ID token.
+func (o *oidcClient) exchangeCode(ctx context.Context, p *oidcProvider, clientID, clientSecret, code, redirectURI, verifier string) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {clientID},
+		"code_verifier": {verifier},
+	}
+	if clientSecret != "" {
+		form.Set("client_secret", clientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := o.http.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("échange du code OIDC : %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("échange du code OIDC : statut %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.Error != "" {
+		return "", fmt.Errorf("échange du code OIDC : %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("échange du code OIDC : id_token absent")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken vérifie la signature RS256, l'émetteur, l'audience, l'expiration
+// et le nonce de l'ID token, et renvoie ses claims.
+func (o *oidcClient) verifyIDToken(ctx context.Context, p *oidcProvider, clientID, nonce, raw string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return o.key(ctx, p, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(clientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("ID token invalide : %v", err)
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if got, _ := claims["nonce"].(string); got != nonce {
+		return nil, errors.New("ID token invalide : nonce inattendu")
+	}
+	return claims, nil
+}
+
+// pkceChallenge renvoie le code_challenge S256 associé au code_verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// claimStrings lit un claim texte ou liste de textes (ex: "groups").
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Générateur PDF minimal en pur Go (PDF 1.4) : texte Helvetica en WinAnsiEncoding,
+// rectangles et traits vectoriels. Suffisant pour des rapports tabulaires et des
+// graphiques en barres, sans dépendance externe.
+//
+// Les coordonnées sont exprimées en points depuis le coin supérieur gauche de la
+// page (A4 : 595 x 842) et converties dans le repère PDF à l'écriture.
+
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+)
+
+type pdfColor struct{ R, G, B float64 }
+
+var (
+	pdfBlack     = pdfColor{0.13, 0.13, 0.13}
+	pdfGrey      = pdfColor{0.45, 0.45, 0.45}
+	pdfLightGrey = pdfColor{0.9, 0.9, 0.9}
+	pdfGreen     = pdfColor{0.06, 0.47, 0.34}
+	pdfWhite     = pdfColor{1, 1, 1}
+)
+
+type pdfDocument struct {
+	Title  string
+	Author string
+	pages  []*pdfPage
+}
+
+type pdfPage struct {
+	content bytes.Buffer
+}
+
+func newPDFDocument(title, author string) *pdfDocument {
+	return &pdfDocument{Title: title, Author: author}
+}
+
+// AddPage ajoute une page A4 vierge et la renvoie.
+func (d *pdfDocument) AddPage() *pdfPage {
+	p := &pdfPage{}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Text écrit une ligne de texte dont la ligne de base est à (x, y).
+func (p *pdfPage) Text(x, y, size float64, bold bool, color pdfColor, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.3f %.3f %.3f rg %.2f %.2f Td (%s) Tj ET\n",
+		font, size, color.R, color.G, color.B, x, pdfPageHeight-y, pdfEscape(s))
+}
+
+// TextRight écrit un texte aligné à droite sur l'abscisse x.
+func (p *pdfPage) TextRight(x, y, size float64, bold bool, color pdfColor, s string) {
+	p.Text(x-pdfTextWidth(s, size), y, size, bold, color, s)
+}
+
+// Rect dessine un rectangle plein dont le coin supérieur gauche est (x, y).
+func (p *pdfPage) Rect(x, y, w, h float64, color pdfColor) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f\n",
+		color.R, color.G, color.B, x, pdfPageHeight-y-h, w, h)
+}
+
+// Line trace un segment.
+func (p *pdfPage) Line(x1, y1, x2, y2, width float64, color pdfColor) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f RG %.2f w %.2f %.2f m %.2f %.2f l S\n",
+		color.R, color.G, color.B, width, x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+// Paragraph écrit un texte sur plusieurs lignes en le coupant à la largeur donnée,
+// et renvoie l'ordonnée sous la dernière ligne écrite.
+func (p *pdfPage) Paragraph(x, y, width, size float64, color pdfColor, s string) float64 {
+	for _, line := range pdfWrap(s, width, size) {
+		p.Text(x, y, size, false, color, line)
+		y += size * 1.4
+	}
+	return y
+}
+
+// Bytes sérialise le document complet.
+func (d *pdfDocument) Bytes() ([]byte, error) {
+	var out bytes.Buffer
+	var offsets []int
+
+	newObj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objets fixes : 1 catalogue, 2 arbre des pages, 3-4 polices, 5 infos.
+	// Viennent ensuite, pour chaque page, l'objet page puis son flux de contenu.
+	firstPage := 6
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	newObj("<< /Type /Catalog /Pages 2 0 R >>")
+	newObj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	newObj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	newObj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	newObj(fmt.Sprintf("<< /Title (%s) /Author (%s) /Producer (carbonv2-api) /CreationDate (D:%s) >>",
+		pdfEscape(d.Title), pdfEscape(d.Author), time.Now().UTC().Format("20060102150405Z")))
+
+	for i, p := range d.pages {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		if _, err := zw.Write(p.content.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+
+		newObj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		newObj(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", z.Len(), z.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return out.Bytes(), nil
+}
+
+// winAnsiExtra couvre les caractères WinAnsi hors Latin-1 utiles en français.
+var winAnsiExtra = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, 'Œ': 0x8C, 'œ': 0x9C,
+}
+
+// pdfEscape convertit une chaîne UTF-8 en chaîne littérale PDF WinAnsi.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(byte(r))
+		case r == '₂':
+			b.WriteByte('2')
+		case r == '\t':
+			b.WriteByte(' ')
+		case r < 0x20:
+			// caractères de contrôle ignorés
+		case r < 0x80 || (r >= 0xA0 && r <= 0xFF):
+			b.WriteByte(byte(r))
+		default:
+			if c, ok := winAnsiExtra[r]; ok {
+				b.WriteByte(c)
+			} else {
+				b.WriteByte('?')
+			}
+		}
+	}
+	return b.String()
+}
+
+// pdfTextWidth estime la largeur d'un texte en Helvetica (chasses approchées).
+func pdfTextWidth(s string, size float64) float64 {
+	var w float64
+	for _, r := range s {
+		switch {
+		case strings.ContainsRune("il.,;:!|'jI ", r):
+			w += 0.28
+		case strings.ContainsRune("ftr()-", r):
+			w += 0.33
+		case strings.ContainsRune("mwMW", r):
+			w += 0.85
+		case r >= 'A' && r <= 'Z':
+			w += 0.68
+		default:
+			w += 0.55
+		}
+	}
+	return w * size
+}
+
+// pdfWrap découpe un texte en lignes tenant dans la largeur donnée.
+func pdfWrap(s string, width, size float64) []string {
+	var lines []string
+	for _, para := range strings.Split(s, "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := words[0]
+		for _, w := range words[1:] {
+			if pdfTextWidth(line+" "+w, size) > width {
+				lines = append(lines, line)
+				line = w
+				continue
+			}
+			line += " " + w
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
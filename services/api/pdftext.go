@@ -0,0 +1,638 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Extraction du texte d'un PDF en pur Go, suffisante pour les factures générées
+// numériquement : flux FlateDecode, flux d'objets (PDF 1.5), opérateurs de texte
+// Tj/TJ/'/" et tables ToUnicode des polices. Les PDF scannés (images) ne
+// contiennent pas de texte et renvoient une chaîne vide.
+
+// pdfRawObject est un objet indirect : son dictionnaire et, le cas échéant, son flux décodé.
+type pdfRawObject struct {
+	Num    int
+	Dict   string
+	Stream []byte
+}
+
+var (
+	pdfObjHeader   = regexp.MustCompile(`(\d+)\s+\d+\s+obj\b`)
+	pdfFontDict    = regexp.MustCompile(`/Font\s*<<([^>]*)>>`)
+	pdfFontRef     = regexp.MustCompile(`/([^\s/<>\[\]()]+)\s+(\d+)\s+\d+\s+R`)
+	pdfToUnicode   = regexp.MustCompile(`/ToUnicode\s+(\d+)\s+\d+\s+R`)
+	pdfDirectLen   = regexp.MustCompile(`/Length\s+(\d+)(?:\s*[/>])`)
+	pdfIntPattern  = regexp.MustCompile(`/(N|First)\s+(\d+)`)
+	pdfCMapHex     = regexp.MustCompile(`<([0-9A-Fa-f]*)>`)
+	errPDFNoObject = errors.New("aucun objet PDF trouvé")
+)
+
+// Bornes de décompression : un flux FlateDecode de quelques Ko peut se
+// décompresser en plusieurs Go. Un flux qui dépasse sa borne est ignoré ; une
+// fois le budget du document épuisé, les flux suivants ne sont plus décodés.
+const (
+	pdfMaxStreamBytes  = 8 * mib  // par flux décodé
+	pdfMaxDecodedBytes = 32 * mib // pour l'ensemble du document
+)
+
+// pdfSkippedStreams repère les flux qui ne contiennent pas de texte de page
+// (images, XObjects, polices embarquées, tables de références) : ils ne sont
+// pas décompressés.
+var pdfSkippedStreams = regexp.MustCompile(`/Subtype\s*/(?:Image|Form|Type1C)\b|/Type\s*/(?:XObject|XRef)\b|/Length[123]\b|/FontFile`)
+
+// extractPDFText renvoie le texte du document, une ligne par ligne de texte du PDF.
+func extractPDFText(data []byte) (string, error) {
+	if !bytes.HasPrefix(bytes.TrimLeft(data, "\x00\t\r\n "), []byte("%PDF")) {
+		return "", errors.New("le fichier n'est pas un PDF")
+	}
+	objects := parsePDFObjects(data)
+	if len(objects) == 0 {
+		return "", errPDFNoObject
+	}
+
+	// Polices : nom de ressource -> table ToUnicode.
+	cmaps := make(map[string]map[string]string)
+	fontObjs := make(map[string]int)
+	for _, o := range objects {
+		for _, fd := range pdfFontDict.FindAllStringSubmatch(o.Dict, -1) {
+			for _, ref := range pdfFontRef.FindAllStringSubmatch(fd[1], -1) {
+				n, _ := strconv.Atoi(ref[2])
+				fontObjs[ref[1]] = n
+			}
+		}
+	}
+	for name, n := range fontObjs {
+		font, ok := objects[n]
+		if !ok {
+			continue
+		}
+		m := pdfToUnicode.FindStringSubmatch(font.Dict)
+		if m == nil {
+			continue
+		}
+		cn, _ := strconv.Atoi(m[1])
+		if cm, ok := objects[cn]; ok && cm.Stream != nil {
+			cmaps[name] = parseToUnicodeCMap(string(cm.Stream))
+		}
+	}
+
+	nums := make([]int, 0, len(objects))
+	for n := range objects {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+
+	var out strings.Builder
+	for _, n := range nums {
+		o := objects[n]
+		if o.Stream == nil || !isPDFContentStream(o) {
+			continue
+		}
+		out.WriteString(pdfContentText(o.Stream, cmaps))
+		out.WriteByte('\n')
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// isPDFContentStream écarte les flux qui ne décrivent pas le contenu d'une page
+// (polices, images, tables de correspondance, flux d'objets).
+func isPDFContentStream(o *pdfRawObject) bool {
+	if pdfSkippedStreams.MatchString(o.Dict) || isPDFObjectStream(o.Dict) {
+		return false
+	}
+	return bytes.Contains(o.Stream, []byte("BT")) && !bytes.Contains(o.Stream, []byte("begincmap"))
+}
+
+func isPDFObjectStream(dict string) bool {
+	return strings.Contains(dict, "/Type /ObjStm") || strings.Contains(dict, "/Type/ObjStm")
+}
+
+// parsePDFObjects lit les objets indirects du fichier, y compris ceux contenus
+// dans des flux d'objets compressés.
+func parsePDFObjects(data []byte) map[int]*pdfRawObject {
+	objects := make(map[int]*pdfRawObject)
+	budget := pdfMaxDecodedBytes
+	locs := pdfObjHeader.FindAllSubmatchIndex(data, -1)
+	for i, loc := range locs {
+		num, _ := strconv.Atoi(string(data[loc[2]:loc[3]]))
+		end := len(data)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		body := data[loc[1]:end]
+		if k := bytes.Index(body, []byte("endobj")); k >= 0 {
+			body = body[:k]
+		}
+
+		o := &pdfRawObject{Num: num}
+		si := bytes.Index(body, []byte("stream"))
+		if si < 0 || bytes.HasPrefix(body[si:], []byte("streams")) {
+			o.Dict = string(body)
+			objects[num] = o
+			continue
+		}
+		o.Dict = string(body[:si])
+		raw := body[si+len("stream"):]
+		raw = bytes.TrimPrefix(raw, []byte("\r"))
+		raw = bytes.TrimPrefix(raw, []byte("\n"))
+		if m := pdfDirectLen.FindStringSubmatch(o.Dict); m != nil {
+			if l, err := strconv.Atoi(m[1]); err == nil && l <= len(raw) {
+				raw = raw[:l]
+			}
+		} else if k := bytes.LastIndex(raw, []byte("endstream")); k >= 0 {
+			raw = bytes.TrimRight(raw[:k], "\r\n")
+		}
+		if !pdfSkippedStreams.MatchString(o.Dict) {
+			o.Stream = decodePDFStream(o.Dict, raw, &budget)
+		}
+		objects[num] = o
+
+		if o.Stream != nil && isPDFObjectStream(o.Dict) {
+			for n, inner := range parsePDFObjectStream(o.Dict, o.Stream) {
+				if _, exists := objects[n]; !exists {
+					objects[n] = inner
+				}
+			}
+		}
+	}
+	return objects
+}
+
+// decodePDFStream décompresse un flux FlateDecode ; les autres filtres (images
+// JPEG, etc.) ne contiennent pas de texte et sont ignorés. La taille décodée est
+// bornée par pdfMaxStreamBytes et imputée sur budget, le reste autorisé pour le
+// document ; un flux qui dépasse la borne est ignoré.
+func decodePDFStream(dict string, raw []byte, budget *int) []byte {
+	if !strings.Contains(dict, "/Filter") {
+		return raw
+	}
+	if !strings.Contains(dict, "FlateDecode") {
+		return nil
+	}
+	limit := pdfMaxStreamBytes
+	if *budget < limit {
+		limit = *budget
+	}
+	if limit <= 0 {
+		return nil
+	}
+	out := inflatePDFStream(raw, limit)
+	if len(out) > limit {
+		*budget -= limit
+		return nil
+	}
+	*budget -= len(out)
+	return out
+}
+
+// inflatePDFStream lit au plus limit+1 octets décompressés : un résultat plus
+// long que limit signale un flux trop volumineux.
+func inflatePDFStream(raw []byte, limit int) []byte {
+	if zr, err := zlib.NewReader(bytes.NewReader(raw)); err == nil {
+		if out, err := io.ReadAll(io.LimitReader(zr, int64(limit)+1)); err == nil || len(out) > 0 {
+			return out
+		}
+	}
+	// Certains générateurs omettent l'en-tête zlib.
+	out, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(raw)), int64(limit)+1))
+	if err != nil && len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// parsePDFObjectStream extrait les objets d'un flux /ObjStm.
+func parsePDFObjectStream(dict string, stream []byte) map[int]*pdfRawObject {
+	var n, first int
+	for _, m := range pdfIntPattern.FindAllStringSubmatch(dict, -1) {
+		v, _ := strconv.Atoi(m[2])
+		if m[1] == "N" {
+			n = v
+		} else {
+			first = v
+		}
+	}
+	if n <= 0 || first <= 0 || first > len(stream) {
+		return nil
+	}
+	header := strings.Fields(string(stream[:first]))
+	out := make(map[int]*pdfRawObject, n)
+	for i := 0; i+1 < len(header) && i/2 < n; i += 2 {
+		num, err1 := strconv.Atoi(header[i])
+		off, err2 := strconv.Atoi(header[i+1])
+		if err1 != nil || err2 != nil || first+off > len(stream) {
+			continue
+		}
+		end := len(stream)
+		if i+3 < len(header) {
+			if next, err := strconv.Atoi(header[i+3]); err == nil && first+next <= len(stream) && next >= off {
+				end = first + next
+			}
+		}
+		out[num] = &pdfRawObject{Num: num, Dict: string(stream[first+off : end])}
+	}
+	return out
+}
+
+// parseToUnicodeCMap lit les sections bfchar et bfrange d'une table ToUnicode.
+// Les clés sont les codes en hexadécimal majuscule.
+func parseToUnicodeCMap(s string) map[string]string {
+	cm := make(map[string]string)
+	for _, block := range pdfSections(s, "beginbfchar", "endbfchar") {
+		hex := pdfCMapHex.FindAllStringSubmatch(block, -1)
+		for i := 0; i+1 < len(hex); i += 2 {
+			cm[strings.ToUpper(hex[i][1])] = utf16HexToString(hex[i+1][1])
+		}
+	}
+	for _, block := range pdfSections(s, "beginbfrange", "endbfrange") {
+		for _, line := range strings.Split(block, "\n") {
+			hex := pdfCMapHex.FindAllStringSubmatch(line, -1)
+			if len(hex) < 3 {
+				continue
+			}
+			lo, err1 := strconv.ParseUint(hex[0][1], 16, 32)
+			hi, err2 := strconv.ParseUint(hex[1][1], 16, 32)
+			if err1 != nil || err2 != nil || hi < lo || hi-lo > 0xFFFF {
+				continue
+			}
+			width := len(hex[0][1])
+			if strings.Contains(line, "[") {
+				// Destinations listées une à une.
+				for i, h := range hex[2:] {
+					if lo+uint64(i) > hi {
+						break
+					}
+					cm[hexCode(lo+uint64(i), width)] = utf16HexToString(h[1])
+				}
+				continue
+			}
+			dst := []rune(utf16HexToString(hex[2][1]))
+			if len(dst) == 0 {
+				continue
+			}
+			for code := lo; code <= hi; code++ {
+				r := append([]rune{}, dst...)
+				r[len(r)-1] += rune(code - lo)
+				cm[hexCode(code, width)] = string(r)
+			}
+		}
+	}
+	return cm
+}
+
+func pdfSections(s, begin, end string) []string {
+	var out []string
+	for {
+		i := strings.Index(s, begin)
+		if i < 0 {
+			return out
+		}
+		s = s[i+len(begin):]
+		j := strings.Index(s, end)
+		if j < 0 {
+			return append(out, s)
+		}
+		out = append(out, s[:j])
+		s = s[j+len(end):]
+	}
+}
+
+func hexCode(v uint64, width int) string {
+	h := strings.ToUpper(strconv.FormatUint(v, 16))
+	for len(h) < width {
+		h = "0" + h
+	}
+	return h
+}
+
+func utf16HexToString(h string) string {
+	b := decodeHex(h)
+	if len(b)%2 == 1 {
+		b = append(b, 0)
+	}
+	u := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u = append(u, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return string(utf16.Decode(u))
+}
+
+func decodeHex(h string) []byte {
+	h = strings.Map(func(r rune) rune {
+		if strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return r
+		}
+		return -1
+	}, h)
+	if len(h)%2 == 1 {
+		h += "0"
+	}
+	out := make([]byte, len(h)/2)
+	for i := range out {
+		v, _ := strconv.ParseUint(h[2*i:2*i+2], 16, 8)
+		out[i] = byte(v)
+	}
+	return out
+}
+
+// pdfToken est un élément lexical d'un flux de contenu.
+type pdfToken struct {
+	kind  byte // 's' chaîne, 'n' nombre, '/' nom, '[' tableau, 'o' opérateur
+	str   []byte
+	num   float64
+	array []pdfToken
+}
+
+// pdfContentText interprète les opérateurs de texte d'un flux de contenu.
+func pdfContentText(content []byte, cmaps map[string]map[string]string) string {
+	var out strings.Builder
+	var operands []pdfToken
+	font := ""
+
+	newline := func() {
+		s := out.String()
+		if len(s) > 0 && s[len(s)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+	}
+	show := func(t pdfToken) {
+		out.WriteString(decodePDFString(t.str, cmaps[font]))
+	}
+
+	lex := pdfLexer{data: content}
+	for {
+		t, ok := lex.next()
+		if !ok {
+			break
+		}
+		if t.kind != 'o' {
+			operands = append(operands, t)
+			continue
+		}
+		op := string(t.str)
+		switch op {
+		case "Tf":
+			for _, o := range operands {
+				if o.kind == '/' {
+					font = string(o.str)
+				}
+			}
+		case "Tj":
+			if len(operands) > 0 && operands[len(operands)-1].kind == 's' {
+				show(operands[len(operands)-1])
+			}
+		case "'", "\"":
+			newline()
+			if len(operands) > 0 && operands[len(operands)-1].kind == 's' {
+				show(operands[len(operands)-1])
+			}
+		case "TJ":
+			if len(operands) > 0 && operands[len(operands)-1].kind == '[' {
+				for _, item := range operands[len(operands)-1].array {
+					switch {
+					case item.kind == 's':
+						show(item)
+					case item.kind == 'n' && item.num < -200:
+						// Un décalage important entre deux fragments correspond à une espace.
+						out.WriteByte(' ')
+					}
+				}
+			}
+		case "Td", "TD":
+			if len(operands) >= 2 && operands[len(operands)-1].num != 0 {
+				newline()
+			} else {
+				out.WriteByte(' ')
+			}
+		case "T*", "ET", "Tm":
+			newline()
+		}
+		operands = operands[:0]
+	}
+	return out.String()
+}
+
+// decodePDFString convertit une chaîne PDF en texte, via la table ToUnicode de la
+// police si elle existe, sinon en WinAnsi.
+func decodePDFString(b []byte, cmap map[string]string) string {
+	if len(cmap) > 0 {
+		width := 2
+		for k := range cmap {
+			width = len(k) / 2
+			break
+		}
+		var sb strings.Builder
+		for i := 0; i+width <= len(b); i += width {
+			code := strings.ToUpper(hexCode(bytesToUint(b[i:i+width]), 2*width))
+			if s, ok := cmap[code]; ok {
+				sb.WriteString(s)
+			}
+		}
+		return sb.String()
+	}
+
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c >= 0x20 && c < 0x80, c >= 0xA0:
+			sb.WriteRune(rune(c))
+		case c >= 0x80:
+			for r, v := range winAnsiExtra {
+				if v == c {
+					sb.WriteRune(r)
+					break
+				}
+			}
+		}
+	}
+	return sb.String()
+}
+
+func bytesToUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// pdfLexer découpe un flux de contenu en jetons.
+type pdfLexer struct {
+	data []byte
+	pos  int
+}
+
+func (l *pdfLexer) next() (pdfToken, bool) {
+	d := l.data
+	for l.pos < len(d) {
+		c := d[l.pos]
+		switch {
+		case c == '%':
+			for l.pos < len(d) && d[l.pos] != '\n' && d[l.pos] != '\r' {
+				l.pos++
+			}
+		case c == ' ' || c == '\n' || c == '\r' || c == '\t' || c == '\f' || c == 0:
+			l.pos++
+		case c == '(':
+			return pdfToken{kind: 's', str: l.literalString()}, true
+		case c == '<' && l.pos+1 < len(d) && d[l.pos+1] == '<':
+			// Dictionnaire en ligne (ex: BDC) : ignoré jusqu'à la fermeture.
+			l.skipDict()
+		case c == '<':
+			end := bytes.IndexByte(d[l.pos:], '>')
+			if end < 0 {
+				l.pos = len(d)
+				return pdfToken{}, false
+			}
+			s := decodeHex(string(d[l.pos+1 : l.pos+end]))
+			l.pos += end + 1
+			return pdfToken{kind: 's', str: s}, true
+		case c == '[':
+			l.pos++
+			var arr []pdfToken
+			for {
+				l.skipSpace()
+				if l.pos >= len(d) {
+					break
+				}
+				if d[l.pos] == ']' {
+					l.pos++
+					break
+				}
+				t, ok := l.next()
+				if !ok {
+					break
+				}
+				arr = append(arr, t)
+			}
+			return pdfToken{kind: '[', array: arr}, true
+		case c == ']' || c == '>' || c == '{' || c == '}' || c == ')':
+			l.pos++
+		case c == '/':
+			start := l.pos + 1
+			l.pos++
+			for l.pos < len(d) && !isPDFDelimiter(d[l.pos]) {
+				l.pos++
+			}
+			return pdfToken{kind: '/', str: d[start:l.pos]}, true
+		default:
+			start := l.pos
+			for l.pos < len(d) && !isPDFDelimiter(d[l.pos]) {
+				l.pos++
+			}
+			if l.pos == start {
+				l.pos++
+				continue
+			}
+			word := d[start:l.pos]
+			if n, err := strconv.ParseFloat(string(word), 64); err == nil {
+				return pdfToken{kind: 'n', num: n}, true
+			}
+			if string(word) == "BI" {
+				// Image en ligne : données binaires jusqu'à EI.
+				if k := bytes.Index(d[l.pos:], []byte("EI")); k >= 0 {
+					l.pos += k + 2
+				}
+				continue
+			}
+			return pdfToken{kind: 'o', str: word}, true
+		}
+	}
+	return pdfToken{}, false
+}
+
+func (l *pdfLexer) skipSpace() {
+	for l.pos < len(l.data) && strings.IndexByte(" \n\r\t\f\x00", l.data[l.pos]) >= 0 {
+		l.pos++
+	}
+}
+
+func (l *pdfLexer) skipDict() {
+	depth := 0
+	for l.pos+1 < len(l.data) {
+		switch {
+		case l.data[l.pos] == '<' && l.data[l.pos+1] == '<':
+			depth++
+			l.pos += 2
+		case l.data[l.pos] == '>' && l.data[l.pos+1] == '>':
+			depth--
+			l.pos += 2
+			if depth == 0 {
+				return
+			}
+		default:
+			l.pos++
+		}
+	}
+	l.pos = len(l.data)
+}
+
+// literalString lit une chaîne (...) avec parenthèses imbriquées et échappements.
+func (l *pdfLexer) literalString() []byte {
+	d := l.data
+	l.pos++ // '('
+	var out []byte
+	depth := 1
+	for l.pos < len(d) {
+		c := d[l.pos]
+		l.pos++
+		switch c {
+		case '\\':
+			if l.pos >= len(d) {
+				return out
+			}
+			e := d[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				out = append(out, '\n')
+			case 'r':
+				out = append(out, '\r')
+			case 't':
+				out = append(out, '\t')
+			case 'b', 'f':
+			case '\r', '\n':
+				// continuation de ligne
+				if e == '\r' && l.pos < len(d) && d[l.pos] == '\n' {
+					l.pos++
+				}
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for k := 0; k < 2 && l.pos < len(d) && d[l.pos] >= '0' && d[l.pos] <= '7'; k++ {
+						v = v*8 + int(d[l.pos]-'0')
+						l.pos++
+					}
+					out = append(out, byte(v))
+				} else {
+					out = append(out, e)
+				}
+			}
+		case '(':
+			depth++
+			out = append(out, c)
+		case ')':
+			depth--
+			if depth == 0 {
+				return out
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func isPDFDelimiter(c byte) bool {
+	return strings.IndexByte(" \n\r\t\f\x00()<>[]{}/%", c) >= 0
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Limitation de débit : compteurs par fenêtre fixe (par IP, par utilisateur
+// ou clé d'API, par tenant), partagés entre instances via Redis ou locaux à
+// l'instance en mémoire. Les réponses portent les en-têtes RateLimit-* ; un
+// dépassement renvoie 429 avec Retry-After. Une panne du store laisse passer
+// les requêtes (journalisée) plutôt que de bloquer l'API.
+
+// RateLimitStore conserve des compteurs expirant automatiquement.
+type RateLimitStore interface {
+	// Incr incrémente le compteur (créé pour la durée window s'il n'existe pas)
+	// et renvoie sa valeur et le temps restant avant son expiration.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error)
+	// Get renvoie la valeur du compteur (0 s'il n'existe pas) et son temps restant.
+	Get(ctx context.Context, key string) (int64, time.Duration, error)
+	// Delete supprime le compteur.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewRateLimitStore renvoie le store configuré : "memory" (par défaut, propre
+// à l'instance) ou "redis" (partagé, REDIS_URL).
+func NewRateLimitStore(cfg Config) (RateLimitStore, error) {
+	switch cfg.RateLimitStore {
+	case "", "memory":
+		return NewMemoryRateLimitStore(), nil
+	case "redis":
+		return NewRedisStore(cfg.RedisURL)
+	default:
+		return nil, fmt.Errorf("RATE_LIMIT_STORE inconnu : %q (attendu : memory ou redis)", cfg.RateLimitStore)
+	}
+}
+
+// MemoryRateLimitStore garde les compteurs en mémoire ; les compteurs expirés
+// sont purgés au plus une fois par minute.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	counters  map[string]*memoryCounter
+	lastSweep time.Time
+}
+
+type memoryCounter struct {
+	count   int64
+	expires time.Time
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{counters: make(map[string]*memoryCounter), lastSweep: time.Now()}
+}
+
+func (s *MemoryRateLimitStore) Incr(_ context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, ctr := range s.counters {
+			if !now.Before(ctr.expires) {
+				delete(s.counters, k)
+			}
+		}
+		s.lastSweep = now
+	}
+	ctr, ok := s.counters[key]
+	if !ok || !now.Before(ctr.expires) {
+		ctr = &memoryCounter{expires: now.Add(window)}
+		s.counters[key] = ctr
+	}
+	ctr.count++
+	return ctr.count, ctr.expires.Sub(now), nil
+}
+
+func (s *MemoryRateLimitStore) Get(_ context.Context, key string) (int64, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ctr, ok := s.counters[key]
+	if !ok || !now.Before(ctr.expires) {
+		return 0, 0, nil
+	}
+	return ctr.count, ctr.expires.Sub(now), nil
+}
+
+func (s *MemoryRateLimitStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.counters, key)
+	return nil
+}
+
+// rateLimit est une règle : au plus Limit requêtes par Window et par identité.
+type rateLimit struct {
+	Name   string // préfixe des compteurs, ex: "auth-ip"
+	Limit  int64
+	Window time.Duration
+}
+
+// Règles appliquées aux routes (voir main.go).
+var (
+	// Routes d'authentification qui vérifient un secret ou envoient un email.
+	authIPLimit = rateLimit{Name: "auth-ip", Limit: 60, Window: time.Minute}
+	// Routes d'IA : chaque appel consomme le quota Mistral.
+	mlUserLimit   = rateLimit{Name: "ml-user", Limit: 20, Window: time.Minute}
+	mlTenantLimit = rateLimit{Name: "ml-tenant", Limit: 300, Window: time.Hour}
+	// Routes des tenants (utilisateurs et clés d'API).
+	apiUserLimit   = rateLimit{Name: "api-user", Limit: 300, Window: time.Minute}
+	apiTenantLimit = rateLimit{Name: "api-tenant", Limit: 1200, Window: time.Minute}
+)
+
+// rateLimitByIP identifie le client par son adresse IP.
+func rateLimitByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// rateLimitByPrincipal identifie l'utilisateur ou la clé d'API authentifié.
+func rateLimitByPrincipal(c *gin.Context) string {
+	claimsVal, ok := c.Get("user")
+	if !ok {
+		return ""
+	}
+	claims, _ := claimsVal.(jwt.MapClaims)
+	if id, ok := claims["api_key_id"]; ok {
+		return "key:" + toStringID(id)
+	}
+	if userID := userIDFromRequest(c); userID != nil {
+		return "user:" + strconv.FormatInt(*userID, 10)
+	}
+	return ""
+}
+
+// rateLimitByTenant identifie le tenant du token.
+func rateLimitByTenant(c *gin.Context) string {
+	claimsVal, ok := c.Get("user")
+	if !ok {
+		return ""
+	}
+	claims, _ := claimsVal.(jwt.MapClaims)
+	if tenantID, ok := claims["tenant_id"]; ok {
+		return toStringID(tenantID)
+	}
+	return ""
+}
+
+// RateLimit applique la règle à l'identité renvoyée par identify (ignorée si
+// vide). Quand plusieurs règles s'appliquent, les en-têtes RateLimit-*
+// décrivent la plus contraignante.
+func RateLimit(store RateLimitStore, rule rateLimit, identify func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := identify(c)
+		if id == "" {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second)
+		defer cancel()
+		count, resetIn, err := store.Incr(ctx, "rl:"+rule.Name+":"+id, rule.Window)
+		if err != nil {
+			log.Printf("limitation de débit %s indisponible : %v", rule.Name, err)
+			c.Next()
+			return
+		}
+
+		remaining := rule.Limit - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		reset := int64(math.Ceil(resetIn.Seconds()))
+		h := c.Writer.Header()
+		if prev, err := strconv.ParseInt(h.Get("RateLimit-Remaining"), 10, 64); err != nil || remaining <= prev {
+			h.Set("RateLimit-Limit", strconv.FormatInt(rule.Limit, 10))
+			h.Set("RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+			h.Set("RateLimit-Reset", strconv.FormatInt(reset, 10))
+			h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit, int64(rule.Window.Seconds())))
+		}
+
+		if count > rule.Limit {
+			h.Set("Retry-After", strconv.FormatInt(reset, 10))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+				"error":   "trop de requêtes, réessayez plus tard",
+				"details": gin.H{"limit": rule.Limit, "window_seconds": int64(rule.Window.Seconds()), "retry_after": reset},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// LoginLockout bloque progressivement les tentatives de connexion après des
+// échecs répétés : à partir du seuil d'échecs consécutifs (5 par défaut),
+// chaque nouvel échec bloque l'identité pendant une durée qui double (1 min,
+// 2 min, 4 min..., au plus 1 h). Une connexion réussie remet les compteurs à zéro.
+type LoginLockout struct {
+	store     RateLimitStore
+	threshold int64
+	base      time.Duration
+	max       time.Duration
+	memory    time.Duration // durée pendant laquelle les échecs sont comptés
+}
+
+func NewLoginLockout(store RateLimitStore) *LoginLockout {
+	return &LoginLockout{store: store, threshold: 5, base: time.Minute, max: time.Hour, memory: 24 * time.Hour}
+}
+
+// NewAccountLockout compte les échecs sur un compte toutes adresses IP
+// confondues, avec un seuil plus élevé : il arrête une attaque répartie sur de
+// nombreuses adresses sans qu'un tiers bloque trop facilement le compte.
+func NewAccountLockout(store RateLimitStore) *LoginLockout {
+	l := NewLoginLockout(store)
+	l.threshold = 20
+	return l
+}
+
+// loginLockoutKey identifie les tentatives sur un compte depuis une adresse :
+// un tiers ne peut pas bloquer un compte depuis une autre adresse IP.
+func loginLockoutKey(email, ip string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(email)) + "|" + ip
+}
+
+// accountLockoutKey identifie les tentatives sur un compte, quelle que soit l'adresse.
+func accountLockoutKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// Locked renvoie le temps de blocage restant (0 si l'identité n'est pas bloquée).
+func (l *LoginLockout) Locked(ctx context.Context, key string) time.Duration {
+	n, ttl, err := l.store.Get(ctx, "lock:"+key)
+	if err != nil {
+		log.Printf("verrouillage des connexions indisponible : %v", err)
+		return 0
+	}
+	if n == 0 {
+		return 0
+	}
+	return ttl
+}
+
+// Failed enregistre un échec et renvoie la durée du blocage qu'il déclenche (0 sinon).
+func (l *LoginLockout) Failed(ctx context.Context, key string) time.Duration {
+	n, _, err := l.store.Incr(ctx, "fail:"+key, l.memory)
+	if err != nil {
+		log.Printf("verrouillage des connexions indisponible : %v", err)
+		return 0
+	}
+	if n < l.threshold {
+		return 0
+	}
+	lock := l.max
+	if shift := n - l.threshold; shift < 6 {
+		lock = l.base << shift
+	}
+	if lock > l.max {
+		lock = l.max
+	}
+	if _, _, err := l.store.Incr(ctx, "lock:"+key, lock); err != nil {
+		log.Printf("verrouillage des connexions indisponible : %v", err)
+		return 0
+	}
+	return lock
+}
+
+// Succeeded remet à zéro les échecs de l'identité.
+func (l *LoginLockout) Succeeded(ctx context.Context, key string) {
+	if err := l.store.Delete(ctx, "fail:"+key); err != nil {
+		log.Printf("verrouillage des connexions indisponible : %v", err)
+	}
+	if err := l.store.Delete(ctx, "lock:"+key); err != nil {
+		log.Printf("verrouillage des connexions indisponible : %v", err)
+	}
+}
+
+// respondLocked répond 429 pendant un blocage des connexions.
+func respondLocked(c *gin.Context, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":   fmt.Sprintf("trop de tentatives échouées, réessayez dans %d secondes", seconds),
+		"details": gin.H{"retry_after": seconds},
+	})
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Contrôle d'accès par rôle : chaque route du tenant exige une permission,
+// accordée ou non au rôle porté par le token.
+
+// Permission est un droit élémentaire exigé par une route.
+type Permission string
+
+const (
+	PermEntriesRead    Permission = "entries:read"    // entrées, émissions, sites, objectifs, règles (lecture)
+	PermEntriesWrite   Permission = "entries:write"   // saisie, import, calcul, catégorisation, justificatifs
+	PermDocumentsRead  Permission = "documents:read"  // liste, téléchargement, versions, analyse, intégrité
+	PermDocumentsWrite Permission = "documents:write" // upload, remplacement, suppression, confirmation
+	PermReportsRead    Permission = "reports:read"    // exports réglementaires, trajectoires, MACC
+	PermAuditExport    Permission = "audit:export"    // export des pièces justificatives
+	PermPlanWrite      Permission = "plan:write"      // objectifs, actions, dénominateurs
+	PermSitesManage    Permission = "sites:manage"    // structure organisationnelle
+	PermSettingsManage Permission = "settings:manage" // matérialité, règles de catégorisation
+	PermMembersManage  Permission = "members:manage"  // membres et invitations
+)
+
+// Rôles d'un membre du tenant. Le propriétaire est le seul à pouvoir nommer ou
+// retirer un autre propriétaire.
+const (
+	RoleOwner       = "owner"
+	RoleAdmin       = "admin"
+	RoleContributor = "contributor"
+	RoleViewer      = "viewer"
+	RoleAuditor     = "auditor"
+)
+
+// memberRoles liste les rôles attribuables à un membre.
+var memberRoles = []string{RoleOwner, RoleAdmin, RoleContributor, RoleViewer, RoleAuditor}
+
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermEntriesRead, PermEntriesWrite, PermDocumentsRead, PermDocumentsWrite, PermReportsRead,
+		PermAuditExport, PermPlanWrite, PermSitesManage, PermSettingsManage, PermMembersManage,
+	},
+	RoleAdmin: {
+		PermEntriesRead, PermEntriesWrite, PermDocumentsRead, PermDocumentsWrite, PermReportsRead,
+		PermAuditExport, PermPlanWrite, PermSitesManage, PermSettingsManage, PermMembersManage,
+	},
+	RoleContributor: {
+		PermEntriesRead, PermEntriesWrite, PermDocumentsRead, PermDocumentsWrite, PermReportsRead,
+		PermPlanWrite,
+	},
+	RoleViewer: {
+		PermEntriesRead, PermReportsRead,
+	},
+	RoleAuditor: {
+		PermEntriesRead, PermDocumentsRead, PermReportsRead, PermAuditExport,
+	},
+}
+
+// normalizeRole ramène les anciens rôles sur la matrice actuelle : "user"
+// (rôle par défaut avant l'introduction des permissions) vaut contributeur.
+func normalizeRole(role string) string {
+	if role == "user" {
+		return RoleContributor
+	}
+	return role
+}
+
+func validMemberRole(role string) bool {
+	return containsString(memberRoles, role)
+}
+
+// roleHasPermission indique si le rôle accorde la permission.
+func roleHasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[normalizeRole(role)] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// roleFromRequest renvoie le rôle porté par le token de la requête.
+func roleFromRequest(c *gin.Context) string {
+	claims, _ := c.MustGet("user").(jwt.MapClaims)
+	role, _ := claims["role"].(string)
+	return normalizeRole(role)
+}
+
+// RequireOwner réserve la route aux propriétaires du tenant. À placer après
+// RequirePermission.
+func RequireOwner() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role := roleFromRequest(c); role != RoleOwner {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "action réservée aux propriétaires du tenant",
+				"details": gin.H{"role": role},
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission refuse la requête (403) si le rôle de l'utilisateur
+// n'accorde pas la permission, ou si le tenant impose la double
+// authentification et que l'utilisateur ne l'a pas activée. Une clé d'API
+// n'est admise que si AllowAPIKey a validé son scope. À placer après
+// AuthMiddleware.
+func RequirePermission(perm Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if isAPIKeyRequest(c) {
+			if _, ok := c.Get("api_key_scope"); !ok {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+					"error":   "route non accessible avec une clé d'API",
+					"details": gin.H{"permission": perm},
+				})
+				return
+			}
+			c.Next()
+			return
+		}
+		claims, _ := c.MustGet("user").(jwt.MapClaims)
+		if enroll, _ := claims["mfa_enrollment_required"].(bool); enroll {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "votre organisation impose la double authentification : activez-la pour continuer",
+				"details": gin.H{"mfa_enrollment_required": true},
+			})
+			return
+		}
+		role := roleFromRequest(c)
+		if !roleHasPermission(role, perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "permission insuffisante pour cette action",
+				"details": gin.H{"permission": perm, "role": role},
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore partage les compteurs de limitation de débit entre les instances
+// de l'API. Le client go-redis gère le pool de connexions, la reconnexion
+// (avec backoff), l'authentification et TLS ; l'incrément et la pose de
+// l'expiration sont atomiques (script Lua).
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore lit une URL de la forme redis://[:motdepasse@]hôte:6379/0
+// (rediss:// pour TLS).
+func NewRedisStore(rawURL string) (*RedisStore, error) {
+	if rawURL == "" {
+		return nil, errors.New("REDIS_URL est requis avec RATE_LIMIT_STORE=redis")
+	}
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("REDIS_URL invalide : %w", err)
+	}
+	// Une limite de débit ne doit pas retarder la requête : délais courts, et
+	// une panne du store laisse passer les requêtes (voir RateLimit).
+	opts.DialTimeout = time.Second
+	opts.ReadTimeout = time.Second
+	opts.WriteTimeout = time.Second
+	opts.PoolTimeout = time.Second
+	return &RedisStore{client: redis.NewClient(opts), prefix: "carbonv2:"}, nil
+}
+
+var redisIncrScript = redis.NewScript(`
+local n = redis.call('INCR', KEYS[1])
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl < 0 then
+  redis.call('PEXPIRE', KEYS[1], ARGV[1])
+  ttl = tonumber(ARGV[1])
+end
+return {n, ttl}`)
+
+var redisGetScript = redis.NewScript(`
+local n = redis.call('GET', KEYS[1])
+if not n then return {0, 0} end
+return {tonumber(n), redis.call('PTTL', KEYS[1])}`)
+
+func (s *RedisStore) Incr(ctx context.Context, key string, window time.Duration) (int64, time.Duration, error) {
+	reply, err := redisIncrScript.Run(ctx, s.client, []string{s.prefix + key}, window.Milliseconds()).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return redisCountAndTTL(reply)
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (int64, time.Duration, error) {
+	reply, err := redisGetScript.Run(ctx, s.client, []string{s.prefix + key}).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	return redisCountAndTTL(reply)
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key string) error {
+	return s.client.Del(ctx, s.prefix+key).Err()
+}
+
+// Close ferme les connexions du pool.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+// redisCountAndTTL lit la réponse {compteur, ttl en ms} des scripts.
+func redisCountAndTTL(reply []int64) (int64, time.Duration, error) {
+	if len(reply) != 2 {
+		return 0, 0, fmt.Errorf("redis : réponse inattendue %v", reply)
+	}
+	ttl := max(reply[1], 0)
+	return reply[0], time.Duration(ttl) * time.Millisecond, nil
+}
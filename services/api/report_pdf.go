@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Rapport Bilan Carbone au format PDF, généré entièrement côté serveur à partir
+// de la base : couverture, synthèse, graphiques vectoriels par scope et par
+// catégorie, analyse IA optionnelle et annexe méthodologique.
+
+const (
+	pdfMarginX = 50.0
+	pdfMaxY    = 790.0
+)
+
+var scopeColors = map[string]pdfColor{
+	"1": {0.04, 0.33, 0.24},
+	"2": {0.13, 0.55, 0.4},
+	"3": {0.45, 0.75, 0.6},
+}
+
+// pdfFlow gère l'enchaînement vertical du contenu et les sauts de page.
+type pdfFlow struct {
+	doc    *pdfDocument
+	page   *pdfPage
+	y      float64
+	footer string
+}
+
+func (f *pdfFlow) newPage() {
+	f.page = f.doc.AddPage()
+	f.y = 60
+	f.page.Line(pdfMarginX, 815, pdfPageWidth-pdfMarginX, 815, 0.5, pdfLightGrey)
+	f.page.Text(pdfMarginX, 828, 8, false, pdfGrey, f.footer)
+	f.page.TextRight(pdfPageWidth-pdfMarginX, 828, 8, false, pdfGrey, fmt.Sprintf("Page %d", len(f.doc.pages)))
+}
+
+// ensure démarre une nouvelle page si la hauteur demandée ne tient plus.
+func (f *pdfFlow) ensure(h float64) {
+	if f.page == nil || f.y+h > pdfMaxY {
+		f.newPage()
+	}
+}
+
+func (f *pdfFlow) heading(s string) {
+	f.ensure(60)
+	f.y += 10
+	f.page.Text(pdfMarginX, f.y, 16, true, pdfGreen, s)
+	f.y += 8
+	f.page.Line(pdfMarginX, f.y, pdfPageWidth-pdfMarginX, f.y, 1, pdfGreen)
+	f.y += 22
+}
+
+func (f *pdfFlow) paragraph(s string, size float64) {
+	for _, line := range pdfWrap(s, pdfPageWidth-2*pdfMarginX, size) {
+		f.ensure(size * 1.4)
+		f.page.Text(pdfMarginX, f.y, size, false, pdfBlack, line)
+		f.y += size * 1.4
+	}
+	f.y += 6
+}
+
+// table écrit un tableau simple ; widths donne la largeur de chaque colonne,
+// les colonnes numériques (align[i] = true) sont alignées à droite.
+func (f *pdfFlow) table(header []string, rows [][]string, widths []float64, align []bool) {
+	const size, rowH = 8.5, 15.0
+	drawRow := func(cells []string, bold bool) {
+		x := pdfMarginX
+		for i, cell := range cells {
+			if align[i] {
+				f.page.TextRight(x+widths[i]-4, f.y, size, bold, pdfBlack, cell)
+			} else {
+				f.page.Text(x+2, f.y, size, bold, pdfBlack, truncateToWidth(cell, widths[i]-6, size))
+			}
+			x += widths[i]
+		}
+	}
+	f.ensure(2 * rowH)
+	f.page.Rect(pdfMarginX, f.y-11, pdfPageWidth-2*pdfMarginX, rowH, pdfLightGrey)
+	drawRow(header, true)
+	f.y += rowH
+	for _, r := range rows {
+		if f.y+rowH > pdfMaxY {
+			f.newPage()
+			f.page.Rect(pdfMarginX, f.y-11, pdfPageWidth-2*pdfMarginX, rowH, pdfLightGrey)
+			drawRow(header, true)
+			f.y += rowH
+		}
+		drawRow(r, false)
+		f.y += rowH
+	}
+	f.y += 10
+}
+
+type chartBar struct {
+	Label string
+	Value float64
+	Color pdfColor
+}
+
+// barChart dessine un graphique en barres horizontales avec valeurs et parts.
+func (f *pdfFlow) barChart(title string, bars []chartBar) {
+	const labelW, barH, gap = 170.0, 14.0, 8.0
+	maxW := pdfPageWidth - 2*pdfMarginX - labelW - 90
+
+	f.ensure(30 + float64(len(bars))*(barH+gap))
+	f.page.Text(pdfMarginX, f.y, 11, true, pdfBlack, title)
+	f.y += 16
+
+	var maxV, total float64
+	for _, b := range bars {
+		maxV = math.Max(maxV, b.Value)
+		total += b.Value
+	}
+	axisX := pdfMarginX + labelW
+	top := f.y
+	for _, b := range bars {
+		f.page.Text(pdfMarginX, f.y+10, 8.5, false, pdfBlack, truncateToWidth(b.Label, labelW-8, 8.5))
+		w := 0.0
+		if maxV > 0 {
+			w = b.Value / maxV * maxW
+		}
+		f.page.Rect(axisX, f.y, math.Max(w, 0.5), barH, b.Color)
+		share := 0.0
+		if total > 0 {
+			share = b.Value / total * 100
+		}
+		f.page.Text(axisX+w+6, f.y+10, 8.5, false, pdfGrey, fmt.Sprintf("%s t (%s %%)", frNumber(b.Value, 1), frNumber(share, 0)))
+		f.y += barH + gap
+	}
+	f.page.Line(axisX, top-4, axisX, f.y-gap+4, 0.8, pdfGrey)
+	f.y += 12
+}
+
+// GET /api/tenants/:tenantId/reports/bilan.pdf?year=2024&narrative=true
+// Rapport Bilan Carbone prêt à être remis à un conseil d'administration.
+func (h *ReportsHandler) BilanPDF(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+	if year == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year est requis (exercice du bilan)"})
+		return
+	}
+	withNarrative := c.Query("narrative") == "true"
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	tenant, err := getTenant(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du tenant"})
+		return
+	}
+	lines, err := loadEmissionLines(ctx, h.db, tenantID, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+	if len(lines) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "aucune émission calculée pour cet exercice"})
+		return
+	}
+	yearly, err := yearlyEmissionsByScope(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+	dens, err := loadDenominators(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des dénominateurs"})
+		return
+	}
+
+	data := newBilanData(tenant, year, lines, yearly, dens[year])
+
+	// L'analyse IA est facultative : en cas d'échec le rapport est produit sans elle.
+	narrative := ""
+	if withNarrative {
+		narrative = "L'analyse rédigée par l'assistant IA n'a pas pu être générée pour ce rapport."
+		if prompt, err := buildReportPrompt(data.promptSummary()); err == nil && h.mistral.enabled() {
+			if out, err := h.mistral.invokeAgent(prompt); err == nil {
+				narrative = cleanMarkdown(agentText(out))
+			} else {
+				log.Printf("rapport PDF tenant %d : échec analyse IA : %v", tenantID, err)
+			}
+		}
+	}
+
+	pdf, err := renderBilanPDF(data, narrative)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le PDF"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="bilan_carbone_%d_%d.pdf"`, tenantID, year))
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
+// bilanData regroupe les agrégats nécessaires au rapport.
+type bilanData struct {
+	Tenant       Tenant
+	Year         int
+	Lines        []emissionLine
+	Total        float64
+	ByScope      map[string]float64
+	ByCategory   map[string]float64
+	PreviousYear float64 // 0 si pas de données N-1
+	Intensities  map[string]intensityRatio
+	Beges        begesExport
+	Uncertainty  float64
+	SpendShare   float64
+}
+
+func newBilanData(tenant Tenant, year int, lines []emissionLine, yearly map[int]map[string]float64, dens map[string]IntensityDenominator) bilanData {
+	d := bilanData{
+		Tenant:     tenant,
+		Year:       year,
+		Lines:      lines,
+		ByScope:    map[string]float64{"1": 0, "2": 0, "3": 0},
+		ByCategory: map[string]float64{},
+		Beges:      buildBEGES(tenant, year, lines),
+	}
+	var spend float64
+	for _, l := range lines {
+		d.Total += l.TCO2e
+		d.ByScope[l.Scope] += l.TCO2e
+		cat := l.Category
+		if cat == "" {
+			cat = "Non catégorisé"
+		}
+		d.ByCategory[cat] += l.TCO2e
+		if l.isSpendBased() {
+			spend += l.TCO2e
+		}
+	}
+	if d.Total > 0 {
+		d.SpendShare = spend / d.Total
+	}
+	for _, v := range yearly[year-1] {
+		d.PreviousYear += v
+	}
+	d.Intensities = computeIntensities(d.Total, dens)
+	d.Uncertainty = combinedUncertainty(lines)
+	return d
+}
+
+// topPostes renvoie les postes BEGES non nuls triés par émissions décroissantes.
+func (d bilanData) topPostes() []begesPosteResult {
+	var out []begesPosteResult
+	for _, p := range d.Beges.Postes {
+		if p.Total > 0 {
+			out = append(out, p)
+		}
+	}
+	slices.SortFunc(out, func(a, b begesPosteResult) int {
+		return cmpDesc(a.Total, b.Total)
+	})
+	return out
+}
+
+// promptSummary construit le résumé JSON transmis à l'agent pour l'analyse rédigée.
+func (d bilanData) promptSummary() map[string]interface{} {
+	postes := []map[string]interface{}{}
+	for _, p := range d.topPostes() {
+		postes = append(postes, map[string]interface{}{"poste": p.Number + " " + p.Label, "scope": p.Scope, "tco2e": p.Total})
+	}
+	summary := map[string]interface{}{
+		"entreprise":  d.Tenant.Name,
+		"annee":       d.Year,
+		"total_tco2e": d.Total,
+		"par_scope":   d.ByScope,
+		"postes":      postes,
+		"intensites":  d.Intensities,
+	}
+	if d.PreviousYear > 0 {
+		summary["total_annee_precedente_tco2e"] = d.PreviousYear
+	}
+	return summary
+}
+
+func renderBilanPDF(d bilanData, narrative string) ([]byte, error) {
+	doc := newPDFDocument(fmt.Sprintf("Bilan Carbone %d - %s", d.Year, d.Tenant.Name), d.Tenant.Name)
+	f := &pdfFlow{doc: doc, footer: fmt.Sprintf("%s — Bilan Carbone %d", d.Tenant.Name, d.Year)}
+
+	// Couverture
+	cover := doc.AddPage()
+	cover.Rect(0, 0, pdfPageWidth, 330, pdfGreen)
+	cover.Text(pdfMarginX, 150, 34, true, pdfWhite, "Bilan Carbone")
+	cover.Text(pdfMarginX, 190, 20, false, pdfWhite, fmt.Sprintf("Exercice %d", d.Year))
+	cover.Text(pdfMarginX, 280, 16, true, pdfWhite, d.Tenant.Name)
+	if d.Tenant.Siret != "" {
+		cover.Text(pdfMarginX, 302, 10, false, pdfWhite, "SIRET "+d.Tenant.Siret)
+	}
+	cover.Text(pdfMarginX, 420, 12, false, pdfGrey, "Émissions totales")
+	cover.Text(pdfMarginX, 470, 44, true, pdfBlack, frNumber(d.Total, 1)+" tCO₂e")
+	cover.Text(pdfMarginX, 500, 11, false, pdfGrey, fmt.Sprintf("Incertitude globale estimée : ± %s %%", frNumber(d.Uncertainty*100, 0)))
+	cover.Text(pdfMarginX, 760, 9, false, pdfGrey, "Méthode Bilan Carbone® / BEGES v5 — GHG Protocol")
+	cover.Text(pdfMarginX, 775, 9, false, pdfGrey, "Généré le "+time.Now().Format("02/01/2006")+" par CarbonV2")
+
+	// Synthèse
+	f.newPage()
+	f.heading("Synthèse")
+	summary := fmt.Sprintf("Sur l'exercice %d, les émissions de gaz à effet de serre de %s s'élèvent à %s tCO2e "+
+		"(scope 1 : %s t, scope 2 : %s t, scope 3 : %s t).",
+		d.Year, d.Tenant.Name, frNumber(d.Total, 1),
+		frNumber(d.ByScope["1"], 1), frNumber(d.ByScope["2"], 1), frNumber(d.ByScope["3"], 1))
+	if d.PreviousYear > 0 {
+		delta := (d.Total - d.PreviousYear) / d.PreviousYear * 100
+		summary += fmt.Sprintf(" Par rapport à %d (%s tCO2e), l'évolution est de %s%s %%.",
+			d.Year-1, frNumber(d.PreviousYear, 1), signPrefix(delta), frNumber(delta, 1))
+	}
+	if top := d.topPostes(); len(top) > 0 {
+		summary += fmt.Sprintf(" Le premier poste d'émissions est « %s » avec %s %% du total.",
+			top[0].Label, frNumber(top[0].Total/d.Total*100, 0))
+	}
+	if d.SpendShare > 0 {
+		summary += fmt.Sprintf(" %s %% des émissions sont estimées à partir de ratios monétaires, d'où une incertitude de ± %s %%.",
+			frNumber(d.SpendShare*100, 0), frNumber(d.Uncertainty*100, 0))
+	}
+	f.paragraph(summary, 10.5)
+
+	if len(d.Intensities) > 0 {
+		labels := map[string]string{"revenue": "Chiffre d'affaires", "headcount": "Effectif", "floor_area": "Surface", "production": "Production"}
+		var rows [][]string
+		for _, m := range []string{"revenue", "headcount", "floor_area", "production"} {
+			if r, ok := d.Intensities[m]; ok {
+				rows = append(rows, []string{labels[m], frNumber(r.Value, 2), r.Unit})
+			}
+		}
+		f.table([]string{"Indicateur d'intensité", "Valeur", "Unité"}, rows, []float64{220, 120, 155}, []bool{false, true, false})
+	}
+
+	f.barChart("Répartition par scope (tCO2e)", []chartBar{
+		{"Scope 1 — émissions directes", d.ByScope["1"], scopeColors["1"]},
+		{"Scope 2 — énergie indirecte", d.ByScope["2"], scopeColors["2"]},
+		{"Scope 3 — autres indirectes", d.ByScope["3"], scopeColors["3"]},
+	})
+
+	var rows [][]string
+	for i, p := range d.topPostes() {
+		if i == 5 {
+			break
+		}
+		rows = append(rows, []string{p.Number, p.Label, p.Scope, frNumber(p.Total, 2), frNumber(p.Total/d.Total*100, 1)})
+	}
+	f.page.Text(pdfMarginX, f.y, 11, true, pdfBlack, "Principaux postes d'émissions")
+	f.y += 16
+	f.table([]string{"Poste", "Libellé", "Scope", "tCO2e", "%"}, rows, []float64{40, 285, 40, 80, 50}, []bool{false, false, false, true, true})
+
+	// Répartition par catégorie
+	f.heading("Répartition par catégorie")
+	cats := make([]chartBar, 0, len(d.ByCategory))
+	for cat, v := range d.ByCategory {
+		cats = append(cats, chartBar{Label: cat, Value: v, Color: pdfGreen})
+	}
+	slices.SortFunc(cats, func(a, b chartBar) int { return cmpDesc(a.Value, b.Value) })
+	if len(cats) > 12 {
+		var other float64
+		for _, b := range cats[11:] {
+			other += b.Value
+		}
+		cats = append(cats[:11], chartBar{Label: "Autres catégories", Value: other, Color: pdfGrey})
+	}
+	f.barChart("Émissions par catégorie (tCO2e)", cats)
+
+	rows = nil
+	for _, p := range d.topPostes() {
+		rows = append(rows, []string{p.Number, p.Label, p.Scope, frNumber(p.Total, 2), "± " + frNumber(p.UncertaintyPct, 0) + " %"})
+	}
+	f.page.Text(pdfMarginX, f.y, 11, true, pdfBlack, "Postes réglementaires BEGES")
+	f.y += 16
+	f.table([]string{"Poste", "Libellé", "Scope", "tCO2e", "Incertitude"}, rows, []float64{40, 275, 40, 75, 65}, []bool{false, false, false, true, true})
+
+	// Analyse rédigée (facultative)
+	if narrative != "" {
+		f.heading("Analyse et recommandations")
+		f.paragraph("Section rédigée par un assistant IA à partir des résultats ci-dessus ; à relire avant diffusion.", 8.5)
+		f.paragraph(narrative, 10)
+	}
+
+	// Annexe méthodologique
+	f.heading("Annexe — Méthodologie")
+	f.paragraph("Les émissions sont calculées ligne à ligne en multipliant chaque donnée d'activité par un facteur "+
+		"d'émission, puis agrégées par scope (GHG Protocol) et par poste réglementaire (BEGES v5). Les entrées sont "+
+		"rattachées à l'exercice selon leur date. L'incertitude de chaque poste combine les incertitudes des lignes "+
+		"par somme quadratique : 50 % pour les ratios monétaires, 20 % pour les données physiques.", 9.5)
+
+	f.page.Text(pdfMarginX, f.y, 11, true, pdfBlack, "Facteurs d'émission utilisés")
+	f.y += 16
+	f.table([]string{"Catégorie", "Type", "Facteur", "Scope", "Méthode", "Lignes"},
+		d.factorRows(), []float64{150, 85, 90, 40, 85, 45}, []bool{false, false, true, false, false, true})
+
+	return doc.Bytes()
+}
+
+// factorRows liste les facteurs effectivement appliqués sur la période.
+func (d bilanData) factorRows() [][]string {
+	type key struct{ category, entryType, method string }
+	counts := map[key]int{}
+	var keys []key
+	for _, l := range d.Lines {
+		k := key{l.Category, l.EntryType, l.MethodologyVersion}
+		if counts[k] == 0 {
+			keys = append(keys, k)
+		}
+		counts[k]++
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		return strings.Compare(a.category+a.entryType, b.category+b.entryType)
+	})
+
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		factor, scope := "n.c.", ""
+		if strings.HasPrefix(k.method, "mvp-simple") {
+			rule := getRule(k.category, k.entryType)
+			factor = frNumber(rule.FactorKgPerEUR, 2) + " kg/EUR"
+			scope = rule.Scope
+		}
+		cat := k.category
+		if cat == "" {
+			cat = "Non catégorisé"
+		}
+		rows = append(rows, []string{cat, k.entryType, factor, scope, k.method, strconv.Itoa(counts[k])})
+	}
+	return rows
+}
+
+// frNumber formate un nombre à la française : espaces pour les milliers, virgule décimale.
+func frNumber(v float64, prec int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', prec, 64)
+	intPart, frac, _ := strings.Cut(s, ".")
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, r := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString("," + frac)
+	}
+	return b.String()
+}
+
+func signPrefix(v float64) string {
+	if v > 0 {
+		return "+"
+	}
+	return ""
+}
+
+func cmpDesc(a, b float64) int {
+	switch {
+	case a > b:
+		return -1
+	case a < b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// truncateToWidth raccourcit un texte pour qu'il tienne dans une cellule.
+func truncateToWidth(s string, width, size float64) string {
+	if pdfTextWidth(s, size) <= width {
+		return s
+	}
+	r := []rune(s)
+	for len(r) > 0 && pdfTextWidth(string(r)+"…", size) > width {
+		r = r[:len(r)-1]
+	}
+	return string(r) + "…"
+}
+
+// cleanMarkdown retire le balisage Markdown courant d'une réponse de l'agent.
+func cleanMarkdown(s string) string {
+	var out []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		line = strings.TrimLeft(line, "#")
+		line = strings.ReplaceAll(line, "**", "")
+		line = strings.ReplaceAll(line, "__", "")
+		if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") {
+			line = "• " + line[2:]
+		}
+		out = append(out, strings.TrimSpace(line))
+	}
+	return strings.TrimSpace(strings.Join(out, "\n"))
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Briques communes aux exports réglementaires (BEGES, CSRD, CDP, rapport PDF) :
+// chargement des émissions détaillées d'une période et correspondances de postes.
+
+// emissionLine est une émission calculée enrichie des informations de son entrée.
+type emissionLine struct {
+	EmissionID         int64
+	EntryID            int64
+	Scope              string
+	TCO2e              float64
+	MethodologyVersion string
+	CO2, CH4, N2O      *float64
+	Other              *float64
+	Biogenic           *float64
+	EntryType          string
+	Category           string
+	Source             string
+	Amount             float64
+	Currency           string
+	Date               time.Time
+	SiteID             *int64
+	Evidence           []int64 // documents justificatifs rattachés à l'entrée
+}
+
+// loadEmissionLines charge les émissions d'un tenant dont l'entrée est datée de l'année donnée.
+func loadEmissionLines(ctx context.Context, db *pgxpool.Pool, tenantID int64, year int) ([]emissionLine, error) {
+	rows, err := db.Query(ctx,
+		`SELECT em.id, em.entry_id, em.scope, em.tco2e, em.methodology_version,
+		        em.tco2e_co2, em.tco2e_ch4, em.tco2e_n2o, em.tco2e_other, em.tco2_biogenic,
+		        en.type, COALESCE(en.category, ''), COALESCE(en.source, ''), en.amount, en.currency, en.date, en.site_id,
+		        ARRAY(SELECT DISTINCT cur.id FROM entry_documents ed
+		              JOIN documents d ON d.id = ed.document_id
+		              JOIN documents cur ON COALESCE(cur.version_of, cur.id) = COALESCE(d.version_of, d.id) AND cur.superseded_by IS NULL
+		              WHERE ed.entry_id = en.id AND cur.deleted_at IS NULL ORDER BY cur.id)
+		 FROM emissions em
+		 JOIN entries en ON en.id = em.entry_id
+		 WHERE em.tenant_id = $1 AND EXTRACT(YEAR FROM en.date) = $2
+		 ORDER BY en.date, em.id`,
+		tenantID, year,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []emissionLine
+	for rows.Next() {
+		var l emissionLine
+		if err := rows.Scan(&l.EmissionID, &l.EntryID, &l.Scope, &l.TCO2e, &l.MethodologyVersion,
+			&l.CO2, &l.CH4, &l.N2O, &l.Other, &l.Biogenic,
+			&l.EntryType, &l.Category, &l.Source, &l.Amount, &l.Currency, &l.Date, &l.SiteID, &l.Evidence); err != nil {
+			return nil, err
+		}
+		lines = append(lines, l)
+	}
+	return lines, rows.Err()
+}
+
+// gasBreakdown renvoie la ventilation CO2/CH4/N2O/autres gaz de la ligne, et
+// indique si elle est estimée (pas de ventilation saisie : tout est reporté en CO2).
+func (l emissionLine) gasBreakdown() (co2, ch4, n2o, other float64, estimated bool) {
+	if l.CO2 == nil && l.CH4 == nil && l.N2O == nil && l.Other == nil {
+		return l.TCO2e, 0, 0, 0, true
+	}
+	deref := func(p *float64) float64 {
+		if p == nil {
+			return 0
+		}
+		return *p
+	}
+	return deref(l.CO2), deref(l.CH4), deref(l.N2O), deref(l.Other), false
+}
+
+// isSpendBased indique si l'émission provient d'un ratio monétaire (kgCO2e/EUR).
+func (l emissionLine) isSpendBased() bool {
+	return strings.HasPrefix(l.MethodologyVersion, "mvp-simple")
+}
+
+// uncertainty renvoie l'incertitude relative de la ligne. Les ratios monétaires
+// ADEME sont affectés d'une incertitude de 50%, les données physiques de 20%.
+func (l emissionLine) uncertainty() float64 {
+	if l.isSpendBased() {
+		return 0.5
+	}
+	return 0.2
+}
+
+// isMaterial indique si l'émission atteint le seuil de matérialité du tenant
+// (aucune ligne n'est matérielle sans seuil configuré).
+func (l emissionLine) isMaterial(threshold *float64) bool {
+	return threshold != nil && l.TCO2e >= *threshold
+}
+
+// combinedUncertainty agrège des incertitudes indépendantes (somme quadratique),
+// exprimée en part du total.
+func combinedUncertainty(lines []emissionLine) float64 {
+	var total, variance float64
+	for _, l := range lines {
+		total += l.TCO2e
+		u := l.uncertainty() * l.TCO2e
+		variance += u * u
+	}
+	if total <= 0 {
+		return 0
+	}
+	return math.Sqrt(variance) / total
+}
+
+// begesPoste décrit un poste réglementaire du BEGES (méthode v5, 6 catégories / 22 postes).
+type begesPoste struct {
+	Number        string
+	Category      int
+	CategoryLabel string
+	Label         string
+	Scope         string
+}
+
+var begesPostes = []begesPoste{
+	{"1.1", 1, "Émissions directes de GES", "Émissions directes des sources fixes de combustion", "1"},
+	{"1.2", 1, "Émissions directes de GES", "Émissions directes des sources mobiles à moteur thermique", "1"},
+	{"1.3", 1, "Émissions directes de GES", "Émissions directes des procédés hors énergie", "1"},
+	{"1.4", 1, "Émissions directes de GES", "Émissions directes fugitives", "1"},
+	{"1.5", 1, "Émissions directes de GES", "Émissions issues de la biomasse (sols et forêts)", "1"},
+	{"2.1", 2, "Émissions indirectes associées à l'énergie", "Émissions indirectes liées à la consommation d'électricité", "2"},
+	{"2.2", 2, "Émissions indirectes associées à l'énergie", "Émissions indirectes liées à la consommation d'énergie autre que l'électricité", "2"},
+	{"3.1", 3, "Émissions indirectes associées au transport", "Transport de marchandise amont", "3"},
+	{"3.2", 3, "Émissions indirectes associées au transport", "Transport de marchandise aval", "3"},
+	{"3.3", 3, "Émissions indirectes associées au transport", "Déplacements domicile-travail", "3"},
+	{"3.4", 3, "Émissions indirectes associées au transport", "Déplacements des visiteurs et des clients", "3"},
+	{"3.5", 3, "Émissions indirectes associées au transport", "Déplacements professionnels", "3"},
+	{"4.1", 4, "Émissions indirectes associées aux produits achetés", "Achats de biens", "3"},
+	{"4.2", 4, "Émissions indirectes associées aux produits achetés", "Immobilisations de biens", "3"},
+	{"4.3", 4, "Émissions indirectes associées aux produits achetés", "Gestion des déchets", "3"},
+	{"4.4", 4, "Émissions indirectes associées aux produits achetés", "Actifs en leasing amont", "3"},
+	{"4.5", 4, "Émissions indirectes associées aux produits achetés", "Achats de services", "3"},
+	{"5.1", 5, "Émissions indirectes associées aux produits vendus", "Utilisation des produits vendus", "3"},
+	{"5.2", 5, "Émissions indirectes associées aux produits vendus", "Actifs en leasing aval", "3"},
+	{"5.3", 5, "Émissions indirectes associées aux produits vendus", "Fin de vie des produits vendus", "3"},
+	{"5.4", 5, "Émissions indirectes associées aux produits vendus", "Investissements", "3"},
+	{"6.1", 6, "Autres émissions indirectes", "Autres émissions indirectes", "3"},
+}
+
+// containsAny indique si s contient l'un des mots-clés.
+func containsAny(s string, keywords ...string) bool {
+	for _, k := range keywords {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// begesPosteFor rattache une émission à un poste BEGES à partir de son scope,
+// de sa catégorie et du type d'entrée (mêmes mots-clés que getRule).
+func begesPosteFor(l emissionLine) string {
+	key := strings.ToLower(l.Category + " " + l.EntryType)
+
+	switch l.Scope {
+	case "1":
+		switch {
+		case containsAny(key, "fuite", "réfrigérant", "refrigerant", "climatisation"):
+			return "1.4"
+		case containsAny(key, "procédé", "process"):
+			return "1.3"
+		case containsAny(key, "véhicule", "vehicle", "flotte", "gazole", "diesel", "essence", "carburant", "fuel"):
+			return "1.2"
+		default:
+			return "1.1"
+		}
+	case "2":
+		if containsAny(key, "chaleur", "vapeur", "froid", "réseau de chaleur", "heat", "steam") {
+			return "2.2"
+		}
+		return "2.1"
+	}
+
+	switch {
+	case containsAny(key, "domicile", "commute"):
+		return "3.3"
+	case containsAny(key, "visiteur", "client"):
+		return "3.4"
+	case containsAny(key, "avion", "train", "flight", "hôtel", "hotel", "taxi", "déplacement", "voyage", "travel"):
+		return "3.5"
+	case containsAny(key, "livraison", "aval", "downstream"):
+		return "3.2"
+	case containsAny(key, "fret", "logistique", "transport", "freight"):
+		return "3.1"
+	case containsAny(key, "déchet", "waste"):
+		return "4.3"
+	case containsAny(key, "leasing", "location"):
+		return "4.4"
+	case containsAny(key, "immobilisation", "équipement", "matériel", "capex", "véhicule"):
+		return "4.2"
+	case containsAny(key, "service", "conseil", "cloud", "logiciel", "saas", "prestation", "numérique", "assurance", "banque"):
+		return "4.5"
+	case containsAny(key, "investissement", "investment"):
+		return "5.4"
+	default:
+		return "4.1"
+	}
+}
+
+// ghgScope3Categories liste les 15 catégories du scope 3 (GHG Protocol).
+var ghgScope3Categories = map[int]string{
+	1:  "Purchased goods and services",
+	2:  "Capital goods",
+	3:  "Fuel- and energy-related activities",
+	4:  "Upstream transportation and distribution",
+	5:  "Waste generated in operations",
+	6:  "Business travel",
+	7:  "Employee commuting",
+	8:  "Upstream leased assets",
+	9:  "Downstream transportation and distribution",
+	10: "Processing of sold products",
+	11: "Use of sold products",
+	12: "End-of-life treatment of sold products",
+	13: "Downstream leased assets",
+	14: "Franchises",
+	15: "Investments",
+}
+
+// ghgScope3CategoryFor convertit un poste BEGES de scope 3 en catégorie GHG Protocol
+// (0 si le poste n'a pas d'équivalent, ex: déplacements des visiteurs).
+func ghgScope3CategoryFor(poste string) int {
+	switch poste {
+	case "3.1":
+		return 4
+	case "3.2":
+		return 9
+	case "3.3":
+		return 7
+	case "3.5":
+		return 6
+	case "4.1", "4.5":
+		return 1
+	case "4.2":
+		return 2
+	case "4.3":
+		return 5
+	case "4.4":
+		return 8
+	case "5.1":
+		return 11
+	case "5.2":
+		return 13
+	case "5.3":
+		return 12
+	case "5.4":
+		return 15
+	default:
+		return 0
+	}
+}
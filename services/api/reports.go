@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ReportsHandler regroupe les exports réglementaires et rapports générés à partir
+// des émissions d'une période (BEGES, etc.).
+type ReportsHandler struct {
+	db      *pgxpool.Pool
+	mistral *MistralClient
+}
+
+func NewReportsHandler(db *pgxpool.Pool, cfg Config) *ReportsHandler {
+	return &ReportsHandler{db: db, mistral: NewMistralClient(cfg)}
+}
+
+type begesPosteResult struct {
+	Category       int     `json:"category"`
+	CategoryLabel  string  `json:"category_label"`
+	Number         string  `json:"number"`
+	Label          string  `json:"label"`
+	Scope          string  `json:"scope"`
+	CO2            float64 `json:"co2_tco2e"`
+	CH4            float64 `json:"ch4_tco2e"`
+	N2O            float64 `json:"n2o_tco2e"`
+	Other          float64 `json:"other_gases_tco2e"`
+	Total          float64 `json:"total_tco2e"`
+	BiogenicCO2    float64 `json:"biogenic_co2_t"`
+	UncertaintyPct float64 `json:"uncertainty_pct"`
+	// Vrai si une partie des émissions n'est pas ventilée par gaz (reportée en CO2).
+	GasBreakdownEstimated bool `json:"gas_breakdown_estimated"`
+	LinesCount            int  `json:"lines_count"`
+	// Lignes justifiées par au moins un document, et lignes matérielles sans justificatif.
+	EvidencedLines          int `json:"evidenced_lines"`
+	MaterialWithoutEvidence int `json:"material_without_evidence"`
+}
+
+type begesExport struct {
+	TenantName   string             `json:"tenant_name"`
+	Siret        string             `json:"siret"`
+	Year         int                `json:"year"`
+	Methodology  string             `json:"methodology"`
+	Postes       []begesPosteResult `json:"postes"`
+	TotalByScope map[string]float64 `json:"total_by_scope"`
+	TotalTCO2e   float64            `json:"total_tco2e"`
+	// Seuil de matérialité appliqué au contrôle des justificatifs (nil = aucun).
+	MaterialityThresholdTCO2e *float64  `json:"materiality_threshold_tco2e"`
+	GeneratedAt               time.Time `json:"generated_at"`
+}
+
+// GET /api/tenants/:tenantId/exports/beges?year=2024&format=csv
+// Export au format attendu par la plateforme ADEME (bilans-ges.ademe.fr) : les 22
+// postes réglementaires avec scope, tCO2e par gaz et incertitude.
+func (h *ReportsHandler) ExportBEGES(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+	if year == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "year est requis (année de reporting)"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format doit valoir 'json' ou 'csv'"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	tenant, err := getTenant(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du tenant"})
+		return
+	}
+	lines, err := loadEmissionLines(ctx, h.db, tenantID, year)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+
+	export := buildBEGES(tenant, year, lines)
+
+	if format == "json" {
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	data, err := export.csv()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le CSV"})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="beges_%d_%d.csv"`, tenantID, year))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
+
+// buildBEGES ventile les émissions de la période sur les postes réglementaires.
+func buildBEGES(tenant Tenant, year int, lines []emissionLine) begesExport {
+	byPoste := make(map[string][]emissionLine)
+	for _, l := range lines {
+		n := begesPosteFor(l)
+		byPoste[n] = append(byPoste[n], l)
+	}
+
+	export := begesExport{
+		TenantName:   tenant.Name,
+		Siret:        tenant.Siret,
+		Year:         year,
+		Methodology:  "Méthode BEGES v5 (article L229-25 du code de l'environnement)",
+		TotalByScope: map[string]float64{"1": 0, "2": 0, "3": 0},
+		GeneratedAt:  time.Now().UTC(),
+
+		MaterialityThresholdTCO2e: tenant.MaterialityThresholdTCO2e,
+	}
+
+	for _, p := range begesPostes {
+		r := begesPosteResult{
+			Category:      p.Category,
+			CategoryLabel: p.CategoryLabel,
+			Number:        p.Number,
+			Label:         p.Label,
+			Scope:         p.Scope,
+		}
+		pl := byPoste[p.Number]
+		for _, l := range pl {
+			co2, ch4, n2o, other, estimated := l.gasBreakdown()
+			r.CO2 += co2
+			r.CH4 += ch4
+			r.N2O += n2o
+			r.Other += other
+			r.Total += l.TCO2e
+			if l.Biogenic != nil {
+				r.BiogenicCO2 += *l.Biogenic
+			}
+			r.GasBreakdownEstimated = r.GasBreakdownEstimated || estimated
+			if len(l.Evidence) > 0 {
+				r.EvidencedLines++
+			} else if l.isMaterial(tenant.MaterialityThresholdTCO2e) {
+				r.MaterialWithoutEvidence++
+			}
+		}
+		r.LinesCount = len(pl)
+		r.UncertaintyPct = combinedUncertainty(pl) * 100
+		export.TotalByScope[p.Scope] += r.Total
+		export.TotalTCO2e += r.Total
+		export.Postes = append(export.Postes, r)
+	}
+
+	return export
+}
+
+// csv produit le fichier d'import (séparateur « ; », décimales à la virgule,
+// BOM UTF-8 pour une ouverture correcte dans Excel).
+func (e begesExport) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("\ufeff")
+	w := csv.NewWriter(&buf)
+	w.Comma = ';'
+
+	num := func(v float64) string {
+		return frDecimal(v, 3)
+	}
+
+	records := [][]string{
+		{"Raison sociale", e.TenantName},
+		{"SIRET", e.Siret},
+		{"Année de reporting", strconv.Itoa(e.Year)},
+		{"Méthode", e.Methodology},
+		{},
+		{"Catégorie", "Libellé catégorie", "Poste", "Libellé poste", "Scope",
+			"CO2 (tCO2e)", "CH4 (tCO2e)", "N2O (tCO2e)", "Autres gaz (tCO2e)", "Total (tCO2e)",
+			"CO2 biogénique (t)", "Incertitude (%)"},
+	}
+	for _, p := range e.Postes {
+		records = append(records, []string{
+			strconv.Itoa(p.Category), p.CategoryLabel, p.Number, p.Label, p.Scope,
+			num(p.CO2), num(p.CH4), num(p.N2O), num(p.Other), num(p.Total),
+			num(p.BiogenicCO2), frDecimal(p.UncertaintyPct, 0),
+		})
+	}
+	records = append(records,
+		[]string{},
+		[]string{"Total scope 1", "", "", "", "1", "", "", "", "", num(e.TotalByScope["1"])},
+		[]string{"Total scope 2", "", "", "", "2", "", "", "", "", num(e.TotalByScope["2"])},
+		[]string{"Total scope 3", "", "", "", "3", "", "", "", "", num(e.TotalByScope["3"])},
+		[]string{"Total", "", "", "", "", "", "", "", "", num(e.TotalTCO2e)},
+	)
+
+	if err := w.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// frDecimal formate un nombre avec une virgule décimale (convention française).
+func frDecimal(v float64, prec int) string {
+	return strings.Replace(strconv.FormatFloat(v, 'f', prec, 64), ".", ",", 1)
+}
+
+func getTenant(ctx context.Context, db *pgxpool.Pool, tenantID int64) (Tenant, error) {
+	var t Tenant
+	err := db.QueryRow(ctx,
+		`SELECT id, name, COALESCE(siret, ''), plan, created_at, materiality_threshold_tco2e FROM tenants WHERE id = $1`,
+		tenantID,
+	).Scan(&t.ID, &t.Name, &t.Siret, &t.Plan, &t.CreatedAt, &t.MaterialityThresholdTCO2e)
+	return t, err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// MalwareScanner analyse le contenu d'un fichier avant son enregistrement.
+type MalwareScanner interface {
+	Scan(ctx context.Context, r io.Reader) (ScanResult, error)
+}
+
+// ScanResult est le verdict de l'antivirus ; Signature nomme la menace détectée.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// NewMalwareScanner construit l'antivirus choisi par MALWARE_SCANNER ("none" ou "clamd").
+func NewMalwareScanner(cfg Config) (MalwareScanner, error) {
+	switch cfg.MalwareScanner {
+	case "", "none":
+		return noScanner{}, nil
+	case "clamd":
+		network, address := "tcp", cfg.ClamdAddress
+		if strings.HasPrefix(address, "unix:") {
+			network, address = "unix", strings.TrimPrefix(address, "unix:")
+		} else {
+			address = strings.TrimPrefix(address, "tcp:")
+		}
+		if address == "" {
+			return nil, errors.New("CLAMD_ADDRESS est requis pour l'antivirus clamd")
+		}
+		return &ClamdScanner{Network: network, Address: address, Timeout: 2 * time.Minute}, nil
+	default:
+		return nil, fmt.Errorf("antivirus inconnu : %q", cfg.MalwareScanner)
+	}
+}
+
+// noScanner laisse passer tous les fichiers (aucun antivirus configuré).
+type noScanner struct{}
+
+func (noScanner) Scan(context.Context, io.Reader) (ScanResult, error) {
+	return ScanResult{}, nil
+}
+
+// ClamdScanner envoie le fichier au démon clamd avec la commande INSTREAM.
+// Les fichiers plus gros que StreamMaxLength (clamd.conf, 25 Mo par défaut)
+// sont refusés par clamd et remontent en erreur.
+type ClamdScanner struct {
+	Network string // "unix" ou "tcp"
+	Address string
+	Timeout time.Duration
+}
+
+const clamdChunkSize = 64 * 1024
+
+func (s *ClamdScanner) Scan(ctx context.Context, r io.Reader) (ScanResult, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, s.Network, s.Address)
+	if err != nil {
+		return ScanResult{}, fmt.Errorf("connexion à clamd : %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return ScanResult{}, fmt.Errorf("envoi à clamd : %w", err)
+	}
+	// Chaque bloc est précédé de sa taille (4 octets, big-endian) ; un bloc vide termine le flux.
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, rerr := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return ScanResult{}, fmt.Errorf("envoi à clamd : %w", err)
+			}
+		}
+		if rerr == io.EOF {
+			break
+		}
+		if rerr != nil {
+			return ScanResult{}, rerr
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return ScanResult{}, fmt.Errorf("envoi à clamd : %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return ScanResult{}, fmt.Errorf("réponse de clamd : %w", err)
+	}
+	return parseClamdReply(string(bytes.TrimRight(reply, "\x00\n")))
+}
+
+// parseClamdReply interprète "stream: OK", "stream: <signature> FOUND" ou "... ERROR".
+func parseClamdReply(reply string) (ScanResult, error) {
+	status := strings.TrimSpace(strings.TrimPrefix(reply, "stream:"))
+	switch {
+	case status == "OK":
+		return ScanResult{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return ScanResult{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return ScanResult{}, fmt.Errorf("clamd : %s", reply)
+	}
+}
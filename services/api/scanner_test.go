@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClamd reçoit un flux INSTREAM, le reconstitue et répond selon son contenu.
+func fakeClamd(t *testing.T, reply func(data []byte) string) (addr string, received chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	received = make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		if cmd, err := r.ReadString(0); err != nil || cmd != "zINSTREAM\x00" {
+			io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			return
+		}
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+				return
+			}
+		}
+		received <- data.Bytes()
+		io.WriteString(conn, reply(data.Bytes())+"\x00")
+	}()
+	return ln.Addr().String(), received
+}
+
+// Signature de test EICAR, reconnue par tous les antivirus.
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func TestClamdScanner(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 3*clamdChunkSize/16+7) // plusieurs blocs
+	tests := []struct {
+		name      string
+		content   []byte
+		reply     string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{name: "fichier sain", content: []byte("%PDF-1.7\n%%EOF\n"), reply: "stream: OK"},
+		{name: "fichier en plusieurs blocs", content: large, reply: "stream: OK"},
+		{name: "fichier infecté", content: []byte(eicar), reply: "stream: Eicar-Test-Signature FOUND", infected: true, signature: "Eicar-Test-Signature"},
+		{name: "flux trop long", content: []byte("x"), reply: "INSTREAM size limit exceeded. ERROR", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, received := fakeClamd(t, func([]byte) string { return tt.reply })
+			s := &ClamdScanner{Network: "tcp", Address: addr, Timeout: 5 * time.Second}
+			res, err := s.Scan(context.Background(), bytes.NewReader(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Scan : err=%v, erreur attendue %v", err, tt.wantErr)
+			}
+			if res.Infected != tt.infected || res.Signature != tt.signature {
+				t.Errorf("Scan = %+v, attendu infecté=%v %q", res, tt.infected, tt.signature)
+			}
+			if got := <-received; !bytes.Equal(got, tt.content) {
+				t.Errorf("clamd a reçu %d octets, attendu %d", len(got), len(tt.content))
+			}
+		})
+	}
+}
+
+func TestClamdScannerUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := &ClamdScanner{Network: "tcp", Address: addr, Timeout: time.Second}
+	if _, err := s.Scan(context.Background(), strings.NewReader("x")); err == nil {
+		t.Error("Scan sans clamd : erreur attendue")
+	}
+}
+
+func TestParseClamdReply(t *testing.T) {
+	tests := []struct {
+		reply     string
+		infected  bool
+		signature string
+		wantErr   bool
+	}{
+		{reply: "stream: OK"},
+		{reply: "stream: Win.Test.EICAR_HDB-1 FOUND", infected: true, signature: "Win.Test.EICAR_HDB-1"},
+		{reply: "stream: Can't allocate memory ERROR", wantErr: true},
+		{reply: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		res, err := parseClamdReply(tt.reply)
+		if (err != nil) != tt.wantErr || res.Infected != tt.infected || res.Signature != tt.signature {
+			t.Errorf("parseClamdReply(%q) = %+v, %v", tt.reply, res, err)
+		}
+	}
+}
+
+func TestNewMalwareScanner(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		network string
+		address string
+		wantErr bool
+	}{
+		{cfg: Config{MalwareScanner: "none"}},
+		{cfg: Config{MalwareScanner: "clamd", ClamdAddress: "unix:/var/run/clamav/clamd.ctl"}, network: "unix", address: "/var/run/clamav/clamd.ctl"},
+		{cfg: Config{MalwareScanner: "clamd", ClamdAddress: "tcp:clamav:3310"}, network: "tcp", address: "clamav:3310"},
+		{cfg: Config{MalwareScanner: "clamd", ClamdAddress: "clamav:3310"}, network: "tcp", address: "clamav:3310"},
+		{cfg: Config{MalwareScanner: "clamd", ClamdAddress: "unix:"}, wantErr: true},
+		{cfg: Config{MalwareScanner: "virustotal"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		s, err := NewMalwareScanner(tt.cfg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewMalwareScanner(%+v) : err=%v", tt.cfg, err)
+			continue
+		}
+		if c, ok := s.(*ClamdScanner); ok && (c.Network != tt.network || c.Address != tt.address) {
+			t.Errorf("NewMalwareScanner(%q) = %s %s", tt.cfg.ClamdAddress, c.Network, c.Address)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// Sessions : chaque connexion ouvre une session côté serveur. Le token d'accès
+// (courte durée) porte l'identifiant de session, vérifié par AuthMiddleware ;
+// le jeton de rafraîchissement est à usage unique et renouvelé à chaque
+// utilisation. Réutiliser un jeton déjà consommé révoque toute la session
+// (vol probable du jeton).
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type sessionItem struct {
+	ID         int64     `json:"id"`
+	UserAgent  *string   `json:"user_agent"`
+	IP         *string   `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
+
+// Mode d'authentification d'une session (sessions.auth_method).
+const (
+	authMethodPassword = "password"
+	authMethodMFA      = "mfa" // mot de passe et code TOTP ou de récupération
+	authMethodSSO      = "sso"
+)
+
+// randomToken renvoie n octets aléatoires encodés en base64 URL.
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken renvoie l'empreinte stockée d'un jeton de rafraîchissement : le
+// jeton lui-même n'est jamais conservé.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken crée un nouveau jeton de rafraîchissement pour la session.
+func (h *AuthHandler) issueRefreshToken(ctx context.Context, tx pgx.Tx, sessionID int64) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec(ctx,
+		`INSERT INTO refresh_tokens (session_id, token_hash, expires_at) VALUES ($1,$2,$3)`,
+		sessionID, hashToken(token), time.Now().Add(h.cfg.RefreshTokenTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// startSession ouvre une session pour l'utilisateur et renvoie ses jetons.
+func (h *AuthHandler) startSession(c *gin.Context, userID, tenantID int64, role, method string) (authResponse, error) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		return authResponse{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var sessionID int64
+	err = tx.QueryRow(ctx,
+		`INSERT INTO sessions (user_id, tenant_id, user_agent, ip, auth_method) VALUES ($1,$2,$3,$4,$5) RETURNING id`,
+		userID, tenantID, c.Request.UserAgent(), c.ClientIP(), method,
+	).Scan(&sessionID)
+	if err != nil {
+		return authResponse{}, err
+	}
+	refresh, err := h.issueRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		return authResponse{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return authResponse{}, err
+	}
+
+	access, err := h.createToken(userID, tenantID, role, sessionID)
+	if err != nil {
+		return authResponse{}, err
+	}
+	return authResponse{Token: access, RefreshToken: refresh, ExpiresIn: int64(h.cfg.AccessTokenTTL.Seconds())}, nil
+}
+
+// POST /api/auth/refresh
+// Échange un jeton de rafraîchissement contre un nouveau token d'accès et un
+// nouveau jeton de rafraîchissement (l'ancien devient inutilisable).
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req refreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "refresh_token manquant", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		tokenID, sessionID, userID, tenantID int64
+		role                                 string
+		usedAt, revokedAt                    *time.Time
+		expiresAt                            time.Time
+	)
+	err = tx.QueryRow(ctx,
+		`SELECT rt.id, rt.session_id, rt.used_at, rt.expires_at, s.revoked_at, s.user_id, s.tenant_id, u.role
+		 FROM refresh_tokens rt
+		 JOIN sessions s ON s.id = rt.session_id
+		 JOIN users u ON u.id = s.user_id
+		 WHERE rt.token_hash = $1
+		 FOR UPDATE OF rt, s`,
+		hashToken(req.RefreshToken),
+	).Scan(&tokenID, &sessionID, &usedAt, &expiresAt, &revokedAt, &userID, &tenantID, &role)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "jeton de rafraîchissement invalide"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	if revokedAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session révoquée, reconnectez-vous"})
+		return
+	}
+	if usedAt != nil {
+		// Le jeton a déjà servi : quelqu'un d'autre le détient. Toute la session est révoquée.
+		if _, err := tx.Exec(ctx,
+			`UPDATE sessions SET revoked_at = now(), revoked_reason = 'refresh_reuse' WHERE id = $1`,
+			sessionID,
+		); err == nil {
+			err = tx.Commit(ctx)
+		}
+		if err != nil {
+			log.Printf("révocation de la session %d après réutilisation : %v", sessionID, err)
+		} else {
+			log.Printf("réutilisation d'un jeton de rafraîchissement : session %d révoquée (utilisateur %d)", sessionID, userID)
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "jeton de rafraîchissement déjà utilisé, session révoquée"})
+		return
+	}
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "session expirée, reconnectez-vous"})
+		return
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE id = $1`, tokenID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `UPDATE sessions SET last_used_at = now() WHERE id = $1`, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	refresh, err := h.issueRefreshToken(ctx, tx, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le jeton de rafraîchissement"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur de validation"})
+		return
+	}
+
+	access, err := h.createToken(userID, tenantID, role, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de générer le token"})
+		return
+	}
+	c.JSON(http.StatusOK, authResponse{Token: access, RefreshToken: refresh, ExpiresIn: int64(h.cfg.AccessTokenTTL.Seconds())})
+}
+
+// sessionFromRequest renvoie l'identifiant de session porté par le token.
+func sessionFromRequest(c *gin.Context) int64 {
+	claims, _ := c.MustGet("user").(jwt.MapClaims)
+	sid, _ := claims["sid"].(float64)
+	return int64(sid)
+}
+
+// POST /api/auth/logout
+// Ferme la session courante : son token d'accès et son jeton de
+// rafraîchissement cessent immédiatement d'être acceptés.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	_, err := h.db.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now(), revoked_reason = 'logout'
+		 WHERE id = $1 AND revoked_at IS NULL`,
+		sessionFromRequest(c),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de fermer la session"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /api/auth/logout-all
+// Ferme toutes les sessions de l'utilisateur, sur tous ses appareils.
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`UPDATE sessions SET revoked_at = now(), revoked_reason = 'logout_all'
+		 WHERE user_id = $1 AND revoked_at IS NULL`,
+		*userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de fermer les sessions"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked_sessions": tag.RowsAffected()})
+}
+
+// GET /api/auth/sessions
+// Sessions ouvertes de l'utilisateur.
+func (h *AuthHandler) ListSessions(c *gin.Context) {
+	userID := userIDFromRequest(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return
+	}
+	current := sessionFromRequest(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := h.db.Query(ctx,
+		`SELECT id, user_agent, ip, created_at, last_used_at
+		 FROM sessions
+		 WHERE user_id = $1 AND revoked_at IS NULL
+		   AND EXISTS (SELECT 1 FROM refresh_tokens rt
+		               WHERE rt.session_id = sessions.id AND rt.used_at IS NULL AND rt.expires_at > now())
+		 ORDER BY last_used_at DESC`,
+		*userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des sessions"})
+		return
+	}
+	defer rows.Close()
+
+	sessions := []sessionItem{}
+	for rows.Next() {
+		var s sessionItem
+		if err := rows.Scan(&s.ID, &s.UserAgent, &s.IP, &s.CreatedAt, &s.LastUsedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la lecture des sessions"})
+			return
+		}
+		s.Current = s.ID == current
+		sessions = append(sessions, s)
+	}
+	c.JSON(http.StatusOK, sessions)
+}
+
+// newJTI renvoie un identifiant unique de token d'accès.
+func newJTI() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// RunSessionCleanup supprime périodiquement les jetons expirés (rafraîchissement,
+// liens envoyés par email, connexions SSO inachevées) et les sessions terminées,
+// jusqu'à l'annulation du contexte.
+func (h *AuthHandler) RunSessionCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := h.cleanupSessions(ctx); err != nil {
+			log.Printf("nettoyage des sessions : %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *AuthHandler) cleanupSessions(ctx context.Context) error {
+	qctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	if _, err := h.db.Exec(qctx, `DELETE FROM refresh_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	if _, err := h.db.Exec(qctx, `DELETE FROM user_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	if _, err := h.db.Exec(qctx, `DELETE FROM sso_login_states WHERE expires_at < now()`); err != nil {
+		return err
+	}
+	// Une session sans jeton valide ne peut plus être prolongée : son dernier
+	// token d'accès a expiré depuis longtemps.
+	_, err := h.db.Exec(qctx,
+		`DELETE FROM sessions s
+		 WHERE (s.revoked_at IS NOT NULL AND s.revoked_at < $1)
+		    OR NOT EXISTS (SELECT 1 FROM refresh_tokens rt WHERE rt.session_id = s.id)`,
+		time.Now().Add(-h.cfg.AccessTokenTTL),
+	)
+	return err
+}
@@ -0,0 +1,574 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// SitesHandler gère la structure organisationnelle d'un tenant (entités, sites,
+// centres de coût) et la consolidation des émissions sur cette hiérarchie.
+type SitesHandler struct {
+	db *pgxpool.Pool
+}
+
+func NewSitesHandler(db *pgxpool.Pool) *SitesHandler {
+	return &SitesHandler{db: db}
+}
+
+type siteRequest struct {
+	ParentID           *int64   `json:"parent_id"`
+	Name               string   `json:"name" binding:"required"`
+	Kind               string   `json:"kind"`
+	Country            string   `json:"country"`
+	OwnershipPct       *float64 `json:"ownership_pct"`
+	OperationalControl *bool    `json:"operational_control"`
+	FinancialControl   *bool    `json:"financial_control"`
+}
+
+// toSite valide la requête et applique les valeurs par défaut (100%, contrôle total).
+func (r siteRequest) toSite(tenantID int64) (Site, string) {
+	s := Site{
+		TenantID:           tenantID,
+		ParentID:           r.ParentID,
+		Name:               r.Name,
+		Kind:               r.Kind,
+		OwnershipPct:       100,
+		OperationalControl: true,
+		FinancialControl:   true,
+	}
+	if s.Kind == "" {
+		s.Kind = "site"
+	}
+	if s.Kind != "entity" && s.Kind != "site" && s.Kind != "cost_centre" {
+		return s, "kind doit valoir 'entity', 'site' ou 'cost_centre'"
+	}
+	if r.Country != "" {
+		if len(r.Country) != 2 {
+			return s, "country doit être un code ISO à 2 lettres (ex: FR)"
+		}
+		country := strings.ToUpper(r.Country)
+		s.Country = &country
+	}
+	if r.OwnershipPct != nil {
+		if *r.OwnershipPct < 0 || *r.OwnershipPct > 100 {
+			return s, "ownership_pct doit être compris entre 0 et 100"
+		}
+		s.OwnershipPct = *r.OwnershipPct
+	}
+	if r.OperationalControl != nil {
+		s.OperationalControl = *r.OperationalControl
+	}
+	if r.FinancialControl != nil {
+		s.FinancialControl = *r.FinancialControl
+	}
+	return s, ""
+}
+
+// POST /api/tenants/:tenantId/sites
+func (h *SitesHandler) CreateSite(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req siteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	s, msg := req.toSite(tenantID)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if s.ParentID != nil {
+		if _, err := getSite(ctx, h.db, tenantID, *s.ParentID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent_id inconnu pour ce tenant"})
+			return
+		}
+	}
+
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO sites (tenant_id, parent_id, name, kind, country, ownership_pct, operational_control, financial_control)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+		 RETURNING id, created_at`,
+		s.TenantID, s.ParentID, s.Name, s.Kind, s.Country, s.OwnershipPct, s.OperationalControl, s.FinancialControl,
+	).Scan(&s.ID, &s.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer le site", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, s)
+}
+
+// GET /api/tenants/:tenantId/sites
+func (h *SitesHandler) ListSites(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sites, err := loadSites(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des sites"})
+		return
+	}
+
+	c.JSON(http.StatusOK, sites)
+}
+
+// PUT /api/tenants/:tenantId/sites/:siteId
+func (h *SitesHandler) UpdateSite(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	siteID, ok := int64Param(c, "siteId")
+	if !ok {
+		return
+	}
+
+	var req siteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	s, msg := req.toSite(tenantID)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	s.ID = siteID
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sites, err := loadSites(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des sites"})
+		return
+	}
+	// Un site ne peut pas être rattaché à lui-même ni à l'un de ses descendants.
+	if s.ParentID != nil {
+		if createsCycle(sites, siteID, *s.ParentID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent_id créerait un cycle dans la hiérarchie"})
+			return
+		}
+		if !siteExists(sites, *s.ParentID) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "parent_id inconnu pour ce tenant"})
+			return
+		}
+	}
+
+	err = h.db.QueryRow(ctx,
+		`UPDATE sites
+		 SET parent_id = $3, name = $4, kind = $5, country = $6, ownership_pct = $7,
+		     operational_control = $8, financial_control = $9
+		 WHERE id = $1 AND tenant_id = $2
+		 RETURNING created_at`,
+		s.ID, s.TenantID, s.ParentID, s.Name, s.Kind, s.Country, s.OwnershipPct, s.OperationalControl, s.FinancialControl,
+	).Scan(&s.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "site non trouvé"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de mettre à jour le site", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, s)
+}
+
+// DELETE /api/tenants/:tenantId/sites/:siteId
+// Supprime le site et ses descendants ; les entrées rattachées repassent au niveau du tenant.
+func (h *SitesHandler) DeleteSite(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	siteID, ok := int64Param(c, "siteId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`DELETE FROM sites WHERE id = $1 AND tenant_id = $2`,
+		siteID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer le site"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "site non trouvé"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type assignSiteRequest struct {
+	SiteID *int64 `json:"site_id"` // null pour détacher l'entrée
+}
+
+// PUT /api/tenants/:tenantId/entries/:entryId/site
+// Rattache une entrée à un site (ou la détache avec site_id = null).
+func (h *SitesHandler) AssignEntrySite(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	entryID, ok := int64Param(c, "entryId")
+	if !ok {
+		return
+	}
+
+	var req assignSiteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if req.SiteID != nil {
+		if _, err := getSite(ctx, h.db, tenantID, *req.SiteID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "site_id inconnu pour ce tenant"})
+			return
+		}
+	}
+
+	tag, err := h.db.Exec(ctx,
+		`UPDATE entries SET site_id = $3 WHERE id = $1 AND tenant_id = $2`,
+		entryID, tenantID, req.SiteID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de rattacher l'entrée"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "entrée non trouvée"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entry_id": entryID, "site_id": req.SiteID})
+}
+
+type siteTreeNode struct {
+	Site
+	// Facteur de consolidation appliqué aux émissions propres du nœud (0 à 1).
+	ConsolidationFactor float64 `json:"consolidation_factor"`
+	// Émissions consolidées des entrées rattachées directement au nœud.
+	OwnTCO2e float64 `json:"own_tco2e"`
+	// Émissions consolidées du nœud et de tous ses descendants, par scope et au total.
+	ByScope    map[string]float64 `json:"by_scope"`
+	TotalTCO2e float64            `json:"total_tco2e"`
+	Children   []*siteTreeNode    `json:"children"`
+}
+
+type siteTreeResponse struct {
+	Consolidation string `json:"consolidation"`
+	Year          *int   `json:"year,omitempty"`
+	// Émissions des entrées non rattachées à un site (niveau tenant).
+	UnassignedByScope map[string]float64 `json:"unassigned_by_scope"`
+	TotalTCO2e        float64            `json:"total_tco2e"`
+	Roots             []*siteTreeNode    `json:"roots"`
+}
+
+// GET /api/tenants/:tenantId/sites/tree?consolidation=operational&year=2024
+// Hiérarchie des sites avec émissions consolidées remontées jusqu'aux racines.
+func (h *SitesHandler) SiteTree(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	approach, ok := consolidationQuery(c)
+	if !ok {
+		return
+	}
+	year, ok := optionalYearQuery(c, "year")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sites, err := loadSites(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des sites"})
+		return
+	}
+	bySite, err := emissionsBySiteScope(ctx, h.db, tenantID, year, nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+	factors := consolidationFactors(sites, approach)
+
+	nodes := make(map[int64]*siteTreeNode, len(sites))
+	for _, s := range sites {
+		nodes[s.ID] = &siteTreeNode{
+			Site:                s,
+			ConsolidationFactor: factors[s.ID],
+			ByScope:             map[string]float64{},
+			Children:            []*siteTreeNode{},
+		}
+	}
+
+	resp := siteTreeResponse{
+		Consolidation:     approach,
+		UnassignedByScope: bySite[0],
+		Roots:             []*siteTreeNode{},
+	}
+	if resp.UnassignedByScope == nil {
+		resp.UnassignedByScope = map[string]float64{}
+	}
+	if year != 0 {
+		resp.Year = &year
+	}
+	for _, s := range sites {
+		n := nodes[s.ID]
+		if s.ParentID != nil && nodes[*s.ParentID] != nil {
+			nodes[*s.ParentID].Children = append(nodes[*s.ParentID].Children, n)
+		} else {
+			resp.Roots = append(resp.Roots, n)
+		}
+	}
+
+	var rollup func(n *siteTreeNode)
+	rollup = func(n *siteTreeNode) {
+		for scope, v := range bySite[n.ID] {
+			n.ByScope[scope] += v * n.ConsolidationFactor
+			n.OwnTCO2e += v * n.ConsolidationFactor
+		}
+		for _, child := range n.Children {
+			rollup(child)
+			for scope, v := range child.ByScope {
+				n.ByScope[scope] += v
+			}
+		}
+		for _, v := range n.ByScope {
+			n.TotalTCO2e += v
+		}
+	}
+	for _, root := range resp.Roots {
+		rollup(root)
+		resp.TotalTCO2e += root.TotalTCO2e
+	}
+	for _, v := range resp.UnassignedByScope {
+		resp.TotalTCO2e += v
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+// consolidationQuery lit le paramètre ?consolidation= :
+// "" (aucune, 100% de chaque entrée), "operational", "financial" ou "equity".
+func consolidationQuery(c *gin.Context) (string, bool) {
+	approach := c.Query("consolidation")
+	switch approach {
+	case "", "operational", "financial", "equity":
+		return approach, true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "consolidation doit valoir 'operational', 'financial' ou 'equity'"})
+		return "", false
+	}
+}
+
+// consolidationFactors calcule, pour chaque site, la part de ses émissions à
+// intégrer au périmètre du tenant selon l'approche GHG Protocol choisie :
+//   - operational / financial : 100% si le site et tous ses parents sont contrôlés, 0% sinon ;
+//   - equity : produit des pourcentages de détention le long de la hiérarchie ;
+//   - "" : 100% partout (pas de consolidation).
+func consolidationFactors(sites []Site, approach string) map[int64]float64 {
+	byID := make(map[int64]Site, len(sites))
+	for _, s := range sites {
+		byID[s.ID] = s
+	}
+
+	factors := make(map[int64]float64, len(sites))
+	var factor func(id int64, depth int) float64
+	factor = func(id int64, depth int) float64 {
+		if f, ok := factors[id]; ok {
+			return f
+		}
+		s, ok := byID[id]
+		if !ok || depth > len(sites) {
+			return 1
+		}
+		own := 1.0
+		switch approach {
+		case "operational":
+			if !s.OperationalControl {
+				own = 0
+			}
+		case "financial":
+			if !s.FinancialControl {
+				own = 0
+			}
+		case "equity":
+			own = s.OwnershipPct / 100
+		}
+		if s.ParentID != nil {
+			own *= factor(*s.ParentID, depth+1)
+		}
+		factors[id] = own
+		return own
+	}
+	for _, s := range sites {
+		factor(s.ID, 0)
+	}
+	return factors
+}
+
+// subtreeIDs renvoie l'identifiant du site racine et de tous ses descendants.
+func subtreeIDs(sites []Site, rootID int64) map[int64]bool {
+	children := make(map[int64][]int64)
+	for _, s := range sites {
+		if s.ParentID != nil {
+			children[*s.ParentID] = append(children[*s.ParentID], s.ID)
+		}
+	}
+	out := map[int64]bool{rootID: true}
+	queue := []int64{rootID}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range children[id] {
+			if !out[child] {
+				out[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return out
+}
+
+// createsCycle indique si rattacher le site à parentID créerait un cycle :
+// le parent serait le site lui-même ou l'un de ses descendants.
+func createsCycle(sites []Site, siteID, parentID int64) bool {
+	return subtreeIDs(sites, siteID)[parentID]
+}
+
+// subtreeIDList renvoie les identifiants de subtreeIDs sous forme de liste,
+// pour un filtre SQL site_id = ANY(...).
+func subtreeIDList(sites []Site, rootID int64) []int64 {
+	ids := []int64{}
+	for id := range subtreeIDs(sites, rootID) {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func siteExists(sites []Site, id int64) bool {
+	for _, s := range sites {
+		if s.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// emissionsBySiteScope agrège les émissions par site (0 = non rattaché) et par scope,
+// éventuellement filtrées sur une année et une liste de sites.
+func emissionsBySiteScope(ctx context.Context, db *pgxpool.Pool, tenantID int64, year int, siteIDs []int64) (map[int64]map[string]float64, error) {
+	rows, err := db.Query(ctx,
+		`SELECT COALESCE(en.site_id, 0), em.scope, COALESCE(SUM(em.tco2e), 0)
+		 FROM emissions em
+		 JOIN entries en ON en.id = em.entry_id
+		 WHERE em.tenant_id = $1
+		   AND ($2 = 0 OR EXTRACT(YEAR FROM en.date) = $2)
+		   AND ($3::bigint[] IS NULL OR en.site_id = ANY($3))
+		 GROUP BY 1, 2`,
+		tenantID, year, siteIDs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[int64]map[string]float64)
+	for rows.Next() {
+		var siteID int64
+		var scope string
+		var sum float64
+		if err := rows.Scan(&siteID, &scope, &sum); err != nil {
+			return nil, err
+		}
+		if out[siteID] == nil {
+			out[siteID] = make(map[string]float64)
+		}
+		out[siteID][scope] = sum
+	}
+	return out, rows.Err()
+}
+
+func getSite(ctx context.Context, db *pgxpool.Pool, tenantID, siteID int64) (Site, error) {
+	var s Site
+	err := db.QueryRow(ctx,
+		`SELECT id, tenant_id, parent_id, name, kind, country, ownership_pct, operational_control, financial_control, created_at
+		 FROM sites
+		 WHERE id = $1 AND tenant_id = $2`,
+		siteID, tenantID,
+	).Scan(&s.ID, &s.TenantID, &s.ParentID, &s.Name, &s.Kind, &s.Country, &s.OwnershipPct, &s.OperationalControl, &s.FinancialControl, &s.CreatedAt)
+	return s, err
+}
+
+func loadSites(ctx context.Context, db *pgxpool.Pool, tenantID int64) ([]Site, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, tenant_id, parent_id, name, kind, country, ownership_pct, operational_control, financial_control, created_at
+		 FROM sites
+		 WHERE tenant_id = $1
+		 ORDER BY name, id`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sites := []Site{}
+	for rows.Next() {
+		var s Site
+		if err := rows.Scan(&s.ID, &s.TenantID, &s.ParentID, &s.Name, &s.Kind, &s.Country, &s.OwnershipPct, &s.OperationalControl, &s.FinancialControl, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		sites = append(sites, s)
+	}
+	return sites, rows.Err()
+}
+
+// siteIDQuery lit le paramètre optionnel ?site_id= (0 si absent).
+func siteIDQuery(c *gin.Context) (int64, bool) {
+	raw := c.Query("site_id")
+	if raw == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "site_id invalide"})
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+// Groupe de test :
+//
+//	1 holding (100 %, contrôlée)
+//	├── 2 filiale France (80 %, contrôle opérationnel seulement)
+//	│   ├── 4 usine Lyon (100 %, contrôlée)
+//	│   └── 5 entrepôt (50 %, non contrôlé)
+//	└── 3 coentreprise (50 %, contrôle financier seulement)
+//	6 site isolé (100 %, contrôlé)
+func testSites() []Site {
+	parent := func(id int64) *int64 { return &id }
+	return []Site{
+		{ID: 1, OwnershipPct: 100, OperationalControl: true, FinancialControl: true},
+		{ID: 2, ParentID: parent(1), OwnershipPct: 80, OperationalControl: true},
+		{ID: 3, ParentID: parent(1), OwnershipPct: 50, FinancialControl: true},
+		{ID: 4, ParentID: parent(2), OwnershipPct: 100, OperationalControl: true, FinancialControl: true},
+		{ID: 5, ParentID: parent(2), OwnershipPct: 50},
+		{ID: 6, OwnershipPct: 100, OperationalControl: true, FinancialControl: true},
+	}
+}
+
+func TestSubtreeIDs(t *testing.T) {
+	tests := []struct {
+		root int64
+		want []int64
+	}{
+		{1, []int64{1, 2, 3, 4, 5}},
+		{2, []int64{2, 4, 5}},
+		{4, []int64{4}},
+		{6, []int64{6}},
+		{99, []int64{99}}, // site inconnu : la racine seule
+	}
+	for _, tt := range tests {
+		got := subtreeIDList(testSites(), tt.root)
+		slices.Sort(got)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("subtreeIDList(%d) = %v, attendu %v", tt.root, got, tt.want)
+		}
+		set := subtreeIDs(testSites(), tt.root)
+		if len(set) != len(tt.want) {
+			t.Errorf("subtreeIDs(%d) = %v", tt.root, set)
+		}
+	}
+}
+
+// Une hiérarchie déjà cyclique en base ne doit pas faire boucler le parcours.
+func TestSubtreeIDsWithCycle(t *testing.T) {
+	a, b := int64(1), int64(2)
+	sites := []Site{{ID: 1, ParentID: &b}, {ID: 2, ParentID: &a}, {ID: 3, ParentID: &b}}
+	got := subtreeIDList(sites, 1)
+	slices.Sort(got)
+	if !slices.Equal(got, []int64{1, 2, 3}) {
+		t.Errorf("subtreeIDList = %v", got)
+	}
+}
+
+func TestCreatesCycle(t *testing.T) {
+	tests := []struct {
+		name         string
+		site, parent int64
+		cycle        bool
+	}{
+		{"rattaché à lui-même", 2, 2, true},
+		{"rattaché à un enfant", 2, 4, true},
+		{"rattaché à un petit-enfant", 1, 5, true},
+		{"rattaché à un frère", 4, 5, false},
+		{"rattaché à un autre arbre", 1, 6, false},
+		{"déplacé sous son grand-parent", 4, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createsCycle(testSites(), tt.site, tt.parent); got != tt.cycle {
+				t.Errorf("createsCycle(%d, %d) = %v, attendu %v", tt.site, tt.parent, got, tt.cycle)
+			}
+		})
+	}
+}
+
+func TestConsolidationFactors(t *testing.T) {
+	tests := []struct {
+		approach string
+		want     map[int64]float64
+	}{
+		{"", map[int64]float64{1: 1, 2: 1, 3: 1, 4: 1, 5: 1, 6: 1}},
+		{"operational", map[int64]float64{1: 1, 2: 1, 3: 0, 4: 1, 5: 0, 6: 1}},
+		{"financial", map[int64]float64{1: 1, 2: 0, 3: 1, 4: 0, 5: 0, 6: 1}},
+		{"equity", map[int64]float64{1: 1, 2: 0.8, 3: 0.5, 4: 0.8, 5: 0.4, 6: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.approach, func(t *testing.T) {
+			got := consolidationFactors(testSites(), tt.approach)
+			for id, want := range tt.want {
+				if math.Abs(got[id]-want) > 1e-9 {
+					t.Errorf("site %d : facteur %v, attendu %v", id, got[id], want)
+				}
+			}
+		})
+	}
+}
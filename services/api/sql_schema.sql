@@ -74,4 +74,361 @@ CREATE TABLE IF NOT EXISTS billing (
     created_at         TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
+-- Objectifs de réduction (ex: -42% scopes 1+2 en 2030 vs 2022).
+CREATE TABLE IF NOT EXISTS reduction_targets (
+    id               BIGSERIAL PRIMARY KEY,
+    tenant_id        BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name             TEXT NOT NULL,
+    base_year        INT NOT NULL,
+    target_year      INT NOT NULL,
+    scopes           TEXT[] NOT NULL,          -- ex: {'1','2'}
+    target_type      TEXT NOT NULL DEFAULT 'absolute', -- 'absolute' | 'intensity'
+    intensity_metric TEXT,                     -- dénominateur si target_type = 'intensity'
+    reduction_pct    NUMERIC(6,2) NOT NULL,    -- ex: 42.00
+    pathway          TEXT NOT NULL DEFAULT 'linear', -- 'linear' | 'sbti_1_5c'
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Plan d'actions de réduction (post-Bilan) avec gain estimé.
+CREATE TABLE IF NOT EXISTS reduction_actions (
+    id                     BIGSERIAL PRIMARY KEY,
+    tenant_id              BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    title                  TEXT NOT NULL,
+    owner                  TEXT,
+    categories             TEXT[] NOT NULL DEFAULT '{}',
+    scopes                 TEXT[] NOT NULL DEFAULT '{}',
+    start_date             DATE NOT NULL,
+    status                 TEXT NOT NULL DEFAULT 'planned', -- 'planned' | 'in_progress' | 'done' | 'cancelled'
+    cost_eur               NUMERIC(18,2) NOT NULL DEFAULT 0,
+    lifetime_years         INT NOT NULL DEFAULT 10, -- durée d'amortissement pour la courbe MACC
+    annual_reduction_tco2e NUMERIC(18,6) NOT NULL DEFAULT 0,
+    created_at             TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Dénominateurs d'intensité par période (chiffre d'affaires, effectif, surface, production).
+CREATE TABLE IF NOT EXISTS intensity_denominators (
+    id         BIGSERIAL PRIMARY KEY,
+    tenant_id  BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    year       INT NOT NULL,
+    metric     TEXT NOT NULL, -- 'revenue' | 'headcount' | 'floor_area' | 'production'
+    value      NUMERIC(20,4) NOT NULL,
+    unit       TEXT NOT NULL, -- ex: "EUR", "FTE", "m2", "tonnes"
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, year, metric)
+);
+
+-- Structure organisationnelle du tenant : entités juridiques, sites et centres de coût.
+CREATE TABLE IF NOT EXISTS sites (
+    id                  BIGSERIAL PRIMARY KEY,
+    tenant_id           BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    parent_id           BIGINT REFERENCES sites(id) ON DELETE CASCADE,
+    name                TEXT NOT NULL,
+    kind                TEXT NOT NULL DEFAULT 'site', -- 'entity' | 'site' | 'cost_centre'
+    country             TEXT,                         -- code ISO 3166-1 alpha-2, ex: "FR"
+    ownership_pct       NUMERIC(5,2) NOT NULL DEFAULT 100,
+    operational_control BOOLEAN NOT NULL DEFAULT true,
+    financial_control   BOOLEAN NOT NULL DEFAULT true,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+ALTER TABLE entries ADD COLUMN IF NOT EXISTS site_id BIGINT REFERENCES sites(id) ON DELETE SET NULL;
+
+-- Ventilation optionnelle par gaz (tCO2e) pour les exports réglementaires (BEGES, CSRD).
+-- NULL = non ventilé : la totalité de tco2e est alors reportée en CO2.
+ALTER TABLE emissions ADD COLUMN IF NOT EXISTS tco2e_co2      NUMERIC(18,6);
+ALTER TABLE emissions ADD COLUMN IF NOT EXISTS tco2e_ch4      NUMERIC(18,6);
+ALTER TABLE emissions ADD COLUMN IF NOT EXISTS tco2e_n2o      NUMERIC(18,6);
+ALTER TABLE emissions ADD COLUMN IF NOT EXISTS tco2e_other    NUMERIC(18,6); -- HFC, PFC, SF6, NF3
+ALTER TABLE emissions ADD COLUMN IF NOT EXISTS tco2_biogenic  NUMERIC(18,6); -- hors total, reporté à part
+
+-- Catégorisation automatique des entrées sans catégorie (règles puis IA).
+CREATE TABLE IF NOT EXISTS categorization_jobs (
+    id            BIGSERIAL PRIMARY KEY,
+    tenant_id     BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    status        TEXT NOT NULL DEFAULT 'running', -- 'running' | 'done' | 'failed'
+    total_entries INT NOT NULL DEFAULT 0,
+    rule_matches  INT NOT NULL DEFAULT 0,
+    llm_matches   INT NOT NULL DEFAULT 0,
+    unmatched     INT NOT NULL DEFAULT 0,
+    error         TEXT,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    finished_at   TIMESTAMPTZ
+);
+
+-- Un seul job « running » par tenant. Les doublons antérieurs à l'index sont
+-- clos (seul le plus récent reste en cours) pour que sa création aboutisse.
+UPDATE categorization_jobs j
+SET status = 'failed', error = 'interrompu (job concurrent)', finished_at = now()
+WHERE j.status = 'running'
+  AND EXISTS (SELECT 1 FROM categorization_jobs k
+              WHERE k.tenant_id = j.tenant_id AND k.status = 'running' AND k.id > j.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS categorization_jobs_running
+    ON categorization_jobs (tenant_id) WHERE status = 'running';
+
+-- Suggestions soumises à validation : la catégorie de l'entrée n'est modifiée qu'à l'acceptation.
+CREATE TABLE IF NOT EXISTS category_suggestions (
+    id          BIGSERIAL PRIMARY KEY,
+    tenant_id   BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    job_id      BIGINT NOT NULL REFERENCES categorization_jobs(id) ON DELETE CASCADE,
+    entry_id    BIGINT NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    category    TEXT NOT NULL,
+    scope       TEXT NOT NULL,
+    origin      TEXT NOT NULL,          -- 'rule' | 'llm'
+    matched_by  TEXT,                   -- règle appliquée, ex: "account:6061", "vendor:sncf"
+    reason      TEXT,
+    confidence  NUMERIC(4,3) NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'pending', -- 'pending' | 'accepted' | 'rejected'
+    accepted_category TEXT,             -- catégorie retenue si corrigée à l'acceptation
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    reviewed_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS category_suggestions_pending_entry
+    ON category_suggestions (entry_id) WHERE status = 'pending';
+
+-- Règles de catégorisation propres au tenant (fournisseur, libellé, compte -> catégorie),
+-- saisies manuellement ou apprises des corrections validées. Évaluées par priorité décroissante.
+CREATE TABLE IF NOT EXISTS categorization_rules (
+    id              BIGSERIAL PRIMARY KEY,
+    tenant_id       BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    match_type      TEXT NOT NULL,              -- 'vendor' | 'label_regex' | 'account'
+    pattern         TEXT NOT NULL,              -- nom de fournisseur, expression régulière ou préfixe de compte
+    category        TEXT NOT NULL,
+    priority        INT NOT NULL DEFAULT 100,   -- les règles apprises sont créées à 50
+    origin          TEXT NOT NULL DEFAULT 'manual', -- 'manual' | 'learned'
+    hits            INT NOT NULL DEFAULT 0,
+    last_matched_at TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (tenant_id, match_type, pattern)
+);
+
+-- Analyse automatique des factures PDF (texte extrait, type, kWh/litres, montant, PDL/PCE).
+-- Statut : 'none' (non analysable) | 'pending' | 'processing' | 'done' | 'failed'.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS analysis_status TEXT NOT NULL DEFAULT 'none';
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS analysis_error TEXT;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS analyzed_at TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS entries_confirmed_at TIMESTAMPTZ; -- entrées proposées créées
+
+-- Pièces justificatives : lien n-n entre entrées et documents, pour remonter d'une
+-- émission à la facture qui la justifie (piste d'audit).
+CREATE TABLE IF NOT EXISTS entry_documents (
+    entry_id    BIGINT NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    document_id BIGINT NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    tenant_id   BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    note        TEXT,
+    attached_by BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    attached_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (entry_id, document_id)
+);
+
+CREATE INDEX IF NOT EXISTS entry_documents_document ON entry_documents (document_id);
+
+-- Seuil de matérialité (tCO2e par entrée) au-delà duquel une pièce justificative est attendue.
+-- NULL = pas de contrôle.
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS materiality_threshold_tco2e NUMERIC(18,6);
+
+-- Cycle de vie des documents : suppression logique (fichier purgé après le délai de
+-- rétention) et remplacement par une nouvelle version, l'historique étant conservé.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS purged_at TIMESTAMPTZ;                -- fichier supprimé du stockage
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1;
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS version_of BIGINT REFERENCES documents(id) ON DELETE CASCADE; -- première version, NULL pour celle-ci
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS superseded_by BIGINT REFERENCES documents(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS documents_version_of ON documents (version_of);
+CREATE INDEX IF NOT EXISTS documents_pending_purge ON documents (deleted_at) WHERE deleted_at IS NOT NULL AND purged_at IS NULL;
+
+-- Les chemins de stockage sont désormais des clés relatives à la racine du stockage
+-- (local ou S3) : on retire l'ancien préfixe du répertoire local "uploads/".
+UPDATE documents SET storage_path = substr(storage_path, length('uploads/') + 1)
+WHERE storage_path LIKE 'uploads/%';
+
+-- Fichiers refusés par l'antivirus à l'upload : conservés hors des documents du tenant, pour examen.
+CREATE TABLE IF NOT EXISTS quarantined_uploads (
+    id            BIGSERIAL PRIMARY KEY,
+    tenant_id     BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    original_name TEXT NOT NULL,
+    mime_type     TEXT NOT NULL,
+    size_bytes    BIGINT NOT NULL,
+    storage_path  TEXT NOT NULL,          -- clé sous le préfixe "quarantine/" du stockage
+    signature     TEXT NOT NULL,          -- menace détectée, ex: "Eicar-Test-Signature"
+    uploaded_by   BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS quarantined_uploads_tenant ON quarantined_uploads (tenant_id, created_at);
+
+-- Déduplication : empreinte SHA-256 du contenu, qui sert aussi de clé de stockage.
+-- Un même contenu n'apparaît qu'une fois parmi les documents courants d'un tenant.
+ALTER TABLE documents ADD COLUMN IF NOT EXISTS sha256 TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS documents_tenant_sha256 ON documents (tenant_id, sha256)
+    WHERE sha256 IS NOT NULL AND deleted_at IS NULL AND superseded_by IS NULL;
+CREATE INDEX IF NOT EXISTS documents_storage_path ON documents (storage_path);
+
+-- Invitations de collègues dans un tenant. Le jeton envoyé à l'invité est signé
+-- et porte l'identifiant de l'invitation ; la ligne le rend révocable et à usage unique.
+CREATE TABLE IF NOT EXISTS invitations (
+    id               BIGSERIAL PRIMARY KEY,
+    tenant_id        BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    email            TEXT NOT NULL,
+    role             TEXT NOT NULL DEFAULT 'user',
+    invited_by       BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    expires_at       TIMESTAMPTZ NOT NULL,
+    accepted_at      TIMESTAMPTZ,
+    accepted_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    revoked_at       TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS invitations_tenant ON invitations (tenant_id, created_at);
+
+-- Contrôle d'accès par rôle : owner | admin | contributor | viewer | auditor.
+-- L'ancien rôle 'user' devient 'contributor' ; dans chaque tenant sans propriétaire,
+-- le premier administrateur (créateur du tenant) devient propriétaire.
+ALTER TABLE users ALTER COLUMN role SET DEFAULT 'contributor';
+ALTER TABLE invitations ALTER COLUMN role SET DEFAULT 'contributor';
+UPDATE users SET role = 'contributor' WHERE role = 'user';
+UPDATE invitations SET role = 'contributor' WHERE role = 'user';
+UPDATE users SET role = 'owner'
+WHERE id IN (
+    SELECT DISTINCT ON (u.tenant_id) u.id
+    FROM users u
+    WHERE u.role = 'admin'
+      AND NOT EXISTS (SELECT 1 FROM users o WHERE o.tenant_id = u.tenant_id AND o.role = 'owner')
+    ORDER BY u.tenant_id, u.created_at, u.id
+);
+
+-- Sessions de connexion. Le token d'accès (JWT de courte durée) porte l'identifiant
+-- de session, vérifié à chaque requête : une session révoquée n'est plus acceptée.
+CREATE TABLE IF NOT EXISTS sessions (
+    id             BIGSERIAL PRIMARY KEY,
+    user_id        BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    tenant_id      BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    user_agent     TEXT,
+    ip             TEXT,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at     TIMESTAMPTZ,
+    revoked_reason TEXT               -- 'logout' | 'logout_all' | 'refresh_reuse'
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user ON sessions (user_id) WHERE revoked_at IS NULL;
+
+-- Jetons de rafraîchissement : à usage unique, seule leur empreinte SHA-256 est stockée.
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    session_id BIGINT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,          -- renseigné lors de la rotation ; une réutilisation révoque la session
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_session ON refresh_tokens (session_id);
+
+-- Récupération du compte : adresse email confirmée et jetons à usage unique
+-- (réinitialisation du mot de passe, vérification de l'adresse) envoyés par email.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS user_tokens (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose    TEXT NOT NULL,        -- 'password_reset' | 'email_verification'
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS user_tokens_user ON user_tokens (user_id, purpose);
+
+-- Connexion unique OpenID Connect, configurée par tenant.
+CREATE TABLE IF NOT EXISTS tenant_sso (
+    tenant_id       BIGINT PRIMARY KEY REFERENCES tenants(id) ON DELETE CASCADE,
+    issuer          TEXT NOT NULL,                       -- ex: https://login.microsoftonline.com/<tenant>/v2.0
+    client_id       TEXT NOT NULL,
+    client_secret   TEXT NOT NULL DEFAULT '',
+    allowed_domains TEXT[] NOT NULL,                     -- domaines email acceptés, ex: {'acme.fr'}
+    default_role    TEXT NOT NULL DEFAULT 'contributor', -- rôle des comptes créés sans groupe associé
+    groups_claim    TEXT NOT NULL DEFAULT 'groups',
+    group_roles     JSONB NOT NULL DEFAULT '{}'::jsonb,  -- groupe du fournisseur -> rôle, ex: {"Carbone-Admins": "admin"}
+    enabled         BOOLEAN NOT NULL DEFAULT true,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Connexions SSO en cours (state, nonce et code_verifier PKCE), à usage unique.
+CREATE TABLE IF NOT EXISTS sso_login_states (
+    state         TEXT PRIMARY KEY,
+    tenant_id     BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    nonce         TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    return_to     TEXT NOT NULL,
+    expires_at    TIMESTAMPTZ NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Double authentification (TOTP) : secret chiffré (AES-GCM), actif une fois le
+-- premier code confirmé ; totp_last_step empêche de rejouer un code.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+-- Double authentification imposée aux membres du tenant.
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS require_mfa BOOLEAN NOT NULL DEFAULT false;
+
+-- Mode d'authentification de la session : 'password' | 'mfa' | 'sso'.
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS auth_method TEXT NOT NULL DEFAULT 'password';
+
+-- Codes de récupération (à usage unique), seule leur empreinte SHA-256 est stockée.
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash  TEXT NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (user_id, code_hash)
+);
+
+-- Clés d'API des intégrations : limitées à un tenant et à des scopes
+-- ('entries:read' | 'entries:write' | 'emissions:read'), seule l'empreinte
+-- SHA-256 de la clé est stockée.
+CREATE TABLE IF NOT EXISTS api_keys (
+    id           BIGSERIAL PRIMARY KEY,
+    tenant_id    BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name         TEXT NOT NULL,
+    prefix       TEXT NOT NULL,            -- début de la clé, affiché pour la reconnaître
+    key_hash     TEXT NOT NULL UNIQUE,
+    scopes       TEXT[] NOT NULL,
+    expires_at   TIMESTAMPTZ,              -- NULL = sans expiration
+    last_used_at TIMESTAMPTZ,
+    last_used_ip TEXT,
+    created_by   BIGINT REFERENCES users(id) ON DELETE SET NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS api_keys_tenant ON api_keys (tenant_id);
+
+-- Identité SSO du compte : (issuer, sub) enregistrée à la première connexion
+-- SSO ; les connexions suivantes doivent présenter la même identité.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sso_issuer TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sso_subject TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_sso_identity ON users (sso_issuer, sso_subject) WHERE sso_subject IS NOT NULL;
+
+-- Domaines revendiqués par la configuration SSO : un domaine ne sert à la
+-- découverte et à la connexion qu'une fois sa propriété prouvée par un
+-- enregistrement DNS TXT, et ne peut être vérifié que par un seul tenant.
+CREATE TABLE IF NOT EXISTS tenant_sso_domains (
+    tenant_id          BIGINT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    domain             TEXT NOT NULL,
+    verification_token TEXT NOT NULL,
+    verified_at        TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (tenant_id, domain)
+);
 
+CREATE UNIQUE INDEX IF NOT EXISTS tenant_sso_domains_verified ON tenant_sso_domains (domain) WHERE verified_at IS NOT NULL;
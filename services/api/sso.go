@@ -0,0 +1,759 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Connexion unique (SSO) OpenID Connect, configurée par tenant : flux
+// « authorization code » avec PKCE, création des comptes à la première
+// connexion et attribution du rôle selon les groupes du fournisseur d'identité.
+// Un compte est rattaché à l'identité (issuer, sub) de sa première connexion
+// SSO, et un domaine email ne compte qu'une fois sa propriété prouvée par un
+// enregistrement DNS TXT.
+
+// ssoLoginTTL borne la durée entre la redirection vers le fournisseur et le retour.
+const ssoLoginTTL = 10 * time.Minute
+
+// ssoRoleRank départage les rôles quand plusieurs groupes correspondent : le
+// plus élevé l'emporte. Le rôle propriétaire n'est jamais attribué par SSO.
+var ssoRoleRank = map[string]int{RoleViewer: 1, RoleAuditor: 2, RoleContributor: 3, RoleAdmin: 4}
+
+// ssoDomainTXTPrefix précède le jeton de vérification dans l'enregistrement
+// TXT publié sur le domaine.
+const ssoDomainTXTPrefix = "carbonv2-domain-verification="
+
+type SSOHandler struct {
+	db        *pgxpool.Pool
+	cfg       Config
+	auth      *AuthHandler
+	oidc      *oidcClient
+	lookupTXT func(ctx context.Context, name string) ([]string, error)
+}
+
+func NewSSOHandler(db *pgxpool.Pool, cfg Config, auth *AuthHandler) *SSOHandler {
+	return &SSOHandler{db: db, cfg: cfg, auth: auth, oidc: newOIDCClient(), lookupTXT: net.DefaultResolver.LookupTXT}
+}
+
+// ssoDomain est l'état de vérification d'un domaine de la configuration SSO.
+type ssoDomain struct {
+	Domain     string     `json:"domain"`
+	Verified   bool       `json:"verified"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	TXTRecord  string     `json:"txt_record"` // valeur TXT à publier sur le domaine
+}
+
+// ssoConfig est la configuration OIDC d'un tenant. Le secret client n'est
+// jamais renvoyé par l'API.
+type ssoConfig struct {
+	Issuer          string            `json:"issuer"`
+	ClientID        string            `json:"client_id"`
+	ClientSecret    string            `json:"client_secret,omitempty"`
+	HasClientSecret bool              `json:"has_client_secret"`
+	AllowedDomains  []string          `json:"allowed_domains"`
+	DefaultRole     string            `json:"default_role"`
+	GroupsClaim     string            `json:"groups_claim"`
+	GroupRoles      map[string]string `json:"group_roles"`
+	Enabled         bool              `json:"enabled"`
+	RedirectURI     string            `json:"redirect_uri,omitempty"` // à déclarer chez le fournisseur
+	Domains         []ssoDomain       `json:"domains,omitempty"`      // vérification des domaines autorisés
+	UpdatedAt       *time.Time        `json:"updated_at,omitempty"`
+}
+
+// loadSSOConfig charge la configuration SSO du tenant (pgx.ErrNoRows si absente).
+func (h *SSOHandler) loadSSOConfig(ctx context.Context, tenantID int64) (ssoConfig, error) {
+	var cfg ssoConfig
+	var groupRoles []byte
+	err := h.db.QueryRow(ctx,
+		`SELECT issuer, client_id, client_secret, allowed_domains, default_role, groups_claim, group_roles, enabled, updated_at
+		 FROM tenant_sso WHERE tenant_id = $1`,
+		tenantID,
+	).Scan(&cfg.Issuer, &cfg.ClientID, &cfg.ClientSecret, &cfg.AllowedDomains, &cfg.DefaultRole,
+		&cfg.GroupsClaim, &groupRoles, &cfg.Enabled, &cfg.UpdatedAt)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(groupRoles, &cfg.GroupRoles); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+// loadSSODomains renvoie l'état de vérification des domaines du tenant.
+func (h *SSOHandler) loadSSODomains(ctx context.Context, tenantID int64) ([]ssoDomain, error) {
+	rows, err := h.db.Query(ctx,
+		`SELECT domain, verification_token, verified_at FROM tenant_sso_domains
+		 WHERE tenant_id = $1 ORDER BY domain`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := []ssoDomain{}
+	for rows.Next() {
+		var d ssoDomain
+		var token string
+		if err := rows.Scan(&d.Domain, &token, &d.VerifiedAt); err != nil {
+			return nil, err
+		}
+		d.Verified = d.VerifiedAt != nil
+		d.TXTRecord = ssoDomainTXTPrefix + token
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// callbackURL est l'URL de retour déclarée chez le fournisseur d'identité.
+func (h *SSOHandler) callbackURL(c *gin.Context) string {
+	base := h.cfg.PublicURL
+	if base == "" {
+		scheme := "http"
+		if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+			scheme = "https"
+		}
+		base = scheme + "://" + c.Request.Host
+	}
+	return strings.TrimRight(base, "/") + "/api/auth/sso/callback"
+}
+
+// GET /api/tenants/:tenantId/sso
+func (h *SSOHandler) GetConfig(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	cfg, err := h.loadSSOConfig(ctx, tenantID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO non configuré pour ce tenant", "details": gin.H{"redirect_uri": h.callbackURL(c)}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de la configuration SSO"})
+		return
+	}
+	if cfg.Domains, err = h.loadSSODomains(ctx, tenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des domaines SSO"})
+		return
+	}
+	cfg.HasClientSecret = cfg.ClientSecret != ""
+	cfg.ClientSecret = ""
+	cfg.RedirectURI = h.callbackURL(c)
+	c.JSON(http.StatusOK, cfg)
+}
+
+// PUT /api/tenants/:tenantId/sso
+// Crée ou met à jour la configuration OIDC (propriétaires uniquement). Un
+// client_secret vide conserve le secret existant. Le fournisseur est interrogé
+// pour valider l'issuer. Chaque nouveau domaine reçoit un jeton de
+// vérification à publier en TXT (voir VerifyDomain).
+func (h *SSOHandler) UpdateConfig(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req ssoConfig
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	req.Issuer = strings.TrimRight(strings.TrimSpace(req.Issuer), "/")
+	req.ClientID = strings.TrimSpace(req.ClientID)
+	if !validIssuer(req.Issuer) || req.ClientID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "issuer (URL HTTPS) et client_id sont requis"})
+		return
+	}
+	domains := make([]string, 0, len(req.AllowedDomains))
+	for _, d := range req.AllowedDomains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if d != "" && !containsString(domains, d) {
+			domains = append(domains, d)
+		}
+	}
+	if len(domains) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "au moins un domaine email autorisé est requis"})
+		return
+	}
+	if req.DefaultRole == "" {
+		req.DefaultRole = RoleContributor
+	}
+	if _, ok := ssoRoleRank[req.DefaultRole]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "default_role invalide", "details": gin.H{"roles": []string{RoleAdmin, RoleContributor, RoleViewer, RoleAuditor}}})
+		return
+	}
+	for group, role := range req.GroupRoles {
+		if _, ok := ssoRoleRank[role]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "rôle invalide pour le groupe " + group})
+			return
+		}
+	}
+	if req.GroupRoles == nil {
+		req.GroupRoles = map[string]string{}
+	}
+	if req.GroupsClaim == "" {
+		req.GroupsClaim = "groups"
+	}
+	groupRoles, _ := json.Marshal(req.GroupRoles)
+	tokens := make([]string, len(domains))
+	for i := range tokens {
+		token, err := randomToken(16)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+			return
+		}
+		tokens[i] = token
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	if err := h.oidc.checkIssuer(ctx, req.Issuer); err != nil {
+		log.Printf("SSO tenant %d : issuer %s refusé : %v", tenantID, req.Issuer, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "issuer OIDC refusé : il doit être hébergé sur une adresse publique"})
+		return
+	}
+
+	// Un domaine vérifié par un autre tenant ne peut pas être revendiqué.
+	var taken string
+	err := h.db.QueryRow(ctx,
+		`SELECT domain FROM tenant_sso_domains
+		 WHERE tenant_id <> $1 AND verified_at IS NOT NULL AND domain = ANY($2) LIMIT 1`,
+		tenantID, domains,
+	).Scan(&taken)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "domaine déjà utilisé par un autre tenant", "details": gin.H{"domain": taken}})
+		return
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification des domaines"})
+		return
+	}
+
+	if req.Enabled {
+		// Le détail de l'erreur reste dans les journaux : il décrirait le
+		// réseau vu depuis le serveur.
+		if _, err := h.oidc.provider(ctx, req.Issuer); err != nil {
+			log.Printf("SSO tenant %d : %v", tenantID, err)
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "fournisseur OIDC injoignable ou invalide"})
+			return
+		}
+	}
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO tenant_sso (tenant_id, issuer, client_id, client_secret, allowed_domains, default_role,
+		                         groups_claim, group_roles, enabled)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		 ON CONFLICT (tenant_id) DO UPDATE SET
+		     issuer = EXCLUDED.issuer,
+		     client_id = EXCLUDED.client_id,
+		     client_secret = COALESCE(NULLIF(EXCLUDED.client_secret, ''), tenant_sso.client_secret),
+		     allowed_domains = EXCLUDED.allowed_domains,
+		     default_role = EXCLUDED.default_role,
+		     groups_claim = EXCLUDED.groups_claim,
+		     group_roles = EXCLUDED.group_roles,
+		     enabled = EXCLUDED.enabled,
+		     updated_at = now()`,
+		tenantID, req.Issuer, req.ClientID, req.ClientSecret, domains, req.DefaultRole,
+		req.GroupsClaim, groupRoles, req.Enabled,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer la configuration SSO", "details": err.Error()})
+		return
+	}
+	// Les domaines retirés perdent leur vérification ; les domaines déjà
+	// présents gardent leur jeton.
+	if _, err := tx.Exec(ctx,
+		`DELETE FROM tenant_sso_domains WHERE tenant_id = $1 AND NOT (domain = ANY($2))`,
+		tenantID, domains,
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer les domaines SSO"})
+		return
+	}
+	for i, d := range domains {
+		if _, err := tx.Exec(ctx,
+			`INSERT INTO tenant_sso_domains (tenant_id, domain, verification_token)
+			 VALUES ($1,$2,$3)
+			 ON CONFLICT (tenant_id, domain) DO NOTHING`,
+			tenantID, d, tokens[i],
+		); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer les domaines SSO"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer la configuration SSO"})
+		return
+	}
+
+	h.GetConfig(c)
+}
+
+// DELETE /api/tenants/:tenantId/sso
+// Supprime la configuration SSO et libère ses domaines (propriétaires uniquement).
+func (h *SSOHandler) DeleteConfig(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := h.db.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `DELETE FROM tenant_sso WHERE tenant_id = $1`, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer la configuration SSO"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO non configuré pour ce tenant"})
+		return
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM tenant_sso_domains WHERE tenant_id = $1`, tenantID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer la configuration SSO"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer la configuration SSO"})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /api/tenants/:tenantId/sso/domains/:domain/verify
+// Vérifie la propriété d'un domaine de la configuration SSO : l'enregistrement
+// TXT renvoyé par GetConfig doit être publié sur le domaine (propriétaires
+// uniquement). Un domaine ne peut être vérifié que par un seul tenant.
+func (h *SSOHandler) VerifyDomain(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	domain := strings.ToLower(strings.TrimSpace(c.Param("domain")))
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	d := ssoDomain{Domain: domain}
+	var token string
+	err := h.db.QueryRow(ctx,
+		`SELECT verification_token, verified_at FROM tenant_sso_domains WHERE tenant_id = $1 AND domain = $2`,
+		tenantID, domain,
+	).Scan(&token, &d.VerifiedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "domaine absent de la configuration SSO"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération du domaine"})
+		return
+	}
+	d.TXTRecord = ssoDomainTXTPrefix + token
+
+	if d.VerifiedAt == nil {
+		records, err := h.lookupTXT(ctx, domain)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "lecture des enregistrements DNS impossible", "details": err.Error()})
+			return
+		}
+		found := false
+		for _, r := range records {
+			if strings.TrimSpace(r) == d.TXTRecord {
+				found = true
+			}
+		}
+		if !found {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "enregistrement TXT de vérification introuvable", "details": gin.H{"txt_record": d.TXTRecord}})
+			return
+		}
+		err = h.db.QueryRow(ctx,
+			`UPDATE tenant_sso_domains SET verified_at = now()
+			 WHERE tenant_id = $1 AND domain = $2
+			 RETURNING verified_at`,
+			tenantID, domain,
+		).Scan(&d.VerifiedAt)
+		if isUniqueViolation(err) {
+			c.JSON(http.StatusConflict, gin.H{"error": "domaine déjà vérifié par un autre tenant", "details": gin.H{"domain": domain}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible d'enregistrer la vérification du domaine"})
+			return
+		}
+	}
+	d.Verified = true
+	c.JSON(http.StatusOK, d)
+}
+
+// GET /api/auth/sso/discover?email=...
+// Indique si l'adresse relève d'un tenant connecté par SSO (domaine vérifié),
+// et l'URL de connexion.
+func (h *SSOHandler) Discover(c *gin.Context) {
+	email := strings.ToLower(strings.TrimSpace(c.Query("email")))
+	at := strings.LastIndex(email, "@")
+	if at < 0 || at == len(email)-1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "email invalide"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var tenantID int64
+	err := h.db.QueryRow(ctx,
+		`SELECT s.tenant_id FROM tenant_sso s
+		 JOIN tenant_sso_domains d ON d.tenant_id = s.tenant_id
+		 WHERE s.enabled AND d.domain = $1 AND d.verified_at IS NOT NULL`,
+		email[at+1:],
+	).Scan(&tenantID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "aucun SSO pour ce domaine"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tenant_id": tenantID,
+		"login_url": "/api/auth/sso/" + toStringID(tenantID) + "/login",
+	})
+}
+
+// GET /api/auth/sso/:tenantId/login?return_to=...
+// Redirige vers le fournisseur d'identité du tenant. return_to est la page
+// du frontend qui recevra les tokens (dans le fragment de l'URL).
+func (h *SSOHandler) Login(c *gin.Context) {
+	tenantID, ok := int64Param(c, "tenantId")
+	if !ok {
+		return
+	}
+	returnTo := c.DefaultQuery("return_to", h.cfg.AppURL+"/sso/callback")
+	if returnTo != h.cfg.AppURL && !strings.HasPrefix(returnTo, h.cfg.AppURL+"/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "return_to doit pointer vers l'application"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	cfg, err := h.loadSSOConfig(ctx, tenantID)
+	if errors.Is(err, pgx.ErrNoRows) || (err == nil && !cfg.Enabled) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "SSO non activé pour ce tenant"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de la configuration SSO"})
+		return
+	}
+	provider, err := h.oidc.provider(ctx, cfg.Issuer)
+	if err != nil {
+		log.Printf("SSO tenant %d : %v", tenantID, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "fournisseur d'identité injoignable"})
+		return
+	}
+
+	state, err1 := randomToken(32)
+	nonce, err2 := randomToken(32)
+	verifier, err3 := randomToken(48)
+	if err1 != nil || err2 != nil || err3 != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+	if _, err := h.db.Exec(ctx,
+		`INSERT INTO sso_login_states (state, tenant_id, nonce, code_verifier, return_to, expires_at)
+		 VALUES ($1,$2,$3,$4,$5,$6)`,
+		state, tenantID, nonce, verifier, returnTo, time.Now().Add(ssoLoginTTL),
+	); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de démarrer la connexion SSO"})
+		return
+	}
+
+	authURL, err := url.Parse(provider.AuthorizationEndpoint)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "fournisseur d'identité invalide"})
+		return
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", cfg.ClientID)
+	q.Set("redirect_uri", h.callbackURL(c))
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", pkceChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	authURL.RawQuery = q.Encode()
+
+	c.Redirect(http.StatusFound, authURL.String())
+}
+
+// GET /api/auth/sso/callback?code=...&state=...
+// Retour du fournisseur : vérifie l'ID token, crée ou met à jour le compte,
+// ouvre une session et redirige vers le frontend avec les tokens.
+func (h *SSOHandler) Callback(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	// Le state est à usage unique.
+	var tenantID int64
+	var nonce, verifier, returnTo string
+	err := h.db.QueryRow(ctx,
+		`DELETE FROM sso_login_states WHERE state = $1 AND expires_at > now()
+		 RETURNING tenant_id, nonce, code_verifier, return_to`,
+		c.Query("state"),
+	).Scan(&tenantID, &nonce, &verifier, &returnTo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "connexion SSO expirée ou invalide, recommencez"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur interne"})
+		return
+	}
+
+	fail := func(code, logMsg string) {
+		log.Printf("SSO tenant %d : %s", tenantID, logMsg)
+		c.Redirect(http.StatusFound, returnTo+"#"+url.Values{"sso_error": {code}}.Encode())
+	}
+	if idpErr := c.Query("error"); idpErr != "" {
+		fail("idp_error", idpErr+" "+c.Query("error_description"))
+		return
+	}
+
+	cfg, err := h.loadSSOConfig(ctx, tenantID)
+	if err != nil || !cfg.Enabled {
+		fail("sso_disabled", "configuration absente ou désactivée")
+		return
+	}
+	provider, err := h.oidc.provider(ctx, cfg.Issuer)
+	if err != nil {
+		fail("idp_unreachable", err.Error())
+		return
+	}
+	rawIDToken, err := h.oidc.exchangeCode(ctx, provider, cfg.ClientID, cfg.ClientSecret, c.Query("code"), h.callbackURL(c), verifier)
+	if err != nil {
+		fail("code_exchange_failed", err.Error())
+		return
+	}
+	claims, err := h.oidc.verifyIDToken(ctx, provider, cfg.ClientID, nonce, rawIDToken)
+	if err != nil {
+		fail("invalid_id_token", err.Error())
+		return
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		fail("subject_missing", "ID token sans sub")
+		return
+	}
+	email := ssoEmail(claims)
+	if email == "" {
+		fail("email_missing", "ID token sans email")
+		return
+	}
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		fail("email_not_verified", "email non vérifié par le fournisseur : "+email)
+		return
+	}
+	var domainVerified bool
+	if err := h.db.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM tenant_sso_domains WHERE tenant_id = $1 AND domain = $2 AND verified_at IS NOT NULL)`,
+		tenantID, email[strings.LastIndex(email, "@")+1:],
+	).Scan(&domainVerified); err != nil {
+		fail("provisioning_failed", err.Error())
+		return
+	}
+	if !domainVerified {
+		fail("domain_not_allowed", "domaine non autorisé ou non vérifié : "+email)
+		return
+	}
+	mappedRole := ssoRoleFromGroups(cfg, claimStrings(claims, cfg.GroupsClaim))
+
+	userID, role, err := h.provisionUser(ctx, tenantID, cfg.Issuer, subject, email, mappedRole, cfg.DefaultRole)
+	if errors.Is(err, errSSOOtherTenant) {
+		fail("account_in_other_tenant", "compte "+email+" rattaché à un autre tenant")
+		return
+	}
+	if errors.Is(err, errSSOAccountNotLinked) {
+		fail("account_not_linked", "compte "+email+" non rattaché à l'identité "+cfg.Issuer+" "+subject)
+		return
+	}
+	if err != nil {
+		fail("provisioning_failed", err.Error())
+		return
+	}
+
+	resp, err := h.auth.startSession(c, userID, tenantID, role, authMethodSSO)
+	if err != nil {
+		fail("session_failed", err.Error())
+		return
+	}
+	fragment := url.Values{
+		"token":         {resp.Token},
+		"refresh_token": {resp.RefreshToken},
+		"expires_in":    {toStringID(resp.ExpiresIn)},
+	}
+	c.Redirect(http.StatusFound, returnTo+"#"+fragment.Encode())
+}
+
+var (
+	errSSOOtherTenant      = errors.New("compte rattaché à un autre tenant")
+	errSSOAccountNotLinked = errors.New("compte non rattaché à cette identité SSO")
+)
+
+// provisionUser renvoie le compte rattaché à l'identité (issuer, sub), ou à
+// défaut celui de l'email, créé à la première connexion. Un compte existant
+// est rattaché à l'identité à sa première connexion SSO, sauf s'il appartient
+// à un propriétaire ou est protégé par la double authentification : ces
+// comptes ne se connectent pas par SSO. Le rôle issu des groupes est appliqué
+// à chaque connexion, sauf aux propriétaires ; sans groupe correspondant, un
+// compte existant garde son rôle.
+func (h *SSOHandler) provisionUser(ctx context.Context, tenantID int64, issuer, subject, email, mappedRole, defaultRole string) (int64, string, error) {
+	var userID int64
+	var account ssoAccount
+	err := h.db.QueryRow(ctx,
+		`SELECT id, tenant_id, role, sso_issuer, sso_subject, totp_enabled_at IS NOT NULL
+		 FROM users
+		 WHERE (sso_issuer = $1 AND sso_subject = $2) OR email = $3
+		 ORDER BY (sso_issuer = $1 AND sso_subject = $2) IS TRUE DESC
+		 LIMIT 1`,
+		issuer, subject, email,
+	).Scan(&userID, &account.TenantID, &account.Role, &account.Issuer, &account.Subject, &account.TOTPEnabled)
+	if err == nil {
+		link, err := ssoLink(account, tenantID, issuer, subject)
+		if err != nil {
+			return 0, "", err
+		}
+		if link {
+			tag, err := h.db.Exec(ctx,
+				`UPDATE users SET sso_issuer = $2, sso_subject = $3 WHERE id = $1 AND sso_subject IS NULL`,
+				userID, issuer, subject,
+			)
+			if isUniqueViolation(err) || (err == nil && tag.RowsAffected() == 0) {
+				return 0, "", errSSOAccountNotLinked
+			}
+			if err != nil {
+				return 0, "", err
+			}
+		}
+		role := account.Role
+		if mappedRole != "" && mappedRole != role && role != RoleOwner {
+			if _, err := h.db.Exec(ctx, `UPDATE users SET role = $2 WHERE id = $1`, userID, mappedRole); err != nil {
+				return 0, "", err
+			}
+			role = mappedRole
+		}
+		_, err = h.db.Exec(ctx, `UPDATE users SET email_verified_at = COALESCE(email_verified_at, now()) WHERE id = $1`, userID)
+		return userID, role, err
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", err
+	}
+
+	role := defaultRole
+	if mappedRole != "" {
+		role = mappedRole
+	}
+	// Mot de passe aléatoire inconnu : le compte se connecte par SSO (ou après
+	// une réinitialisation du mot de passe).
+	secret, err := randomToken(32)
+	if err != nil {
+		return 0, "", err
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, "", err
+	}
+	err = h.db.QueryRow(ctx,
+		`INSERT INTO users (tenant_id, email, role, password_hash, email_verified_at, sso_issuer, sso_subject)
+		 VALUES ($1,$2,$3,$4,now(),$5,$6) RETURNING id`,
+		tenantID, email, role, string(passwordHash), issuer, subject,
+	).Scan(&userID)
+	if isUniqueViolation(err) {
+		return 0, "", errSSOOtherTenant
+	}
+	return userID, role, err
+}
+
+// ssoAccount est le compte existant trouvé pour une connexion SSO.
+type ssoAccount struct {
+	TenantID    int64
+	Role        string
+	TOTPEnabled bool
+	Issuer      *string // identité SSO rattachée, nil si aucune
+	Subject     *string
+}
+
+// ssoLink indique si la connexion de l'identité (issuer, sub) peut utiliser le
+// compte, et s'il faut d'abord l'y rattacher (première connexion SSO).
+func ssoLink(a ssoAccount, tenantID int64, issuer, subject string) (link bool, err error) {
+	if a.TenantID != tenantID {
+		return false, errSSOOtherTenant
+	}
+	if a.Subject == nil {
+		if a.Role == RoleOwner || a.TOTPEnabled {
+			return false, errSSOAccountNotLinked
+		}
+		return true, nil
+	}
+	if a.Issuer == nil || *a.Issuer != issuer || *a.Subject != subject {
+		// Le compte trouvé par email est rattaché à une autre identité.
+		return false, errSSOAccountNotLinked
+	}
+	return false, nil
+}
+
+// ssoEmail lit l'email de l'ID token ("email", ou "preferred_username" chez Azure AD).
+func ssoEmail(claims jwt.MapClaims) string {
+	for _, name := range []string{"email", "preferred_username", "upn"} {
+		if v, _ := claims[name].(string); strings.Contains(v, "@") {
+			return strings.ToLower(strings.TrimSpace(v))
+		}
+	}
+	return ""
+}
+
+// ssoRoleFromGroups renvoie le rôle le plus élevé associé aux groupes de
+// l'utilisateur, ou "" si aucun groupe n'est associé à un rôle.
+func ssoRoleFromGroups(cfg ssoConfig, groups []string) string {
+	best := ""
+	for _, g := range groups {
+		if role, ok := cfg.GroupRoles[g]; ok && ssoRoleRank[role] > ssoRoleRank[best] {
+			best = role
+		}
+	}
+	return best
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Stockage des fichiers des documents. Les clés sont relatives à la racine du
+// stockage (ex: "tenant_12/20240105-101500-facture.pdf") et enregistrées dans
+// documents.storage_path.
+
+// DocumentStorage est implémenté par le disque local et par un stockage objet
+// compatible S3 (AWS, MinIO, Scaleway, OVHcloud...).
+type DocumentStorage interface {
+	// Put enregistre le contenu sous la clé donnée et renvoie le nombre d'octets écrits.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error)
+	// Open renvoie le contenu et sa taille ; errStorageNotFound si la clé n'existe pas.
+	Open(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	// Delete supprime la clé ; une clé absente n'est pas une erreur.
+	Delete(ctx context.Context, key string) error
+	// PresignGet renvoie une URL de téléchargement temporaire, sans authentification.
+	PresignGet(ctx context.Context, key string, expires time.Duration, filename, contentType string) (string, error)
+}
+
+var errStorageNotFound = errors.New("fichier absent du stockage")
+
+// NewDocumentStorage construit le stockage choisi par la configuration.
+func NewDocumentStorage(cfg Config) (DocumentStorage, error) {
+	switch cfg.StorageBackend {
+	case "", "local":
+		return &LocalStorage{Root: cfg.StorageLocalDir, Secret: []byte(cfg.JWTSecret), PublicURL: cfg.PublicURL}, nil
+	case "s3":
+		if cfg.S3Bucket == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+			return nil, errors.New("S3_BUCKET, S3_ACCESS_KEY_ID et S3_SECRET_ACCESS_KEY sont requis pour le stockage s3")
+		}
+		endpoint, err := url.Parse(cfg.S3Endpoint)
+		if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT invalide : %q", cfg.S3Endpoint)
+		}
+		return &S3Storage{
+			Endpoint:  endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKeyID,
+			SecretKey: cfg.S3SecretAccessKey,
+			PathStyle: cfg.S3PathStyle,
+			Client:    &http.Client{Timeout: 60 * time.Second},
+		}, nil
+	default:
+		return nil, fmt.Errorf("STORAGE_BACKEND inconnu : %q (attendu 'local' ou 's3')", cfg.StorageBackend)
+	}
+}
+
+// documentStorageKey construit une clé horodatée pour un fichier du tenant (les
+// documents eux-mêmes sont stockés sous leur empreinte, voir documentContentKey).
+func documentStorageKey(tenantID int64, originalName string) string {
+	return fmt.Sprintf("tenant_%d/%s-%s", tenantID, time.Now().Format("20060102-150405.000000"), sanitizeFilename(originalName))
+}
+
+// LocalStorage stocke les fichiers sur le disque local. Les URL présignées
+// pointent vers l'API elle-même (GET /api/files), signées par HMAC.
+type LocalStorage struct {
+	Root      string
+	Secret    []byte
+	PublicURL string // préfixe des URL présignées, ex: "https://api.example.com" (vide = chemin relatif)
+}
+
+func (s *LocalStorage) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)[1:]
+	if clean == "" || clean != key {
+		return "", fmt.Errorf("clé de stockage invalide : %q", key)
+	}
+	return filepath.Join(s.Root, clean), nil
+}
+
+func (s *LocalStorage) Put(_ context.Context, key string, r io.Reader, _ int64, _ string) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return 0, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(p)
+		return 0, err
+	}
+	return written, nil
+}
+
+func (s *LocalStorage) Open(_ context.Context, key string) (io.ReadCloser, int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, errStorageNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+func (s *LocalStorage) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalStorage) PresignGet(_ context.Context, key string, expires time.Duration, filename, contentType string) (string, error) {
+	q := url.Values{}
+	q.Set("key", key)
+	q.Set("exp", strconv.FormatInt(time.Now().Add(expires).Unix(), 10))
+	q.Set("name", filename)
+	q.Set("type", contentType)
+	q.Set("sig", s.signature(q))
+	return strings.TrimRight(s.PublicURL, "/") + "/api/files?" + q.Encode(), nil
+}
+
+// signature couvre la clé, l'expiration, le nom et le type renvoyés.
+func (s *LocalStorage) signature(q url.Values) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	for _, k := range []string{"key", "exp", "name", "type"} {
+		mac.Write([]byte(q.Get(k)))
+		mac.Write([]byte{0})
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// GET /api/files?key=...&exp=...&sig=...
+// Sert un fichier du stockage local à partir d'une URL présignée (sans JWT).
+func (s *LocalStorage) ServeSigned(c *gin.Context) {
+	q := c.Request.URL.Query()
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil || !hmac.Equal([]byte(q.Get("sig")), []byte(s.signature(q))) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "signature invalide"})
+		return
+	}
+	if time.Now().Unix() > exp {
+		c.JSON(http.StatusForbidden, gin.H{"error": "lien expiré"})
+		return
+	}
+
+	rc, size, err := s.Open(c.Request.Context(), q.Get("key"))
+	if errors.Is(err, errStorageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "fichier introuvable"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de lire le fichier"})
+		return
+	}
+	defer rc.Close()
+
+	c.DataFromReader(http.StatusOK, size, q.Get("type"), rc, map[string]string{
+		"Content-Disposition":    contentDisposition("attachment", q.Get("name")),
+		"X-Content-Type-Options": "nosniff",
+		"Cache-Control":          "private, no-store",
+	})
+}
+
+// contentDisposition formate l'en-tête Content-Disposition ; FormatMediaType
+// encode les noms non ASCII (paramètre filename* en UTF-8, RFC 2231).
+func contentDisposition(disposition, filename string) string {
+	if h := mime.FormatMediaType(disposition, map[string]string{"filename": filename}); h != "" {
+		return h
+	}
+	return mime.FormatMediaType(disposition, map[string]string{"filename": sanitizeFilename(filename)})
+}
+
+// S3Storage stocke les fichiers dans un bucket compatible S3, via l'API REST
+// signée en AWS Signature Version 4.
+type S3Storage struct {
+	Endpoint  *url.URL // ex: https://s3.eu-west-3.amazonaws.com ou http://localhost:9000 (MinIO)
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	PathStyle bool // URL https://endpoint/bucket/key (MinIO) plutôt que https://bucket.endpoint/key
+	Client    *http.Client
+}
+
+const s3UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// objectURL renvoie l'URL de l'objet, en style chemin ou hôte virtuel.
+func (s *S3Storage) objectURL(key string) *url.URL {
+	u := *s.Endpoint
+	path := "/" + key
+	if s.PathStyle {
+		path = "/" + s.Bucket + path
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + path
+	u.RawPath = s3EscapePath(u.Path)
+	return &u
+}
+
+func (s *S3Storage) do(ctx context.Context, method, key string, body io.Reader, size int64, contentType string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.sign(req, time.Now().UTC())
+	return s.Client.Do(req)
+}
+
+func (s *S3Storage) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (int64, error) {
+	if size < 0 {
+		return 0, errors.New("taille du fichier inconnue")
+	}
+	resp, err := s.do(ctx, http.MethodPut, key, r, size, contentType)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, s3Error(resp)
+	}
+	return size, nil
+}
+
+func (s *S3Storage) Open(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, 0, "")
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, 0, errStorageNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, 0, s3Error(resp)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// PresignGet produit une URL présignée (SigV4, paramètres X-Amz-*) qui impose le
+// nom et le type du fichier téléchargé.
+func (s *S3Storage) PresignGet(_ context.Context, key string, expires time.Duration, filename, contentType string) (string, error) {
+	if expires <= 0 || expires > 7*24*time.Hour {
+		return "", errors.New("durée de validité hors de ]0, 7 jours]")
+	}
+	u := s.objectURL(key)
+	q := url.Values{}
+	if filename != "" {
+		q.Set("response-content-disposition", contentDisposition("attachment", filename))
+	}
+	if contentType != "" {
+		q.Set("response-content-type", contentType)
+	}
+	s.presign(u, q, time.Now().UTC(), expires)
+	return u.String(), nil
+}
+
+// presign ajoute à u les paramètres de signature d'une requête GET.
+func (s *S3Storage) presign(u *url.URL, q url.Values, now time.Time, expires time.Duration) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+
+	canonical := strings.Join([]string{
+		http.MethodGet,
+		s3EscapePath(u.Path),
+		s3CanonicalQuery(q),
+		"host:" + u.Host + "\n",
+		"host",
+		s3UnsignedPayload,
+	}, "\n")
+	u.RawQuery = s3CanonicalQuery(q) + "&X-Amz-Signature=" + s.signature(now, scope, amzDate, canonical)
+}
+
+// sign ajoute les en-têtes d'authentification SigV4 à une requête.
+func (s *S3Storage) sign(req *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", s3UnsignedPayload)
+
+	headers := map[string]string{
+		"host":                 req.URL.Host,
+		"x-amz-content-sha256": s3UnsignedPayload,
+		"x-amz-date":           amzDate,
+	}
+	if ct := req.Header.Get("Content-Type"); ct != "" {
+		headers["content-type"] = ct
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + strings.TrimSpace(headers[k]) + "\n")
+	}
+	signed := strings.Join(names, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signed,
+		s3UnsignedPayload,
+	}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signed, s.signature(now, scope, amzDate, canonical)))
+}
+
+func (s *S3Storage) signature(now time.Time, scope, amzDate, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape encode une chaîne selon les règles SigV4 (seuls A-Z a-z 0-9 - _ . ~
+// restent en clair) ; les « / » sont conservés si keepSlash.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', keepSlash && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3EscapePath(p string) string {
+	return s3Escape(p, true)
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Error résume une réponse d'erreur S3 (code XML et message).
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := strings.TrimSpace(string(body))
+	if i := strings.Index(msg, "<Code>"); i >= 0 {
+		if j := strings.Index(msg[i:], "</Code>"); j >= 0 {
+			msg = msg[i+len("<Code>") : i+j]
+		}
+	}
+	log.Printf("stockage S3 : %s %s", resp.Status, msg)
+	return fmt.Errorf("stockage S3 : %s (%s)", resp.Status, msg)
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// TargetsHandler gère les objectifs de réduction et le suivi de trajectoire.
+type TargetsHandler struct {
+	db *pgxpool.Pool
+}
+
+func NewTargetsHandler(db *pgxpool.Pool) *TargetsHandler {
+	return &TargetsHandler{db: db}
+}
+
+// Taux de réduction linéaire annuel minimal exigé par la SBTi pour une
+// trajectoire 1,5°C (approche de contraction absolue), en part des émissions
+// de l'année de référence.
+const sbti15AnnualRate = 0.042
+
+type createTargetRequest struct {
+	Name            string   `json:"name" binding:"required"`
+	BaseYear        int      `json:"base_year" binding:"required"`
+	TargetYear      int      `json:"target_year" binding:"required"`
+	Scopes          []string `json:"scopes" binding:"required"`
+	TargetType      string   `json:"target_type"`
+	IntensityMetric string   `json:"intensity_metric"`
+	ReductionPct    float64  `json:"reduction_pct" binding:"required"`
+	Pathway         string   `json:"pathway"`
+}
+
+// validate normalise la requête et renvoie un message d'erreur lisible si elle est incohérente.
+func (r *createTargetRequest) validate() string {
+	if r.TargetType == "" {
+		r.TargetType = "absolute"
+	}
+	if r.Pathway == "" {
+		r.Pathway = "linear"
+	}
+	if r.BaseYear < 1990 || r.TargetYear > 2100 || r.TargetYear <= r.BaseYear {
+		return "base_year doit être antérieure à target_year"
+	}
+	if r.ReductionPct <= 0 || r.ReductionPct > 100 {
+		return "reduction_pct doit être compris entre 0 et 100"
+	}
+	if len(r.Scopes) == 0 {
+		return "au moins un scope est requis"
+	}
+	for _, s := range r.Scopes {
+		if s != "1" && s != "2" && s != "3" {
+			return "scope invalide : " + s
+		}
+	}
+	slices.Sort(r.Scopes)
+	r.Scopes = slices.Compact(r.Scopes)
+	switch r.TargetType {
+	case "absolute":
+		r.IntensityMetric = ""
+	case "intensity":
+		if _, ok := intensityMetrics[r.IntensityMetric]; !ok {
+			return "intensity_metric doit valoir 'revenue', 'headcount', 'floor_area' ou 'production'"
+		}
+	default:
+		return "target_type doit valoir 'absolute' ou 'intensity'"
+	}
+	if r.Pathway != "linear" && r.Pathway != "sbti_1_5c" {
+		return "pathway doit valoir 'linear' ou 'sbti_1_5c'"
+	}
+	return ""
+}
+
+// POST /api/tenants/:tenantId/targets
+func (h *TargetsHandler) CreateTarget(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	var req createTargetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payload invalide", "details": err.Error()})
+		return
+	}
+	if msg := req.validate(); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var metric *string
+	if req.IntensityMetric != "" {
+		metric = &req.IntensityMetric
+	}
+
+	t := ReductionTarget{
+		TenantID:        tenantID,
+		Name:            req.Name,
+		BaseYear:        req.BaseYear,
+		TargetYear:      req.TargetYear,
+		Scopes:          req.Scopes,
+		TargetType:      req.TargetType,
+		IntensityMetric: metric,
+		ReductionPct:    req.ReductionPct,
+		Pathway:         req.Pathway,
+	}
+	err := h.db.QueryRow(ctx,
+		`INSERT INTO reduction_targets (tenant_id, name, base_year, target_year, scopes, target_type, intensity_metric, reduction_pct, pathway)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+		 RETURNING id, created_at`,
+		t.TenantID, t.Name, t.BaseYear, t.TargetYear, t.Scopes, t.TargetType, t.IntensityMetric, t.ReductionPct, t.Pathway,
+	).Scan(&t.ID, &t.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de créer l'objectif", "details": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, t)
+}
+
+// GET /api/tenants/:tenantId/targets
+func (h *TargetsHandler) ListTargets(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	targets, err := listTargets(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des objectifs"})
+		return
+	}
+
+	c.JSON(http.StatusOK, targets)
+}
+
+// DELETE /api/tenants/:tenantId/targets/:targetId
+func (h *TargetsHandler) DeleteTarget(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	targetID, ok := int64Param(c, "targetId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tag, err := h.db.Exec(ctx,
+		`DELETE FROM reduction_targets WHERE id = $1 AND tenant_id = $2`,
+		targetID, tenantID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de supprimer l'objectif"})
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "objectif non trouvé"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+type trajectoryPoint struct {
+	Year     int      `json:"year"`
+	Required float64  `json:"required"`
+	Actual   *float64 `json:"actual"`
+	Gap      *float64 `json:"gap"` // actual - required ; positif = retard sur la trajectoire
+	OnTrack  *bool    `json:"on_track"`
+}
+
+type trajectoryResponse struct {
+	Target ReductionTarget `json:"target"`
+	Unit   string          `json:"unit"`
+	// Valeur de l'année de référence (émissions ou intensité selon le type d'objectif).
+	BaseValue   float64 `json:"base_value"`
+	TargetValue float64 `json:"target_value"`
+	// Taux de réduction annuel de l'objectif déclaré (part de la valeur de référence).
+	AnnualReductionRate float64 `json:"annual_reduction_rate"`
+	// Ambition exigée par la trajectoire SBTi 1,5°C sur la même période.
+	SBTi   sbtiAlignment     `json:"sbti_1_5c"`
+	Points []trajectoryPoint `json:"points"`
+	// Dernière année disposant de données réelles, et écart restant à combler
+	// jusqu'à la valeur cible de l'objectif.
+	LatestYear      *int     `json:"latest_year"`
+	LatestActual    *float64 `json:"latest_actual"`
+	GapToTarget     *float64 `json:"gap_to_target"`
+	RequiredPerYear *float64 `json:"required_reduction_per_year"`
+	RemainingYears  int      `json:"remaining_years"`
+}
+
+// sbtiAlignment compare l'objectif à la trajectoire SBTi 1,5°C, sans la lui imposer.
+type sbtiAlignment struct {
+	RequiredAnnualRate   float64 `json:"required_annual_reduction_rate"`
+	RequiredReductionPct float64 `json:"required_reduction_pct"` // à l'année cible
+	Aligned              bool    `json:"aligned"`
+}
+
+// GET /api/tenants/:tenantId/targets/:targetId/trajectory
+// Compare les émissions annuelles réelles à la trajectoire requise par l'objectif.
+func (h *TargetsHandler) TargetTrajectory(c *gin.Context) {
+	tenantID, ok := tenantFromRequest(c)
+	if !ok {
+		return
+	}
+	targetID, ok := int64Param(c, "targetId")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	t, err := getTarget(ctx, h.db, tenantID, targetID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "objectif non trouvé"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération de l'objectif"})
+		return
+	}
+
+	yearly, err := yearlyEmissionsByScope(ctx, h.db, tenantID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des émissions"})
+		return
+	}
+
+	var dens map[int]map[string]IntensityDenominator
+	if t.TargetType == "intensity" {
+		if dens, err = loadDenominators(ctx, h.db, tenantID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la récupération des dénominateurs"})
+			return
+		}
+	}
+
+	// Valeurs réelles : émissions absolues, ou intensité pour les années disposant du dénominateur.
+	unit := "tCO2e"
+	actuals := make(map[int]float64)
+	for year, byScope := range yearly {
+		var sum float64
+		for _, s := range t.Scopes {
+			sum += byScope[s]
+		}
+		if t.TargetType == "intensity" {
+			r, ok := dens[year][*t.IntensityMetric].ratio(sum)
+			if !ok {
+				continue
+			}
+			sum, unit = r.Value, r.Unit
+		}
+		actuals[year] = sum
+	}
+
+	base, ok := actuals[t.BaseYear]
+	if !ok || base <= 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "aucune émission (ou aucun dénominateur) pour l'année de référence"})
+		return
+	}
+
+	c.JSON(http.StatusOK, buildTrajectory(t, unit, base, actuals))
+}
+
+// buildTrajectory calcule la trajectoire requise année par année entre base_year
+// et target_year et la confronte aux valeurs réelles disponibles. La trajectoire
+// suit l'objectif déclaré (reduction_pct à target_year), quel que soit son
+// pathway ; l'alignement SBTi 1,5°C est indiqué à part.
+func buildTrajectory(t ReductionTarget, unit string, base float64, actuals map[int]float64) trajectoryResponse {
+	years := float64(t.TargetYear - t.BaseYear)
+	rate := t.ReductionPct / 100 / years
+
+	resp := trajectoryResponse{
+		Target:              t,
+		Unit:                unit,
+		BaseValue:           base,
+		AnnualReductionRate: rate,
+		SBTi: sbtiAlignment{
+			RequiredAnnualRate:   sbti15AnnualRate,
+			RequiredReductionPct: math.Min(sbti15AnnualRate*years*100, 100),
+			Aligned:              sbtiAligned(t),
+		},
+	}
+
+	lastYear := t.TargetYear
+	for year := range actuals {
+		if year > lastYear {
+			lastYear = year
+		}
+	}
+
+	for year := t.BaseYear; year <= lastYear; year++ {
+		elapsed := float64(min(year, t.TargetYear) - t.BaseYear)
+		required := math.Max(base*(1-rate*elapsed), 0)
+		p := trajectoryPoint{Year: year, Required: required}
+		if actual, ok := actuals[year]; ok {
+			a := actual
+			gap := actual - required
+			onTrack := gap <= 0
+			p.Actual, p.Gap, p.OnTrack = &a, &gap, &onTrack
+
+			if resp.LatestYear == nil || year > *resp.LatestYear {
+				y := year
+				resp.LatestYear, resp.LatestActual = &y, &a
+			}
+		}
+		resp.Points = append(resp.Points, p)
+	}
+	resp.TargetValue = resp.Points[t.TargetYear-t.BaseYear].Required
+
+	if resp.LatestYear != nil {
+		gap := math.Max(*resp.LatestActual-resp.TargetValue, 0)
+		resp.GapToTarget = &gap
+		resp.RemainingYears = max(t.TargetYear-*resp.LatestYear, 0)
+		perYear := gap
+		if resp.RemainingYears > 0 {
+			perYear = gap / float64(resp.RemainingYears)
+		}
+		resp.RequiredPerYear = &perYear
+	}
+
+	return resp
+}
+
+// sbtiAligned indique si l'ambition de l'objectif atteint au moins la réduction
+// linéaire de 4,2%/an exigée par la SBTi pour une trajectoire 1,5°C.
+func sbtiAligned(t ReductionTarget) bool {
+	years := float64(t.TargetYear - t.BaseYear)
+	return t.ReductionPct/100 >= sbti15AnnualRate*years-1e-9 || t.ReductionPct >= 100
+}
+
+func getTarget(ctx context.Context, db *pgxpool.Pool, tenantID, targetID int64) (ReductionTarget, error) {
+	var t ReductionTarget
+	err := db.QueryRow(ctx,
+		`SELECT id, tenant_id, name, base_year, target_year, scopes, target_type, intensity_metric, reduction_pct, pathway, created_at
+		 FROM reduction_targets
+		 WHERE id = $1 AND tenant_id = $2`,
+		targetID, tenantID,
+	).Scan(&t.ID, &t.TenantID, &t.Name, &t.BaseYear, &t.TargetYear, &t.Scopes, &t.TargetType, &t.IntensityMetric, &t.ReductionPct, &t.Pathway, &t.CreatedAt)
+	return t, err
+}
+
+func listTargets(ctx context.Context, db *pgxpool.Pool, tenantID int64) ([]ReductionTarget, error) {
+	rows, err := db.Query(ctx,
+		`SELECT id, tenant_id, name, base_year, target_year, scopes, target_type, intensity_metric, reduction_pct, pathway, created_at
+		 FROM reduction_targets
+		 WHERE tenant_id = $1
+		 ORDER BY target_year, id`,
+		tenantID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []ReductionTarget{}
+	for rows.Next() {
+		var t ReductionTarget
+		if err := rows.Scan(&t.ID, &t.TenantID, &t.Name, &t.BaseYear, &t.TargetYear, &t.Scopes, &t.TargetType, &t.IntensityMetric, &t.ReductionPct, &t.Pathway, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuildTrajectory(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      ReductionTarget
+		actuals     map[int]float64
+		rate        float64
+		targetValue float64
+		required    map[int]float64
+		sbtiPct     float64
+		aligned     bool
+	}{
+		{
+			name:        "linéaire, 42 % en 10 ans",
+			target:      ReductionTarget{BaseYear: 2020, TargetYear: 2030, ReductionPct: 42, Pathway: "linear"},
+			rate:        0.042,
+			targetValue: 580,
+			required:    map[int]float64{2020: 1000, 2025: 790, 2030: 580},
+			sbtiPct:     42,
+			aligned:     true,
+		},
+		{
+			name:        "pathway SBTi moins ambitieux que 1,5°C : la trajectoire suit l'objectif déclaré",
+			target:      ReductionTarget{BaseYear: 2020, TargetYear: 2030, ReductionPct: 30, Pathway: "sbti_1_5c"},
+			rate:        0.03,
+			targetValue: 700,
+			required:    map[int]float64{2021: 970, 2030: 700},
+			sbtiPct:     42,
+			aligned:     false,
+		},
+		{
+			name:        "objectif plus ambitieux que 1,5°C",
+			target:      ReductionTarget{BaseYear: 2022, TargetYear: 2027, ReductionPct: 50, Pathway: "sbti_1_5c"},
+			rate:        0.1,
+			targetValue: 500,
+			required:    map[int]float64{2023: 900, 2027: 500},
+			sbtiPct:     21,
+			aligned:     true,
+		},
+		{
+			name:        "neutralité : la trajectoire ne descend pas sous zéro",
+			target:      ReductionTarget{BaseYear: 2020, TargetYear: 2025, ReductionPct: 100, Pathway: "linear"},
+			rate:        0.2,
+			targetValue: 0,
+			required:    map[int]float64{2024: 200, 2025: 0},
+			sbtiPct:     21,
+			aligned:     true,
+		},
+		{
+			name:        "années réelles au-delà de l'année cible : valeur cible maintenue",
+			target:      ReductionTarget{BaseYear: 2020, TargetYear: 2022, ReductionPct: 10, Pathway: "linear"},
+			actuals:     map[int]float64{2024: 850},
+			rate:        0.05,
+			targetValue: 900,
+			required:    map[int]float64{2022: 900, 2023: 900, 2024: 900},
+			sbtiPct:     8.4,
+			aligned:     true,
+		},
+		{
+			name:        "horizon long : exigence SBTi plafonnée à 100 %",
+			target:      ReductionTarget{BaseYear: 2020, TargetYear: 2050, ReductionPct: 90, Pathway: "linear"},
+			rate:        0.03,
+			targetValue: 100,
+			required:    map[int]float64{2050: 100},
+			sbtiPct:     100,
+			aligned:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := buildTrajectory(tt.target, "tCO2e", 1000, tt.actuals)
+			if math.Abs(resp.AnnualReductionRate-tt.rate) > 1e-9 {
+				t.Errorf("taux = %v, attendu %v", resp.AnnualReductionRate, tt.rate)
+			}
+			if math.Abs(resp.TargetValue-tt.targetValue) > 1e-9 {
+				t.Errorf("valeur cible = %v, attendu %v", resp.TargetValue, tt.targetValue)
+			}
+			if want := 1000 * (1 - tt.target.ReductionPct/100); math.Abs(resp.TargetValue-want) > 1e-9 {
+				t.Errorf("valeur cible %v différente de l'objectif déclaré %v", resp.TargetValue, want)
+			}
+			for _, p := range resp.Points {
+				if want, ok := tt.required[p.Year]; ok && math.Abs(p.Required-want) > 1e-9 {
+					t.Errorf("%d : requis %v, attendu %v", p.Year, p.Required, want)
+				}
+			}
+			if resp.SBTi.RequiredAnnualRate != sbti15AnnualRate || math.Abs(resp.SBTi.RequiredReductionPct-tt.sbtiPct) > 1e-9 || resp.SBTi.Aligned != tt.aligned {
+				t.Errorf("SBTi = %+v, attendu %v %% requis, aligné %v", resp.SBTi, tt.sbtiPct, tt.aligned)
+			}
+		})
+	}
+}
+
+func TestBuildTrajectoryActuals(t *testing.T) {
+	target := ReductionTarget{BaseYear: 2020, TargetYear: 2030, ReductionPct: 50, Pathway: "linear"}
+	actuals := map[int]float64{2020: 1000, 2022: 850, 2024: 820}
+	resp := buildTrajectory(target, "tCO2e", 1000, actuals)
+
+	if len(resp.Points) != 11 {
+		t.Fatalf("%d points, attendu 11", len(resp.Points))
+	}
+	tests := []struct {
+		year    int
+		gap     float64
+		onTrack bool
+	}{
+		{2020, 0, true},
+		{2022, -50, true}, // requis 900
+		{2024, 20, false}, // requis 800
+	}
+	for _, tt := range tests {
+		p := resp.Points[tt.year-2020]
+		if p.Actual == nil || p.Gap == nil || p.OnTrack == nil {
+			t.Fatalf("%d : valeurs réelles absentes", tt.year)
+		}
+		if math.Abs(*p.Gap-tt.gap) > 1e-9 || *p.OnTrack != tt.onTrack {
+			t.Errorf("%d : écart %v (%v), attendu %v (%v)", tt.year, *p.Gap, *p.OnTrack, tt.gap, tt.onTrack)
+		}
+	}
+	if p := resp.Points[2023-2020]; p.Actual != nil || p.Gap != nil {
+		t.Errorf("2023 sans donnée : %+v", p)
+	}
+
+	if resp.LatestYear == nil || *resp.LatestYear != 2024 || *resp.LatestActual != 820 {
+		t.Fatalf("dernière année = %v / %v", resp.LatestYear, resp.LatestActual)
+	}
+	if *resp.GapToTarget != 320 || resp.RemainingYears != 6 || math.Abs(*resp.RequiredPerYear-320.0/6) > 1e-9 {
+		t.Errorf("reste %v en %d ans (%v/an), attendu 320 en 6 ans", *resp.GapToTarget, resp.RemainingYears, *resp.RequiredPerYear)
+	}
+
+	none := buildTrajectory(target, "tCO2e", 1000, nil)
+	if none.LatestYear != nil || none.GapToTarget != nil || none.RequiredPerYear != nil {
+		t.Errorf("sans donnée réelle : %+v", none)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Jetons signés envoyés par email (invitation, réinitialisation de mot de
+// passe, vérification d'adresse). Le jeton porte l'identifiant d'une ligne en
+// base, qui le rend révocable et à usage unique ; la signature et l'expiration
+// sont vérifiées avant toute requête.
+
+var errInvalidActionToken = errors.New("jeton invalide ou expiré")
+
+// actionTokenKey dérive du secret JWT une clé propre à chaque usage : un jeton
+// émis pour un usage n'est accepté ni pour un autre, ni comme token d'accès.
+func actionTokenKey(cfg Config, audience string) []byte {
+	return hmacSHA256([]byte(cfg.JWTSecret), "carbonv2-"+audience)
+}
+
+func signActionToken(cfg Config, audience string, id int64, expiresAt time.Time, extra jwt.MapClaims) (string, error) {
+	claims := jwt.MapClaims{
+		"jti": strconv.FormatInt(id, 10),
+		"exp": expiresAt.Unix(),
+		"iat": time.Now().Unix(),
+		"iss": "carbonv2-api",
+		"aud": audience,
+	}
+	for k, v := range extra {
+		claims[k] = v
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(actionTokenKey(cfg, audience))
+}
+
+// parseActionToken vérifie la signature, l'usage et l'expiration d'un jeton et
+// renvoie l'identifiant de la ligne correspondante.
+func parseActionToken(cfg Config, audience, tokenStr string) (int64, error) {
+	token, err := jwt.Parse(tokenStr, func(t *jwt.Token) (interface{}, error) {
+		return actionTokenKey(cfg, audience), nil
+	}, jwt.WithValidMethods([]string{"HS256"}), jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	if err != nil || !token.Valid {
+		return 0, errInvalidActionToken
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	id, err := strconv.ParseInt(jti, 10, 64)
+	if err != nil {
+		return 0, errInvalidActionToken
+	}
+	return id, nil
+}
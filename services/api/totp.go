@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Mots de passe à usage unique basés sur le temps (TOTP, RFC 6238) : codes à
+// 6 chiffres renouvelés toutes les 30 secondes, HMAC-SHA1, compatibles avec
+// les applications d'authentification usuelles.
+
+const (
+	totpDigits = 6
+	totpPeriod = 30 // secondes
+	totpSkew   = 1  // périodes acceptées avant/après l'heure courante (décalage d'horloge)
+	totpIssuer = "CarbonV2"
+
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newTOTPSecret renvoie un secret aléatoire de 160 bits encodé en base32.
+func newTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// totpURI renvoie l'URI otpauth:// à afficher en QR code lors de l'enrôlement.
+func totpURI(secret, email string) string {
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {totpIssuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+email) + "?" + q.Encode()
+}
+
+// totpCode calcule le code de la période step (HOTP, RFC 4226).
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// verifyTOTP vérifie un code et renvoie la période correspondante. Un code
+// d'une période déjà utilisée (lastStep) est refusé, pour qu'un code
+// intercepté ne puisse pas être rejoué.
+func verifyTOTP(secret, code string, lastStep int64, now time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// isTOTPCode distingue un code TOTP (6 chiffres) d'un code de récupération.
+func isTOTPCode(code string) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Le secret TOTP est chiffré en base (AES-256-GCM, clé dérivée du secret JWT) :
+// une fuite de la table users ne suffit pas à générer des codes.
+
+func totpCipher(cfg Config) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(hmacSHA256([]byte(cfg.JWTSecret), "carbonv2-totp-secret"))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealTOTPSecret(cfg Config, secret string) (string, error) {
+	aead, err := totpCipher(cfg)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(secret), nil)), nil
+}
+
+func openTOTPSecret(cfg Config, sealed string) (string, error) {
+	aead, err := totpCipher(cfg)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", errors.New("secret TOTP illisible")
+	}
+	plain, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("secret TOTP illisible")
+	}
+	return string(plain), nil
+}
+
+// Codes de récupération : à usage unique, pour se connecter sans l'application
+// d'authentification. Seule leur empreinte est stockée.
+
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// newRecoveryCodes renvoie des codes de la forme "xxxxx-xxxxx".
+func newRecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := 0; i < n; i++ {
+		var b strings.Builder
+		for j := 0; j < 10; j++ {
+			if j == 5 {
+				b.WriteByte('-')
+			}
+			idx, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return nil, err
+			}
+			b.WriteByte(recoveryCodeAlphabet[idx.Int64()])
+		}
+		codes = append(codes, b.String())
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignore la casse, les espaces et les tirets saisis.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
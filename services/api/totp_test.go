@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Vecteurs de la RFC 6238, annexe B (HMAC-SHA1, clé ASCII
+// "12345678901234567890") : les codes publiés ont 8 chiffres, les 6 derniers
+// sont ceux d'un code à 6 chiffres.
+func TestVerifyTOTPRFC6238(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		unix int64
+		rfc  string // code à 8 chiffres publié dans la RFC
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		code := tt.rfc[len(tt.rfc)-totpDigits:]
+		step, ok := verifyTOTP(secret, code, 0, time.Unix(tt.unix, 0))
+		if !ok {
+			t.Errorf("T=%d : code %s refusé", tt.unix, code)
+			continue
+		}
+		if want := tt.unix / totpPeriod; step != want {
+			t.Errorf("T=%d : période %d, attendu %d", tt.unix, step, want)
+		}
+	}
+}
+
+func TestVerifyTOTPRejects(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111109, 0) // code "081804" à la période 37037036
+	step := now.Unix() / totpPeriod
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		lastStep int64
+		now      time.Time
+		ok       bool
+	}{
+		{"code valide", secret, "081804", 0, now, true},
+		{"secret en minuscules", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "081804", 0, now, true},
+		{"période précédente acceptée", secret, "081804", 0, now.Add(totpPeriod * time.Second), true},
+		{"période suivante acceptée", secret, "081804", 0, now.Add(-totpPeriod * time.Second), true},
+		{"hors de la tolérance", secret, "081804", 0, now.Add(2 * totpPeriod * time.Second), false},
+		{"rejeu de la même période", secret, "081804", step, now, false},
+		{"période déjà dépassée", secret, "081804", step + 1, now, false},
+		{"mauvais code", secret, "081805", 0, now, false},
+		{"code trop court", secret, "81804", 0, now, false},
+		{"code à 8 chiffres", secret, "07081804", 0, now, false},
+		{"secret invalide", "pas du base32!", "081804", 0, now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := verifyTOTP(tt.secret, tt.code, tt.lastStep, tt.now); ok != tt.ok {
+				t.Errorf("verifyTOTP = %v, attendu %v", ok, tt.ok)
+			}
+		})
+	}
+}
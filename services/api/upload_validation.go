@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gin-gonic/gin"
+)
+
+// planLimits borne la taille des documents d'un tenant selon son abonnement.
+type planLimits struct {
+	MaxFileBytes    int64 // taille maximale d'un fichier
+	MaxStorageBytes int64 // volume total des fichiers non purgés (un contenu partagé compte une fois)
+}
+
+const mib = 1 << 20
+
+var uploadPlanLimits = map[string]planLimits{
+	"free":       {MaxFileBytes: 10 * mib, MaxStorageBytes: 500 * mib},
+	"starter":    {MaxFileBytes: 25 * mib, MaxStorageBytes: 5 * 1024 * mib},
+	"pro":        {MaxFileBytes: 50 * mib, MaxStorageBytes: 50 * 1024 * mib},
+	"enterprise": {MaxFileBytes: 100 * mib, MaxStorageBytes: 500 * 1024 * mib},
+}
+
+// maxUploadRequestBytes borne le corps des requêtes d'upload avant toute lecture
+// (plus grande limite par fichier, plus une marge pour l'enveloppe multipart).
+const maxUploadRequestBytes = 100*mib + mib
+
+// fileAllowed indique si un fichier de cette taille est accepté par le plan.
+func (l planLimits) fileAllowed(size int64) bool {
+	return size <= l.MaxFileBytes
+}
+
+// storageAllowed indique si le fichier tient dans l'espace restant du plan.
+func (l planLimits) storageAllowed(used, size int64) bool {
+	return used+size <= l.MaxStorageBytes
+}
+
+func limitsForPlan(plan string) planLimits {
+	if l, ok := uploadPlanLimits[plan]; ok {
+		return l
+	}
+	return uploadPlanLimits["free"]
+}
+
+// uploadType associe un type détecté sur le contenu aux extensions acceptées.
+type uploadType struct {
+	detected   []string // types renvoyés par la détection (le premier est le type enregistré)
+	extensions []string
+}
+
+// Types acceptés à l'upload. Les fichiers CSV séparés par des points-virgules
+// sont détectés comme du texte brut : seule l'extension permet de les reconnaître.
+var uploadTypes = []uploadType{
+	{detected: []string{"application/pdf"}, extensions: []string{".pdf"}},
+	{detected: []string{"image/png"}, extensions: []string{".png"}},
+	{detected: []string{"image/jpeg"}, extensions: []string{".jpg", ".jpeg"}},
+	{detected: []string{"image/webp"}, extensions: []string{".webp"}},
+	{detected: []string{"image/tiff"}, extensions: []string{".tif", ".tiff"}},
+	{detected: []string{"text/csv", "text/plain", "text/tab-separated-values"}, extensions: []string{".csv"}},
+	{detected: []string{"application/vnd.ms-excel", "application/x-ole-storage"}, extensions: []string{".xls"}},
+	{detected: []string{"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"}, extensions: []string{".xlsx"}},
+}
+
+// matchUploadType renvoie le type à enregistrer si le contenu détecté est
+// accepté et cohérent avec l'extension du fichier.
+func matchUploadType(detected *mimetype.MIME, filename string) (string, bool) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, t := range uploadTypes {
+		if !containsString(t.extensions, ext) {
+			continue
+		}
+		for _, d := range t.detected {
+			if detected.Is(d) {
+				return t.detected[0], true
+			}
+		}
+	}
+	return "", false
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedExtensions liste les extensions autorisées, pour les messages d'erreur.
+func acceptedExtensions() []string {
+	var exts []string
+	for _, t := range uploadTypes {
+		exts = append(exts, t.extensions...)
+	}
+	return exts
+}
+
+// uploadedFile lit le fichier "file" d'un formulaire multipart en bornant la
+// taille de la requête. En cas d'échec la réponse d'erreur est déjà écrite.
+func uploadedFile(c *gin.Context) (multipart.File, *multipart.FileHeader, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadRequestBytes)
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "fichier trop volumineux", "details": gin.H{"limit_bytes": maxUploadRequestBytes}})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fichier manquant", "details": err.Error()})
+		return nil, nil, false
+	}
+	if header.Size <= 0 {
+		file.Close()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fichier vide"})
+		return nil, nil, false
+	}
+	return file, header, true
+}
+
+// validateUpload contrôle un fichier avant son enregistrement : quotas du plan,
+// type réel détecté sur le contenu (le Content-Type du client est ignoré),
+// cohérence avec l'extension, puis antivirus. Un fichier infecté est placé en
+// quarantaine et n'est pas enregistré comme document.
+// Renvoie le type MIME à enregistrer ; le fichier est rembobiné au début.
+// En cas d'échec la réponse d'erreur est déjà écrite.
+func (h *DocumentsHandler) validateUpload(c *gin.Context, tenantID int64, file multipart.File, header *multipart.FileHeader) (string, bool) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var plan string
+	var used int64
+	err := h.db.QueryRow(ctx,
+		`SELECT t.plan,
+		        COALESCE((SELECT SUM(f.size_bytes) FROM (
+		                      SELECT DISTINCT ON (d.storage_path) d.size_bytes FROM documents d
+		                      WHERE d.tenant_id = t.id AND d.purged_at IS NULL
+		                  ) f), 0)::bigint
+		 FROM tenants t
+		 WHERE t.id = $1`,
+		tenantID,
+	).Scan(&plan, &used)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "erreur lors de la vérification des quotas", "details": err.Error()})
+		return "", false
+	}
+	limits := limitsForPlan(plan)
+	if !limits.fileAllowed(header.Size) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":   "fichier trop volumineux pour votre abonnement",
+			"details": gin.H{"plan": plan, "limit_bytes": limits.MaxFileBytes, "size_bytes": header.Size},
+		})
+		return "", false
+	}
+	if !limits.storageAllowed(used, header.Size) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error":   "espace de stockage de votre abonnement épuisé",
+			"details": gin.H{"plan": plan, "quota_bytes": limits.MaxStorageBytes, "used_bytes": used},
+		})
+		return "", false
+	}
+
+	detected, err := mimetype.DetectReader(file)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "fichier illisible", "details": err.Error()})
+		return "", false
+	}
+	contentType, ok := matchUploadType(detected, header.Filename)
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{
+			"error": "type de fichier non supporté ou ne correspondant pas à son extension",
+			"details": gin.H{
+				"detected_type":       detected.String(),
+				"extension":           filepath.Ext(header.Filename),
+				"accepted_extensions": acceptedExtensions(),
+			},
+		})
+		return "", false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de relire le fichier", "details": err.Error()})
+		return "", false
+	}
+
+	result, err := h.scanner.Scan(c.Request.Context(), file)
+	if err != nil {
+		log.Printf("antivirus indisponible (tenant %d, %s) : %v", tenantID, header.Filename, err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "analyse antivirus indisponible, réessayez plus tard"})
+		return "", false
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "impossible de relire le fichier", "details": err.Error()})
+		return "", false
+	}
+	if result.Infected {
+		h.quarantine(c, tenantID, file, header, contentType, result.Signature)
+		return "", false
+	}
+	return contentType, true
+}
+
+// quarantine conserve un fichier infecté hors des documents du tenant, pour
+// examen, et répond 422.
+func (h *DocumentsHandler) quarantine(c *gin.Context, tenantID int64, file io.Reader, header *multipart.FileHeader, contentType, signature string) {
+	log.Printf("fichier infecté refusé (tenant %d, %s) : %s", tenantID, header.Filename, signature)
+
+	key := "quarantine/" + documentStorageKey(tenantID, header.Filename)
+	written, err := h.storage.Put(c.Request.Context(), key, file, header.Size, contentType)
+	if err != nil {
+		log.Printf("mise en quarantaine de %s : %v", header.Filename, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "fichier infecté refusé", "details": gin.H{"signature": signature}})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var quarantineID int64
+	err = h.db.QueryRow(ctx,
+		`INSERT INTO quarantined_uploads (tenant_id, original_name, mime_type, size_bytes, storage_path, signature, uploaded_by)
+		 VALUES ($1,$2,$3,$4,$5,$6,$7)
+		 RETURNING id`,
+		tenantID, header.Filename, contentType, written, key, signature, userIDFromRequest(c),
+	).Scan(&quarantineID)
+	if err != nil {
+		log.Printf("enregistrement de la quarantaine de %s : %v", header.Filename, err)
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "fichier infecté refusé", "details": gin.H{"signature": signature}})
+		return
+	}
+
+	c.JSON(http.StatusUnprocessableEntity, gin.H{
+		"error":   "fichier infecté placé en quarantaine",
+		"details": gin.H{"signature": signature, "quarantine_id": quarantineID},
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// xlsxContent construit un classeur minimal : la détection s'appuie sur les
+// entrées de l'archive ZIP.
+func xlsxContent(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml"} {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("<?xml version=\"1.0\"?><x/>"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMatchUploadType(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n1 0 obj\n<< /Type /Catalog >>\nendobj\n%%EOF\n")
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x02\x00\x00\x00")
+	jpeg := []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	webp := []byte("RIFF\x24\x00\x00\x00WEBPVP8 \x18\x00\x00\x00")
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	exe := []byte("MZ\x90\x00\x03\x00\x00\x00\x04\x00\x00\x00\xff\xff\x00\x00")
+
+	tests := []struct {
+		name     string
+		content  []byte
+		filename string
+		want     string // type enregistré, "" si refusé
+	}{
+		{"PDF", pdf, "facture.pdf", "application/pdf"},
+		{"extension en majuscules", pdf, "FACTURE.PDF", "application/pdf"},
+		{"PNG", png, "scan.png", "image/png"},
+		{"JPEG .jpg", jpeg, "photo.jpg", "image/jpeg"},
+		{"JPEG .jpeg", jpeg, "photo.jpeg", "image/jpeg"},
+		{"WebP", webp, "photo.webp", "image/webp"},
+		{"TIFF", tiff, "scan.tiff", "image/tiff"},
+		{"CSV à virgules", []byte("date,montant\n2024-01-01,12.5\n2024-01-02,8\n"), "export.csv", "text/csv"},
+		{"CSV à points-virgules", []byte("date;montant\n2024-01-01;12,5\n2024-01-02;8\n"), "export.csv", "text/csv"},
+		{"XLSX", xlsxContent(t), "ecritures.xlsx", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+
+		{"PDF renommé en PNG", pdf, "scan.png", ""},
+		{"PNG renommé en PDF", png, "facture.pdf", ""},
+		{"exécutable renommé en PDF", exe, "facture.pdf", ""},
+		{"HTML renommé en CSV", []byte("<!DOCTYPE html><html><script>alert(1)</script></html>"), "export.csv", ""},
+		{"texte sans extension reconnue", []byte("bonjour"), "notes.txt", ""},
+		{"sans extension", pdf, "facture", ""},
+		{"XLSX renommé en XLS", xlsxContent(t), "ecritures.xls", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchUploadType(mimetype.Detect(tt.content), tt.filename)
+			if ok != (tt.want != "") || got != tt.want {
+				t.Errorf("matchUploadType = %q, %v ; attendu %q (détecté : %s)", got, ok, tt.want, mimetype.Detect(tt.content))
+			}
+		})
+	}
+}
+
+func TestPlanLimits(t *testing.T) {
+	if l := limitsForPlan("inconnu"); l != uploadPlanLimits["free"] {
+		t.Errorf("plan inconnu : %+v, attendu les limites du plan free", l)
+	}
+	for plan, l := range uploadPlanLimits {
+		if l.MaxFileBytes > maxUploadRequestBytes-mib {
+			t.Errorf("%s : limite par fichier %d au-delà de la limite des requêtes", plan, l.MaxFileBytes)
+		}
+	}
+
+	free := limitsForPlan("free")
+	tests := []struct {
+		name      string
+		used      int64
+		size      int64
+		fileOK    bool
+		storageOK bool
+	}{
+		{"petit fichier", 0, mib, true, true},
+		{"taille maximale", 0, 10 * mib, true, true},
+		{"un octet de trop", 0, 10*mib + 1, false, true},
+		{"remplit exactement l'espace", 490 * mib, 10 * mib, true, true},
+		{"dépasse l'espace restant", 495 * mib, 6 * mib, true, false},
+		{"espace déjà plein", 500 * mib, 1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := free.fileAllowed(tt.size); got != tt.fileOK {
+				t.Errorf("fileAllowed(%d) = %v, attendu %v", tt.size, got, tt.fileOK)
+			}
+			if got := free.storageAllowed(tt.used, tt.size); got != tt.storageOK {
+				t.Errorf("storageAllowed(%d, %d) = %v, attendu %v", tt.used, tt.size, got, tt.storageOK)
+			}
+		})
+	}
+}
@@ -2,7 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 func toStringID(v interface{}) string {
@@ -31,4 +38,87 @@ func toJSONB(m map[string]string) string {
 	return string(b)
 }
 
+// tenantFromRequest vérifie que le tenant du chemin correspond à celui du token
+// et renvoie son identifiant. En cas d'échec la réponse d'erreur est déjà écrite.
+func tenantFromRequest(c *gin.Context) (int64, bool) {
+	claimsVal, ok := c.Get("user")
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "non authentifié"})
+		return 0, false
+	}
+	claims := claimsVal.(jwt.MapClaims)
+
+	tenantIDFromToken := claims["tenant_id"]
+	if c.Param("tenantId") != toStringID(tenantIDFromToken) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "accès interdit à ce tenant"})
+		return 0, false
+	}
+
+	switch v := tenantIDFromToken.(type) {
+	case float64:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format tenant_id invalide"})
+		return 0, false
+	}
+}
+
+// int64Param lit un identifiant numérique dans le chemin (ex: :targetId).
+func int64Param(c *gin.Context, name string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " invalide"})
+		return 0, false
+	}
+	return id, true
+}
+
+// optionalYearQuery lit un paramètre de requête année (ex: ?year=2024).
+// Renvoie 0 si absent.
+func optionalYearQuery(c *gin.Context, name string) (int, bool) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, true
+	}
+	year, err := strconv.Atoi(raw)
+	if err != nil || year < 1990 || year > 2100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " invalide, format attendu YYYY"})
+		return 0, false
+	}
+	return year, true
+}
+
+// userIDFromRequest renvoie l'identifiant de l'utilisateur authentifié (claim
+// "sub"), ou nil s'il est absent.
+func userIDFromRequest(c *gin.Context) *int64 {
+	claimsVal, ok := c.Get("user")
+	if !ok {
+		return nil
+	}
+	claims, ok := claimsVal.(jwt.MapClaims)
+	if !ok {
+		return nil
+	}
+	var id int64
+	switch v := claims["sub"].(type) {
+	case float64:
+		id = int64(v)
+	case int64:
+		id = v
+	case int:
+		id = int64(v)
+	default:
+		return nil
+	}
+	return &id
+}
 
+// isUniqueViolation indique si l'erreur PostgreSQL est une violation de contrainte d'unicité.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}